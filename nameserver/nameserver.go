@@ -68,8 +68,12 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 
 	// Create query for the resolver.
 	q := &resolver.Query{
-		FQDN:  lowerCaseQuestion,
-		QType: dns.Type(originalQuestion.Qtype),
+		FQDN:             lowerCaseQuestion,
+		QType:            dns.Type(originalQuestion.Qtype),
+		CheckingDisabled: request.CheckingDisabled,
+	}
+	if opt := request.IsEdns0(); opt == nil || !opt.Do() {
+		q.StripDNSSECRecords = true
 	}
 
 	// Get remote address of request.
@@ -221,6 +225,17 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 		}
 	}()
 
+	// Save security level to query, so that the resolver can react to configuration.
+	q.SecurityLevel = conn.Process().Profile().SecurityLevel()
+
+	// Qualify single-label names before filtering, so that the name that is
+	// resolved is also the name that is filtered.
+	// Errors are returned again when resolving.
+	if err := q.ApplySingleLabelPolicy(); err == nil && conn.Entity.Domain != q.FQDN {
+		tracer.Tracef("nameserver: qualified single-label name %s as %s", conn.Entity.Domain, q.FQDN)
+		conn.Entity.Domain = q.FQDN
+	}
+
 	// Check request with the privacy filter before resolving.
 	firewall.FilterConnection(ctx, conn, nil, true, false)
 
@@ -246,9 +261,6 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 		return reply(conn, conn)
 	}
 
-	// Save security level to query, so that the resolver can react to configuration.
-	q.SecurityLevel = conn.Process().Profile().SecurityLevel()
-
 	// Resolve request.
 	rrCache, err = resolver.Resolve(ctx, q)
 	// Handle error.
@@ -262,6 +274,11 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 				conn.Failed("domain does not exist", "")
 				return reply(nsutil.NxDomain("nxdomain: " + err.Error()))
 			}
+		case errors.Is(err, resolver.ErrBlocklisted):
+			tracer.Tracef("nameserver: %s", err)
+			conn.Block(err.Error(), "")
+			return reply(nsutil.Refused("blocked: " + err.Error()))
+
 		case errors.Is(err, resolver.ErrBlocked):
 			tracer.Tracef("nameserver: %s", err)
 			conn.Block(err.Error(), "")
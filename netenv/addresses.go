@@ -51,6 +51,38 @@ func GetAssignedGlobalAddresses() (ipv4 []net.IP, ipv6 []net.IP, err error) {
 	return
 }
 
+// GetInterfaceAddresses returns the assigned IPv4 and IPv6 addresses of the
+// network interface with the given name. It fails if the interface does not
+// exist or is down.
+func GetInterfaceAddresses(name string) (ipv4 []net.IP, ipv6 []net.IP, err error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, nil, fmt.Errorf("interface %s is down", name)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		netAddr, ok := addr.(*net.IPNet)
+		if !ok {
+			log.Warningf("netenv: interface address of unexpected type %T", addr)
+			continue
+		}
+
+		if ip4 := netAddr.IP.To4(); ip4 != nil {
+			ipv4 = append(ipv4, ip4)
+		} else {
+			ipv6 = append(ipv6, netAddr.IP)
+		}
+	}
+	return
+}
+
 var (
 	myNetworks                   []*net.IPNet
 	myNetworksLock               sync.Mutex
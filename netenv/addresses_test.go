@@ -1,6 +1,7 @@
 package netenv
 
 import (
+	"net"
 	"testing"
 )
 
@@ -28,3 +29,31 @@ func TestGetAssignedGlobalAddresses(t *testing.T) {
 		t.Fatalf("failed to get addresses: %s", err)
 	}
 }
+
+func TestGetInterfaceAddresses(t *testing.T) {
+	t.Parallel()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("failed to get interfaces: %s", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		ipv4, ipv6, err := GetInterfaceAddresses(iface.Name)
+		t.Logf("%s v4: %v", iface.Name, ipv4)
+		t.Logf("%s v6: %v", iface.Name, ipv6)
+		if err != nil {
+			t.Fatalf("failed to get addresses of %s: %s", iface.Name, err)
+		}
+		if len(ipv4) == 0 && len(ipv6) == 0 {
+			t.Fatalf("GetInterfaceAddresses did not return any addresses for %s", iface.Name)
+		}
+	}
+
+	if _, _, err := GetInterfaceAddresses("does-not-exist0"); err == nil {
+		t.Fatal("GetInterfaceAddresses did not fail for a missing interface")
+	}
+}
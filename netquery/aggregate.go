@@ -0,0 +1,86 @@
+package netquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/safing/portmaster/netquery/orm"
+)
+
+type (
+	// AggregateResult holds the number of connections that share the same
+	// value of the aggregated column.
+	AggregateResult struct {
+		Key   string `sqlite:"key"`
+		Count int    `sqlite:"count"`
+	}
+
+	// AggregateOptions limits the connections that are aggregated and the
+	// number of results.
+	AggregateOptions struct {
+		// From and To limit the aggregation to connections that started
+		// within the time range. A zero time disables the respective bound.
+		From time.Time
+		To   time.Time
+		// Limit limits the number of results. Zero means no limit.
+		Limit int
+	}
+)
+
+// Aggregate groups the connections by the given column and returns the number
+// of connections per value, ordered by the number of connections. Connections
+// without a value in the column are ignored. The column must be part of the
+// schema.
+func (db *Database) Aggregate(ctx context.Context, column string, opts AggregateOptions) ([]AggregateResult, error) {
+	if db.Schema.GetColumnDef(column) == nil {
+		return nil, fmt.Errorf("unknown column %q", column)
+	}
+
+	return db.aggregate(ctx, column, opts)
+}
+
+// TopDomains returns the domains with the most connections.
+func (db *Database) TopDomains(ctx context.Context, opts AggregateOptions) ([]AggregateResult, error) {
+	return db.Aggregate(ctx, "domain", opts)
+}
+
+// ConnectionsPerCountry returns the number of connections per country.
+func (db *Database) ConnectionsPerCountry(ctx context.Context, opts AggregateOptions) ([]AggregateResult, error) {
+	return db.Aggregate(ctx, "country", opts)
+}
+
+// ConnectionsPerProcess returns the number of connections per process path.
+func (db *Database) ConnectionsPerProcess(ctx context.Context, opts AggregateOptions) ([]AggregateResult, error) {
+	return db.Aggregate(ctx, "path", opts)
+}
+
+func (db *Database) aggregate(ctx context.Context, column string, opts AggregateOptions) ([]AggregateResult, error) {
+	conditions := []string{column + " IS NOT NULL", column + " != ''"}
+	args := make(map[string]interface{})
+	if !opts.From.IsZero() {
+		conditions = append(conditions, "datetime(started) >= datetime(:from)")
+		args[":from"] = opts.From.UTC().Format(orm.SqliteTimeFormat)
+	}
+	if !opts.To.IsZero() {
+		conditions = append(conditions, "datetime(started) < datetime(:to)")
+		args[":to"] = opts.To.UTC().Format(orm.SqliteTimeFormat)
+	}
+
+	sql := "SELECT CAST(" + column + " AS TEXT) AS key, COUNT(*) AS count" +
+		" FROM " + db.Schema.Name +
+		" WHERE " + strings.Join(conditions, " AND ") +
+		" GROUP BY " + column +
+		" ORDER BY count DESC, key ASC"
+	if opts.Limit > 0 {
+		sql += " LIMIT :limit"
+		args[":limit"] = opts.Limit
+	}
+
+	var results []AggregateResult
+	if err := db.Execute(ctx, sql, orm.WithNamedArgs(args), orm.WithResult(&results)); err != nil {
+		return nil, fmt.Errorf("failed to aggregate connections by %s: %w", column, err)
+	}
+	return results, nil
+}
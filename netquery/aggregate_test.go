@@ -0,0 +1,82 @@
+package netquery
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := New("file:aggregate-test.db")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	require.NoError(t, db.ApplyMigrations())
+
+	started := time.Date(2022, time.February, 15, 9, 51, 0, 0, time.UTC)
+	for idx, seed := range []struct {
+		domain  string
+		country string
+		path    string
+	}{
+		{"a.example.com.", "AT", "/usr/bin/firefox"},
+		{"a.example.com.", "AT", "/usr/bin/firefox"},
+		{"a.example.com.", "DE", "/usr/bin/curl"},
+		{"b.example.com.", "DE", "/usr/bin/firefox"},
+		{"b.example.com.", "", "/usr/bin/curl"},
+		{"", "US", "/usr/bin/curl"},
+	} {
+		require.NoError(t, db.Save(ctx, Conn{
+			ID:      fmt.Sprintf("conn-%d", idx),
+			Type:    ConnTypeIP,
+			Domain:  seed.domain,
+			Country: seed.country,
+			Path:    seed.path,
+			Started: started.Add(time.Duration(idx) * time.Minute),
+		}))
+	}
+
+	// Connections without a value are ignored.
+	domains, err := db.TopDomains(ctx, AggregateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []AggregateResult{
+		{Key: "a.example.com.", Count: 3},
+		{Key: "b.example.com.", Count: 2},
+	}, domains)
+
+	// Results are limited.
+	countries, err := db.ConnectionsPerCountry(ctx, AggregateOptions{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []AggregateResult{
+		{Key: "AT", Count: 2},
+		{Key: "DE", Count: 2},
+	}, countries)
+
+	// Connections are filtered by their start time.
+	processes, err := db.ConnectionsPerProcess(ctx, AggregateOptions{
+		From: started.Add(2 * time.Minute),
+		To:   started.Add(5 * time.Minute),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []AggregateResult{
+		{Key: "/usr/bin/curl", Count: 2},
+		{Key: "/usr/bin/firefox", Count: 1},
+	}, processes)
+
+	// Non-text columns are aggregated too.
+	types, err := db.Aggregate(ctx, "ip_version", AggregateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []AggregateResult{{Key: "0", Count: 6}}, types)
+
+	// Only columns of the schema can be aggregated.
+	_, err = db.Aggregate(ctx, "domain; DROP TABLE connections", AggregateOptions{})
+	assert.Error(t, err)
+}
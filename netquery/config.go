@@ -0,0 +1,85 @@
+package netquery
+
+import (
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/network"
+)
+
+// Configuration Keys.
+var (
+	CfgOptionRetentionKey   = "netquery/retention"
+	retention               config.IntOption
+	cfgOptionRetentionOrder = 530
+
+	CfgOptionMaxRowsKey   = "netquery/maxRows"
+	maxRows               config.IntOption
+	cfgOptionMaxRowsOrder = 531
+
+	CfgOptionReclaimSpaceKey   = "netquery/reclaimSpace"
+	reclaimSpace               config.BoolOption
+	cfgOptionReclaimSpaceOrder = 532
+)
+
+// defaultRetention is the default retention of ended connections in minutes.
+var defaultRetention = int64(network.DeleteConnsAfterEndedThreshold.Minutes())
+
+func registerConfig() error {
+	err := config.Register(&config.Option{
+		Name:           "Connection Retention",
+		Key:            CfgOptionRetentionKey,
+		Description:    "Define how long ended connections are kept in the connection database.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   defaultRetention,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRetentionOrder,
+			config.UnitAnnotation:         "minutes",
+			config.CategoryAnnotation:     "Development",
+		},
+		ValidationRegex: `^[1-9][0-9]{0,5}$`,
+	})
+	if err != nil {
+		return err
+	}
+	retention = config.Concurrent.GetAsInt(CfgOptionRetentionKey, defaultRetention)
+
+	err = config.Register(&config.Option{
+		Name:           "Maximum Stored Connections",
+		Key:            CfgOptionMaxRowsKey,
+		Description:    "Define how many connections are kept in the connection database at most. When exceeded, the ended connections that started first are removed. Set to 0 for no limit.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxRowsOrder,
+			config.CategoryAnnotation:     "Development",
+		},
+		ValidationRegex: `^[0-9]{1,9}$`,
+	})
+	if err != nil {
+		return err
+	}
+	maxRows = config.Concurrent.GetAsInt(CfgOptionMaxRowsKey, 0)
+
+	err = config.Register(&config.Option{
+		Name:           "Reclaim Connection Database Space",
+		Key:            CfgOptionReclaimSpaceKey,
+		Description:    "Rebuild the connection database after removing old connections in order to free the memory they used. This briefly blocks storing new connections.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionReclaimSpaceOrder,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	reclaimSpace = config.Concurrent.GetAsBool(CfgOptionReclaimSpaceKey, false)
+
+	return nil
+}
@@ -49,6 +49,8 @@
 
 		l         sync.Mutex
 		writeConn *sqlite.Conn
+
+		subs subscriptions
 	}
 
 	// Conn is a network connection that is stored in a SQLite database and accepted
@@ -183,7 +185,10 @@ func NewInMemory() (*Database, error) {
 // become/use a full migration system -- use zombiezen.com/go/sqlite/sqlitemigration.
 func (db *Database) ApplyMigrations() error {
 	// get the create-table SQL statement from the inferred schema
-	sql := db.Schema.CreateStatement(true)
+	sql, err := db.Schema.CreateStatement(orm.IfNotExists())
+	if err != nil {
+		return fmt.Errorf("failed to build schema: %w", err)
+	}
 
 	db.l.Lock()
 	defer db.l.Unlock()
@@ -193,6 +198,13 @@ func (db *Database) ApplyMigrations() error {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	// create the indexes defined by the schema
+	for _, stmt := range db.Schema.CreateIndexStatements(orm.IfNotExists()) {
+		if err := sqlitex.ExecuteTransient(db.writeConn, stmt, nil); err != nil {
+			return fmt.Errorf("failed to create index: %q: %w", stmt, err)
+		}
+	}
+
 	// create a few indexes
 	indexes := []string{
 		`CREATE INDEX profile_id_index ON %s (profile)`,
@@ -241,6 +253,29 @@ func (db *Database) Execute(ctx context.Context, sql string, args ...orm.QueryOp
 	})
 }
 
+// Select returns a query builder for the connections table that selects the
+// given columns, or all columns if none are given. Column names are validated
+// against the schema of Conn. Use ExecuteSelect to run the query.
+func (db *Database) Select(columns ...string) *orm.SelectBuilder {
+	return db.Schema.Select(columns...)
+}
+
+// ExecuteSelect builds the query of the given builder and executes it using
+// a read-only connection. Any error of the builder is returned before the
+// query is executed. The schema of the database is used to decode results.
+func (db *Database) ExecuteSelect(ctx context.Context, query *orm.SelectBuilder, args ...orm.QueryOption) error {
+	sql, queryArgs, err := query.Build()
+	if err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+
+	opts := make([]orm.QueryOption, 0, len(args)+2)
+	opts = append(opts, orm.WithArgs(queryArgs...), orm.WithSchema(*db.Schema))
+	opts = append(opts, args...)
+
+	return db.Execute(ctx, sql, opts...)
+}
+
 // CountRows returns the number of rows stored in the database.
 func (db *Database) CountRows(ctx context.Context) (int, error) {
 	var result []struct {
@@ -288,14 +323,80 @@ func (db *Database) Cleanup(ctx context.Context, threshold time.Time) (int, erro
 		return 0, fmt.Errorf("unexpected number of rows, expected 1 got %d", len(result))
 	}
 
-	err := db.ExecuteWrite(ctx, sql, args)
-	if err != nil {
+	if _, err := db.executeDelete(ctx, sql, args); err != nil {
 		return 0, err
 	}
 
 	return result[0].Count, nil
 }
 
+// pruneBatchSize is the maximum number of rows that Prune deletes at once, so
+// that storing new connections is not blocked for long.
+const pruneBatchSize = 1000
+
+// Prune removes all connections that have ended before olderThan, as well as
+// all ended connections beyond the keepLast most recently started connections.
+// A zero olderThan or keepLast disables the respective limit. Active
+// connections are never removed. Rows are deleted in batches, releasing the
+// write lock in between. Prune returns the number of removed rows.
+func (db *Database) Prune(ctx context.Context, olderThan time.Time, keepLast int) (int, error) {
+	ended, started, err := db.pruneColumns()
+	if err != nil {
+		return 0, err
+	}
+
+	var conditions []string
+	args := map[string]interface{}{
+		":batch": pruneBatchSize,
+	}
+	if !olderThan.IsZero() {
+		conditions = append(conditions, "datetime("+ended+") < datetime(:threshold)")
+		args[":threshold"] = olderThan.UTC().Format(orm.SqliteTimeFormat)
+	}
+	if keepLast > 0 {
+		conditions = append(conditions, "rowid NOT IN (SELECT rowid FROM "+db.Schema.Name+" ORDER BY datetime("+started+") DESC LIMIT :keep)")
+		args[":keep"] = keepLast
+	}
+	if len(conditions) == 0 {
+		return 0, nil
+	}
+
+	sql := "DELETE FROM " + db.Schema.Name + " WHERE rowid IN (" +
+		"SELECT rowid FROM " + db.Schema.Name +
+		" WHERE " + ended + " IS NOT NULL AND (" + strings.Join(conditions, " OR ") + ")" +
+		" LIMIT :batch);"
+
+	var total int
+	for {
+		removed, err := db.executeDelete(ctx, sql, orm.WithNamedArgs(args))
+		total += removed
+		switch {
+		case err != nil:
+			return total, err
+		case removed < pruneBatchSize:
+			return total, nil
+		case ctx.Err() != nil:
+			return total, ctx.Err()
+		}
+	}
+}
+
+// pruneColumns returns the names of the time columns of the schema that
+// Prune uses to decide which rows to remove.
+func (db *Database) pruneColumns() (ended, started string, err error) {
+	for _, name := range []string{"ended", "started"} {
+		if def := db.Schema.GetColumnDef(name); def == nil || !def.IsTime {
+			return "", "", fmt.Errorf("table %s does not have a time column %s", db.Schema.Name, name)
+		}
+	}
+	return "ended", "started", nil
+}
+
+// Vacuum rebuilds the database in order to free the space of removed rows.
+func (db *Database) Vacuum(ctx context.Context) error {
+	return db.ExecuteWrite(ctx, "VACUUM;")
+}
+
 // dumpTo is a simple helper method that dumps all rows stored in the SQLite database
 // as JSON to w.
 // Any error aborts dumping rows and is returned.
@@ -358,6 +459,18 @@ func (db *Database) Save(ctx context.Context, conn Conn) error {
 	db.l.Lock()
 	defer db.l.Unlock()
 
+	// only check whether this is an insert or an update, if anyone cares
+	eventType := ConnectionInserted
+	if db.hasSubscribers() {
+		exists, err := db.connExists(conn.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			eventType = ConnectionUpdated
+		}
+	}
+
 	// TODO(ppacher): make sure this one can be cached to speed up inserting
 	// and save some CPU cycles for the user
 	sql := fmt.Sprintf(
@@ -382,6 +495,10 @@ func (db *Database) Save(ctx context.Context, conn Conn) error {
 		return err
 	}
 
+	if db.hasSubscribers() {
+		db.publish(eventType, conn)
+	}
+
 	return nil
 }
 
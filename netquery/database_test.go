@@ -0,0 +1,120 @@
+package netquery
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portmaster/netquery/orm"
+)
+
+func TestExecuteSelect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := NewInMemory()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	started := time.Date(2022, time.February, 15, 9, 51, 0, 0, time.UTC)
+	for idx, domain := range []string{"a.example.com.", "b.example.com.", "example.org."} {
+		require.NoError(t, db.Save(ctx, Conn{
+			ID:      domain,
+			Type:    ConnTypeDNS,
+			Domain:  domain,
+			Started: started.Add(time.Duration(idx) * time.Minute),
+		}))
+	}
+
+	var conns []Conn
+	err = db.ExecuteSelect(ctx,
+		db.Select().
+			Where("domain", "LIKE", "%.example.com.").
+			And("started", ">=", started).
+			OrderBy("started", true),
+		orm.WithResult(&conns),
+	)
+	require.NoError(t, err)
+	require.Len(t, conns, 2)
+	assert.Equal(t, "b.example.com.", conns[0].Domain)
+	assert.Equal(t, "a.example.com.", conns[1].Domain)
+
+	// Invalid queries fail before being executed.
+	err = db.ExecuteSelect(ctx, db.Select().Where("unknown", "=", 1), orm.WithResult(&conns))
+	assert.Error(t, err)
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := New("file:prune-test.db")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	require.NoError(t, db.ApplyMigrations())
+
+	now := time.Now().UTC().Truncate(time.Second)
+	save := func(id string, started time.Time, ended *time.Time) {
+		t.Helper()
+
+		require.NoError(t, db.Save(ctx, Conn{
+			ID:      id,
+			Type:    ConnTypeIP,
+			Started: started,
+			Ended:   ended,
+			Active:  ended == nil,
+		}))
+	}
+	ago := func(d time.Duration) *time.Time {
+		ts := now.Add(-d)
+		return &ts
+	}
+
+	// More old rows than fit into a single batch.
+	for i := 0; i < pruneBatchSize+500; i++ {
+		save(fmt.Sprintf("old-%d", i), *ago(3 * time.Hour), ago(2*time.Hour))
+	}
+	save("active", *ago(3 * time.Hour), nil)
+	for i := 0; i < 3; i++ {
+		save(fmt.Sprintf("recent-%d", i), *ago(time.Duration(30-i) * time.Minute), ago(time.Duration(20-i)*time.Minute))
+	}
+
+	// Without limits, nothing is removed.
+	removed, err := db.Prune(ctx, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	// Rows that ended before the retention window are removed, in batches.
+	removed, err = db.Prune(ctx, now.Add(-time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, pruneBatchSize+500, removed)
+	count, err := db.CountRows(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// Only the most recently started rows are kept, but active ones are never
+	// removed.
+	removed, err = db.Prune(ctx, time.Time{}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	var ids []struct {
+		ID string `sqlite:"id"`
+	}
+	require.NoError(t, db.Execute(ctx, "SELECT id FROM connections ORDER BY id", orm.WithResult(&ids)))
+	require.Len(t, ids, 3)
+	assert.Equal(t, "active", ids[0].ID)
+	assert.Equal(t, "recent-1", ids[1].ID)
+	assert.Equal(t, "recent-2", ids[2].ID)
+
+	// Space of removed rows can be reclaimed.
+	assert.NoError(t, db.Vacuum(ctx))
+}
@@ -38,6 +38,10 @@ func init() {
 }
 
 func (m *module) prepare() error {
+	if err := registerConfig(); err != nil {
+		return err
+	}
+
 	var err error
 
 	m.db = database.NewInterface(&database.Options{
@@ -138,12 +142,18 @@ func (m *module) start() error {
 			case <-ctx.Done():
 				return nil
 			case <-time.After(10 * time.Second):
-				threshold := time.Now().Add(-network.DeleteConnsAfterEndedThreshold)
-				count, err := m.sqlStore.Cleanup(ctx, threshold)
+				threshold := time.Now().Add(-time.Duration(retention()) * time.Minute)
+				count, err := m.sqlStore.Prune(ctx, threshold, int(maxRows()))
 				if err != nil {
-					log.Errorf("netquery: failed to count number of rows in memory: %s", err)
-				} else {
-					log.Tracef("netquery: successfully removed %d old rows that ended before %s", count, threshold)
+					log.Errorf("netquery: failed to remove old rows: %s", err)
+					continue
+				}
+				log.Tracef("netquery: successfully removed %d old rows that ended before %s", count, threshold)
+
+				if count > 0 && reclaimSpace() {
+					if err := m.sqlStore.Vacuum(ctx); err != nil {
+						log.Warningf("netquery: failed to reclaim space of removed rows: %s", err)
+					}
 				}
 			}
 		}
@@ -0,0 +1,113 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// conditionOperators holds the operators supported in conditions.
+// IS NULL and IS NOT NULL do not take a value, IN and NOT IN take a slice of
+// values and BETWEEN and NOT BETWEEN take a slice of the two bounds.
+var conditionOperators = map[string]bool{
+	"=":           true,
+	"!=":          true,
+	"<":           true,
+	"<=":          true,
+	">":           true,
+	">=":          true,
+	"LIKE":        true,
+	"NOT LIKE":    true,
+	"IN":          true,
+	"NOT IN":      true,
+	"BETWEEN":     true,
+	"NOT BETWEEN": true,
+	"IS NULL":     true,
+	"IS NOT NULL": true,
+}
+
+// Condition builds a parameterized condition comparing column to value using
+// operator. The column must exist in the table and the operator must be one
+// of =, !=, <, <=, >, >=, LIKE, NOT LIKE, IN, NOT IN, BETWEEN, NOT BETWEEN,
+// IS NULL and IS NOT NULL. Values are encoded using the column definition and
+// cfg, so they match the stored values.
+// Condition returns the SQL fragment and the positional arguments for its
+// placeholders.
+//
+// Example:
+//
+//	sql, args, err := schema.Condition("port", "IN", []int{80, 443}, DefaultEncodeConfig)
+//	// sql = "port IN ( ?, ? )", args = [80, 443]
+func (ts *TableSchema) Condition(column, operator string, value interface{}, cfg EncodeConfig) (string, []interface{}, error) {
+	colDef := ts.GetColumnDef(column)
+	if colDef == nil {
+		return "", nil, fmt.Errorf("unknown column %s in table %s", column, ts.Name)
+	}
+	quoted := quoteIdentifier(column)
+
+	operator = strings.ToUpper(operator)
+	if !conditionOperators[operator] {
+		return "", nil, fmt.Errorf("unsupported operator %q for column %s", operator, column)
+	}
+
+	switch operator {
+	case "IS NULL", "IS NOT NULL":
+		if value != nil {
+			return "", nil, fmt.Errorf("operator %s for column %s does not take a value", operator, column)
+		}
+		return quoted + " " + operator, nil, nil
+
+	case "IN", "NOT IN":
+		values := reflect.ValueOf(value)
+		if values.Kind() != reflect.Slice || values.Len() == 0 {
+			return "", nil, fmt.Errorf("operator %s for column %s requires a non-empty slice of values", operator, column)
+		}
+
+		args, err := encodeConditionValues(colDef, values, cfg)
+		if err != nil {
+			return "", nil, err
+		}
+		placeholders := strings.Repeat("?, ", len(args)-1) + "?"
+		return quoted + " " + operator + " ( " + placeholders + " )", args, nil
+
+	case "BETWEEN", "NOT BETWEEN":
+		values := reflect.ValueOf(value)
+		if values.Kind() != reflect.Slice || values.Len() != 2 {
+			return "", nil, fmt.Errorf("operator %s for column %s requires a slice of two values", operator, column)
+		}
+
+		args, err := encodeConditionValues(colDef, values, cfg)
+		if err != nil {
+			return "", nil, err
+		}
+		return quoted + " " + operator + " ? AND ?", args, nil
+
+	default:
+		arg, err := encodeConditionValue(colDef, value, cfg)
+		if err != nil {
+			return "", nil, err
+		}
+		return quoted + " " + operator + " ?", []interface{}{arg}, nil
+	}
+}
+
+func encodeConditionValues(colDef *ColumnDef, values reflect.Value, cfg EncodeConfig) ([]interface{}, error) {
+	args := make([]interface{}, 0, values.Len())
+	for i := 0; i < values.Len(); i++ {
+		arg, err := encodeConditionValue(colDef, values.Index(i).Interface(), cfg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func encodeConditionValue(colDef *ColumnDef, value interface{}, cfg EncodeConfig) (interface{}, error) {
+	encoded, err := EncodeValue(context.Background(), colDef, value, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %v for column %s: %w", value, colDef.Name, err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,78 @@
+package orm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCondition(t *testing.T) {
+	t.Parallel()
+
+	schema, err := GenerateTableSchema("connections", struct {
+		ID      string    `sqlite:"id,primary"`
+		Port    int       `sqlite:"port"`
+		Started time.Time `sqlite:"started,text,time"`
+		Ended   *string   `sqlite:"ended"`
+	}{})
+	require.NoError(t, err)
+
+	from := time.Date(2022, time.February, 15, 9, 51, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	testCases := []struct {
+		column   string
+		operator string
+		value    interface{}
+		sql      string
+		args     []interface{}
+	}{
+		{"port", "=", 53, "port = ?", []interface{}{53}},
+		{"port", "in", []int{53}, "port IN ( ? )", []interface{}{53}},
+		{"port", "IN", []int{53, 80, 443}, "port IN ( ?, ?, ? )", []interface{}{53, 80, 443}},
+		{"port", "NOT IN", []interface{}{80, 443}, "port NOT IN ( ?, ? )", []interface{}{80, 443}},
+		{"started", "BETWEEN", []time.Time{from, to}, "started BETWEEN ? AND ?", []interface{}{"2022-02-15 09:51:00", "2022-02-15 10:51:00"}},
+		{"id", "LIKE", "%.example.com.", "id LIKE ?", []interface{}{"%.example.com."}},
+		{"ended", "is null", nil, "ended IS NULL", nil},
+	}
+	for _, tc := range testCases {
+		sql, args, err := schema.Condition(tc.column, tc.operator, tc.value, DefaultEncodeConfig)
+		if assert.NoError(t, err, tc.sql) {
+			assert.Equal(t, tc.sql, sql)
+			assert.Equal(t, tc.args, args, tc.sql)
+		}
+	}
+}
+
+func TestConditionInvalid(t *testing.T) {
+	t.Parallel()
+
+	schema, err := GenerateTableSchema("connections", struct {
+		ID   string `sqlite:"id,primary"`
+		Port int    `sqlite:"port"`
+	}{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		column   string
+		operator string
+		value    interface{}
+	}{
+		{"unknown", "=", 1},
+		{"port = 1 OR 1", "=", 1},
+		{"port", "==", 1},
+		{"port", "; DROP TABLE connections; --", 1},
+		{"port", "IN", 1},
+		{"port", "IN", []int{}},
+		{"port", "BETWEEN", []int{1}},
+		{"port", "BETWEEN", []int{1, 2, 3}},
+		{"port", "IS NULL", 1},
+	}
+	for _, tc := range testCases {
+		sql, args, err := schema.Condition(tc.column, tc.operator, tc.value, DefaultEncodeConfig)
+		assert.Error(t, err, "%s %s %v", tc.column, tc.operator, tc.value)
+		assert.Empty(t, sql)
+		assert.Empty(t, args)
+	}
+}
@@ -3,6 +3,7 @@
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -100,31 +101,30 @@ func DecodeStmt(ctx context.Context, schema *TableSchema, stmt Stmt, result inte
 
 	// create a lookup map from field name (or sqlite:"" tag)
 	// to the field name
-	lm := make(map[string]string)
-	for i := 0; i < target.NumField(); i++ {
-		fieldType := t.Field(i)
-
-		// skip unexported fields
-		if !fieldType.IsExported() {
+	fields, err := modelFields(t)
+	if err != nil {
+		return err
+	}
+	lm := make(map[string]reflect.StructField, len(fields))
+	for _, fieldType := range fields {
+		// skip table markers
+		if fieldType.Type == tableMarkerType {
 			continue
 		}
 
-		lm[sqlColumnName(fieldType)] = fieldType.Name
+		lm[sqlColumnName(fieldType)] = fieldType
 	}
 
 	// iterate over all columns and assign them to the correct
 	// fields
 	for i := 0; i < stmt.ColumnCount(); i++ {
 		colName := stmt.ColumnName(i)
-		fieldName, ok := lm[colName]
+		fieldType, ok := lm[colName]
 		if !ok {
 			// there's no target field for this column
 			// so we can skip it
 			continue
 		}
-		fieldType, _ := t.FieldByName(fieldName)
-
-		value := target.FieldByName(fieldName)
 
 		colType := stmt.ColumnType(i)
 
@@ -135,6 +135,8 @@ func DecodeStmt(ctx context.Context, schema *TableSchema, stmt Stmt, result inte
 			continue
 		}
 
+		value := fieldByIndexAlloc(target, fieldType.Index)
+
 		// if value is a nil pointer we need to allocate some memory
 		// first
 		if getKind(value) == reflect.Ptr && value.IsNil() {
@@ -157,7 +159,7 @@ func DecodeStmt(ctx context.Context, schema *TableSchema, stmt Stmt, result inte
 			stmt,
 			fieldType,
 			value,
-			append(cfg.DecodeHooks, decodeBasic()),
+			append(cfg.DecodeHooks, decodeJSON(), decodeScanner(), decodeBasic()),
 		)
 		if err != nil {
 			return err
@@ -170,7 +172,7 @@ func DecodeStmt(ctx context.Context, schema *TableSchema, stmt Stmt, result inte
 		}
 
 		// Debugging:
-		// log.Printf("valueTypeName: %s fieldName = %s value-orig = %s value = %s (%v) newValue = %s", value.Type().String(), fieldName, target.FieldByName(fieldName).Type(), value.Type(), value, columnValue)
+		// log.Printf("valueTypeName: %s fieldName = %s value = %s (%v) newValue = %s", value.Type().String(), fieldType.Name, value.Type(), value, columnValue)
 
 		// convert it to the target type if conversion is possible
 		newValue := reflect.ValueOf(columnValue)
@@ -266,7 +268,7 @@ func decodeIntoMap(_ context.Context, schema *TableSchema, stmt Stmt, mp *map[st
 			stmt,
 			fieldType,
 			outVal,
-			append(cfg.DecodeHooks, decodeBasic()),
+			append(cfg.DecodeHooks, decodeJSON(), decodeScanner(), decodeBasic()),
 		)
 		if err != nil {
 			return fmt.Errorf("failed to decode column %s: %w", stmt.ColumnName(i), err)
@@ -278,6 +280,41 @@ func decodeIntoMap(_ context.Context, schema *TableSchema, stmt Stmt, mp *map[st
 	return nil
 }
 
+// decodeJSON decodes the JSON text of JSON columns into the Go type of the
+// column.
+func decodeJSON() DecodeFunc {
+	return func(colIdx int, colDef *ColumnDef, stmt Stmt, fieldDef reflect.StructField, outval reflect.Value) (interface{}, bool, error) {
+		// without a schema, fall back to the struct field tag
+		if colDef == nil && fieldDef.Tag != "" {
+			colDef, _ = getColumnDef(fieldDef)
+		}
+		if colDef == nil || !colDef.IsJSON {
+			return nil, false, nil
+		}
+
+		var blob []byte
+		switch colType := stmt.ColumnType(colIdx); colType { //nolint:exhaustive
+		case sqlite.TypeNull:
+			return nil, true, nil
+		case sqlite.TypeText:
+			blob = []byte(stmt.ColumnText(colIdx))
+		case sqlite.TypeBlob:
+			var err error
+			if blob, err = io.ReadAll(stmt.ColumnReader(colIdx)); err != nil {
+				return nil, false, fmt.Errorf("failed to read blob for column %s: %w", stmt.ColumnName(colIdx), err)
+			}
+		default:
+			return nil, false, fmt.Errorf("%w %s for JSON column %s", errUnexpectedColumnType, colType.String(), stmt.ColumnName(colIdx))
+		}
+
+		target := reflect.New(colDef.GoType)
+		if err := json.Unmarshal(blob, target.Interface()); err != nil {
+			return nil, false, fmt.Errorf("failed to decode JSON of column %s: %w", stmt.ColumnName(colIdx), err)
+		}
+		return target.Elem().Interface(), true, nil
+	}
+}
+
 func decodeBasic() DecodeFunc {
 	return func(colIdx int, colDef *ColumnDef, stmt Stmt, fieldDef reflect.StructField, outval reflect.Value) (result interface{}, handled bool, err error) {
 		valueKind := getKind(outval)
@@ -331,10 +368,20 @@ func decodeBasic() DecodeFunc {
 
 		switch valueKind { //nolint:exhaustive
 		case reflect.String:
-			if colType != sqlite.TypeText {
+			switch colType { //nolint:exhaustive
+			case sqlite.TypeText:
+				return stmt.ColumnText(colIdx), true, nil
+
+			case sqlite.TypeBlob:
+				columnValue, err := io.ReadAll(stmt.ColumnReader(colIdx))
+				if err != nil {
+					return nil, false, fmt.Errorf("failed to read blob for column %s: %w", fieldDef.Name, err)
+				}
+				return string(columnValue), true, nil
+
+			default:
 				return nil, false, errInvalidType
 			}
-			return stmt.ColumnText(colIdx), true, nil
 
 		case reflect.Bool:
 			// sqlite does not have a BOOL type, it rather stores a 1/0 in a column
@@ -422,6 +469,21 @@ func decodeBasic() DecodeFunc {
 	}
 }
 
+// fieldByIndexAlloc returns the nested field of v with the given index
+// sequence, allocating nil pointers to embedded structs on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
 func sqlColumnName(fieldType reflect.StructField) string {
 	tagValue, hasTag := fieldType.Tag.Lookup("sqlite")
 	if !hasTag {
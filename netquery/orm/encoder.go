@@ -2,6 +2,8 @@
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -21,7 +23,9 @@
 
 // ToParamMap returns a map that contains the sqlite compatible value of each struct field of
 // r using the sqlite column name as a map key. It either uses the name of the
-// exported struct field or the value of the "sqlite" tag.
+// exported struct field or the value of the "sqlite" tag. Generated columns
+// are skipped, as they cannot be written. The fields of embedded structs are
+// flattened like GenerateTableSchema does.
 func ToParamMap(ctx context.Context, r interface{}, keyPrefix string, cfg EncodeConfig) (map[string]interface{}, error) {
 	// make sure we work on a struct type
 	val := reflect.Indirect(reflect.ValueOf(r))
@@ -29,28 +33,50 @@ func ToParamMap(ctx context.Context, r interface{}, keyPrefix string, cfg Encode
 		return nil, fmt.Errorf("%w, got %T", errStructExpected, r)
 	}
 
-	res := make(map[string]interface{}, val.NumField())
+	fields, err := modelFields(val.Type())
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < val.NumField(); i++ {
-		fieldType := val.Type().Field(i)
-		field := val.Field(i)
+	res := make(map[string]interface{}, len(fields))
 
-		// skip unexported fields
-		if !fieldType.IsExported() {
-			continue
+	for _, fieldType := range fields {
+		// fields of nil embedded structs are encoded as their zero value
+		field, err := val.FieldByIndexErr(fieldType.Index)
+		if err != nil {
+			field = reflect.Zero(fieldType.Type)
 		}
 
 		colDef, err := getColumnDef(fieldType)
 		if err != nil {
+			if errors.Is(err, errSkipStructField) {
+				continue
+			}
+
 			return nil, fmt.Errorf("failed to get column definition for %s: %w", fieldType.Name, err)
 		}
 
+		// generated columns cannot be written
+		if colDef.Generated != "" {
+			continue
+		}
+
+		valType, value := fieldType.Type, field
+		if valType.Kind() == reflect.Interface && !colDef.IsJSON {
+			valType, value, err = interfaceValue(colDef, field)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s: %w", fieldType.Name, err)
+			}
+		}
+
 		x, found, err := runEncodeHooks(
 			colDef,
-			fieldType.Type,
-			field,
+			valType,
+			value,
 			append(
 				cfg.EncodeHooks,
+				encodeJSON(),
+				encodeValuer(),
 				encodeBasic(),
 			),
 		)
@@ -82,6 +108,8 @@ func EncodeValue(ctx context.Context, colDef *ColumnDef, val interface{}, cfg En
 		fieldValue,
 		append(
 			cfg.EncodeHooks,
+			encodeJSON(),
+			encodeValuer(),
 			encodeBasic(),
 		),
 	)
@@ -98,6 +126,54 @@ func EncodeValue(ctx context.Context, colDef *ColumnDef, val interface{}, cfg En
 	return x, nil
 }
 
+// interfaceValue returns the type and value stored in the interface field.
+// A nil interface is returned without a type, so that it is stored as NULL,
+// or as the zero value if the column is not nullable. Values of REAL columns
+// must be numeric.
+func interfaceValue(col *ColumnDef, field reflect.Value) (reflect.Type, reflect.Value, error) {
+	if field.IsNil() {
+		return nil, reflect.Value{}, nil
+	}
+	elem := field.Elem()
+
+	if col.Type == sqlite.TypeFloat {
+		kind := elem.Kind()
+		if kind == reflect.Ptr {
+			kind = elem.Type().Elem().Kind()
+		}
+		switch normalizeKind(kind) { //nolint:exhaustive
+		case reflect.Float64, reflect.Int, reflect.Uint:
+		default:
+			return nil, reflect.Value{}, fmt.Errorf("cannot store value of type %s in REAL column %s", elem.Type(), col.Name)
+		}
+	}
+
+	return elem.Type(), elem, nil
+}
+
+// encodeJSON encodes values of JSON columns as JSON text. Nil values are
+// stored as NULL if the column is nullable.
+func encodeJSON() EncodeFunc {
+	return func(col *ColumnDef, valType reflect.Type, val reflect.Value) (interface{}, bool, error) {
+		if !col.IsJSON {
+			return nil, false, nil
+		}
+
+		switch val.Kind() { //nolint:exhaustive
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			if val.IsNil() && col.Nullable {
+				return nil, true, nil
+			}
+		}
+
+		blob, err := json.Marshal(val.Interface())
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to encode %s as JSON: %w", col.Name, err)
+		}
+		return string(blob), true, nil
+	}
+}
+
 func encodeBasic() EncodeFunc {
 	return func(col *ColumnDef, valType reflect.Type, val reflect.Value) (interface{}, bool, error) {
 		kind := valType.Kind()
@@ -109,6 +185,9 @@ func encodeBasic() EncodeFunc {
 				if !col.Nullable {
 					// we need to set the zero value here since the column
 					// is not marked as nullable
+					if kind == reflect.Bool {
+						return 0, true, nil
+					}
 					return reflect.New(valType).Elem().Interface(), true, nil
 				}
 
@@ -119,19 +198,39 @@ func encodeBasic() EncodeFunc {
 		}
 
 		switch normalizeKind(kind) { //nolint:exhaustive
-		case reflect.String,
-			reflect.Float64,
-			reflect.Bool,
+		case reflect.String:
+			if col.Type == sqlite.TypeBlob {
+				// store the raw bytes so the value keeps the BLOB
+				// storage class
+				return []byte(val.String()), true, nil
+			}
+			return val.Interface(), true, nil
+
+		case reflect.Float64,
 			reflect.Int,
 			reflect.Uint:
 			// sqlite package handles conversion of those types
 			// already
 			return val.Interface(), true, nil
 
+		case reflect.Bool:
+			// sqlite does not have a BOOL type, store true/false as 1/0
+			if val.Bool() {
+				return 1, true, nil
+			}
+			return 0, true, nil
+
 		case reflect.Slice:
 			if valType.Elem().Kind() == reflect.Uint8 {
-				// this is []byte
-				return val.Interface(), true, nil
+				// this is []byte. A nil slice is stored as NULL if the
+				// column is nullable and as an empty blob otherwise.
+				if val.IsNil() {
+					if col.Nullable {
+						return nil, true, nil
+					}
+					return []byte{}, true, nil
+				}
+				return val.Bytes(), true, nil
 			}
 			fallthrough
 
@@ -40,6 +40,44 @@ func TestEncodeAsMap(t *testing.T) { //nolint:tparallel
 				"B": ([]byte)("bytes"),
 			},
 		},
+		{
+			"Encode bool as integer",
+			struct {
+				T       bool
+				F       bool
+				Ptr     *bool
+				Nil     *bool
+				NotNull *bool `sqlite:",not-null"`
+			}{
+				T:   true,
+				Ptr: func() *bool { b := true; return &b }(),
+			},
+			map[string]interface{}{
+				"T":       1,
+				"F":       0,
+				"Ptr":     1,
+				"Nil":     nil,
+				"NotNull": 0,
+			},
+		},
+		{
+			"Encode blobs",
+			struct {
+				B       []byte
+				Nil     []byte `sqlite:",nullable"`
+				NotNull []byte
+				S       string `sqlite:",blob"`
+			}{
+				B: ([]byte)("bytes"),
+				S: "string",
+			},
+			map[string]interface{}{
+				"B":       ([]byte)("bytes"),
+				"Nil":     nil,
+				"NotNull": []byte{},
+				"S":       ([]byte)("string"),
+			},
+		},
 		{
 			"Encode using struct tags",
 			struct {
@@ -67,6 +105,28 @@ func TestEncodeAsMap(t *testing.T) { //nolint:tparallel
 				"I": 1,
 			},
 		},
+		{
+			"Ignore table markers",
+			markerModel{
+				ID: 1,
+			},
+			map[string]interface{}{
+				"id": 1,
+			},
+		},
+		{
+			"Skip generated columns",
+			struct {
+				A int
+				B int `sqlite:",generated:A * 2,stored"`
+			}{
+				A: 1,
+				B: 2,
+			},
+			map[string]interface{}{
+				"A": 1,
+			},
+		},
 		{
 			"Handle Pointers",
 			struct {
@@ -0,0 +1,234 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/safing/portbase/log"
+)
+
+// MaxInsertVariables is the maximum number of variables used in a single
+// statement built by BuildInsert. It defaults to the limit of SQLite versions
+// prior to 3.32.0, which raised the limit to 32766.
+var MaxInsertVariables = 999
+
+// InsertStatement is a SQL statement with its positional arguments.
+type InsertStatement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// BuildInsert builds multi-row INSERT statements for the given models, which
+// must all be of the same struct type. The models are split into multiple
+// statements in order to stay below MaxInsertVariables. AUTOINCREMENT and
+// generated columns are skipped. Values are encoded using DefaultEncodeConfig.
+// The statements are returned in the order of the models.
+func BuildInsert(tableName string, models []interface{}) ([]InsertStatement, error) {
+	if len(models) == 0 {
+		return nil, nil
+	}
+
+	schema, err := GenerateTableSchema(tableName, models[0])
+	if err != nil {
+		return nil, err
+	}
+	modelType := reflect.Indirect(reflect.ValueOf(models[0])).Type()
+
+	columns, err := insertColumns(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rowsPerStatement = MaxInsertVariables / len(columns)
+		placeholder      = rowPlaceholder(len(columns))
+		prefix           = "INSERT INTO " + quoteIdentifier(schema.Name) + " (" + strings.Join(quoteIdentifiers(columns, quoteIdentifier), ", ") + ") VALUES "
+		statements       = make([]InsertStatement, 0, len(models)/rowsPerStatement+1)
+	)
+	for start := 0; start < len(models); start += rowsPerStatement {
+		end := start + rowsPerStatement
+		if end > len(models) {
+			end = len(models)
+		}
+
+		stmt := InsertStatement{
+			Args: make([]interface{}, 0, (end-start)*len(columns)),
+		}
+		placeholders := make([]string, 0, end-start)
+		for idx, model := range models[start:end] {
+			if val := reflect.Indirect(reflect.ValueOf(model)); !val.IsValid() || val.Type() != modelType {
+				return nil, fmt.Errorf("model at index %d is of type %T, expected %s", start+idx, model, modelType)
+			}
+
+			values, err := encodeRow(model, columns)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode model at index %d: %w", start+idx, err)
+			}
+			stmt.Args = append(stmt.Args, values...)
+			placeholders = append(placeholders, placeholder)
+		}
+		stmt.SQL = prefix + strings.Join(placeholders, ", ") + ";"
+
+		statements = append(statements, stmt)
+	}
+
+	return statements, nil
+}
+
+// BuildUpsert builds an INSERT statement for model that updates the existing
+// row if inserting conflicts with the unique constraint on conflictCols.
+// The updateCols are set to the values of model. If updateCols is empty, all
+// inserted columns that are neither conflict nor primary key columns are
+// updated. If there is nothing to update, the conflict is ignored, like
+// BuildInsertOrIgnore does.
+func BuildUpsert(tableName string, model interface{}, conflictCols []string, updateCols []string) (InsertStatement, error) {
+	return buildUpsert(tableName, model, conflictCols, updateCols, false)
+}
+
+// BuildInsertOrIgnore builds an INSERT statement for model that does nothing
+// if inserting conflicts with the unique constraint on conflictCols. If
+// conflictCols is empty, conflicts with any constraint are ignored.
+func BuildInsertOrIgnore(tableName string, model interface{}, conflictCols []string) (InsertStatement, error) {
+	return buildUpsert(tableName, model, conflictCols, nil, true)
+}
+
+func buildUpsert(tableName string, model interface{}, conflictCols, updateCols []string, doNothing bool) (InsertStatement, error) {
+	schema, err := GenerateTableSchema(tableName, model)
+	if err != nil {
+		return InsertStatement{}, err
+	}
+	columns, err := insertColumns(schema)
+	if err != nil {
+		return InsertStatement{}, err
+	}
+
+	for _, col := range conflictCols {
+		if schema.GetColumnDef(col) == nil {
+			return InsertStatement{}, fmt.Errorf("unknown conflict column %s in table %s", col, schema.Name)
+		}
+	}
+	if len(conflictCols) == 0 && !doNothing {
+		return InsertStatement{}, fmt.Errorf("conflict columns are required to update table %s", schema.Name)
+	}
+	if len(conflictCols) > 0 && !schema.hasUniqueConstraint(conflictCols) {
+		// the constraint might still be created manually
+		log.Warningf("orm: conflict columns (%s) do not match a unique constraint of table %s", strings.Join(conflictCols, ", "), schema.Name)
+	}
+
+	if !doNothing {
+		if len(updateCols) == 0 {
+			for _, col := range columns {
+				if !slices.Contains(conflictCols, col) && !schema.GetColumnDef(col).PrimaryKey {
+					updateCols = append(updateCols, col)
+				}
+			}
+		}
+		for _, col := range updateCols {
+			if !slices.Contains(columns, col) {
+				return InsertStatement{}, fmt.Errorf("column %s of table %s cannot be updated", col, schema.Name)
+			}
+		}
+		doNothing = len(updateCols) == 0
+	}
+
+	values, err := encodeRow(model, columns)
+	if err != nil {
+		return InsertStatement{}, fmt.Errorf("failed to encode model: %w", err)
+	}
+
+	sql := "INSERT INTO " + quoteIdentifier(schema.Name) + " (" + strings.Join(quoteIdentifiers(columns, quoteIdentifier), ", ") + ") VALUES " + rowPlaceholder(len(columns)) + " ON CONFLICT"
+	if len(conflictCols) > 0 {
+		sql += "(" + strings.Join(quoteIdentifiers(conflictCols, quoteIdentifier), ", ") + ")"
+	}
+	if doNothing {
+		sql += " DO NOTHING;"
+	} else {
+		assignments := make([]string, 0, len(updateCols))
+		for _, col := range updateCols {
+			col = quoteIdentifier(col)
+			assignments = append(assignments, col+" = excluded."+col)
+		}
+		sql += " DO UPDATE SET " + strings.Join(assignments, ", ") + ";"
+	}
+
+	return InsertStatement{
+		SQL:  sql,
+		Args: values,
+	}, nil
+}
+
+// hasUniqueConstraint returns whether the table has a primary key, unique
+// column or unique index on exactly the given columns.
+func (ts TableSchema) hasUniqueConstraint(columns []string) bool {
+	sameColumns := func(other []string) bool {
+		if len(other) != len(columns) {
+			return false
+		}
+		for _, col := range other {
+			if !slices.Contains(columns, col) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(columns) == 1 {
+		if col := ts.GetColumnDef(columns[0]); col != nil && col.Unique {
+			return true
+		}
+	}
+
+	if primaryKey, err := ts.primaryKey(); err == nil && sameColumns(primaryKey) {
+		return true
+	}
+
+	for _, idx := range ts.Indexes {
+		if idx.Unique && sameColumns(idx.Columns) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// insertColumns returns the columns of the table that are written when
+// inserting rows, skipping AUTOINCREMENT and generated columns.
+func insertColumns(schema *TableSchema) ([]string, error) {
+	columns := make([]string, 0, len(schema.Columns))
+	for _, col := range schema.Columns {
+		if col.AutoIncrement || col.Generated != "" {
+			continue
+		}
+		columns = append(columns, col.Name)
+	}
+
+	switch {
+	case len(columns) == 0:
+		return nil, fmt.Errorf("table %s has no columns to insert", schema.Name)
+	case len(columns) > MaxInsertVariables:
+		return nil, fmt.Errorf("table %s has more columns than allowed variables", schema.Name)
+	}
+	return columns, nil
+}
+
+// encodeRow returns the encoded values of the given columns of model.
+func encodeRow(model interface{}, columns []string) ([]interface{}, error) {
+	params, err := ToParamMap(context.Background(), model, "", DefaultEncodeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(columns))
+	for _, col := range columns {
+		values = append(values, params[col])
+	}
+	return values, nil
+}
+
+// rowPlaceholder returns the placeholders for a row with n values.
+func rowPlaceholder(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
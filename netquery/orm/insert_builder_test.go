@@ -0,0 +1,204 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+)
+
+type insertRow struct {
+	ID      int               `sqlite:"id,primary,autoincrement"`
+	Name    string            `sqlite:"name"`
+	Active  bool              `sqlite:"active"`
+	Started time.Time         `sqlite:"started,text,time"`
+	Labels  map[string]string `sqlite:"labels,json,nullable"`
+	Upper   string            `sqlite:"upper,generated:upper(name)"`
+}
+
+func TestBuildInsert(t *testing.T) {
+	t.Parallel()
+
+	started := time.Date(2022, time.February, 15, 9, 51, 0, 0, time.UTC)
+	statements, err := BuildInsert("rows", []interface{}{
+		insertRow{Name: "a", Active: true, Started: started},
+		&insertRow{Name: "b", Started: started, Labels: map[string]string{"k": "v"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, statements, 1)
+	assert.Equal(t, `INSERT INTO "rows" (name, active, started, labels) VALUES (?, ?, ?, ?), (?, ?, ?, ?);`, statements[0].SQL)
+	assert.Equal(t, []interface{}{
+		"a", 1, "2022-02-15 09:51:00", nil,
+		"b", 0, "2022-02-15 09:51:00", `{"k":"v"}`,
+	}, statements[0].Args)
+
+	// All models must have the same type.
+	_, err = BuildInsert("rows", []interface{}{insertRow{}, struct{ Name string }{}})
+	assert.Error(t, err)
+	_, err = BuildInsert("rows", []interface{}{insertRow{}, nil})
+	assert.Error(t, err)
+}
+
+func TestBuildInsertChunking(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	schema, err := GenerateTableSchema("rows", insertRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+
+	// With four columns per row, a statement holds at most 249 rows.
+	models := make([]interface{}, 0, 600)
+	for i := 0; i < 600; i++ {
+		models = append(models, insertRow{Name: fmt.Sprintf("row-%03d", i), Started: time.Now()})
+	}
+	statements, err := BuildInsert("rows", models)
+	require.NoError(t, err)
+	require.Len(t, statements, 3)
+	for _, stmt := range statements {
+		assert.LessOrEqual(t, len(stmt.Args), MaxInsertVariables)
+		require.NoError(t, RunQuery(ctx, conn, stmt.SQL, WithArgs(stmt.Args...)))
+	}
+	assert.Len(t, statements[0].Args, 249*4)
+	assert.Len(t, statements[2].Args, (600-2*249)*4)
+
+	// Rows are inserted in order.
+	var result []struct {
+		ID    int    `sqlite:"id"`
+		Name  string `sqlite:"name"`
+		Upper string `sqlite:"upper"`
+	}
+	require.NoError(t, RunQuery(ctx, conn, `SELECT id, name, upper FROM rows ORDER BY id`, WithResult(&result)))
+	require.Len(t, result, 600)
+	for i, row := range result {
+		assert.Equal(t, i+1, row.ID)
+		assert.Equal(t, fmt.Sprintf("row-%03d", i), row.Name)
+	}
+	assert.Equal(t, "ROW-599", result[599].Upper)
+}
+
+type upsertRow struct {
+	ID      string `sqlite:"id,primary"`
+	Email   string `sqlite:"email,unique"`
+	Tenant  string `sqlite:"tenant,unique:idx_tenant_handle"`
+	Handle  string `sqlite:"handle,unique:idx_tenant_handle"`
+	Name    string `sqlite:"name"`
+	Visits  int    `sqlite:"visits"`
+	Display string `sqlite:"display,generated:upper(name)"`
+}
+
+func TestBuildUpsert(t *testing.T) {
+	t.Parallel()
+
+	row := upsertRow{ID: "1", Email: "a@example.com", Tenant: "t", Handle: "h", Name: "a", Visits: 1}
+
+	stmt, err := BuildUpsert("users", row, []string{"id"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`INSERT INTO users (id, email, tenant, handle, name, visits) VALUES (?, ?, ?, ?, ?, ?)`+
+			` ON CONFLICT(id) DO UPDATE SET email = excluded.email, tenant = excluded.tenant, handle = excluded.handle, name = excluded.name, visits = excluded.visits;`,
+		stmt.SQL,
+	)
+	assert.Equal(t, []interface{}{"1", "a@example.com", "t", "h", "a", 1}, stmt.Args)
+
+	stmt, err = BuildUpsert("users", row, []string{"handle", "tenant"}, []string{"name"})
+	require.NoError(t, err)
+	assert.Equal(t,
+		`INSERT INTO users (id, email, tenant, handle, name, visits) VALUES (?, ?, ?, ?, ?, ?)`+
+			` ON CONFLICT(handle, tenant) DO UPDATE SET name = excluded.name;`,
+		stmt.SQL,
+	)
+
+	for _, c := range []struct {
+		conflict []string
+		update   []string
+	}{
+		{nil, nil},
+		{[]string{"unknown"}, nil},
+		{[]string{"id"}, []string{"unknown"}},
+		{[]string{"id"}, []string{"display"}},
+	} {
+		_, err := BuildUpsert("users", row, c.conflict, c.update)
+		assert.Error(t, err, "%v", c)
+	}
+}
+
+func TestBuildInsertOrIgnore(t *testing.T) {
+	t.Parallel()
+
+	row := upsertRow{ID: "1", Email: "a@example.com", Name: "a"}
+
+	stmt, err := BuildInsertOrIgnore("users", row, []string{"email"})
+	require.NoError(t, err)
+	assert.Equal(t,
+		`INSERT INTO users (id, email, tenant, handle, name, visits) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(email) DO NOTHING;`,
+		stmt.SQL,
+	)
+
+	stmt, err = BuildInsertOrIgnore("users", row, nil)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`INSERT INTO users (id, email, tenant, handle, name, visits) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING;`,
+		stmt.SQL,
+	)
+
+	// Upserts without columns to update do nothing, too.
+	stmt, err = BuildUpsert("ids", struct {
+		ID string `sqlite:"id,primary"`
+	}{ID: "1"}, []string{"id"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO ids (id) VALUES (?) ON CONFLICT(id) DO NOTHING;`, stmt.SQL)
+}
+
+func TestUpsertExecution(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	schema, err := GenerateTableSchema("users", upsertRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+	for _, stmt := range schema.CreateIndexStatements() {
+		require.NoError(t, RunQuery(ctx, conn, stmt))
+	}
+
+	for _, row := range []upsertRow{
+		{ID: "1", Email: "a@example.com", Tenant: "t", Handle: "a", Name: "first", Visits: 1},
+		{ID: "1", Email: "a@example.com", Tenant: "t", Handle: "a", Name: "second", Visits: 2},
+	} {
+		stmt, err := BuildUpsert("users", row, []string{"id"}, nil)
+		require.NoError(t, err)
+		require.NoError(t, RunQuery(ctx, conn, stmt.SQL, WithArgs(stmt.Args...)))
+	}
+
+	// Ignored conflicts keep the existing row.
+	stmt, err := BuildInsertOrIgnore("users", upsertRow{ID: "2", Email: "a@example.com", Name: "ignored"}, []string{"email"})
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, stmt.SQL, WithArgs(stmt.Args...)))
+
+	var result []upsertRow
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM users`, WithResult(&result)))
+	require.Len(t, result, 1)
+	assert.Equal(t, "second", result[0].Name)
+	assert.Equal(t, 2, result[0].Visits)
+	assert.Equal(t, "SECOND", result[0].Display)
+}
@@ -0,0 +1,259 @@
+package orm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+)
+
+func TestBlobRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type blobRow struct {
+		ID    int    `sqlite:"id,primary"`
+		Data  []byte `sqlite:"data"`
+		Null  []byte `sqlite:"null_data,nullable"`
+		Empty []byte `sqlite:"empty_data"`
+		Text  string `sqlite:"text,blob"`
+		Count int    `sqlite:"count,blob"`
+	}
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	schema, err := GenerateTableSchema("blobs", blobRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+
+	// Include bytes that would not survive a round trip through TEXT.
+	input := blobRow{
+		ID:    1,
+		Data:  []byte{0x00, 0xff, 0x10, 'a'},
+		Text:  "raw text",
+		Count: 42,
+	}
+	params, err := ToParamMap(ctx, input, ":", DefaultEncodeConfig)
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn,
+		`INSERT INTO blobs (id, data, null_data, empty_data, text, count) VALUES (:id, :data, :null_data, :empty_data, :text, :count)`,
+		WithNamedArgs(params),
+	))
+
+	// Blobs must be stored as is, without any encoding.
+	var types []struct {
+		Data  string `sqlite:"data"`
+		Null  string `sqlite:"null_data"`
+		Empty string `sqlite:"empty_data"`
+		Text  string `sqlite:"text"`
+		Hex   string `sqlite:"hex"`
+	}
+	require.NoError(t, RunQuery(ctx, conn,
+		`SELECT typeof(data) AS data, typeof(null_data) AS null_data, typeof(empty_data) AS empty_data, typeof(text) AS text, hex(data) AS hex FROM blobs`,
+		WithResult(&types),
+	))
+	require.Len(t, types, 1)
+	assert.Equal(t, "blob", types[0].Data)
+	assert.Equal(t, "null", types[0].Null)
+	assert.Equal(t, "blob", types[0].Empty)
+	assert.Equal(t, "blob", types[0].Text)
+	assert.Equal(t, "00FF1061", types[0].Hex)
+
+	var result []blobRow
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM blobs`, WithResult(&result)))
+	require.Len(t, result, 1)
+	assert.Equal(t, input.Data, result[0].Data)
+	assert.Nil(t, result[0].Null)
+	assert.NotNil(t, result[0].Empty)
+	assert.Empty(t, result[0].Empty)
+	assert.Equal(t, input.Text, result[0].Text)
+	assert.Equal(t, input.Count, result[0].Count)
+}
+
+func TestInterfaceFloatRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type floatRow struct {
+		ID    int         `sqlite:"id,primary"`
+		Float interface{} `sqlite:"float,float,nullable"`
+	}
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	schema, err := GenerateTableSchema("floats", floatRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+
+	// nil is stored as NULL and numbers as REAL.
+	for _, input := range []floatRow{
+		{ID: 1, Float: nil},
+		{ID: 2, Float: 3.14},
+		{ID: 3, Float: float32(2)},
+		{ID: 4, Float: func() *float64 { f := 1.5; return &f }()},
+	} {
+		params, err := ToParamMap(ctx, input, ":", DefaultEncodeConfig)
+		require.NoError(t, err)
+		require.NoError(t, RunQuery(ctx, conn, `INSERT INTO floats (id, float) VALUES (:id, :float)`, WithNamedArgs(params)))
+	}
+
+	var types []struct {
+		Type  string   `sqlite:"type"`
+		Value *float64 `sqlite:"value"`
+	}
+	require.NoError(t, RunQuery(ctx, conn,
+		`SELECT typeof(float) AS type, float AS value FROM floats ORDER BY id`,
+		WithResult(&types),
+	))
+	require.Len(t, types, 4)
+	assert.Equal(t, "null", types[0].Type)
+	assert.Nil(t, types[0].Value)
+	for i, expected := range []float64{3.14, 2, 1.5} {
+		assert.Equal(t, "real", types[i+1].Type)
+		if assert.NotNil(t, types[i+1].Value) {
+			assert.Equal(t, expected, *types[i+1].Value)
+		}
+	}
+
+	// Non-numeric values are rejected instead of being coerced.
+	_, err = ToParamMap(ctx, floatRow{ID: 5, Float: "3.14"}, ":", DefaultEncodeConfig)
+	assert.ErrorContains(t, err, "cannot store value of type string in REAL column float")
+	_, err = ToParamMap(ctx, floatRow{ID: 6, Float: true}, ":", DefaultEncodeConfig)
+	assert.Error(t, err)
+
+	// A nil interface of a NOT NULL column is stored as zero.
+	params, err := ToParamMap(ctx, struct {
+		Float interface{} `sqlite:"float,float"`
+	}{}, "", DefaultEncodeConfig)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, params["float"])
+}
+
+func TestEmbeddedStructRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type event struct {
+		ID int `sqlite:"id,primary"`
+		*Timestamps
+		Name string `sqlite:"name"`
+	}
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	schema, err := GenerateTableSchema("events", event{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+
+	// Nil embedded structs still encode all of their columns.
+	params, err := ToParamMap(ctx, event{ID: 2}, ":", DefaultEncodeConfig)
+	require.NoError(t, err)
+	assert.Contains(t, params, ":created")
+	assert.Nil(t, params[":updated"])
+
+	created := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	params, err = ToParamMap(ctx, event{ID: 1, Timestamps: &Timestamps{Created: created}, Name: "event"}, ":", DefaultEncodeConfig)
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, `INSERT INTO events (id, created, updated, name) VALUES (:id, :created, :updated, :name)`, WithNamedArgs(params)))
+
+	// Nil embedded structs are allocated when decoding their columns.
+	var events []event
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM events`, WithResult(&events), WithDecodeConfig(DefaultDecodeConfig)))
+	require.Len(t, events, 1)
+	if assert.NotNil(t, events[0].Timestamps) {
+		assert.True(t, created.Equal(events[0].Created))
+		assert.Nil(t, events[0].Updated)
+	}
+	assert.Equal(t, "event", events[0].Name)
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	type meta struct {
+		Name  string `json:"name"`
+		Ports []int  `json:"ports"`
+	}
+	type jsonRow struct {
+		ID      int               `sqlite:"id,primary"`
+		Labels  map[string]string `sqlite:"labels,json"`
+		Tags    []string          `sqlite:"tags,json,nullable"`
+		Meta    *meta             `sqlite:"meta,json-check"`
+		NilMeta *meta             `sqlite:"nil_meta,json"`
+	}
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	schema, err := GenerateTableSchema("json", jsonRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+
+	input := jsonRow{
+		ID:     1,
+		Labels: map[string]string{"env": "prod"},
+		Meta: &meta{
+			Name:  "web",
+			Ports: []int{80, 443},
+		},
+	}
+	params, err := ToParamMap(ctx, input, ":", DefaultEncodeConfig)
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn,
+		`INSERT INTO json (id, labels, tags, meta, nil_meta) VALUES (:id, :labels, :tags, :meta, :nil_meta)`,
+		WithNamedArgs(params),
+	))
+
+	// Nil values are stored as NULL.
+	var stored []struct {
+		Labels string  `sqlite:"labels"`
+		Tags   *string `sqlite:"tags"`
+		Meta   string  `sqlite:"meta"`
+	}
+	require.NoError(t, RunQuery(ctx, conn, `SELECT labels, tags, meta FROM json`, WithResult(&stored)))
+	require.Len(t, stored, 1)
+	assert.Equal(t, `{"env":"prod"}`, stored[0].Labels)
+	assert.Nil(t, stored[0].Tags)
+	assert.Equal(t, `{"name":"web","ports":[80,443]}`, stored[0].Meta)
+
+	var result []jsonRow
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM json`, WithResult(&result), WithSchema(*schema)))
+	require.Len(t, result, 1)
+	assert.Equal(t, input, result[0])
+
+	// Decoding also works without a schema.
+	result = nil
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM json`, WithResult(&result)))
+	require.Len(t, result, 1)
+	assert.Equal(t, input, result[0])
+
+	// Columns with json-check reject invalid JSON.
+	assert.Error(t, RunQuery(ctx, conn, `UPDATE json SET meta = '{invalid'`))
+}
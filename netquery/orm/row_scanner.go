@@ -0,0 +1,248 @@
+package orm
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+	nullTimeType = reflect.TypeOf(sql.NullTime{})
+)
+
+// nullTypes maps the sql.Null* types to the sqlite type of their value.
+var nullTypes = map[reflect.Type]sqlite.ColumnType{
+	reflect.TypeOf(sql.NullBool{}):    sqlite.TypeInteger,
+	reflect.TypeOf(sql.NullByte{}):    sqlite.TypeInteger,
+	reflect.TypeOf(sql.NullInt16{}):   sqlite.TypeInteger,
+	reflect.TypeOf(sql.NullInt32{}):   sqlite.TypeInteger,
+	reflect.TypeOf(sql.NullInt64{}):   sqlite.TypeInteger,
+	reflect.TypeOf(sql.NullFloat64{}): sqlite.TypeFloat,
+	reflect.TypeOf(sql.NullString{}):  sqlite.TypeText,
+	nullTimeType:                      sqlite.TypeText,
+}
+
+// isCustomType returns whether t converts its values itself by implementing
+// driver.Valuer or sql.Scanner.
+func isCustomType(t reflect.Type) bool {
+	return t.Implements(valuerType) ||
+		reflect.PtrTo(t).Implements(valuerType) ||
+		reflect.PtrTo(t).Implements(scannerType)
+}
+
+// ScanRow decodes the current row of rows into the struct pointed to by
+// dest, using the same sqlite:"" struct tags and decoders as DecodeStmt.
+// NULL values leave pointer fields nil, and fields implementing sql.Scanner,
+// like sql.NullString, are scanned directly. Each column of rows must have a
+// matching struct field.
+func ScanRow(rows *sql.Rows, dest interface{}) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w, got %T", errStructPointerExpected, dest)
+	}
+
+	schema, err := GenerateTableSchema(destValue.Elem().Type().Name()+"_row", dest)
+	if err != nil {
+		return fmt.Errorf("failed to get schema of %T: %w", dest, err)
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to get columns: %w", err)
+	}
+	for _, col := range columns {
+		if schema.GetColumnDef(col) == nil {
+			return fmt.Errorf("column %s has no matching field in %T", col, dest)
+		}
+	}
+
+	values := make([]interface{}, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return fmt.Errorf("failed to scan row: %w", err)
+	}
+
+	return DecodeStmt(context.Background(), schema, rowStmt{
+		columns: columns,
+		values:  values,
+	}, dest, DefaultDecodeConfig)
+}
+
+// rowStmt provides a row scanned from sql.Rows as Stmt.
+type rowStmt struct {
+	columns []string
+	values  []interface{}
+}
+
+// Compile time check.
+var _ Stmt = rowStmt{}
+
+func (row rowStmt) ColumnCount() int          { return len(row.columns) }
+func (row rowStmt) ColumnName(i int) string   { return row.columns[i] }
+func (row rowStmt) ColumnBool(i int) bool     { return row.ColumnInt(i) != 0 }
+func (row rowStmt) ColumnFloat(i int) float64 { return asFloat(row.values[i]) }
+
+func (row rowStmt) ColumnType(i int) sqlite.ColumnType {
+	switch row.values[i].(type) {
+	case int64, bool:
+		return sqlite.TypeInteger
+	case float64:
+		return sqlite.TypeFloat
+	case string, time.Time:
+		return sqlite.TypeText
+	case []byte:
+		return sqlite.TypeBlob
+	default:
+		return sqlite.TypeNull
+	}
+}
+
+func (row rowStmt) ColumnText(i int) string {
+	switch v := row.values[i].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(SqliteTimeFormat)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (row rowStmt) ColumnInt(i int) int {
+	switch v := row.values[i].(type) {
+	case int64:
+		return int(v)
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	default:
+		return int(asFloat(v))
+	}
+}
+
+func (row rowStmt) ColumnReader(i int) *bytes.Reader {
+	if b, ok := row.values[i].([]byte); ok {
+		return bytes.NewReader(b)
+	}
+	return bytes.NewReader([]byte(row.ColumnText(i)))
+}
+
+func asFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	default:
+		return 0
+	}
+}
+
+// decodeScanner decodes columns into types implementing sql.Scanner, like
+// sql.NullString.
+func decodeScanner() DecodeFunc {
+	return func(colIdx int, colDef *ColumnDef, stmt Stmt, fieldDef reflect.StructField, outval reflect.Value) (interface{}, bool, error) {
+		if !outval.IsValid() {
+			return nil, false, nil
+		}
+		outType := outval.Type()
+		if colDef != nil {
+			outType = colDef.GoType
+		}
+		if !reflect.PtrTo(outType).Implements(scannerType) {
+			return nil, false, nil
+		}
+
+		value, err := columnValue(stmt, colIdx)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// sql.NullTime cannot scan the TEXT format of time.Time columns
+		if text, ok := value.(string); ok && outType == nullTimeType {
+			if value, err = time.ParseInLocation(SqliteTimeFormat, text, time.UTC); err != nil {
+				return nil, false, fmt.Errorf("failed to parse %q in %s: %w", text, stmt.ColumnName(colIdx), err)
+			}
+		}
+
+		target := reflect.New(outType)
+		if err := target.Interface().(sql.Scanner).Scan(value); err != nil { //nolint:forcetypeassert
+			return nil, false, fmt.Errorf("failed to scan column %s: %w", stmt.ColumnName(colIdx), err)
+		}
+		return target.Elem().Interface(), true, nil
+	}
+}
+
+// encodeValuer encodes values implementing driver.Valuer, like
+// sql.NullString.
+func encodeValuer() EncodeFunc {
+	return func(col *ColumnDef, valType reflect.Type, val reflect.Value) (interface{}, bool, error) {
+		if !valType.Implements(valuerType) {
+			if valType.Kind() == reflect.Ptr || !reflect.PtrTo(valType).Implements(valuerType) {
+				return nil, false, nil
+			}
+			// Value has a pointer receiver, call it on a copy.
+			ptr := reflect.New(valType)
+			ptr.Elem().Set(val)
+			val = ptr
+		}
+		if val.Kind() == reflect.Ptr && val.IsNil() {
+			return nil, true, nil
+		}
+
+		value, err := val.Interface().(driver.Valuer).Value() //nolint:forcetypeassert
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get value of %s: %w", col.Name, err)
+		}
+		if t, ok := value.(time.Time); ok {
+			// store times in the same format as time.Time columns
+			return t.UTC().Format(SqliteTimeFormat), true, nil
+		}
+		if b, ok := value.(bool); ok {
+			if b {
+				return 1, true, nil
+			}
+			return 0, true, nil
+		}
+		return value, true, nil
+	}
+}
+
+// columnValue returns the value of the column as driver.Value.
+func columnValue(stmt Stmt, colIdx int) (driver.Value, error) {
+	switch stmt.ColumnType(colIdx) { //nolint:exhaustive
+	case sqlite.TypeInteger:
+		return int64(stmt.ColumnInt(colIdx)), nil
+	case sqlite.TypeFloat:
+		return stmt.ColumnFloat(colIdx), nil
+	case sqlite.TypeText:
+		return stmt.ColumnText(colIdx), nil
+	case sqlite.TypeBlob:
+		value, err := io.ReadAll(stmt.ColumnReader(colIdx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob for column %s: %w", stmt.ColumnName(colIdx), err)
+		}
+		return value, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,258 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+type scanRow struct {
+	ID       int               `sqlite:"id,primary"`
+	Name     string            `sqlite:"name"`
+	Active   bool              `sqlite:"active"`
+	Started  time.Time         `sqlite:"started,text,time"`
+	Ended    *time.Time        `sqlite:"ended,text,time"`
+	Labels   map[string]string `sqlite:"labels,json"`
+	Data     []byte            `sqlite:"data"`
+	Comment  *string           `sqlite:"comment"`
+	Nick     sql.NullString    `sqlite:"nick"`
+	Score    sql.NullInt64     `sqlite:"score"`
+	Verified sql.NullBool      `sqlite:"verified"`
+	Seen     sql.NullTime      `sqlite:"seen"`
+}
+
+func TestScanRow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	// every connection would open a new in-memory database
+	db.SetMaxOpenConns(1)
+
+	schema, err := GenerateTableSchema("scan", scanRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	assert.Contains(t, createSQL, "nick TEXT, score INTEGER, verified INTEGER, seen TEXT")
+	_, err = db.ExecContext(ctx, createSQL)
+	require.NoError(t, err)
+
+	started := time.Date(2022, time.February, 15, 9, 51, 0, 0, time.UTC)
+	comment := "comment"
+	rows := []scanRow{
+		{
+			ID:       1,
+			Name:     "full",
+			Active:   true,
+			Started:  started,
+			Ended:    &started,
+			Labels:   map[string]string{"env": "prod"},
+			Data:     []byte{0x00, 0xff},
+			Comment:  &comment,
+			Nick:     sql.NullString{String: "nick", Valid: true},
+			Score:    sql.NullInt64{Int64: 42, Valid: true},
+			Verified: sql.NullBool{Bool: false, Valid: true},
+			Seen:     sql.NullTime{Time: started, Valid: true},
+		},
+		{
+			ID:      2,
+			Name:    "nulls",
+			Started: started,
+			Labels:  map[string]string{},
+			Data:    []byte{},
+		},
+	}
+	for _, row := range rows {
+		params, err := ToParamMap(ctx, row, "", DefaultEncodeConfig)
+		require.NoError(t, err)
+
+		var args []interface{}
+		for name, value := range params {
+			args = append(args, sql.Named(name, value))
+		}
+		_, err = db.ExecContext(ctx,
+			`INSERT INTO scan (id, name, active, started, ended, labels, data, comment, nick, score, verified, seen)
+			VALUES (:id, :name, :active, :started, :ended, :labels, :data, :comment, :nick, :score, :verified, :seen)`,
+			args...,
+		)
+		require.NoError(t, err)
+	}
+
+	result, err := db.QueryContext(ctx, `SELECT * FROM scan ORDER BY id`)
+	require.NoError(t, err)
+	defer func() {
+		_ = result.Close()
+	}()
+
+	var scanned []scanRow
+	for result.Next() {
+		var row scanRow
+		require.NoError(t, ScanRow(result, &row))
+		scanned = append(scanned, row)
+	}
+	require.NoError(t, result.Err())
+	assert.Equal(t, rows, scanned)
+}
+
+func TestScanRowUnmatchedColumn(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	rows, err := db.QueryContext(ctx, `SELECT 1 AS id, 'x' AS unknown`)
+	require.NoError(t, err)
+	defer func() {
+		_ = rows.Close()
+	}()
+	require.True(t, rows.Next())
+
+	var row struct {
+		ID int `sqlite:"id"`
+	}
+	assert.ErrorContains(t, ScanRow(rows, &row), "column unknown has no matching field")
+}
+
+// hostPort is a custom type that is stored as "host:port" TEXT.
+type hostPort struct {
+	Host string
+	Port int
+}
+
+func (hp hostPort) Value() (driver.Value, error) {
+	return net.JoinHostPort(hp.Host, strconv.Itoa(hp.Port)), nil
+}
+
+func (hp *hostPort) Scan(value interface{}) error {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into hostPort", value)
+	}
+	host, port, err := net.SplitHostPort(text)
+	if err != nil {
+		return err
+	}
+	hp.Host = host
+	hp.Port, err = strconv.Atoi(port)
+	return err
+}
+
+// level is a custom integer type that has a pointer receiver Value method
+// and is stored as its name.
+type level int
+
+func (l *level) Value() (driver.Value, error) {
+	switch *l {
+	case 0:
+		return "low", nil
+	case 1:
+		return "high", nil
+	default:
+		return nil, fmt.Errorf("invalid level %d", *l)
+	}
+}
+
+func (l *level) Scan(value interface{}) error {
+	switch value {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return fmt.Errorf("invalid level %v", value)
+	}
+	return nil
+}
+
+func TestScanRowCustomTypes(t *testing.T) {
+	t.Parallel()
+
+	type customRow struct {
+		ID       int       `sqlite:"id,primary"`
+		Server   hostPort  `sqlite:"server,text"`
+		Fallback *hostPort `sqlite:"fallback,text"`
+		Level    level     `sqlite:"level,text"`
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	db.SetMaxOpenConns(1)
+
+	// The tagged type is used, even though level is an integer.
+	schema, err := GenerateTableSchema("custom", customRow{})
+	require.NoError(t, err)
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	assert.Contains(t, createSQL, "server TEXT NOT NULL, fallback TEXT, level TEXT NOT NULL")
+	_, err = db.ExecContext(ctx, createSQL)
+	require.NoError(t, err)
+
+	rows := []customRow{
+		{
+			ID:       1,
+			Server:   hostPort{Host: "::1", Port: 53},
+			Fallback: &hostPort{Host: "9.9.9.9", Port: 853},
+			Level:    1,
+		},
+		{
+			ID:     2,
+			Server: hostPort{Host: "127.0.0.1", Port: 53},
+		},
+	}
+	for _, row := range rows {
+		params, err := ToParamMap(ctx, row, "", DefaultEncodeConfig)
+		require.NoError(t, err)
+
+		var args []interface{}
+		for name, value := range params {
+			args = append(args, sql.Named(name, value))
+		}
+		_, err = db.ExecContext(ctx,
+			`INSERT INTO custom (id, server, fallback, level) VALUES (:id, :server, :fallback, :level)`,
+			args...,
+		)
+		require.NoError(t, err)
+	}
+
+	// Check that the values are stored as returned by Value.
+	var server, level string
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT server, level FROM custom WHERE id = 1`).Scan(&server, &level))
+	assert.Equal(t, "[::1]:53", server)
+	assert.Equal(t, "high", level)
+
+	result, err := db.QueryContext(ctx, `SELECT * FROM custom ORDER BY id`)
+	require.NoError(t, err)
+	defer func() {
+		_ = result.Close()
+	}()
+
+	var scanned []customRow
+	for result.Next() {
+		var row customRow
+		require.NoError(t, ScanRow(result, &row))
+		scanned = append(scanned, row)
+	}
+	require.NoError(t, result.Err())
+	assert.Equal(t, rows, scanned)
+}
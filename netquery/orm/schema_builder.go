@@ -4,14 +4,44 @@
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"zombiezen.com/go/sqlite"
 )
 
 var errSkipStructField = errors.New("struct field should be skipped")
 
+var (
+	tableMarkerType = reflect.TypeOf(TableMarker{})
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+var (
+	numericLiteral  = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+	referenceTarget = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\(([A-Za-z_][A-Za-z0-9_]*)\)$`)
+)
+
+// foreignKeyActions holds the allowed actions of ON DELETE and ON UPDATE
+// clauses.
+var foreignKeyActions = map[string]bool{
+	"SET NULL":    true,
+	"SET DEFAULT": true,
+	"CASCADE":     true,
+	"RESTRICT":    true,
+	"NO ACTION":   true,
+}
+
+// collations holds the names of the built-in collating sequences of SQLite.
+var collations = map[string]bool{
+	"BINARY": true,
+	"NOCASE": true,
+	"RTRIM":  true,
+}
+
 // Struct Tags.
 var (
 	TagUnixNano          = "unixnano"
@@ -25,6 +55,21 @@
 	TagTypePrefixVarchar = "varchar"
 	TagTypeBlob          = "blob"
 	TagTypeFloat         = "float"
+	TagIndex             = "index"
+	TagUnique            = "unique"
+	TagDefault           = "default"
+	TagReferences        = "references"
+	TagOnDelete          = "on-delete"
+	TagOnUpdate          = "on-update"
+	TagJSON              = "json"
+	TagJSONCheck         = "json-check"
+	TagEnum              = "enum"
+	TagGenerated         = "generated"
+	TagStored            = "stored"
+	TagVirtual           = "virtual"
+	TagOrder             = "order"
+	TagCollate           = "collate"
+	TagIndexCollate      = "index-collate"
 )
 
 var sqlTypeMap = map[sqlite.ColumnType]string{
@@ -34,11 +79,76 @@
 	sqlite.TypeText:    "TEXT",
 }
 
+// strictTypes holds the type names that are allowed in STRICT tables.
+var strictTypes = map[string]bool{
+	"INT":     true,
+	"INTEGER": true,
+	"REAL":    true,
+	"TEXT":    true,
+	"BLOB":    true,
+	"ANY":     true,
+}
+
 type (
-	// TableSchema defines a SQL table schema.
+	// Tabler is implemented by models that define the name of their table.
+	Tabler interface {
+		TableName() string
+	}
+
+	// TableMarker can be embedded into a model to define the name of its
+	// table using the sqlite:"" struct tag. It is not stored in a column.
+	//
+	// Example:
+	//
+	//	type Conn struct {
+	//		orm.TableMarker `sqlite:"connections"`
+	//
+	//		ID string `sqlite:"id,primary"`
+	//	}
+	TableMarker struct{}
+
+	// CreateOption can be specified at CreateStatement to alter the
+	// generated SQL.
+	CreateOption func(opts *createOpts)
+
+	createOpts struct {
+		IfNotExists      bool
+		WithIndexes      bool
+		Strict           bool
+		WithoutRowID     bool
+		QuoteIdentifiers bool
+	}
+
+	// TableSchema defines a SQL table schema. It holds the metadata parsed
+	// from the model, which can be used by tooling without parsing the
+	// struct tags again.
 	TableSchema struct {
+		Name string
+		// Columns holds the column definitions in the order of the table.
+		Columns     []ColumnDef
+		Indexes     []IndexDef
+		ForeignKeys []ForeignKeyDef
+	}
+
+	// IndexDef defines a SQL index.
+	IndexDef struct {
 		Name    string
-		Columns []ColumnDef
+		Columns []string
+		Unique  bool
+		// Collations holds the collating sequence of the indexed columns
+		// that do not use the collating sequence of the column.
+		Collations map[string]string
+	}
+
+	// ForeignKeyDef defines a SQL foreign key constraint.
+	ForeignKeyDef struct {
+		Column    string
+		Table     string
+		RefColumn string
+		// OnDelete and OnUpdate hold the action of the ON DELETE and
+		// ON UPDATE clauses, if any.
+		OnDelete string
+		OnUpdate string
 	}
 
 	// ColumnDef defines a SQL column.
@@ -52,9 +162,100 @@
 		AutoIncrement bool
 		UnixNano      bool
 		IsTime        bool
+		Unique        bool
+		// IsJSON is set for columns that store the JSON encoding of the field
+		// value. If JSONCheck is set, the column only accepts valid JSON.
+		IsJSON    bool
+		JSONCheck bool
+		// Enum holds the allowed values of the column, if restricted.
+		Enum []string
+		// Generated holds the expression of generated columns. Generated
+		// columns are VIRTUAL, unless GeneratedStored is set.
+		Generated       string
+		GeneratedStored bool
+		// Default holds the SQL literal of the default value of the column.
+		Default string
+		// Collate holds the collating sequence of the column, like NOCASE.
+		// IndexCollate holds the collating sequence used for the column in
+		// its indexes, if it differs from the one of the column.
+		Collate      string
+		IndexCollate string
+		// Order holds the explicit position of the column, starting at 1.
+		// Columns without an explicit position have an Order of 0 and follow
+		// the ordered ones in declaration order.
+		Order int
+
+		// Indexes holds the names of the indexes the column is part of. An empty
+		// name refers to the default index name of the column.
+		Indexes []string
+		// UniqueIndexes holds the names of the unique indexes the column is part
+		// of.
+		UniqueIndexes []string
+
+		// ForeignKey holds the foreign key constraint of the column, if any.
+		ForeignKey *ForeignKeyDef
 	}
 )
 
+// IfNotExists makes the CREATE statements not fail if the table or index
+// already exists.
+func IfNotExists() CreateOption {
+	return func(opts *createOpts) {
+		opts.IfNotExists = true
+	}
+}
+
+// WithIndexes appends the CREATE INDEX statements of the table to the
+// CREATE TABLE statement.
+func WithIndexes() CreateOption {
+	return func(opts *createOpts) {
+		opts.WithIndexes = true
+	}
+}
+
+// Strict creates a STRICT table, which enforces the column types.
+//
+// See the SQLite documentation for details: https://sqlite.org/stricttables.html
+func Strict() CreateOption {
+	return func(opts *createOpts) {
+		opts.Strict = true
+	}
+}
+
+// WithoutRowID creates a table without the implicit rowid column. The table
+// must have a primary key and may not use AUTOINCREMENT.
+//
+// See the SQLite documentation for details: https://sqlite.org/withoutrowid.html
+func WithoutRowID() CreateOption {
+	return func(opts *createOpts) {
+		opts.WithoutRowID = true
+	}
+}
+
+// QuoteIdentifiers quotes all table, column and index names. By default,
+// only names that are SQLite keywords, like order or group, are quoted.
+func QuoteIdentifiers() CreateOption {
+	return func(opts *createOpts) {
+		opts.QuoteIdentifiers = true
+	}
+}
+
+// quote returns the function used to quote identifiers.
+func (opts createOpts) quote() func(string) string {
+	if opts.QuoteIdentifiers {
+		return alwaysQuoteIdentifier
+	}
+	return quoteIdentifier
+}
+
+func getCreateOpts(opts []CreateOption) createOpts {
+	var options createOpts
+	for _, fn := range opts {
+		fn(&options)
+	}
+	return options
+}
+
 // GetColumnDef returns the column definition with the given name.
 func (ts TableSchema) GetColumnDef(name string) *ColumnDef {
 	for _, def := range ts.Columns {
@@ -65,65 +266,270 @@ func (ts TableSchema) GetColumnDef(name string) *ColumnDef {
 	return nil
 }
 
-// CreateStatement build the CREATE SQL statement for the table.
-func (ts TableSchema) CreateStatement(ifNotExists bool) string {
+// CreateStatement build the CREATE SQL statement for the table. By default,
+// only the CREATE TABLE statement is built. Use CreateOptions to alter the
+// statement. Identifiers that are SQLite keywords are quoted.
+func (ts TableSchema) CreateStatement(opts ...CreateOption) (string, error) {
+	options := getCreateOpts(opts)
+	quote := options.quote()
+
 	sql := "CREATE TABLE"
-	if ifNotExists {
+	if options.IfNotExists {
 		sql += " IF NOT EXISTS"
 	}
-	sql += " " + ts.Name + " ( "
+	sql += " " + quote(ts.Name) + " ( "
+
+	primaryKey, err := ts.primaryKey()
+	if err != nil {
+		return "", err
+	}
+
+	// a composite primary key is defined as a table constraint
+	compositeKey := len(primaryKey) > 1
+	definitions := make([]string, 0, len(ts.Columns)+len(ts.ForeignKeys)+1)
+	for _, col := range ts.Columns {
+		colSQL, err := col.asSQL(options.Strict, !compositeKey, quote)
+		if err != nil {
+			return "", err
+		}
+		definitions = append(definitions, colSQL)
+
+		if options.WithoutRowID && col.AutoIncrement {
+			return "", fmt.Errorf("column %s: AUTOINCREMENT is not allowed in tables without rowid", col.Name)
+		}
+	}
+
+	// table constraints must follow the column definitions
+	if compositeKey {
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(quoteIdentifiers(primaryKey, quote), ", ")+")")
+	}
+	for _, fk := range ts.ForeignKeys {
+		definitions = append(definitions, fk.asSQL(quote))
+	}
+
+	sql += strings.Join(definitions, ", ") + " )"
+
+	// table options
+	var tableOptions []string
+	if options.Strict {
+		tableOptions = append(tableOptions, "STRICT")
+	}
+	if options.WithoutRowID {
+		if len(primaryKey) == 0 {
+			return "", fmt.Errorf("table %s: tables without rowid require a primary key", ts.Name)
+		}
+		tableOptions = append(tableOptions, "WITHOUT ROWID")
+	}
+	if len(tableOptions) > 0 {
+		sql += " " + strings.Join(tableOptions, ", ")
+	}
+
+	sql += ";"
+
+	if options.WithIndexes {
+		for _, stmt := range ts.CreateIndexStatements(opts...) {
+			sql += " " + stmt
+		}
+	}
+
+	return sql, nil
+}
+
+// primaryKey returns the names of the primary key columns in the order they
+// are declared.
+func (ts TableSchema) primaryKey() ([]string, error) {
+	var columns []string
+	for _, col := range ts.Columns {
+		if col.PrimaryKey {
+			columns = append(columns, col.Name)
+		}
+	}
+
+	// sqlite only supports AUTOINCREMENT on a single INTEGER PRIMARY KEY
+	if len(columns) > 1 {
+		for _, col := range ts.Columns {
+			if col.AutoIncrement {
+				return nil, fmt.Errorf("column %s: AUTOINCREMENT is not allowed with the composite primary key (%s)", col.Name, strings.Join(columns, ", "))
+			}
+		}
+	}
+
+	return columns, nil
+}
+
+// CreateIndexStatements builds the CREATE INDEX SQL statements for all
+// indexes of the table. Only the IfNotExists and QuoteIdentifiers options are
+// used.
+func (ts TableSchema) CreateIndexStatements(opts ...CreateOption) []string {
+	options := getCreateOpts(opts)
+
+	statements := make([]string, 0, len(ts.Indexes))
+	for _, idx := range ts.Indexes {
+		statements = append(statements, idx.asSQL(ts.Name, options.IfNotExists, options.quote()))
+	}
+	return statements
+}
 
-	for idx, col := range ts.Columns {
-		sql += col.AsSQL()
-		if idx < len(ts.Columns)-1 {
-			sql += ", "
+// AsSQL builds the CREATE INDEX SQL statement for the index on the given
+// table.
+func (idx IndexDef) AsSQL(table string, ifNotExists bool) string {
+	return idx.asSQL(table, ifNotExists, quoteIdentifier)
+}
+
+func (idx IndexDef) asSQL(table string, ifNotExists bool, quote func(string) string) string {
+	sql := "CREATE"
+	if idx.Unique {
+		sql += " UNIQUE"
+	}
+	sql += " INDEX"
+	if ifNotExists {
+		sql += " IF NOT EXISTS"
+	}
+	columns := quoteIdentifiers(idx.Columns, quote)
+	for i, column := range idx.Columns {
+		if collation := idx.Collations[column]; collation != "" {
+			columns[i] += " COLLATE " + collation
 		}
 	}
+	sql += " " + quote(idx.Name) + " ON " + quote(table) + " ( " + strings.Join(columns, ", ") + " );"
+	return sql
+}
+
+// AsSQL builds the FOREIGN KEY table constraint.
+func (fk ForeignKeyDef) AsSQL() string {
+	return fk.asSQL(quoteIdentifier)
+}
+
+func (fk ForeignKeyDef) asSQL(quote func(string) string) string {
+	return "FOREIGN KEY (" + quote(fk.Column) + ") " + fk.referencesSQL(quote)
+}
 
-	sql += " );"
+// referencesSQL builds the REFERENCES clause of the foreign key.
+func (fk ForeignKeyDef) referencesSQL(quote func(string) string) string {
+	sql := "REFERENCES " + quote(fk.Table) + "(" + quote(fk.RefColumn) + ")"
+	if fk.OnDelete != "" {
+		sql += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		sql += " ON UPDATE " + fk.OnUpdate
+	}
 	return sql
 }
 
 // AsSQL builds the SQL column definition.
 func (def ColumnDef) AsSQL() string {
-	sql := def.Name + " "
+	// building the definition only fails in strict mode
+	sql, _ := def.asSQL(false, true, quoteIdentifier)
+	return sql
+}
+
+// asSQL builds the SQL column definition. If inlinePrimaryKey is false, the
+// primary key is expected to be defined as a table constraint. Identifiers
+// are quoted using quote.
+func (def ColumnDef) asSQL(strict, inlinePrimaryKey bool, quote func(string) string) (string, error) {
+	name := quote(def.Name)
+	sql := name + " "
 
-	if def.Type == sqlite.TypeText && def.Length > 0 {
+	switch {
+	case def.Type == sqlite.TypeText && def.Length > 0 && !strict:
 		sql += fmt.Sprintf("VARCHAR(%d)", def.Length)
-	} else {
+	case strict:
+		// strict tables only allow a limited set of type names
+		sqlType, ok := sqlTypeMap[def.Type]
+		if !ok || !strictTypes[sqlType] {
+			return "", fmt.Errorf("column %s: type %s is not allowed in strict tables", def.Name, def.Type)
+		}
+		sql += sqlType
+	default:
 		sql += sqlTypeMap[def.Type]
 	}
 
-	if def.PrimaryKey {
+	if def.Generated != "" {
+		sql += " GENERATED ALWAYS AS (" + def.Generated + ")"
+		if def.GeneratedStored {
+			sql += " STORED"
+		} else {
+			sql += " VIRTUAL"
+		}
+	}
+	if def.PrimaryKey && inlinePrimaryKey {
 		sql += " PRIMARY KEY"
 	}
 	if def.AutoIncrement {
 		sql += " AUTOINCREMENT"
 	}
+	if def.Unique {
+		sql += " UNIQUE"
+	}
 	if !def.Nullable {
 		sql += " NOT NULL"
 	}
+	if def.Default != "" {
+		sql += " DEFAULT " + def.Default
+	}
+	if def.Collate != "" {
+		sql += " COLLATE " + def.Collate
+	}
+	if def.isBool() {
+		// sqlite does not have a BOOL type, make sure only 1/0 are stored.
+		sql += " CHECK (" + name + " IN (0, 1))"
+	}
+	if len(def.Enum) > 0 {
+		// NULL passes the check, so nullable columns still accept it.
+		values := make([]string, 0, len(def.Enum))
+		for _, v := range def.Enum {
+			values = append(values, quoteString(v))
+		}
+		sql += " CHECK (" + name + " IN (" + strings.Join(values, ", ") + "))"
+	}
+	if def.JSONCheck {
+		sql += " CHECK (json_valid(" + name + "))"
+	}
+	if def.Type == sqlite.TypeText && def.Length > 0 && strict {
+		// strict tables do not allow VARCHAR, enforce the length instead.
+		sql += fmt.Sprintf(" CHECK (length(%s) <= %d)", name, def.Length)
+	}
 
-	return sql
+	return sql, nil
 }
 
-// GenerateTableSchema generates a table schema from the given struct.
-func GenerateTableSchema(name string, d interface{}) (*TableSchema, error) {
-	ts := &TableSchema{
-		Name: name,
-	}
+// isBool returns whether the column stores a Go bool as INTEGER.
+func (def ColumnDef) isBool() bool {
+	return def.GoType != nil && def.GoType.Kind() == reflect.Bool && def.Type == sqlite.TypeInteger
+}
 
+// GenerateTableSchema generates a table schema from the given struct. If name
+// is empty, the name of the table is taken from the model, which either needs
+// to implement Tabler or embed a TableMarker. Columns pinned with the order
+// modifier, like sqlite:"id,order:1", come first, followed by all other
+// columns in declaration order. The fields of embedded structs without a
+// sqlite tag are flattened into the table as if they were declared inline.
+// Fields tagged with sqlite:"-" are ignored, while fields without a sqlite tag
+// are stored in a column named like the field.
+func GenerateTableSchema(name string, d interface{}) (*TableSchema, error) {
 	val := reflect.Indirect(reflect.ValueOf(d))
 	if val.Kind() != reflect.Struct {
 		return nil, fmt.Errorf("%w, got %T", errStructExpected, d)
 	}
 
-	for i := 0; i < val.NumField(); i++ {
-		fieldType := val.Type().Field(i)
-		if !fieldType.IsExported() {
-			continue
+	if name == "" {
+		name = getTableName(d, val)
+		if name == "" {
+			return nil, fmt.Errorf("missing table name for %T", d)
 		}
+	}
 
+	ts := &TableSchema{
+		Name: name,
+	}
+
+	fields, err := modelFields(val.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	declaredBy := make(map[string]string, len(fields))
+	for _, fieldType := range fields {
 		def, err := getColumnDef(fieldType)
 		if err != nil {
 			if errors.Is(err, errSkipStructField) {
@@ -133,13 +539,215 @@ func GenerateTableSchema(name string, d interface{}) (*TableSchema, error) {
 			return nil, fmt.Errorf("struct field %s: %w", fieldType.Name, err)
 		}
 
+		if other, ok := declaredBy[def.Name]; ok {
+			return nil, fmt.Errorf("struct fields %s and %s both declare column %s", other, fieldType.Name, def.Name)
+		}
+		declaredBy[def.Name] = fieldType.Name
+
 		ts.Columns = append(ts.Columns, *def)
 	}
 
+	if err := ts.sortColumns(); err != nil {
+		return nil, err
+	}
+	for _, col := range ts.Columns {
+		if col.ForeignKey != nil {
+			ts.ForeignKeys = append(ts.ForeignKeys, *col.ForeignKey)
+		}
+	}
+
+	if _, err := ts.primaryKey(); err != nil {
+		return nil, err
+	}
+
+	if err := ts.buildIndexes(); err != nil {
+		return nil, err
+	}
+
 	return ts, nil
 }
 
+// sortColumns sorts the columns with an explicit order by their order,
+// followed by all other columns in declaration order.
+func (ts *TableSchema) sortColumns() error {
+	positions := make(map[int]string)
+	for _, col := range ts.Columns {
+		if col.Order == 0 {
+			continue
+		}
+		if other, ok := positions[col.Order]; ok {
+			return fmt.Errorf("columns %s and %s have the same order %d", other, col.Name, col.Order)
+		}
+		positions[col.Order] = col.Name
+	}
+
+	sort.SliceStable(ts.Columns, func(i, j int) bool {
+		a, b := ts.Columns[i].Order, ts.Columns[j].Order
+		switch {
+		case a == 0:
+			return false
+		case b == 0:
+			return true
+		default:
+			return a < b
+		}
+	})
+	return nil
+}
+
+// getTableName returns the table name defined by the model d, either by
+// implementing Tabler or by embedding a TableMarker. val must hold the struct
+// value of d.
+func getTableName(d interface{}, val reflect.Value) string {
+	if tabler, ok := d.(Tabler); ok {
+		return tabler.TableName()
+	}
+	// models passed by value may implement Tabler with a pointer receiver
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	if tabler, ok := ptr.Interface().(Tabler); ok {
+		return tabler.TableName()
+	}
+
+	for i := 0; i < val.NumField(); i++ {
+		fieldType := val.Type().Field(i)
+		if fieldType.Anonymous && fieldType.Type == tableMarkerType {
+			return sqlColumnName(fieldType)
+		}
+	}
+
+	return ""
+}
+
+// modelFields returns the exported fields of the struct type t, except for
+// fields tagged with sqlite:"-". The fields of
+// anonymous embedded structs, or pointers to structs, without a sqlite tag are
+// returned in place of the embedded struct, as if they were declared inline.
+// The Index of each returned field holds the index sequence to use with
+// reflect.Value.FieldByIndex.
+func modelFields(t reflect.Type) ([]reflect.StructField, error) {
+	return appendModelFields(nil, t, nil, map[reflect.Type]bool{t: true})
+}
+
+func appendModelFields(fields []reflect.StructField, t reflect.Type, index []int, visiting map[reflect.Type]bool) ([]reflect.StructField, error) {
+	for i := 0; i < t.NumField(); i++ {
+		fieldType := t.Field(i)
+		if !fieldType.IsExported() || isIgnoredField(fieldType) {
+			continue
+		}
+		fieldType.Index = append(append(make([]int, 0, len(index)+1), index...), i)
+
+		embedded, ok := flattenedEmbed(fieldType)
+		if !ok {
+			fields = append(fields, fieldType)
+			continue
+		}
+
+		if visiting[embedded] {
+			return nil, fmt.Errorf("struct field %s: %s is embedded recursively", fieldType.Name, embedded)
+		}
+		visiting[embedded] = true
+
+		var err error
+		fields, err = appendModelFields(fields, embedded, fieldType.Index, visiting)
+		if err != nil {
+			return nil, err
+		}
+
+		delete(visiting, embedded)
+	}
+
+	return fields, nil
+}
+
+// isIgnoredField returns whether fieldType is excluded from the table by
+// using "-" as its column name, like sqlite:"-".
+func isIgnoredField(fieldType reflect.StructField) bool {
+	parts := splitStructFieldTag(fieldType.Tag.Get("sqlite"))
+	return len(parts) > 0 && parts[0] == "-"
+}
+
+// flattenedEmbed returns the struct type of fieldType if it is an embedded
+// struct whose fields are flattened into the table.
+func flattenedEmbed(fieldType reflect.StructField) (reflect.Type, bool) {
+	if !fieldType.Anonymous || fieldType.Type == tableMarkerType {
+		return nil, false
+	}
+	// tagged embeds are stored in a single column, like other fields
+	if _, hasTag := fieldType.Tag.Lookup("sqlite"); hasTag {
+		return nil, false
+	}
+
+	ft := fieldType.Type
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	if ft.Kind() != reflect.Struct || ft == timeType || isCustomType(ft) {
+		return nil, false
+	}
+
+	return ft, true
+}
+
+// buildIndexes collects the indexes of all columns and combines indexes with
+// the same name into multi-column indexes. Indexes are ordered by their first
+// column.
+func (ts *TableSchema) buildIndexes() error {
+	lookup := make(map[string]int)
+
+	addIndex := func(name string, col ColumnDef, unique bool) error {
+		if name == "" {
+			name = "idx_" + ts.Name + "_" + col.Name
+		}
+
+		pos, ok := lookup[name]
+		switch {
+		case !ok:
+			pos = len(ts.Indexes)
+			lookup[name] = pos
+			ts.Indexes = append(ts.Indexes, IndexDef{
+				Name:   name,
+				Unique: unique,
+			})
+		case ts.Indexes[pos].Unique != unique:
+			return fmt.Errorf("index %s is declared both as unique and non-unique", name)
+		}
+
+		idx := &ts.Indexes[pos]
+		idx.Columns = append(idx.Columns, col.Name)
+		if col.IndexCollate != "" {
+			if idx.Collations == nil {
+				idx.Collations = make(map[string]string)
+			}
+			idx.Collations[col.Name] = col.IndexCollate
+		}
+		return nil
+	}
+
+	for _, col := range ts.Columns {
+		if col.IndexCollate != "" && len(col.Indexes) == 0 && len(col.UniqueIndexes) == 0 {
+			return fmt.Errorf("column %s: %s requires an index", col.Name, TagIndexCollate)
+		}
+		for _, name := range col.Indexes {
+			if err := addIndex(name, col, false); err != nil {
+				return err
+			}
+		}
+		for _, name := range col.UniqueIndexes {
+			if err := addIndex(name, col, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func getColumnDef(fieldType reflect.StructField) (*ColumnDef, error) {
+	if fieldType.Anonymous && fieldType.Type == tableMarkerType {
+		return nil, errSkipStructField
+	}
+
 	def := &ColumnDef{
 		Name:     fieldType.Name,
 		Nullable: fieldType.Type.Kind() == reflect.Ptr,
@@ -154,8 +762,19 @@ func getColumnDef(fieldType reflect.StructField) (*ColumnDef, error) {
 	def.GoType = ft
 	kind := normalizeKind(ft.Kind())
 
+	// sql.Null* types may hold NULL
+	colType, isNullType := nullTypes[ft]
+	if isNullType {
+		def.Type = colType
+		def.Nullable = true
+	}
+
+	// other types implementing driver.Valuer or sql.Scanner convert their
+	// values themselves, so only the column type is taken from them.
+	customType := !isNullType && isCustomType(ft)
+
 	switch kind { //nolint:exhaustive
-	case reflect.Int:
+	case reflect.Int, reflect.Bool:
 		def.Type = sqlite.TypeInteger
 
 	case reflect.Float64:
@@ -165,33 +784,42 @@ func getColumnDef(fieldType reflect.StructField) (*ColumnDef, error) {
 		def.Type = sqlite.TypeText
 
 	case reflect.Slice:
-		// only []byte/[]uint8 is supported
-		if ft.Elem().Kind() != reflect.Uint8 {
-			return nil, fmt.Errorf("slices of type %s is not supported", ft.Elem())
+		if ft.Elem().Kind() == reflect.Uint8 {
+			def.Type = sqlite.TypeBlob
 		}
-
-		def.Type = sqlite.TypeBlob
 	}
 
 	if err := applyStructFieldTag(fieldType, def); err != nil {
 		return nil, err
 	}
 
+	if customType {
+		if def.Type == 0 {
+			return nil, fmt.Errorf("column %s: type %s requires a column type tag", def.Name, ft)
+		}
+		return def, nil
+	}
+
+	// other slices are only supported as JSON
+	if kind == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 && !def.IsJSON {
+		return nil, fmt.Errorf("slices of type %s is not supported", ft.Elem())
+	}
+
 	return def, nil
 }
 
 // applyStructFieldTag parses the sqlite:"" struct field tag and update the column
 // definition def accordingly.
 func applyStructFieldTag(fieldType reflect.StructField, def *ColumnDef) error {
-	parts := strings.Split(fieldType.Tag.Get("sqlite"), ",")
+	parts := splitStructFieldTag(fieldType.Tag.Get("sqlite"))
 	if len(parts) > 0 && parts[0] != "" {
-		if parts[0] == "-" {
-			return errSkipStructField
-		}
-
 		def.Name = parts[0]
 	}
 
+	var (
+		onDelete, onUpdate string
+		storage            string
+	)
 	if len(parts) > 1 {
 		for _, k := range parts[1:] {
 			switch k {
@@ -208,6 +836,22 @@ func applyStructFieldTag(fieldType reflect.StructField, def *ColumnDef) error {
 				def.UnixNano = true
 			case TagTime:
 				def.IsTime = true
+			case TagUnique:
+				def.Unique = true
+			case TagIndex:
+				def.Indexes = append(def.Indexes, "")
+			case TagStored, TagVirtual:
+				if storage != "" && storage != k {
+					return fmt.Errorf("column %s cannot be both %s and %s", def.Name, TagStored, TagVirtual)
+				}
+				storage = k
+			case TagJSON:
+				def.IsJSON = true
+				def.Type = sqlite.TypeText
+			case TagJSONCheck:
+				def.IsJSON = true
+				def.JSONCheck = true
+				def.Type = sqlite.TypeText
 
 			// basic column types
 			case TagTypeInt:
@@ -221,6 +865,114 @@ func applyStructFieldTag(fieldType reflect.StructField, def *ColumnDef) error {
 
 			// advanced column types
 			default:
+				if name, ok := strings.CutPrefix(k, TagIndex+":"); ok {
+					if name == "" {
+						return fmt.Errorf("missing name of index %q", k)
+					}
+					def.Indexes = append(def.Indexes, name)
+					continue
+				}
+
+				if name, ok := strings.CutPrefix(k, TagUnique+":"); ok {
+					if name == "" {
+						return fmt.Errorf("missing name of unique index %q", k)
+					}
+					def.UniqueIndexes = append(def.UniqueIndexes, name)
+					continue
+				}
+
+				if target, ok := strings.CutPrefix(k, TagReferences+":"); ok {
+					match := referenceTarget.FindStringSubmatch(target)
+					if match == nil {
+						return fmt.Errorf("invalid foreign key reference %q, expected table(column)", target)
+					}
+
+					def.ForeignKey = &ForeignKeyDef{
+						Table:     match[1],
+						RefColumn: match[2],
+					}
+					continue
+				}
+
+				if action, ok := strings.CutPrefix(k, TagOnDelete+":"); ok {
+					var err error
+					if onDelete, err = parseForeignKeyAction(action); err != nil {
+						return err
+					}
+					continue
+				}
+
+				if action, ok := strings.CutPrefix(k, TagOnUpdate+":"); ok {
+					var err error
+					if onUpdate, err = parseForeignKeyAction(action); err != nil {
+						return err
+					}
+					continue
+				}
+
+				if expr, ok := strings.CutPrefix(k, TagGenerated+":"); ok {
+					if strings.TrimSpace(expr) == "" {
+						return fmt.Errorf("missing expression of generated column %s", def.Name)
+					}
+
+					def.Generated = expr
+					continue
+				}
+
+				if values, ok := strings.CutPrefix(k, TagEnum+":"); ok {
+					if def.GoType.Kind() != reflect.String {
+						return fmt.Errorf("%s is only supported for string fields", TagEnum)
+					}
+
+					def.Enum = strings.Split(values, "|")
+					for _, v := range def.Enum {
+						if v == "" {
+							return fmt.Errorf("empty value in %q", k)
+						}
+					}
+					continue
+				}
+
+				if value, ok := strings.CutPrefix(k, TagDefault+":"); ok {
+					defaultValue, err := parseDefaultValue(def, value)
+					if err != nil {
+						return err
+					}
+
+					def.Default = defaultValue
+					continue
+				}
+
+				if name, ok := strings.CutPrefix(k, TagCollate+":"); ok {
+					collation, err := parseCollation(name)
+					if err != nil {
+						return err
+					}
+
+					def.Collate = collation
+					continue
+				}
+
+				if name, ok := strings.CutPrefix(k, TagIndexCollate+":"); ok {
+					collation, err := parseCollation(name)
+					if err != nil {
+						return err
+					}
+
+					def.IndexCollate = collation
+					continue
+				}
+
+				if value, ok := strings.CutPrefix(k, TagOrder+":"); ok {
+					order, err := strconv.ParseUint(value, 10, 16)
+					if err != nil || order == 0 {
+						return fmt.Errorf("invalid order %q of column %s, expected a positive number", value, def.Name)
+					}
+
+					def.Order = int(order)
+					continue
+				}
+
 				if strings.HasPrefix(k, TagTypePrefixVarchar) {
 					lenStr := strings.TrimSuffix(strings.TrimPrefix(k, TagTypePrefixVarchar+"("), ")")
 					length, err := strconv.ParseInt(lenStr, 10, 0)
@@ -236,5 +988,138 @@ func applyStructFieldTag(fieldType reflect.StructField, def *ColumnDef) error {
 		}
 	}
 
+	if def.ForeignKey != nil {
+		def.ForeignKey.Column = def.Name
+		def.ForeignKey.OnDelete = onDelete
+		def.ForeignKey.OnUpdate = onUpdate
+	} else if onDelete != "" || onUpdate != "" {
+		return fmt.Errorf("column %s: %s and %s require %s", def.Name, TagOnDelete, TagOnUpdate, TagReferences)
+	}
+
+	// sqlite does not allow generated columns to be part of the primary key
+	// or to have a default value
+	if def.Generated != "" {
+		switch {
+		case def.PrimaryKey, def.AutoIncrement:
+			return fmt.Errorf("generated column %s cannot be a primary key", def.Name)
+		case def.Default != "":
+			return fmt.Errorf("generated column %s cannot have a default value", def.Name)
+		}
+		def.GeneratedStored = storage == TagStored
+	} else if storage != "" {
+		return fmt.Errorf("column %s: %s requires %s", def.Name, storage, TagGenerated)
+	}
+
+	// check that the default value can actually be stored
+	if def.Default == "NULL" && !def.Nullable {
+		return fmt.Errorf("default value NULL is not allowed for NOT NULL column %s", def.Name)
+	}
+
 	return nil
 }
+
+// splitStructFieldTag splits the sqlite:"" struct field tag at commas that
+// are not part of a quoted string literal. String literals start directly
+// after a colon, like default:'a, b', and may contain quotes escaped by
+// doubling them. Expressions of generated columns may contain string literals
+// anywhere, as well as commas within parentheses.
+func splitStructFieldTag(tag string) []string {
+	var (
+		parts  []string
+		start  int
+		quoted bool
+		depth  int
+		isExpr = strings.HasPrefix(tag, TagGenerated+":")
+	)
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case quoted && tag[i] == '\'':
+			if i+1 < len(tag) && tag[i+1] == '\'' {
+				// skip escaped quote
+				i++
+				continue
+			}
+			quoted = false
+		case quoted:
+		case tag[i] == '\'' && (isExpr || (i > 0 && tag[i-1] == ':')):
+			quoted = true
+		case isExpr && tag[i] == '(':
+			depth++
+		case isExpr && tag[i] == ')':
+			depth--
+		case tag[i] == ',' && depth <= 0:
+			parts = append(parts, tag[start:i])
+			start = i + 1
+			isExpr = strings.HasPrefix(tag[start:], TagGenerated+":")
+			depth = 0
+		}
+	}
+	return append(parts, tag[start:])
+}
+
+// quoteString returns s as a quoted SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// parseForeignKeyAction parses the action of an on-delete:<action> or
+// on-update:<action> struct field tag. Words may be separated by spaces or
+// dashes, like set-null.
+func parseForeignKeyAction(action string) (string, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(action, "-", " "))
+	if !foreignKeyActions[normalized] {
+		return "", fmt.Errorf("invalid foreign key action %q", action)
+	}
+	return normalized, nil
+}
+
+// parseCollation parses the name of a collating sequence of a collate:<name>
+// or index-collate:<name> struct field tag. Only the built-in collating
+// sequences of SQLite are supported.
+func parseCollation(name string) (string, error) {
+	normalized := strings.ToUpper(name)
+	if !collations[normalized] {
+		return "", fmt.Errorf("invalid collating sequence %q, expected BINARY, NOCASE or RTRIM", name)
+	}
+	return normalized, nil
+}
+
+// parseDefaultValue parses the value of a default:<value> struct field tag
+// and returns the SQL literal to use in the DEFAULT clause. Supported are
+// quoted string literals, numeric literals and the keywords NULL,
+// CURRENT_TIME, CURRENT_DATE and CURRENT_TIMESTAMP. Columns of bool fields
+// also accept true and false.
+func parseDefaultValue(def *ColumnDef, value string) (string, error) {
+	// bool columns store true/false as 1/0
+	if def.GoType.Kind() == reflect.Bool {
+		if b, err := strconv.ParseBool(value); err == nil {
+			if b {
+				return "1", nil
+			}
+			return "0", nil
+		}
+	}
+
+	switch {
+	case value == "":
+		return "", fmt.Errorf("missing default value")
+
+	case strings.HasPrefix(value, "'"):
+		// string literals must be quoted entirely, with quotes escaped as ''
+		inner, ok := strings.CutSuffix(value[1:], "'")
+		if !ok || strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return "", fmt.Errorf("invalid string literal %s as default value", value)
+		}
+		return value, nil
+
+	case numericLiteral.MatchString(value):
+		return value, nil
+	}
+
+	switch keyword := strings.ToUpper(value); keyword {
+	case "NULL", "CURRENT_TIME", "CURRENT_DATE", "CURRENT_TIMESTAMP":
+		return keyword, nil
+	}
+
+	return "", fmt.Errorf("invalid default value %q, expected a quoted string, a number or an SQL keyword", value)
+}
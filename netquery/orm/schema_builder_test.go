@@ -1,9 +1,15 @@
 package orm
 
 import (
+	"context"
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
 )
 
 func TestSchemaBuilder(t *testing.T) {
@@ -31,6 +37,34 @@ func TestSchemaBuilder(t *testing.T) {
 			}{},
 			`CREATE TABLE Varchar ( S VARCHAR(10) NOT NULL );`,
 		},
+		{
+			"Bool",
+			struct {
+				B       bool  `sqlite:"b"`
+				Default bool  `sqlite:"d,default:true"`
+				Ptr     *bool `sqlite:"p,default:false"`
+			}{},
+			`CREATE TABLE Bool ( b INTEGER NOT NULL CHECK (b IN (0, 1)), d INTEGER NOT NULL DEFAULT 1 CHECK (d IN (0, 1)), p INTEGER DEFAULT 0 CHECK (p IN (0, 1)) );`,
+		},
+		{
+			"Blob",
+			struct {
+				B    []byte          `sqlite:"b"`
+				Null []byte          `sqlite:"null,nullable"`
+				Raw  json.RawMessage `sqlite:"raw"`
+				S    string          `sqlite:"s,blob"`
+			}{},
+			`CREATE TABLE Blob ( b BLOB NOT NULL, "null" BLOB, raw BLOB NOT NULL, s BLOB NOT NULL );`,
+		},
+		{
+			"JSON",
+			struct {
+				Meta    map[string]string `sqlite:"meta,json"`
+				Tags    []string          `sqlite:"tags,json,nullable"`
+				Checked *struct{ A int }  `sqlite:"checked,json-check"`
+			}{},
+			`CREATE TABLE JSON ( meta TEXT NOT NULL, tags TEXT, checked TEXT CHECK (json_valid(checked)) );`,
+		},
 	}
 
 	for idx := range cases {
@@ -38,6 +72,727 @@ func TestSchemaBuilder(t *testing.T) {
 
 		res, err := GenerateTableSchema(c.Name, c.Model)
 		assert.NoError(t, err)
-		assert.Equal(t, c.ExpectedSQL, res.CreateStatement(false))
+		sql, err := res.CreateStatement()
+		assert.NoError(t, err)
+		assert.Equal(t, c.ExpectedSQL, sql)
+	}
+}
+
+func TestSchemaBuilderColumnMetadata(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("Simple", struct {
+		ID    int         `sqlite:"id,primary,autoincrement"`
+		Text  string      `sqlite:"text,nullable"`
+		Int   *int        `sqlite:",not-null"`
+		Float interface{} `sqlite:",float,nullable"`
+	}{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []ColumnDef{
+		{
+			Name:          "id",
+			Type:          sqlite.TypeInteger,
+			GoType:        reflect.TypeOf(0),
+			PrimaryKey:    true,
+			AutoIncrement: true,
+		},
+		{
+			Name:     "text",
+			Type:     sqlite.TypeText,
+			GoType:   reflect.TypeOf(""),
+			Nullable: true,
+		},
+		{
+			Name:   "Int",
+			Type:   sqlite.TypeInteger,
+			GoType: reflect.TypeOf(0),
+		},
+		{
+			Name:     "Float",
+			Type:     sqlite.TypeFloat,
+			GoType:   reflect.TypeOf((*interface{})(nil)).Elem(),
+			Nullable: true,
+		},
+	}, res.Columns)
+
+	sqlTypes := make([]string, 0, len(res.Columns))
+	for _, col := range res.Columns {
+		sqlTypes = append(sqlTypes, col.SQLType())
+	}
+	assert.Equal(t, []string{"INTEGER", "TEXT", "INTEGER", "REAL"}, sqlTypes)
+	assert.Empty(t, res.Indexes)
+	assert.Empty(t, res.ForeignKeys)
+}
+
+type tablerModel struct {
+	ID int `sqlite:"id,primary"`
+}
+
+func (tablerModel) TableName() string { return "tabler" }
+
+type pointerTablerModel struct {
+	ID int `sqlite:"id,primary"`
+}
+
+func (*pointerTablerModel) TableName() string { return "pointer_tabler" }
+
+type markerModel struct {
+	TableMarker `sqlite:"marker"`
+
+	ID int `sqlite:"id,primary"`
+}
+
+func TestSchemaBuilderTableName(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Name     string
+		Model    interface{}
+		Expected string
+	}{
+		{"", tablerModel{}, "tabler"},
+		{"", &tablerModel{}, "tabler"},
+		{"", pointerTablerModel{}, "pointer_tabler"},
+		{"", &pointerTablerModel{}, "pointer_tabler"},
+		{"", markerModel{}, "marker"},
+		// The explicit name wins.
+		{"explicit", tablerModel{}, "explicit"},
+		{"explicit", markerModel{}, "explicit"},
+	}
+
+	for _, c := range cases {
+		res, err := GenerateTableSchema(c.Name, c.Model)
+		if !assert.NoError(t, err, "%T", c.Model) {
+			continue
+		}
+		assert.Equal(t, c.Expected, res.Name, "%T", c.Model)
+
+		// The table marker is not a column.
+		assert.Len(t, res.Columns, 1, "%T", c.Model)
+	}
+
+	_, err := GenerateTableSchema("", struct {
+		ID int `sqlite:"id,primary"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderDefaults(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("Defaults", struct {
+		Status  string     `sqlite:"status,default:'active'"`
+		Quoted  *string    `sqlite:"quoted,default:'it''s, quoted'"`
+		Count   int        `sqlite:"count,default:0"`
+		Ratio   float64    `sqlite:"ratio,default:-1.5e3"`
+		Created *time.Time `sqlite:"created,text,time,default:current_timestamp"`
+		Deleted *time.Time `sqlite:"deleted,text,time,default:NULL"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sql, err := res.CreateStatement()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE Defaults ( status TEXT NOT NULL DEFAULT 'active', quoted TEXT DEFAULT 'it''s, quoted', count INTEGER NOT NULL DEFAULT 0, ratio REAL NOT NULL DEFAULT -1.5e3, created TEXT DEFAULT CURRENT_TIMESTAMP, deleted TEXT DEFAULT NULL );`,
+		sql,
+	)
+}
+
+func TestSchemaBuilderInvalidDefault(t *testing.T) {
+	t.Parallel()
+
+	for _, model := range []interface{}{
+		struct {
+			S string `sqlite:"s,default:'unterminated"`
+		}{},
+		struct {
+			S string `sqlite:"s,default:'unescaped'quote'"`
+		}{},
+		struct {
+			S string `sqlite:"s,default:active"`
+		}{},
+		struct {
+			S string `sqlite:"s,default:NULL"`
+		}{},
+	} {
+		_, err := GenerateTableSchema("invalid", model)
+		assert.Error(t, err, "%T", model)
+	}
+
+	_, err := GenerateTableSchema("invalid", struct {
+		B bool `sqlite:"b,default:yes please"`
+	}{})
+	assert.Error(t, err)
+
+	_, err = GenerateTableSchema("invalid", struct {
+		S string `sqlite:"s,default:true"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderBlobOverride(t *testing.T) {
+	t.Parallel()
+
+	// Any type can be forced into a BLOB column.
+	schema, err := GenerateTableSchema("blobs", struct {
+		I int     `sqlite:"i,blob"`
+		F float64 `sqlite:"f,blob"`
+		P *int    `sqlite:"p,blob"`
+	}{})
+	require.NoError(t, err)
+	for _, col := range schema.Columns {
+		assert.Equal(t, sqlite.TypeBlob, col.Type, col.Name)
+	}
+	createSQL, err := schema.CreateStatement()
+	require.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE blobs ( i BLOB NOT NULL, f BLOB NOT NULL, p BLOB );`, createSQL)
+}
+
+func TestSchemaBuilderInvalidSlice(t *testing.T) {
+	t.Parallel()
+
+	// Slices other than []byte need to be stored as JSON.
+	_, err := GenerateTableSchema("invalid", struct {
+		Tags []string `sqlite:"tags"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderCustomTypes(t *testing.T) {
+	t.Parallel()
+
+	// Custom types may use any column type, but it needs to be known.
+	schema, err := GenerateTableSchema("custom", struct {
+		Server hostPort `sqlite:"server,blob"`
+	}{})
+	require.NoError(t, err)
+	assert.Equal(t, sqlite.TypeBlob, schema.Columns[0].Type)
+
+	_, err = GenerateTableSchema("invalid", struct {
+		Server hostPort `sqlite:"server"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderEnum(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("Enum", struct {
+		Status string  `sqlite:"status,enum:active|disabled|pending,default:'pending'"`
+		Quoted *string `sqlite:"quoted,enum:it's|plain,not-null"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sql, err := res.CreateStatement()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE Enum ( status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('active', 'disabled', 'pending')), quoted TEXT NOT NULL CHECK (quoted IN ('it''s', 'plain')) );`,
+		sql,
+	)
+
+	for _, model := range []interface{}{
+		struct {
+			I int `sqlite:"i,enum:1|2"`
+		}{},
+		struct {
+			S string `sqlite:"s,enum:a||b"`
+		}{},
+	} {
+		_, err := GenerateTableSchema("invalid", model)
+		assert.Error(t, err, "%T", model)
+	}
+}
+
+func TestSchemaBuilderGenerated(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("people", struct {
+		First    string `sqlite:"first_name"`
+		Last     string `sqlite:"last_name"`
+		Full     string `sqlite:"full_name,generated:first_name || ' ' || last_name,stored"`
+		Display  string `sqlite:"display_name,generated:coalesce(nullif(first_name, ''), 'a, b'),virtual,index"`
+		Initials string `sqlite:"initials,generated:substr(first_name, 1, 1)"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sql, err := res.CreateStatement()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE people ( first_name TEXT NOT NULL, last_name TEXT NOT NULL,`+
+			` full_name TEXT GENERATED ALWAYS AS (first_name || ' ' || last_name) STORED NOT NULL,`+
+			` display_name TEXT GENERATED ALWAYS AS (coalesce(nullif(first_name, ''), 'a, b')) VIRTUAL NOT NULL,`+
+			` initials TEXT GENERATED ALWAYS AS (substr(first_name, 1, 1)) VIRTUAL NOT NULL );`,
+		sql,
+	)
+	assert.Equal(t, []string{
+		`CREATE INDEX idx_people_display_name ON people ( display_name );`,
+	}, res.CreateIndexStatements())
+
+	for _, model := range []interface{}{
+		struct {
+			ID int `sqlite:"id,primary,generated:1"`
+		}{},
+		struct {
+			S string `sqlite:"s,generated:'a',default:'b'"`
+		}{},
+		struct {
+			S string `sqlite:"s,generated:'a',stored,virtual"`
+		}{},
+		struct {
+			S string `sqlite:"s,stored"`
+		}{},
+		struct {
+			S string `sqlite:"s,generated:"`
+		}{},
+	} {
+		_, err := GenerateTableSchema("invalid", model)
+		assert.Error(t, err, "%T", model)
+	}
+}
+
+func TestSchemaBuilderIndexes(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("users", struct {
+		ID     int    `sqlite:"id,primary"`
+		Email  string `sqlite:"email,index"`
+		Name   string `sqlite:"name,unique"`
+		Tenant string `sqlite:"tenant,index:idx_tenant"`
+		Group  string `sqlite:"group_id,index:idx_tenant,unique:idx_group_handle"`
+		Handle string `sqlite:"handle,unique:idx_group_handle"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{
+		`CREATE INDEX idx_users_email ON users ( email );`,
+		`CREATE INDEX idx_tenant ON users ( tenant, group_id );`,
+		`CREATE UNIQUE INDEX idx_group_handle ON users ( group_id, handle );`,
+	}, res.CreateIndexStatements())
+
+	sql, err := res.CreateStatement(IfNotExists(), WithIndexes())
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE IF NOT EXISTS users ( id INTEGER PRIMARY KEY NOT NULL, email TEXT NOT NULL, name TEXT UNIQUE NOT NULL, tenant TEXT NOT NULL, group_id TEXT NOT NULL, handle TEXT NOT NULL );`+
+			` CREATE INDEX IF NOT EXISTS idx_users_email ON users ( email );`+
+			` CREATE INDEX IF NOT EXISTS idx_tenant ON users ( tenant, group_id );`+
+			` CREATE UNIQUE INDEX IF NOT EXISTS idx_group_handle ON users ( group_id, handle );`,
+		sql,
+	)
+
+	// An index may not be unique and non-unique at the same time.
+	_, err = GenerateTableSchema("invalid", struct {
+		A string `sqlite:"a,index:idx_ab"`
+		B string `sqlite:"b,unique:idx_ab"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderColumnOrder(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("ordered", struct {
+		Name    string `sqlite:"name"`
+		Created int    `sqlite:"created,order:2"`
+		Owner   string `sqlite:"owner,references:users(id)"`
+		ID      int    `sqlite:"id,primary,order:1"`
+		Note    string `sqlite:"note"`
+		Group   string `sqlite:"group_id,order:5,references:groups(id)"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Ordered columns come first, sorted by their order, followed by the
+	// others in declaration order. Gaps in the order are allowed.
+	names := make([]string, 0, len(res.Columns))
+	for _, col := range res.Columns {
+		names = append(names, col.Name)
+	}
+	assert.Equal(t, []string{"id", "created", "group_id", "name", "owner", "note"}, names)
+
+	// Foreign keys follow the column order.
+	sql, err := res.CreateStatement()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE ordered ( id INTEGER PRIMARY KEY NOT NULL, created INTEGER NOT NULL, group_id TEXT NOT NULL, name TEXT NOT NULL, owner TEXT NOT NULL, note TEXT NOT NULL,`+
+			` FOREIGN KEY (group_id) REFERENCES "groups"(id), FOREIGN KEY (owner) REFERENCES users(id) );`,
+		sql,
+	)
+
+	// Reordering the struct fields does not change the ordered columns.
+	reordered, err := GenerateTableSchema("ordered", struct {
+		ID      int    `sqlite:"id,primary,order:1"`
+		Group   string `sqlite:"group_id,order:5,references:groups(id)"`
+		Created int    `sqlite:"created,order:2"`
+		Name    string `sqlite:"name"`
+		Owner   string `sqlite:"owner,references:users(id)"`
+		Note    string `sqlite:"note"`
+	}{})
+	if assert.NoError(t, err) {
+		reorderedSQL, err := reordered.CreateStatement()
+		assert.NoError(t, err)
+		assert.Equal(t, sql, reorderedSQL)
+	}
+
+	// Orders must be positive and unique.
+	_, err = GenerateTableSchema("invalid", struct {
+		A string `sqlite:"a,order:0"`
+	}{})
+	assert.Error(t, err)
+	_, err = GenerateTableSchema("invalid", struct {
+		A string `sqlite:"a,order:first"`
+	}{})
+	assert.Error(t, err)
+	_, err = GenerateTableSchema("invalid", struct {
+		A string `sqlite:"a,order:1"`
+		B string `sqlite:"b,order:1"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderIgnoredFields(t *testing.T) {
+	t.Parallel()
+
+	type model struct {
+		ID       int      `sqlite:"id,primary"`
+		Cache    []string `sqlite:"-"`
+		Name     string
+		Internal string      `sqlite:"-,text"`
+		Note     string      `sqlite:"note"`
+		Skipped  *Timestamps `sqlite:"-"`
+	}
+
+	res, err := GenerateTableSchema("mixed", model{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sql, err := res.CreateStatement()
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE mixed ( id INTEGER PRIMARY KEY NOT NULL, Name TEXT NOT NULL, note TEXT NOT NULL );`, sql)
+
+	// Ignored fields are neither encoded nor decoded.
+	params, err := ToParamMap(context.Background(), model{ID: 1, Cache: []string{"a"}, Internal: "secret"}, "", DefaultEncodeConfig)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"id": 1, "Name": "", "note": ""}, params)
+	}
+
+	var decoded model
+	err = DecodeStmt(context.Background(), res, rowStmt{
+		columns: []string{"id", "Name", "Internal", "-"},
+		values:  []interface{}{int64(1), "name", "internal", "dash"},
+	}, &decoded, DefaultDecodeConfig)
+	if assert.NoError(t, err) {
+		assert.Equal(t, model{ID: 1, Name: "name"}, decoded)
+	}
+}
+
+type Timestamps struct {
+	Created time.Time  `sqlite:"created,text,time"`
+	Updated *time.Time `sqlite:"updated,text,time"`
+}
+
+func TestSchemaBuilderEmbeddedStructs(t *testing.T) {
+	t.Parallel()
+
+	// Embedded structs are flattened, whether embedded by value or pointer.
+	users, err := GenerateTableSchema("users", struct {
+		ID int `sqlite:"id,primary"`
+		Timestamps
+		Name string `sqlite:"name"`
+	}{})
+	if assert.NoError(t, err) {
+		sql, err := users.CreateStatement()
+		assert.NoError(t, err)
+		assert.Equal(t, `CREATE TABLE users ( id INTEGER PRIMARY KEY NOT NULL, created TEXT NOT NULL, updated TEXT, name TEXT NOT NULL );`, sql)
+	}
+
+	groups, err := GenerateTableSchema("groups", &struct {
+		*Timestamps
+		ID int `sqlite:"id,primary,order:1"`
+	}{})
+	if assert.NoError(t, err) {
+		sql, err := groups.CreateStatement()
+		assert.NoError(t, err)
+		assert.Equal(t, `CREATE TABLE "groups" ( id INTEGER PRIMARY KEY NOT NULL, created TEXT NOT NULL, updated TEXT );`, sql)
+	}
+
+	// Embeds with a sqlite tag are stored in a single column.
+	tagged, err := GenerateTableSchema("tagged", struct {
+		ID         int `sqlite:"id,primary"`
+		Timestamps `sqlite:"timestamps,json"`
+	}{})
+	if assert.NoError(t, err) {
+		assert.Len(t, tagged.Columns, 2)
+		assert.NotNil(t, tagged.GetColumnDef("timestamps"))
+	}
+
+	// Columns of embedded structs must not collide with other columns.
+	_, err = GenerateTableSchema("invalid", struct {
+		ID      int    `sqlite:"id,primary"`
+		Created string `sqlite:"created"`
+		Timestamps
+	}{})
+	assert.ErrorContains(t, err, "column created")
+}
+
+func TestSchemaBuilderKeywords(t *testing.T) {
+	t.Parallel()
+
+	type keywordRow struct {
+		ID    int    `sqlite:"id,primary"`
+		Order int    `sqlite:",index"`
+		Group string `sqlite:"group,enum:a|b,index:index"`
+	}
+
+	res, err := GenerateTableSchema("select", keywordRow{})
+	require.NoError(t, err)
+
+	// Keywords are quoted, other identifiers are not.
+	sql, err := res.CreateStatement(WithIndexes())
+	require.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE "select" ( id INTEGER PRIMARY KEY NOT NULL, "Order" INTEGER NOT NULL, "group" TEXT NOT NULL CHECK ("group" IN ('a', 'b')) );`+
+			` CREATE INDEX idx_select_Order ON "select" ( "Order" ); CREATE INDEX "index" ON "select" ( "group" );`,
+		sql,
+	)
+
+	// All identifiers are quoted if requested.
+	quoted, err := res.CreateStatement(QuoteIdentifiers())
+	require.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE "select" ( "id" INTEGER PRIMARY KEY NOT NULL, "Order" INTEGER NOT NULL, "group" TEXT NOT NULL CHECK ("group" IN ('a', 'b')) );`,
+		quoted,
+	)
+
+	// The statements are valid SQL.
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	createSQL, err := res.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+	for _, stmt := range res.CreateIndexStatements() {
+		require.NoError(t, RunQuery(ctx, conn, stmt))
+	}
+
+	statements, err := BuildInsert("select", []interface{}{keywordRow{ID: 1, Order: 2, Group: "a"}})
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, statements[0].SQL, WithArgs(statements[0].Args...)))
+
+	query, args, err := res.Select("Order", "group").Where("group", "=", "a").OrderBy("Order", false).Build()
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT "Order", "group" FROM "select" WHERE "group" = ? ORDER BY "Order" ASC`, query)
+
+	var result []keywordRow
+	require.NoError(t, RunQuery(ctx, conn, query, WithArgs(args...), WithResult(&result)))
+	assert.Equal(t, []keywordRow{{Order: 2, Group: "a"}}, result)
+}
+
+func TestSchemaBuilderCollation(t *testing.T) {
+	t.Parallel()
+
+	type domainRow struct {
+		Domain string `sqlite:"domain,collate:nocase,index"`
+		Path   string `sqlite:"path,index:idx_path,index-collate:RTRIM"`
+	}
+
+	res, err := GenerateTableSchema("domains", domainRow{})
+	require.NoError(t, err)
+	assert.Equal(t, "NOCASE", res.Columns[0].Collate)
+
+	sql, err := res.CreateStatement(WithIndexes())
+	require.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE domains ( domain TEXT NOT NULL COLLATE NOCASE, path TEXT NOT NULL );`+
+			` CREATE INDEX idx_domains_domain ON domains ( domain ); CREATE INDEX idx_path ON domains ( path COLLATE RTRIM );`,
+		sql,
+	)
+
+	// The statements are valid SQL and the column compares case-insensitively.
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	createSQL, err := res.CreateStatement()
+	require.NoError(t, err)
+	require.NoError(t, RunQuery(ctx, conn, createSQL))
+	for _, stmt := range res.CreateIndexStatements() {
+		require.NoError(t, RunQuery(ctx, conn, stmt))
+	}
+	require.NoError(t, RunQuery(ctx, conn, `INSERT INTO domains (domain, path) VALUES ('Example.COM', '/')`))
+
+	var result []domainRow
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM domains WHERE domain = 'example.com'`, WithResult(&result)))
+	assert.Equal(t, []domainRow{{Domain: "Example.COM", Path: "/"}}, result)
+
+	// Only the built-in collating sequences are supported.
+	_, err = GenerateTableSchema("domains", struct {
+		Domain string `sqlite:"domain,collate:unicode"`
+	}{})
+	assert.ErrorContains(t, err, "invalid collating sequence")
+
+	// Index collations require an index.
+	_, err = GenerateTableSchema("domains", struct {
+		Domain string `sqlite:"domain,index-collate:NOCASE"`
+	}{})
+	assert.ErrorContains(t, err, "requires an index")
+}
+
+func TestSchemaBuilderCompositePrimaryKey(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("memberships", struct {
+		Group string `sqlite:"group_id,primary"`
+		Role  string `sqlite:"role"`
+		User  int    `sqlite:"user_id,primary,references:users(id)"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The primary key columns keep their declaration order.
+	sql, err := res.CreateStatement(WithoutRowID())
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE memberships ( group_id TEXT NOT NULL, role TEXT NOT NULL, user_id INTEGER NOT NULL,`+
+			` PRIMARY KEY (group_id, user_id), FOREIGN KEY (user_id) REFERENCES users(id) ) WITHOUT ROWID;`,
+		sql,
+	)
+
+	// AUTOINCREMENT requires a single primary key column.
+	_, err = GenerateTableSchema("invalid", struct {
+		ID   int `sqlite:"id,primary,autoincrement"`
+		User int `sqlite:"user_id,primary"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestSchemaBuilderForeignKeys(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("sessions", struct {
+		ID     int  `sqlite:"id,primary"`
+		User   int  `sqlite:"user_id,references:users(id)"`
+		Device *int `sqlite:"device_id,references:devices(id),on-delete:cascade,on-update:set-null"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sql, err := res.CreateStatement()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE sessions ( id INTEGER PRIMARY KEY NOT NULL, user_id INTEGER NOT NULL, device_id INTEGER,`+
+			` FOREIGN KEY (user_id) REFERENCES users(id),`+
+			` FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE ON UPDATE SET NULL );`,
+		sql,
+	)
+
+	for _, model := range []interface{}{
+		struct {
+			User int `sqlite:"user_id,references:users"`
+		}{},
+		struct {
+			User int `sqlite:"user_id,references:users(id); DROP TABLE users"`
+		}{},
+		struct {
+			User int `sqlite:"user_id,references:(id)"`
+		}{},
+		struct {
+			User int `sqlite:"user_id,references:users(id),on-delete:explode"`
+		}{},
+		struct {
+			User int `sqlite:"user_id,on-delete:cascade"`
+		}{},
+	} {
+		_, err := GenerateTableSchema("invalid", model)
+		assert.Error(t, err, "%T", model)
+	}
+}
+
+func TestSchemaBuilderTableOptions(t *testing.T) {
+	t.Parallel()
+
+	res, err := GenerateTableSchema("options", struct {
+		ID   string `sqlite:"id,primary"`
+		Name string `sqlite:"name,varchar(10)"`
+		Flag bool   `sqlite:"flag"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Strict tables use TEXT instead of VARCHAR and enforce the length.
+	sql, err := res.CreateStatement(IfNotExists(), Strict(), WithoutRowID())
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE IF NOT EXISTS options ( id TEXT PRIMARY KEY NOT NULL, name TEXT NOT NULL CHECK (length(name) <= 10), flag INTEGER NOT NULL CHECK (flag IN (0, 1)) ) STRICT, WITHOUT ROWID;`,
+		sql,
+	)
+
+	// Tables without rowid need a primary key and may not use AUTOINCREMENT.
+	res, err = GenerateTableSchema("no_pk", struct {
+		Name string `sqlite:"name"`
+	}{})
+	if assert.NoError(t, err) {
+		_, err = res.CreateStatement(WithoutRowID())
+		assert.ErrorContains(t, err, "tables without rowid require a primary key")
+	}
+	res, err = GenerateTableSchema("autoincrement", struct {
+		ID int `sqlite:"id,primary,autoincrement"`
+	}{})
+	if assert.NoError(t, err) {
+		_, err = res.CreateStatement(WithoutRowID())
+		assert.ErrorContains(t, err, "AUTOINCREMENT is not allowed in tables without rowid")
+	}
+
+	// Tables without rowid work with a composite primary key and can be used.
+	type lookupRow struct {
+		Domain string `sqlite:"domain,primary"`
+		Scope  string `sqlite:"scope,primary"`
+		Value  int    `sqlite:"value"`
+	}
+	res, err = GenerateTableSchema("lookups", lookupRow{})
+	require.NoError(t, err)
+	sql, err = res.CreateStatement(IfNotExists(), Strict(), WithoutRowID())
+	require.NoError(t, err)
+	assert.Equal(t,
+		`CREATE TABLE IF NOT EXISTS lookups ( domain TEXT NOT NULL, scope TEXT NOT NULL, value INTEGER NOT NULL, PRIMARY KEY (domain, scope) ) STRICT, WITHOUT ROWID;`,
+		sql,
+	)
+
+	ctx := context.Background()
+	conn, err := sqlite.OpenConn(":memory:")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	require.NoError(t, RunQuery(ctx, conn, sql))
+	require.NoError(t, RunQuery(ctx, conn, `INSERT INTO lookups (domain, scope, value) VALUES ('example.com.', 'global', 1)`))
+	assert.Error(t, RunQuery(ctx, conn, `SELECT rowid FROM lookups`), "table should not have a rowid")
+	var result []lookupRow
+	require.NoError(t, RunQuery(ctx, conn, `SELECT * FROM lookups`, WithResult(&result)))
+	assert.Equal(t, []lookupRow{{Domain: "example.com.", Scope: "global", Value: 1}}, result)
+
+	// Strict tables need a valid type for every column.
+	res, err = GenerateTableSchema("untyped", struct {
+		Value interface{} `sqlite:"value"`
+	}{})
+	if assert.NoError(t, err) {
+		_, err = res.CreateStatement(Strict())
+		assert.Error(t, err)
+		_, err = res.CreateStatement()
+		assert.NoError(t, err)
 	}
 }
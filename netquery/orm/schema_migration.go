@@ -0,0 +1,180 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// ErrUnsupportedSchemaChange is returned by DiffSchema if the desired schema
+// cannot be reached by altering the existing table.
+var ErrUnsupportedSchemaChange = errors.New("unsupported schema change")
+
+// DiffSchema returns the SQL statements that migrate the table of the existing
+// schema to the desired schema without losing data. SQLite only supports
+// adding columns to existing tables, so new columns are added with
+// ALTER TABLE ADD COLUMN, while new or changed indexes are (re-)created.
+// Any other change, like removing a column or changing its type, is reported
+// as ErrUnsupportedSchemaChange, describing all unsupported changes.
+func DiffSchema(existing, desired *TableSchema) ([]string, error) {
+	if existing.Name != desired.Name {
+		return nil, fmt.Errorf("%w: table %s cannot be migrated to table %s", ErrUnsupportedSchemaChange, existing.Name, desired.Name)
+	}
+
+	var (
+		statements  []string
+		unsupported []string
+	)
+
+	// columns
+	for _, col := range existing.Columns {
+		if desired.GetColumnDef(col.Name) == nil {
+			unsupported = append(unsupported, fmt.Sprintf("column %s was removed", col.Name))
+		}
+	}
+	for _, col := range desired.Columns {
+		existingCol := existing.GetColumnDef(col.Name)
+		if existingCol != nil {
+			unsupported = append(unsupported, diffColumn(*existingCol, col)...)
+			continue
+		}
+
+		stmt, err := addColumnStatement(desired.Name, col)
+		if err != nil {
+			unsupported = append(unsupported, err.Error())
+			continue
+		}
+		statements = append(statements, stmt)
+	}
+
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSchemaChange, strings.Join(unsupported, "; "))
+	}
+
+	// indexes are dropped before being created, in order to allow changing
+	// the columns of an index.
+	for _, idx := range existing.Indexes {
+		if desiredIdx := desired.getIndexDef(idx.Name); desiredIdx == nil || !idx.equal(*desiredIdx) {
+			statements = append(statements, "DROP INDEX IF EXISTS "+quoteIdentifier(idx.Name)+";")
+		}
+	}
+	for _, idx := range desired.Indexes {
+		if existingIdx := existing.getIndexDef(idx.Name); existingIdx == nil || !idx.equal(*existingIdx) {
+			statements = append(statements, idx.AsSQL(desired.Name, false))
+		}
+	}
+
+	return statements, nil
+}
+
+// addColumnStatement builds the ALTER TABLE ADD COLUMN statement for the
+// given column, or returns an error if sqlite cannot add the column to an
+// existing table.
+func addColumnStatement(table string, col ColumnDef) (string, error) {
+	switch {
+	case col.PrimaryKey:
+		return "", fmt.Errorf("primary key column %s cannot be added", col.Name)
+	case col.Unique:
+		return "", fmt.Errorf("unique column %s cannot be added", col.Name)
+	case !col.Nullable && (col.Default == "" || col.Default == "NULL"):
+		return "", fmt.Errorf("NOT NULL column %s cannot be added without a default value", col.Name)
+	case strings.HasPrefix(col.Default, "CURRENT_"):
+		return "", fmt.Errorf("column %s with default value %s cannot be added", col.Name, col.Default)
+	}
+
+	sql := "ALTER TABLE " + quoteIdentifier(table) + " ADD COLUMN " + col.AsSQL()
+
+	// table constraints cannot be added, use a column constraint instead
+	if fk := col.ForeignKey; fk != nil {
+		sql += " " + fk.referencesSQL(quoteIdentifier)
+	}
+
+	return sql + ";", nil
+}
+
+// diffColumn describes the differences between the existing and the desired
+// definition of a column.
+func diffColumn(existing, desired ColumnDef) []string {
+	var changes []string
+	changed := func(what string, from, to interface{}) {
+		changes = append(changes, fmt.Sprintf("column %s: %s changed from %v to %v", desired.Name, what, from, to))
+	}
+
+	if existingType, desiredType := existing.SQLType(), desired.SQLType(); existingType != desiredType {
+		changed("type", existingType, desiredType)
+	}
+	if existing.Nullable != desired.Nullable {
+		changed("nullable", existing.Nullable, desired.Nullable)
+	}
+	if existing.PrimaryKey != desired.PrimaryKey {
+		changed("primary key", existing.PrimaryKey, desired.PrimaryKey)
+	}
+	if existing.AutoIncrement != desired.AutoIncrement {
+		changed("autoincrement", existing.AutoIncrement, desired.AutoIncrement)
+	}
+	if existing.Unique != desired.Unique {
+		changed("unique", existing.Unique, desired.Unique)
+	}
+	if existing.Default != desired.Default {
+		changed("default value", orNone(existing.Default), orNone(desired.Default))
+	}
+	if existing.Collate != desired.Collate {
+		changed("collating sequence", orNone(existing.Collate), orNone(desired.Collate))
+	}
+	if existingFK, desiredFK := existing.foreignKeySQL(), desired.foreignKeySQL(); existingFK != desiredFK {
+		changed("foreign key", orNone(existingFK), orNone(desiredFK))
+	}
+
+	return changes
+}
+
+// SQLType returns the SQL type of the column as used in the column
+// definition, like INTEGER or VARCHAR(10).
+func (def ColumnDef) SQLType() string {
+	if def.Type == sqlite.TypeText && def.Length > 0 {
+		return fmt.Sprintf("VARCHAR(%d)", def.Length)
+	}
+	return sqlTypeMap[def.Type]
+}
+
+// foreignKeySQL returns the FOREIGN KEY constraint of the column, if any.
+func (def ColumnDef) foreignKeySQL() string {
+	if def.ForeignKey == nil {
+		return ""
+	}
+	return def.ForeignKey.AsSQL()
+}
+
+// getIndexDef returns the index definition with the given name.
+func (ts TableSchema) getIndexDef(name string) *IndexDef {
+	for _, idx := range ts.Indexes {
+		if idx.Name == name {
+			return &idx
+		}
+	}
+	return nil
+}
+
+// equal returns whether both indexes cover the same columns in the same
+// order with the same collating sequences and have the same uniqueness.
+func (idx IndexDef) equal(other IndexDef) bool {
+	if idx.Unique != other.Unique || len(idx.Columns) != len(other.Columns) {
+		return false
+	}
+	for i, column := range idx.Columns {
+		if column != other.Columns[i] || idx.Collations[column] != other.Collations[column] {
+			return false
+		}
+	}
+	return true
+}
+
+// orNone returns s or "none" if s is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package orm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSchema(t *testing.T) {
+	t.Parallel()
+
+	existing, err := GenerateTableSchema("connections", struct {
+		ID     string `sqlite:"id,primary"`
+		Domain string `sqlite:"domain,index"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	desired, err := GenerateTableSchema("connections", struct {
+		ID      string  `sqlite:"id,primary"`
+		Domain  string  `sqlite:"domain"`
+		Profile *string `sqlite:"profile,index"`
+		Country string  `sqlite:"country,varchar(2),default:''"`
+		Blocked bool    `sqlite:"blocked,default:false"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	statements, err := DiffSchema(existing, desired)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`ALTER TABLE connections ADD COLUMN profile TEXT;`,
+		`ALTER TABLE connections ADD COLUMN country VARCHAR(2) NOT NULL DEFAULT '';`,
+		`ALTER TABLE connections ADD COLUMN blocked INTEGER NOT NULL DEFAULT 0 CHECK (blocked IN (0, 1));`,
+		`DROP INDEX IF EXISTS idx_connections_domain;`,
+		`CREATE INDEX idx_connections_profile ON connections ( profile );`,
+	}, statements)
+
+	// Nothing to do if the schemas are equal.
+	statements, err = DiffSchema(desired, desired)
+	assert.NoError(t, err)
+	assert.Empty(t, statements)
+}
+
+func TestDiffSchemaUnsupported(t *testing.T) {
+	t.Parallel()
+
+	existing, err := GenerateTableSchema("connections", struct {
+		ID      string `sqlite:"id,primary"`
+		Count   string `sqlite:"count"`
+		Removed string `sqlite:"removed"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, model := range []interface{}{
+		// type change
+		struct {
+			ID      string `sqlite:"id,primary"`
+			Count   int    `sqlite:"count"`
+			Removed string `sqlite:"removed"`
+		}{},
+		// removed column
+		struct {
+			ID    string `sqlite:"id,primary"`
+			Count string `sqlite:"count"`
+		}{},
+		// new NOT NULL column without default value
+		struct {
+			ID      string `sqlite:"id,primary"`
+			Count   string `sqlite:"count"`
+			Removed string `sqlite:"removed"`
+			Added   string `sqlite:"added"`
+		}{},
+		// new unique column
+		struct {
+			ID      string  `sqlite:"id,primary"`
+			Count   string  `sqlite:"count"`
+			Removed string  `sqlite:"removed"`
+			Added   *string `sqlite:"added,unique"`
+		}{},
+	} {
+		desired, err := GenerateTableSchema("connections", model)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		_, err = DiffSchema(existing, desired)
+		assert.ErrorIs(t, err, ErrUnsupportedSchemaChange, "%T", model)
+	}
+
+	desired, err := GenerateTableSchema("connections", struct {
+		ID      string `sqlite:"id,primary"`
+		Count   int    `sqlite:"count"`
+		Removed string `sqlite:"removed"`
+	}{})
+	if assert.NoError(t, err) {
+		_, err = DiffSchema(existing, desired)
+		assert.EqualError(t, err, "unsupported schema change: column count: type changed from TEXT to INTEGER")
+	}
+}
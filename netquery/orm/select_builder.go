@@ -0,0 +1,173 @@
+package orm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// SelectBuilder builds parameterized SELECT statements for a table
+	// schema. Column names are validated against the schema and values are
+	// encoded using the column definition, so they match the stored values.
+	//
+	// Errors are collected while building and returned by Build.
+	//
+	// Example:
+	//
+	//	sql, args, err := schema.Select("id", "domain").
+	//		Where("domain", "LIKE", "%.example.com.").
+	//		And("started", ">", since).
+	//		OrderBy("started", true).
+	//		Limit(10).
+	//		Build()
+	SelectBuilder struct {
+		schema       *TableSchema
+		encodeConfig EncodeConfig
+
+		columns    []string
+		conditions []string
+		args       []interface{}
+		orderBy    []string
+		limit      int
+		offset     int
+
+		err error
+	}
+)
+
+// Select returns a SelectBuilder for the table that selects the given
+// columns. If no columns are given, all columns are selected.
+func (ts *TableSchema) Select(columns ...string) *SelectBuilder {
+	b := &SelectBuilder{
+		schema:       ts,
+		encodeConfig: DefaultEncodeConfig,
+	}
+
+	for _, col := range columns {
+		if b.checkColumn(col) {
+			b.columns = append(b.columns, col)
+		}
+	}
+
+	return b
+}
+
+// WithEncodeConfig sets the EncodeConfig used to encode condition values.
+// If not specified, DefaultEncodeConfig is used.
+func (b *SelectBuilder) WithEncodeConfig(cfg EncodeConfig) *SelectBuilder {
+	b.encodeConfig = cfg
+	return b
+}
+
+// Where adds a condition comparing column to value using operator. It is the
+// same as And, but reads better as the first condition.
+func (b *SelectBuilder) Where(column, operator string, value interface{}) *SelectBuilder {
+	return b.And(column, operator, value)
+}
+
+// And adds a condition that must match in addition to the previous ones.
+// As in SQL, AND takes precedence over OR.
+func (b *SelectBuilder) And(column, operator string, value interface{}) *SelectBuilder {
+	return b.addCondition("AND", column, operator, value)
+}
+
+// Or adds a condition that matches as an alternative to the previous ones.
+// As in SQL, AND takes precedence over OR.
+func (b *SelectBuilder) Or(column, operator string, value interface{}) *SelectBuilder {
+	return b.addCondition("OR", column, operator, value)
+}
+
+// OrderBy adds a column to sort the result by.
+func (b *SelectBuilder) OrderBy(column string, desc bool) *SelectBuilder {
+	if !b.checkColumn(column) {
+		return b
+	}
+
+	if desc {
+		b.orderBy = append(b.orderBy, quoteIdentifier(column)+" DESC")
+	} else {
+		b.orderBy = append(b.orderBy, quoteIdentifier(column)+" ASC")
+	}
+	return b
+}
+
+// Limit limits the number of returned rows. A value of zero or less removes
+// the limit.
+func (b *SelectBuilder) Limit(n int) *SelectBuilder {
+	b.limit = n
+	return b
+}
+
+// Offset skips the first n rows of the result.
+func (b *SelectBuilder) Offset(n int) *SelectBuilder {
+	b.offset = n
+	return b
+}
+
+// Build returns the SELECT statement and the positional arguments for its
+// placeholders, or the first error encountered while building the query.
+func (b *SelectBuilder) Build() (string, []interface{}, error) {
+	if b.err != nil {
+		return "", nil, b.err
+	}
+
+	sql := "SELECT "
+	if len(b.columns) == 0 {
+		sql += "*"
+	} else {
+		sql += strings.Join(quoteIdentifiers(b.columns, quoteIdentifier), ", ")
+	}
+	sql += " FROM " + quoteIdentifier(b.schema.Name)
+
+	if len(b.conditions) > 0 {
+		sql += " WHERE " + strings.Join(b.conditions, " ")
+	}
+	if len(b.orderBy) > 0 {
+		sql += " ORDER BY " + strings.Join(b.orderBy, ", ")
+	}
+
+	switch {
+	case b.limit > 0:
+		sql += fmt.Sprintf(" LIMIT %d", b.limit)
+		if b.offset > 0 {
+			sql += fmt.Sprintf(" OFFSET %d", b.offset)
+		}
+	case b.offset > 0:
+		// sqlite requires a LIMIT for OFFSET, -1 means no limit
+		sql += fmt.Sprintf(" LIMIT -1 OFFSET %d", b.offset)
+	}
+
+	return sql, b.args, nil
+}
+
+func (b *SelectBuilder) addCondition(conjunction, column, operator string, value interface{}) *SelectBuilder {
+	condition, args, err := b.schema.Condition(column, operator, value, b.encodeConfig)
+	if err != nil {
+		b.setErr(err)
+		return b
+	}
+
+	if len(b.conditions) > 0 {
+		condition = conjunction + " " + condition
+	}
+	b.conditions = append(b.conditions, condition)
+	b.args = append(b.args, args...)
+
+	return b
+}
+
+// checkColumn returns whether column exists in the table and records an
+// error otherwise.
+func (b *SelectBuilder) checkColumn(column string) bool {
+	if b.schema.GetColumnDef(column) == nil {
+		b.setErr(fmt.Errorf("unknown column %s in table %s", column, b.schema.Name))
+		return false
+	}
+	return true
+}
+
+func (b *SelectBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
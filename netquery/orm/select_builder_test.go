@@ -0,0 +1,74 @@
+package orm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectBuilder(t *testing.T) {
+	t.Parallel()
+
+	schema, err := GenerateTableSchema("connections", struct {
+		ID       string    `sqlite:"id,primary"`
+		Domain   string    `sqlite:"domain"`
+		External bool      `sqlite:"external"`
+		Port     int       `sqlite:"port"`
+		Started  time.Time `sqlite:"started,text,time"`
+		Ended    *string   `sqlite:"ended"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	started := time.Date(2022, time.February, 15, 9, 51, 0, 0, time.UTC)
+	sql, args, err := schema.Select("id", "domain").
+		Where("domain", "=", "example.com.").
+		And("external", "=", true).
+		And("port", "in", []int{80, 443}).
+		Or("started", ">=", started).
+		And("ended", "IS NULL", nil).
+		OrderBy("started", true).
+		OrderBy("id", false).
+		Limit(10).
+		Offset(20).
+		Build()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`SELECT id, domain FROM connections WHERE domain = ? AND external = ? AND port IN ( ?, ? ) OR started >= ? AND ended IS NULL ORDER BY started DESC, id ASC LIMIT 10 OFFSET 20`,
+		sql,
+	)
+	assert.Equal(t, []interface{}{"example.com.", 1, 80, 443, "2022-02-15 09:51:00"}, args)
+
+	sql, args, err = schema.Select().Offset(5).Build()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM connections LIMIT -1 OFFSET 5`, sql)
+	assert.Empty(t, args)
+}
+
+func TestSelectBuilderInvalid(t *testing.T) {
+	t.Parallel()
+
+	schema, err := GenerateTableSchema("connections", struct {
+		ID     string `sqlite:"id,primary"`
+		Domain string `sqlite:"domain"`
+	}{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, b := range []*SelectBuilder{
+		schema.Select("unknown"),
+		schema.Select().Where("unknown", "=", 1),
+		schema.Select().Where("domain", "=", "a").Or("domain; DROP TABLE connections", "=", "b"),
+		schema.Select().OrderBy("unknown", false),
+		schema.Select().Where("domain", "==", "a"),
+		schema.Select().Where("domain", "IN", "a"),
+		schema.Select().Where("domain", "IN", []string{}),
+		schema.Select().Where("domain", "IS NULL", "a"),
+	} {
+		_, _, err := b.Build()
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,163 @@
+package netquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+
+	"github.com/safing/portmaster/netquery/orm"
+)
+
+// subscriptionBufferSize is the amount of events that are buffered for a
+// subscriber before further events are dropped.
+const subscriptionBufferSize = 256
+
+// Available connection event types.
+const (
+	ConnectionInserted ConnectionEventType = "insert"
+	ConnectionUpdated  ConnectionEventType = "update"
+	ConnectionDeleted  ConnectionEventType = "delete"
+)
+
+type (
+	// ConnectionEventType describes what happened to a connection row.
+	ConnectionEventType string
+
+	// ConnectionEvent is sent to subscribers when a connection row is
+	// inserted, updated or deleted.
+	ConnectionEvent struct {
+		Type ConnectionEventType `json:"type"`
+		Conn Conn                `json:"conn"`
+	}
+
+	// ConnectionFilter decides whether a subscriber receives events of a
+	// connection.
+	ConnectionFilter func(Conn) bool
+
+	subscription struct {
+		filter ConnectionFilter
+		events chan ConnectionEvent
+	}
+
+	subscriptions struct {
+		l       sync.RWMutex
+		subs    map[*subscription]struct{}
+		active  atomic.Bool
+		dropped atomic.Uint64
+	}
+)
+
+// Subscribe returns a channel that receives an event whenever a connection
+// row matching filter is inserted, updated or deleted. A nil filter matches
+// all connections. Events are sent from the write path of the database and
+// never block it: if the buffer of the subscriber is full, events are dropped
+// and counted instead (see DroppedEvents).
+// The returned function cancels the subscription and closes the channel.
+func (db *Database) Subscribe(filter ConnectionFilter) (<-chan ConnectionEvent, func()) {
+	sub := &subscription{
+		filter: filter,
+		events: make(chan ConnectionEvent, subscriptionBufferSize),
+	}
+
+	db.subs.l.Lock()
+	defer db.subs.l.Unlock()
+
+	if db.subs.subs == nil {
+		db.subs.subs = make(map[*subscription]struct{})
+	}
+	db.subs.subs[sub] = struct{}{}
+	db.subs.active.Store(true)
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			db.subs.l.Lock()
+			defer db.subs.l.Unlock()
+
+			delete(db.subs.subs, sub)
+			db.subs.active.Store(len(db.subs.subs) > 0)
+			close(sub.events)
+		})
+	}
+}
+
+// DroppedEvents returns the amount of connection events that were dropped,
+// because subscribers did not keep up.
+func (db *Database) DroppedEvents() uint64 {
+	return db.subs.dropped.Load()
+}
+
+// hasSubscribers returns whether anyone is subscribed to connection events.
+func (db *Database) hasSubscribers() bool {
+	return db.subs.active.Load()
+}
+
+// publish sends an event for each of the given connections to all matching
+// subscribers, without blocking.
+func (db *Database) publish(eventType ConnectionEventType, conns ...Conn) {
+	db.subs.l.RLock()
+	defer db.subs.l.RUnlock()
+
+	for _, conn := range conns {
+		for sub := range db.subs.subs {
+			if sub.filter != nil && !sub.filter(conn) {
+				continue
+			}
+
+			select {
+			case sub.events <- ConnectionEvent{Type: eventType, Conn: conn}:
+			default:
+				db.subs.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// connExists returns whether a connection with the given ID is stored. It
+// must be called with the write lock held.
+func (db *Database) connExists(id string) (bool, error) {
+	var exists bool
+	err := sqlitex.Execute(db.writeConn, "SELECT 1 FROM connections WHERE id = :id", &sqlitex.ExecOptions{
+		Named: map[string]interface{}{
+			":id": id,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			exists = true
+			return nil
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check if connection %s exists: %w", id, err)
+	}
+	return exists, nil
+}
+
+// executeDelete executes the DELETE statement sql and returns the number of
+// removed rows. If there are subscribers, the removed rows are returned by
+// the statement and published.
+func (db *Database) executeDelete(ctx context.Context, sql string, args ...orm.QueryOption) (int, error) {
+	db.l.Lock()
+	defer db.l.Unlock()
+
+	if !db.hasSubscribers() {
+		if err := orm.RunQuery(ctx, db.writeConn, sql, args...); err != nil {
+			return 0, err
+		}
+		return db.writeConn.Changes(), nil
+	}
+
+	var deleted []Conn
+	sql = strings.TrimSuffix(strings.TrimSpace(sql), ";") + " RETURNING *;"
+	args = append(args, orm.WithResult(&deleted), orm.WithSchema(*db.Schema))
+	if err := orm.RunQuery(ctx, db.writeConn, sql, args...); err != nil {
+		return 0, err
+	}
+	db.publish(ConnectionDeleted, deleted...)
+
+	return len(deleted), nil
+}
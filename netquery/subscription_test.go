@@ -0,0 +1,118 @@
+package netquery
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveEvent(t *testing.T, events <-chan ConnectionEvent) ConnectionEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return ConnectionEvent{}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := New("file:subscribe-test.db")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	require.NoError(t, db.ApplyMigrations())
+
+	events, cancel := db.Subscribe(func(conn Conn) bool {
+		return conn.Domain == "example.com."
+	})
+	defer cancel()
+
+	started := time.Now().UTC().Truncate(time.Second)
+	conn := Conn{
+		ID:      "conn-1",
+		Type:    ConnTypeIP,
+		Domain:  "example.com.",
+		Started: started,
+		Active:  true,
+	}
+
+	// Check that inserting and updating a row is streamed.
+	require.NoError(t, db.Save(ctx, conn))
+	event := receiveEvent(t, events)
+	assert.Equal(t, ConnectionInserted, event.Type)
+	assert.Equal(t, conn, event.Conn)
+
+	ended := started.Add(time.Minute)
+	conn.Ended = &ended
+	conn.Active = false
+	require.NoError(t, db.Save(ctx, conn))
+	event = receiveEvent(t, events)
+	assert.Equal(t, ConnectionUpdated, event.Type)
+	assert.Equal(t, &ended, event.Conn.Ended)
+
+	// Check that rows not matching the filter are not streamed.
+	require.NoError(t, db.Save(ctx, Conn{
+		ID:      "conn-2",
+		Type:    ConnTypeIP,
+		Domain:  "other.example.",
+		Started: started,
+		Ended:   &ended,
+	}))
+
+	// Check that removed rows are streamed.
+	removed, err := db.Prune(ctx, ended.Add(time.Second), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	event = receiveEvent(t, events)
+	assert.Equal(t, ConnectionDeleted, event.Type)
+	assert.Equal(t, "conn-1", event.Conn.ID)
+	assert.Equal(t, "example.com.", event.Conn.Domain)
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %s for %s", event.Type, event.Conn.ID)
+	default:
+	}
+
+	// Check that cancelling closes the channel.
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, err := New("file:subscribe-slow-test.db")
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	require.NoError(t, db.ApplyMigrations())
+
+	events, cancel := db.Subscribe(nil)
+	defer cancel()
+
+	// Check that a subscriber that does not read does not block the writer.
+	for i := 0; i < subscriptionBufferSize+10; i++ {
+		require.NoError(t, db.Save(ctx, Conn{
+			ID:      fmt.Sprintf("conn-%d", i),
+			Type:    ConnTypeIP,
+			Started: time.Now().UTC(),
+		}))
+	}
+	assert.Len(t, events, subscriptionBufferSize)
+	assert.Equal(t, uint64(10), db.DroppedEvents())
+}
@@ -31,12 +31,32 @@ func registerAPI() error {
 	}
 
 	if err := api.RegisterEndpoint(api.Endpoint{
-		Path:      `dns/cache/{query:[a-z0-9\.-]{0,512}\.[A-Z]{1,32}}`,
-		Read:      api.PermitUser,
-		BelongsTo: module,
-		RecordFunc: func(r *api.Request) (record.Record, error) {
-			return recordDatabase.Get(nameRecordsKeyPrefix + r.URLVars["query"])
-		},
+		Path:        "dns/stats",
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  func(*api.Request) (interface{}, error) { return Stats(), nil },
+		Name:        "Get DNS Resolver Statistics",
+		Description: "Returns query statistics of all DNS resolvers and the DNS cache.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "dns/health",
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  func(*api.Request) (interface{}, error) { return HealthSnapshot(), nil },
+		Name:        "Get DNS Resolver Health",
+		Description: "Returns the health status of all configured DNS resolvers.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `dns/cache/{query:[a-z0-9\.-]{0,512}\.[A-Z]{1,32}}`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		RecordFunc:  getCachedNameRecordHandler,
 		Name:        "Get DNS Record from Cache",
 		Description: "Returns cached dns records from the internal cache.",
 		Parameters: []api.Parameter{{
@@ -71,3 +91,44 @@ func exportDNSResolvers(*api.Request) (interface{}, error) {
 
 	return export, nil
 }
+
+// getCachedNameRecordHandler returns the cached NameRecord of the requested
+// query. The records are returned in presentation format, as the packed
+// records are not readable.
+func getCachedNameRecordHandler(ar *api.Request) (record.Record, error) {
+	r, err := recordDatabase.Get(nameRecordsKeyPrefix + ar.URLVars["query"])
+	if err != nil {
+		return nil, err
+	}
+	nameRecord, err := parseNameRecord(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nameRecord.Lock()
+	defer nameRecord.Unlock()
+
+	answer, ns, extra, err := nameRecord.sections()
+	if err != nil {
+		return nil, err
+	}
+	export := &NameRecord{
+		Domain:            nameRecord.Domain,
+		Question:          nameRecord.Question,
+		RCode:             nameRecord.RCode,
+		Expires:           nameRecord.Expires,
+		Answer:            toPresentationFormat(answer),
+		Ns:                toPresentationFormat(ns),
+		Extra:             toPresentationFormat(extra),
+		Resolver:          nameRecord.Resolver,
+		AuthenticatedData: nameRecord.AuthenticatedData,
+		Insecure:          nameRecord.Insecure,
+		ECSNetwork:        nameRecord.ECSNetwork,
+		ECSScope:          nameRecord.ECSScope,
+		ClientScope:       nameRecord.ClientScope,
+		Checksum:          nameRecord.Checksum,
+	}
+	export.SetKey(nameRecord.Key())
+	export.SetMeta(nameRecord.Meta())
+	return export, nil
+}
@@ -0,0 +1,43 @@
+package resolver
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/api"
+)
+
+func TestCachedNameRecordAPI(t *testing.T) {
+	t.Parallel()
+
+	rrCache := newTestPackingRRCache(t, "api.example.com.")
+	require.NoError(t, rrCache.Save())
+
+	// Check that the packed records are returned in presentation format.
+	r, err := getCachedNameRecordHandler(&api.Request{
+		URLVars: map[string]string{"query": "api.example.com.A"},
+	})
+	require.NoError(t, err)
+	nameRecord, ok := r.(*NameRecord)
+	require.True(t, ok)
+	assert.Empty(t, nameRecord.Packed)
+	assert.Equal(t, toPresentationFormat(rrCache.Answer), nameRecord.Answer)
+	assert.Equal(t, toPresentationFormat(rrCache.Ns), nameRecord.Ns)
+	assert.Equal(t, toPresentationFormat(rrCache.Extra), nameRecord.Extra)
+	assert.Equal(t, nameRecordsKeyPrefix+"api.example.com.A", nameRecord.Key())
+	assert.NotNil(t, nameRecord.Meta())
+
+	data, err := json.Marshal(nameRecord)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "edge.api.example.com.\\t300\\tIN\\tA\\t192.0.2.1")
+	assert.NotContains(t, string(data), "Packed")
+
+	// Check that unknown queries fail.
+	_, err = getCachedNameRecordHandler(&api.Request{
+		URLVars: map[string]string{"query": "unknown.example.com.A"},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,34 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+)
+
+// ResolveAsync resolves the given query in a module worker and calls callback
+// with the result when done. The query goes through Resolve, so it is
+// deduplicated with any other queries for the same record.
+//
+// The returned function cancels the interest of the caller: afterwards, the
+// callback is not called anymore. Cancelling ctx has the same effect. The
+// query itself is not aborted, as other queries might be waiting for it.
+func ResolveAsync(ctx context.Context, q *Query, callback func(*RRCache, error)) (cancel func()) {
+	// The callback is called at most once and never after cancelling.
+	var callbackOnce sync.Once
+
+	module.StartWorker("resolve async query", func(workerCtx context.Context) error {
+		// Do not resolve with ctx, as cancelling it would abort the query for
+		// everyone waiting for it.
+		rrCache, err := Resolve(workerCtx, q)
+		callbackOnce.Do(func() {
+			if ctx.Err() == nil {
+				callback(rrCache, err)
+			}
+		})
+		return nil
+	})
+
+	return func() {
+		callbackOnce.Do(func() {})
+	}
+}
@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAsync(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, _, restore := useTestCountingResolver(200 * time.Millisecond)
+	defer restore()
+
+	// Concurrent async and sync queries collapse into one upstream query.
+	fqdn := "async." + InternalSpecialUseDomain
+	done := make(chan error, 1)
+	_ = ResolveAsync(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)}, func(rrCache *RRCache, err error) {
+		if err == nil && len(rrCache.Answer) != 1 {
+			err = ErrNotFound
+		}
+		done <- err
+	})
+	time.Sleep(50 * time.Millisecond)
+	_, err := Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+	assert.NoError(t, err)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(maxRequestTimeout):
+		t.Fatal("callback was not called")
+	}
+	assert.Equal(t, int32(1), conn.queries.Load(), "only one upstream query should be sent")
+
+	// Cancelling does not abort the query for others waiting for it.
+	fqdn = "async-cancel." + InternalSpecialUseDomain
+	called := make(chan struct{}, 1)
+	cancel := ResolveAsync(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)}, func(*RRCache, error) {
+		called <- struct{}{}
+	})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	rrCache, err := Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+	if assert.NoError(t, err) {
+		assert.Len(t, rrCache.Answer, 1)
+	}
+	assert.Equal(t, int32(2), conn.queries.Load(), "the cancelled query should still be shared")
+	select {
+	case <-called:
+		t.Fatal("callback was called after cancelling")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
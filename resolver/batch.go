@@ -0,0 +1,51 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+)
+
+// maxBatchParallelism limits how many queries of a batch are resolved at the
+// same time, in order to not overflow the query queues of the resolvers.
+const maxBatchParallelism = 16
+
+// BatchResult holds the result of a single query of a batch.
+type BatchResult struct {
+	RRCache *RRCache
+	Err     error
+}
+
+// ResolveBatch resolves the given queries concurrently with bounded
+// parallelism and returns their results in the same order. Every query goes
+// through Resolve separately, so it is checked, deduplicated and cached
+// individually. A failing query does not affect the other queries of the
+// batch.
+func ResolveBatch(ctx context.Context, queries []*Query) []*BatchResult {
+	results := make([]*BatchResult, len(queries))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxBatchParallelism)
+	for i, q := range queries {
+		if q == nil || !q.check() {
+			results[i] = &BatchResult{Err: ErrInvalid}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			rrCache, err := Resolve(ctx, queries[i])
+			results[i] = &BatchResult{
+				RRCache: rrCache,
+				Err:     err,
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/status"
+)
+
+func TestResolveBatch(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, _, restore := useTestCountingResolver(100 * time.Millisecond)
+	defer restore()
+
+	queries := []*Query{
+		{FQDN: "one.batch." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeA)},
+		nil,
+		{FQDN: "two.batch." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeA)},
+		{FQDN: "one.batch." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeAXFR), SecurityLevel: status.SecurityLevelHigh},
+	}
+	for _, q := range queries {
+		if q != nil {
+			_ = ResetCachedRecord(q.FQDN, q.QType.String())
+		}
+	}
+	results := ResolveBatch(context.Background(), queries)
+
+	// Check that the results are aligned to the queries.
+	if !assert.Len(t, results, len(queries)) {
+		return
+	}
+	assert.ErrorIs(t, results[1].Err, ErrInvalid)
+	assert.ErrorIs(t, results[3].Err, ErrQTypeBlocked)
+	for _, i := range []int{0, 2} {
+		if assert.NoError(t, results[i].Err) {
+			assert.Equal(t, queries[i].FQDN, results[i].RRCache.Domain)
+		}
+	}
+
+	// Check that only the valid queries were sent.
+	assert.Equal(t, int32(2), conn.queries.Load())
+}
@@ -0,0 +1,110 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// BlockMode defines how queries for blocklisted domains are answered.
+type BlockMode uint8
+
+// Block Modes.
+const (
+	// BlockModeNXDomain answers blocked queries with NXDOMAIN.
+	BlockModeNXDomain BlockMode = iota
+	// BlockModeRefused answers blocked queries with REFUSED.
+	BlockModeRefused
+	// BlockModeSinkhole answers blocked A and AAAA queries with the
+	// unspecified address (0.0.0.0 and ::) and other queries with an empty
+	// answer.
+	BlockModeSinkhole
+)
+
+// BlocklistChecker returns whether the query is blocked by a blocklist and
+// how it should be answered. It is called for every query and must be fast.
+type BlocklistChecker func(q *Query) (blocked bool, mode BlockMode)
+
+// blocklistSinkholeTTL is the TTL of sinkholed answers.
+const blocklistSinkholeTTL = 60
+
+var (
+	// ErrBlocklisted is returned for queries blocked by the blocklist checker
+	// with BlockModeRefused.
+	ErrBlocklisted = fmt.Errorf("%w: domain is blocklisted", ErrBlocked)
+
+	// errBlocklistedNXDomain is returned for queries blocked by the blocklist
+	// checker with BlockModeNXDomain.
+	errBlocklistedNXDomain = fmt.Errorf("%w: domain is blocklisted", ErrNotFound)
+
+	// errBlocklistSinkhole is returned by the compliance check when a query
+	// should be answered with a sinkholed response.
+	errBlocklistSinkhole = errors.New("blocklist sinkhole")
+
+	blocklistChecker atomic.Pointer[BlocklistChecker]
+)
+
+// SetBlocklistChecker sets the function that is consulted for every query in
+// order to block domains on a blocklist. Blocked queries are not cached and
+// never sent to a DNS server. Set to nil to remove the checker.
+func SetBlocklistChecker(checker BlocklistChecker) {
+	if checker == nil {
+		blocklistChecker.Store(nil)
+		return
+	}
+	blocklistChecker.Store(&checker)
+}
+
+// checkBlocklist returns the error for a query blocked by the blocklist
+// checker, or nil if the query is not blocked.
+func (q *Query) checkBlocklist() error {
+	checker := blocklistChecker.Load()
+	if checker == nil {
+		return nil
+	}
+
+	blocked, mode := (*checker)(q)
+	if !blocked {
+		return nil
+	}
+
+	switch mode {
+	case BlockModeRefused:
+		return ErrBlocklisted
+	case BlockModeSinkhole:
+		return errBlocklistSinkhole
+	default:
+		return errBlocklistedNXDomain
+	}
+}
+
+// blocklistSinkholeResponse returns a synthesized response to a query that is
+// sinkholed by the blocklist checker.
+func blocklistSinkholeResponse(q *Query) *RRCache {
+	rrCache := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Expires:  time.Now().Add(blocklistSinkholeTTL * time.Second).Unix(),
+		Resolver: envResolver.Info.Copy(),
+	}
+
+	hdr := dns.RR_Header{
+		Name:   q.FQDN,
+		Rrtype: uint16(q.QType),
+		Class:  dns.ClassINET,
+		Ttl:    blocklistSinkholeTTL,
+	}
+	switch uint16(q.QType) {
+	case dns.TypeA:
+		rrCache.Answer = []dns.RR{&dns.A{Hdr: hdr, A: net.IPv4zero}}
+	case dns.TypeAAAA:
+		rrCache.Answer = []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero}}
+	}
+
+	return rrCache
+}
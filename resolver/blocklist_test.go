@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklistChecker(t *testing.T) { //nolint:paralleltest // Changes the global blocklist checker.
+	modes := map[string]BlockMode{
+		"nxdomain.blocklist.example.com.": BlockModeNXDomain,
+		"refused.blocklist.example.com.":  BlockModeRefused,
+		"sinkhole.blocklist.example.com.": BlockModeSinkhole,
+	}
+	SetBlocklistChecker(func(q *Query) (bool, BlockMode) {
+		mode, ok := modes[q.FQDN]
+		return ok, mode
+	})
+	defer SetBlocklistChecker(nil)
+
+	resolve := func(fqdn string, qType uint16) (*RRCache, error) {
+		return Resolve(silencingTraceCtx, &Query{FQDN: fqdn, QType: dns.Type(qType)})
+	}
+
+	// NXDOMAIN
+	_, err := resolve("nxdomain.blocklist.example.com.", dns.TypeA)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotErrorIs(t, err, ErrBlocked)
+
+	// REFUSED
+	_, err = resolve("refused.blocklist.example.com.", dns.TypeA)
+	assert.ErrorIs(t, err, ErrBlocklisted)
+	assert.ErrorIs(t, err, ErrBlocked)
+
+	// Sinkhole
+	rrCache, err := resolve("sinkhole.blocklist.example.com.", dns.TypeA)
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "0.0.0.0", rrCache.Answer[0].(*dns.A).A.String()) //nolint:forcetypeassert // Checked by test.
+		assert.Equal(t, AnswerSourceSynthesized, rrCache.Source())
+	}
+	rrCache, err = resolve("sinkhole.blocklist.example.com.", dns.TypeAAAA)
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "::", rrCache.Answer[0].(*dns.AAAA).AAAA.String()) //nolint:forcetypeassert // Checked by test.
+	}
+	rrCache, err = resolve("sinkhole.blocklist.example.com.", dns.TypeTXT)
+	if assert.NoError(t, err) {
+		assert.True(t, rrCache.IsNODATA())
+	}
+
+	// Sinkholed answers are not cached.
+	_, err = getRRCache("sinkhole.blocklist.example.com.", dns.Type(dns.TypeA), "")
+	assert.Error(t, err)
+
+	// Other domains are not blocked, without allocating.
+	q := &Query{FQDN: "allowed.blocklist.example.com.", QType: dns.Type(dns.TypeA)}
+	if assert.True(t, q.check()) {
+		assert.NoError(t, q.checkCompliance())
+	}
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		_ = q.checkBlocklist()
+	}))
+
+	// Without a checker, nothing is blocked.
+	SetBlocklistChecker(nil)
+	q = &Query{FQDN: "refused.blocklist.example.com.", QType: dns.Type(dns.TypeA)}
+	if assert.True(t, q.check()) {
+		assert.NoError(t, q.checkCompliance())
+	}
+}
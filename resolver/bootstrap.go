@@ -0,0 +1,135 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+)
+
+// bootstrapRefreshInterval defines how long a bootstrapped IP address is used
+// before it is resolved again.
+const bootstrapRefreshInterval = 30 * time.Minute
+
+// bootstrapResolvers holds the resolvers used to resolve the domains of
+// resolvers that are configured by domain only. Protected by resolversLock.
+var bootstrapResolvers []*Resolver
+
+// bootstrapState holds the bootstrapped IP address of a resolver.
+type bootstrapState struct {
+	sync.Mutex
+
+	ip         net.IP
+	resolvedAt time.Time
+}
+
+// getBootstrapResolvers returns the resolvers to use for bootstrapping. If no
+// bootstrap resolvers are configured, the system resolvers are used.
+// Must be called with resolversLock held.
+func getBootstrapResolvers() (resolvers []*Resolver) {
+	for _, server := range bootstrapNameServers() {
+		resolver, skip, err := createResolver(server, ServerSourceBootstrap)
+		switch {
+		case err != nil:
+			log.Errorf("resolver: cannot use bootstrap resolver %s: %s", server, err)
+		case skip:
+		case resolver.bootstrap != nil:
+			log.Errorf("resolver: cannot use bootstrap resolver %s: must be configured by IP address", server)
+		default:
+			resolvers = append(resolvers, resolver)
+		}
+	}
+
+	if len(resolvers) == 0 {
+		resolvers = systemResolvers
+	}
+	return resolvers
+}
+
+// getServerAddress returns the address to connect to. If the resolver is
+// configured by domain only, the domain is resolved via the bootstrap
+// resolvers and the resulting IP address is pinned until it is refreshed.
+func (resolver *Resolver) getServerAddress(ctx context.Context) (string, error) {
+	if resolver.bootstrap == nil {
+		return resolver.ServerAddress, nil
+	}
+
+	ip, err := resolver.bootstrap.getIP(ctx, resolver.Info.Domain)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(resolver.Info.Port))), nil
+}
+
+func (bs *bootstrapState) getIP(ctx context.Context, domain string) (net.IP, error) {
+	bs.Lock()
+	defer bs.Unlock()
+
+	// Return pinned IP if still fresh.
+	if bs.ip != nil && time.Since(bs.resolvedAt) < bootstrapRefreshInterval {
+		return bs.ip, nil
+	}
+
+	ip, err := bootstrap(ctx, domain)
+	if err != nil {
+		// Continue to use the previous IP, if available.
+		if bs.ip != nil {
+			log.Tracer(ctx).Warningf("resolver: failed to refresh IP of %s, continuing to use %s: %s", domain, bs.ip, err)
+			return bs.ip, nil
+		}
+		return nil, err
+	}
+
+	log.Tracer(ctx).Debugf("resolver: bootstrapped %s to %s", domain, ip)
+	bs.ip = ip
+	bs.resolvedAt = time.Now()
+	return ip, nil
+}
+
+// bootstrap resolves the given domain via the bootstrap resolvers. The
+// queries are sent to the resolvers directly, bypassing the cache and
+// compliance checks.
+func bootstrap(ctx context.Context, domain string) (net.IP, error) {
+	resolversLock.RLock()
+	resolvers := bootstrapResolvers
+	resolversLock.RUnlock()
+
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("%w: no bootstrap servers available to resolve %s", ErrBootstrapFailed, domain)
+	}
+
+	lastErr := ErrNotFound
+	for _, resolver := range resolvers {
+		for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			q := &Query{
+				FQDN:  domain,
+				QType: dns.Type(qType),
+			}
+			if !q.check() {
+				return nil, fmt.Errorf("%w: invalid domain %s", ErrBootstrapFailed, domain)
+			}
+
+			rrCache, err := resolver.Conn.Query(ctx, q)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			for _, rr := range rrCache.Answer {
+				switch v := rr.(type) {
+				case *dns.A:
+					return v.A, nil
+				case *dns.AAAA:
+					return v.AAAA, nil
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: failed to resolve %s via %d bootstrap servers: %w", ErrBootstrapFailed, domain, len(resolvers), lastErr)
+}
@@ -0,0 +1,133 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/netenv"
+)
+
+type testBootstrapConn struct {
+	BasicResolverConn
+
+	answer string
+	err    error
+}
+
+func (tbc *testBootstrapConn) Query(_ context.Context, q *Query) (*RRCache, error) {
+	if tbc.err != nil {
+		return nil, tbc.err
+	}
+
+	rrCache := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+	}
+	if uint16(q.QType) == dns.TypeA {
+		rr, err := dns.NewRR(tbc.answer)
+		if err != nil {
+			return nil, err
+		}
+		rrCache.Answer = append(rrCache.Answer, rr)
+	}
+	return rrCache, nil
+}
+
+func TestBootstrap(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	bootstrapConn := &testBootstrapConn{answer: "dns.example.com. 60 IN A 192.0.2.1"}
+
+	resolversLock.Lock()
+	previousBootstrapResolvers := bootstrapResolvers
+	bootstrapResolvers = []*Resolver{{
+		Info: &ResolverInfo{Type: ServerTypeDNS, Source: ServerSourceBootstrap},
+		Conn: bootstrapConn,
+	}}
+	newResolver, _, err := createResolver("doh://dns.example.com/dns-query", ServerSourceConfigured)
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		bootstrapResolvers = previousBootstrapResolvers
+		resolversLock.Unlock()
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the domain is resolved via the bootstrap resolvers.
+	serverAddress, err := newResolver.getServerAddress(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:443", serverAddress)
+
+	// Check that the pinned IP is used.
+	bootstrapConn.answer = "dns.example.com. 60 IN A 192.0.2.2"
+	serverAddress, err = newResolver.getServerAddress(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:443", serverAddress)
+
+	// Check that the pinned IP is still used if refreshing fails.
+	bootstrapConn.err = ErrTimeout
+	newResolver.bootstrap.resolvedAt = newResolver.bootstrap.resolvedAt.Add(-bootstrapRefreshInterval)
+	serverAddress, err = newResolver.getServerAddress(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:443", serverAddress)
+
+	// Check that failing to bootstrap results in a clear error.
+	newResolver.bootstrap = &bootstrapState{}
+	_, err = newResolver.getServerAddress(context.Background())
+	assert.ErrorIs(t, err, ErrBootstrapFailed)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// Check that resolvers configured by IP are not bootstrapped.
+	resolversLock.Lock()
+	ipResolver, _, err := createResolver("dot://9.9.9.9:853?verify=dns.quad9.net", ServerSourceConfigured)
+	resolversLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverAddress, err = ipResolver.getServerAddress(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "9.9.9.9:853", serverAddress)
+}
+
+func TestBootstrapViaSystemResolvers(t *testing.T) { //nolint:paralleltest // Changes global config and resolvers.
+	previousSystemNameservers := systemNameservers
+	systemNameservers = func() []netenv.Nameserver {
+		return []netenv.Nameserver{{IP: net.IPv4(192, 0, 2, 53)}}
+	}
+	require.NoError(t, config.SetConfigOption(CfgOptionNameServersKey, []string{"doh://dns.example.com/dns-query"}))
+	defer func() {
+		systemNameservers = previousSystemNameservers
+		_ = config.SetConfigOption(CfgOptionNameServersKey, defaultNameServers)
+		loadResolvers()
+	}()
+	loadResolvers()
+
+	// Check that the system resolvers are used for bootstrapping on first load.
+	resolversLock.RLock()
+	bootstrappers := bootstrapResolvers
+	var domainResolver *Resolver
+	for _, resolver := range globalResolvers {
+		if resolver.bootstrap != nil {
+			domainResolver = resolver
+		}
+	}
+	resolversLock.RUnlock()
+	require.Len(t, bootstrappers, 1)
+	assert.Equal(t, ServerSourceOperatingSystem, bootstrappers[0].Info.Source)
+	assert.Equal(t, "192.0.2.53", bootstrappers[0].Info.IP.String())
+	require.NotNil(t, domainResolver)
+
+	// Check that the domain-only resolver can be bootstrapped.
+	bootstrapConn := &testBootstrapConn{answer: "dns.example.com. 60 IN A 192.0.2.1"}
+	bootstrappers[0].Conn = bootstrapConn
+	serverAddress, err := domainResolver.getServerAddress(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:443", serverAddress)
+}
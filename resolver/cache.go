@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// cacheFlushRetention defines how long cache flushes are remembered. It must
+// be longer than entries may wait in the write cache of the database.
+const cacheFlushRetention = 10 * time.Minute
+
+// cacheFlush records a flush of a domain suffix. The root domain "." flushes
+// all domains.
+type cacheFlush struct {
+	suffix string
+	at     int64
+}
+
+var (
+	recentCacheFlushes     []cacheFlush
+	recentCacheFlushesLock sync.Mutex
+)
+
+// LookupCache returns the cached RRCache for the given domain and question,
+// without resolving it. Only entries that are not scoped to an ECS network or
+// client are returned. If no entry exists, database.ErrNotFound is returned.
+func LookupCache(fqdn string, qtype dns.Type) (*RRCache, error) {
+	return GetRRCache(fqdn, qtype)
+}
+
+// EvictCache removes the cached entry for the given domain and question.
+// Only entries that are not scoped to an ECS network or client are removed.
+// If no entry exists, database.ErrNotFound is returned.
+func EvictCache(fqdn string, qtype dns.Type) error {
+	return ResetCachedRecord(fqdn, qtype.String())
+}
+
+// FlushCache removes all cached entries of the given domain and its
+// subdomains, including scoped entries, and returns the number of removed
+// entries. The root domain "." removes all entries.
+func FlushCache(ctx context.Context, suffix string) (int, error) {
+	suffix = NormalizeFQDN(suffix)
+
+	q := query.New(nameRecordsKeyPrefix)
+	if suffix != "." {
+		if !netutils.IsValidFqdn(suffix) {
+			return 0, ErrInvalid
+		}
+		q = q.Where(query.Or(
+			query.Where("Domain", query.SameAs, suffix),
+			query.Where("Domain", query.EndsWith, "."+suffix),
+		))
+	}
+
+	// Purging only affects persisted entries. Remember the flush, so that
+	// entries still waiting in the write cache, or being read concurrently, are
+	// disregarded too. This also covers entries in the read cache, so it does
+	// not need to be cleared.
+	markCacheFlushed(suffix)
+	n, err := recordDatabase.Purge(ctx, q)
+	if errors.Is(err, database.ErrNotImplemented) {
+		n, err = deleteCacheRecords(q)
+	}
+	if err != nil {
+		return n, err
+	}
+
+	log.Debugf("resolver: flushed %d entries of %s from dns cache", n, suffix)
+	return n, nil
+}
+
+// deleteCacheRecords deletes all records matching the given query one by one,
+// for storages that do not support purging.
+func deleteCacheRecords(q *query.Query) (int, error) {
+	it, err := recordDatabase.Query(q)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query dns cache: %w", err)
+	}
+
+	var keys []string
+	for r := range it.Next {
+		keys = append(keys, r.Key())
+	}
+	if err := it.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate over dns cache: %w", err)
+	}
+
+	// Delete entries after iterating, in order to not interfere with the query.
+	var n int
+	for _, key := range keys {
+		if err := recordDatabase.Delete(key); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// markCacheFlushed remembers a flush of the given domain suffix.
+func markCacheFlushed(suffix string) {
+	recentCacheFlushesLock.Lock()
+	defer recentCacheFlushesLock.Unlock()
+
+	now := time.Now()
+	threshold := now.Add(-cacheFlushRetention).Unix()
+	kept := recentCacheFlushes[:0]
+	for _, flush := range recentCacheFlushes {
+		if flush.at > threshold {
+			kept = append(kept, flush)
+		}
+	}
+	recentCacheFlushes = append(kept, cacheFlush{
+		suffix: suffix,
+		at:     now.Unix(),
+	})
+}
+
+// isFlushedCacheRecord returns whether a record of the given domain, which was
+// last modified at the given time, was flushed.
+func isFlushedCacheRecord(domain string, modified int64) bool {
+	recentCacheFlushesLock.Lock()
+	defer recentCacheFlushesLock.Unlock()
+
+	domain = strings.ToLower(domain)
+	for _, flush := range recentCacheFlushes {
+		switch {
+		case modified > flush.at:
+		case flush.suffix == ".",
+			domain == flush.suffix,
+			strings.HasSuffix(domain, "."+flush.suffix):
+			return true
+		}
+	}
+	return false
+}
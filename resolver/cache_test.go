@@ -0,0 +1,87 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/database"
+)
+
+func TestCacheInspection(t *testing.T) { //nolint:paralleltest // Clears the record cache.
+	expires := time.Now().Unix() + 60
+
+	// Write entries of a domain, its subdomains and an unrelated domain
+	// directly to storage.
+	db := database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+	for _, nameRecord := range []*NameRecord{
+		{Domain: "flush-test.example.com.", Question: "A"},
+		{Domain: "sub.flush-test.example.com.", Question: "A"},
+		{Domain: "sub.flush-test.example.com.", Question: "A", ClientScope: "client-1"},
+		{Domain: "other-flush-test.example.com.", Question: "A"},
+	} {
+		nameRecord.Expires = expires
+		nameRecord.Resolver = envResolver.Info.Copy()
+		nameRecord.SetKey(makeNameRecordKey(nameRecord.Domain, nameRecord.Question+makeCacheKeySuffix("", nameRecord.ClientScope)))
+		nameRecord.Checksum = nameRecord.checksum()
+		nameRecord.UpdateMeta()
+		if err := db.Put(nameRecord); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Save entries that stay in the write cache.
+	for _, nameRecord := range []*NameRecord{
+		{Domain: "flush-test.example.com.", Question: "AAAA"},
+		{Domain: "pending.flush-test.example.com.", Question: "A"},
+	} {
+		nameRecord.Expires = expires
+		nameRecord.Resolver = envResolver.Info.Copy()
+		if err := nameRecord.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Check lookup and eviction of single entries.
+	rrCache, err := LookupCache("flush-test.example.com", dns.Type(dns.TypeAAAA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrCache.Domain != "flush-test.example.com." || rrCache.Question != dns.Type(dns.TypeAAAA) {
+		t.Errorf("unexpected entry %s%s", rrCache.Domain, rrCache.Question)
+	}
+	if err := EvictCache("flush-test.example.com.", dns.Type(dns.TypeAAAA)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupCache("flush-test.example.com.", dns.Type(dns.TypeAAAA)); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("evicted entry was found: %v", err)
+	}
+
+	// Check flushing a domain suffix.
+	n, err := FlushCache(context.Background(), "Flush-Test.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 flushed entries, got %d", n)
+	}
+	for _, domain := range []string{"flush-test.example.com.", "sub.flush-test.example.com.", "pending.flush-test.example.com."} {
+		if _, err := LookupCache(domain, dns.Type(dns.TypeA)); !errors.Is(err, database.ErrNotFound) {
+			t.Errorf("flushed entry of %s was found: %v", domain, err)
+		}
+	}
+	if _, err := LookupCache("other-flush-test.example.com.", dns.Type(dns.TypeA)); err != nil {
+		t.Errorf("unrelated entry was flushed: %s", err)
+	}
+
+	// Check invalid suffixes.
+	if _, err := FlushCache(context.Background(), "invalid domain"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected invalid suffix to fail, got %v", err)
+	}
+}
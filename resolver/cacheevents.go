@@ -0,0 +1,94 @@
+package resolver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheEventType describes what happened to a cache entry.
+type CacheEventType string
+
+// Cache event types.
+const (
+	// CacheEventSaved is emitted when an entry is saved to the cache.
+	CacheEventSaved CacheEventType = "saved"
+	// CacheEventServed is emitted when a valid entry is served from the cache.
+	CacheEventServed CacheEventType = "served"
+	// CacheEventExpired is emitted when an expired entry is found in the cache.
+	CacheEventExpired CacheEventType = "expired"
+	// CacheEventReset is emitted when an entry is removed from the cache.
+	CacheEventReset CacheEventType = "reset"
+)
+
+// cacheEventQueueSize is the amount of events that are queued for delivery
+// before further events are dropped.
+const cacheEventQueueSize = 1000
+
+// CacheEvent describes something that happened to a cache entry.
+type CacheEvent struct {
+	Type     CacheEventType
+	Domain   string
+	Question string
+	Time     time.Time
+}
+
+var (
+	cacheEventListeners     []func(CacheEvent)
+	cacheEventListenersLock sync.RWMutex
+	cacheEventListening     atomic.Bool
+
+	cacheEventQueue       = make(chan CacheEvent, cacheEventQueueSize)
+	cacheEventDispatching sync.Once
+	droppedCacheEvents    atomic.Uint64
+)
+
+// OnCacheEvent registers a function that is called for every cache event.
+// Events are delivered asynchronously and in order by a single goroutine, so
+// listeners should return quickly. If listeners cannot keep up, events are
+// dropped instead of slowing down resolving.
+func OnCacheEvent(fn func(CacheEvent)) {
+	cacheEventListenersLock.Lock()
+	defer cacheEventListenersLock.Unlock()
+
+	cacheEventListeners = append(cacheEventListeners, fn)
+	cacheEventListening.Store(true)
+	cacheEventDispatching.Do(func() {
+		go dispatchCacheEvents()
+	})
+}
+
+// DroppedCacheEvents returns the amount of cache events that were dropped,
+// because the listeners did not keep up.
+func DroppedCacheEvents() uint64 {
+	return droppedCacheEvents.Load()
+}
+
+// emitCacheEvent queues a cache event for delivery, if there are listeners.
+// It never blocks.
+func emitCacheEvent(eventType CacheEventType, domain, question string) {
+	if !cacheEventListening.Load() {
+		return
+	}
+
+	select {
+	case cacheEventQueue <- CacheEvent{
+		Type:     eventType,
+		Domain:   domain,
+		Question: question,
+		Time:     time.Now(),
+	}:
+	default:
+		droppedCacheEvents.Add(1)
+	}
+}
+
+func dispatchCacheEvents() {
+	for event := range cacheEventQueue {
+		cacheEventListenersLock.RLock()
+		for _, fn := range cacheEventListeners {
+			fn(event)
+		}
+		cacheEventListenersLock.RUnlock()
+	}
+}
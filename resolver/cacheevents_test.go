@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheEvents(t *testing.T) { //nolint:paralleltest // Saves to the global cache.
+	domain := "events.cache.example.com."
+	events := make(chan CacheEvent, 100)
+	OnCacheEvent(func(event CacheEvent) {
+		if event.Domain == domain {
+			events <- event
+		}
+	})
+	waitForEvent := func(eventType CacheEventType) {
+		t.Helper()
+
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case event := <-events:
+				if event.Type == eventType {
+					assert.Equal(t, "A", event.Question)
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %s event received", eventType)
+			}
+		}
+	}
+
+	save := func(expires time.Time) {
+		t.Helper()
+
+		resolversLock.RLock()
+		if len(globalResolvers) == 0 {
+			resolversLock.RUnlock()
+			t.Skip("no active resolvers")
+		}
+		resolverInfo := globalResolvers[0].Info
+		resolversLock.RUnlock()
+
+		rr, err := dns.NewRR(domain + " 17 IN A 192.0.2.1")
+		require.NoError(t, err)
+		rrCache := &RRCache{
+			Domain:   domain,
+			Question: dns.Type(dns.TypeA),
+			RCode:    dns.RcodeSuccess,
+			Answer:   []dns.RR{rr},
+			Expires:  expires.Unix(),
+			Resolver: resolverInfo,
+		}
+		require.NoError(t, rrCache.Save())
+	}
+
+	// Check that saving an entry emits an event.
+	save(time.Now().Add(-time.Hour))
+	waitForEvent(CacheEventSaved)
+
+	// Check that finding an expired entry emits an event.
+	rrCache := checkCache(silencingTraceCtx, &Query{FQDN: domain, QType: dns.Type(dns.TypeA)})
+	assert.NotNil(t, rrCache)
+	waitForEvent(CacheEventExpired)
+
+	// Check that serving a valid entry emits an event.
+	save(time.Now().Add(time.Hour))
+	waitForEvent(CacheEventSaved)
+	rrCache = checkCache(silencingTraceCtx, &Query{FQDN: domain, QType: dns.Type(dns.TypeA)})
+	assert.NotNil(t, rrCache)
+	waitForEvent(CacheEventServed)
+
+	// Check that resetting an entry emits an event.
+	assert.NoError(t, EvictCache(domain, dns.Type(dns.TypeA)))
+	waitForEvent(CacheEventReset)
+}
+
+func TestCacheEventBackpressure(t *testing.T) { //nolint:paralleltest // Fills the global event queue.
+	// Block the delivery of events.
+	release := make(chan struct{})
+	defer close(release)
+	blocked := make(chan struct{}, 1)
+	OnCacheEvent(func(event CacheEvent) {
+		if event.Domain == "block.cache.example.com." {
+			blocked <- struct{}{}
+			<-release
+		}
+	})
+	emitCacheEvent(CacheEventSaved, "block.cache.example.com.", "A")
+	<-blocked
+
+	// Check that events are dropped instead of blocking.
+	dropped := DroppedCacheEvents()
+	for i := 0; i < cacheEventQueueSize+10; i++ {
+		emitCacheEvent(CacheEventSaved, "flood.cache.example.com.", "A")
+	}
+	assert.GreaterOrEqual(t, DroppedCacheEvents(), dropped+10)
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"errors"
+
+	"github.com/safing/portbase/database"
+)
+
+// clientScopeKeySeparator separates the client scope in cache keys.
+const clientScopeKeySeparator = "#"
+
+// makeCacheKeySuffix returns the suffix to add to cache keys, so that answers
+// for different client subnets and client scopes do not collide.
+func makeCacheKeySuffix(ecsNetwork, clientScope string) string {
+	suffix := ecsKeySuffix(ecsNetwork)
+	if clientScope != "" {
+		suffix += clientScopeKeySeparator + clientScope
+	}
+	return suffix
+}
+
+// cacheKeySuffix returns the cache key suffix of the query. It identifies the
+// query, but answers for the query may be cached with other suffixes, see
+// getCachedRRCache.
+func (q *Query) cacheKeySuffix() string {
+	var ecsNetwork string
+	if network := q.ecsNetwork(); network != nil {
+		ecsNetwork = network.String()
+	}
+	return makeCacheKeySuffix(ecsNetwork, q.ClientScope)
+}
+
+// getCachedRRCache returns the cached answer for the query. Answers for
+// client subnets are looked up by the scope they were cached with.
+func (q *Query) getCachedRRCache() (*RRCache, error) {
+	network := q.ecsNetwork()
+	if network == nil {
+		return getRRCache(q.FQDN, q.QType, q.cacheKeySuffix())
+	}
+
+	var err error
+	for _, suffix := range q.ecsCacheKeySuffixes(network) {
+		var rrCache *RRCache
+		rrCache, err = getRRCache(q.FQDN, q.QType, suffix)
+		if err == nil {
+			return rrCache, nil
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// applyCacheScope records the client scope and client subnet of the query on
+// the RRCache.
+func (q *Query) applyCacheScope(rrCache *RRCache) {
+	rrCache.ClientScope = q.ClientScope
+	q.applyECSInfo(rrCache)
+}
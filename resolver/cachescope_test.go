@@ -0,0 +1,33 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheScope(t *testing.T) {
+	t.Parallel()
+
+	plain := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA)}
+	clientA := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA), ClientScope: "a"}
+	clientA2 := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA), ClientScope: "a"}
+	clientB := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA), ClientScope: "b"}
+
+	// Check that an empty scope does not change the key.
+	assert.Equal(t, "example.com.A", plain.ID())
+
+	// Check that scopes are separated, but shared within the scope.
+	assert.Equal(t, "example.com.A#a", clientA.ID())
+	assert.Equal(t, clientA.ID(), clientA2.ID())
+	assert.NotEqual(t, clientA.ID(), clientB.ID())
+
+	// Check that the scope is recorded and persisted with the cache entry.
+	rrCache := &RRCache{Domain: "example.com.", Question: dns.Type(dns.TypeA)}
+	clientA.applyCacheScope(rrCache)
+	assert.Equal(t, clientA.ID(), rrCache.ID())
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	assert.Equal(t, "a", rrCache.ToNameRecord().ClientScope)
+	assert.Equal(t, "a", rrCache.ShallowCopy().ClientScope)
+}
@@ -0,0 +1,151 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChainDepth limits how many CNAMEs are followed when flattening.
+const maxCNAMEChainDepth = 16
+
+// canFlattenCNAMEs returns whether CNAME chains of queries with the given type
+// may be flattened.
+func canFlattenCNAMEs(qtype dns.Type) bool {
+	switch uint16(qtype) {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeSVCB, dns.TypeHTTPS:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveAndFlattenCNAMEs resolves the given query and follows any CNAME
+// chain until the final records are found. Every hop is resolved
+// separately through Resolve, so that it is checked and cached on its own.
+func resolveAndFlattenCNAMEs(ctx context.Context, q *Query) (*RRCache, error) {
+	var (
+		result  *RRCache
+		chain   []dns.RR
+		visited = make(map[string]struct{})
+		current = q.FQDN
+	)
+	visited[strings.ToLower(current)] = struct{}{}
+
+	for {
+		// Resolve the current hop.
+		hopRRCache, err := Resolve(ctx, q.subQuery(current, q.QType))
+		if err != nil {
+			return nil, err
+		}
+		result = mergeCNAMEHop(q, result, hopRRCache)
+
+		// Follow the chain as far as the answer goes.
+		name := current
+		for {
+			// Check if we have the final records.
+			if records := findRecords(hopRRCache.Answer, name, uint16(q.QType)); len(records) > 0 {
+				result.Answer = append(chain, records...) //nolint:gocritic // Chain is not used anymore.
+				return result, nil
+			}
+
+			// Check if there is another CNAME.
+			cname := findCNAME(hopRRCache.Answer, name)
+			if cname == nil {
+				break
+			}
+			chain = append(chain, cname)
+			if len(chain) > maxCNAMEChainDepth {
+				return nil, fmt.Errorf("%w: CNAME chain for %s exceeds %d hops", ErrFailure, q.ID(), maxCNAMEChainDepth)
+			}
+			name = cname.Target
+
+			// Check for loops.
+			if _, ok := visited[strings.ToLower(name)]; ok {
+				return nil, fmt.Errorf("%w: CNAME loop detected at %s for %s", ErrFailure, name, q.ID())
+			}
+			visited[strings.ToLower(name)] = struct{}{}
+		}
+
+		// Return what we have if the chain ends here.
+		if name == current || hopRRCache.RCode != dns.RcodeSuccess {
+			result.Answer = chain
+			return result, nil
+		}
+
+		// Otherwise, resolve the next hop.
+		current = name
+	}
+}
+
+// mergeCNAMEHop merges the metadata of a resolved hop into the result.
+func mergeCNAMEHop(q *Query, result, hop *RRCache) *RRCache {
+	if result == nil {
+		result = hop.ShallowCopy()
+		result.Domain = q.FQDN
+		result.Question = q.QType
+		return result
+	}
+
+	result.RCode = hop.RCode
+	result.Ns = hop.Ns
+	result.Extra = hop.Extra
+	result.Resolver = hop.Resolver
+	if hop.Expires < result.Expires {
+		result.Expires = hop.Expires
+	}
+	result.ServedFromCache = result.ServedFromCache && hop.ServedFromCache
+	result.AuthenticatedData = result.AuthenticatedData && hop.AuthenticatedData
+	result.Insecure = result.Insecure || hop.Insecure
+	return result
+}
+
+// checkAliasLoops returns an error wrapping ErrFailure if the CNAME or DNAME
+// records in the answer of the given RRCache form a loop, as following them
+// would never end.
+func checkAliasLoops(rrCache *RRCache) error {
+	aliases := make(map[string]string)
+	for _, rr := range rrCache.Answer {
+		switch v := rr.(type) {
+		case *dns.CNAME:
+			aliases[strings.ToLower(v.Hdr.Name)] = strings.ToLower(v.Target)
+		case *dns.DNAME:
+			// A DNAME pointing into its own subtree matches its target again.
+			if dns.IsSubDomain(v.Hdr.Name, v.Target) {
+				return fmt.Errorf("%w: DNAME loop detected at %s for %s", ErrFailure, v.Hdr.Name, rrCache.Domain)
+			}
+		}
+	}
+
+	for start := range aliases {
+		visited := map[string]struct{}{start: {}}
+		for name, ok := aliases[start]; ok; name, ok = aliases[name] {
+			if _, seen := visited[name]; seen {
+				return fmt.Errorf("%w: CNAME loop detected at %s for %s", ErrFailure, name, rrCache.Domain)
+			}
+			visited[name] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func findRecords(section []dns.RR, name string, rrType uint16) (records []dns.RR) {
+	for _, rr := range section {
+		if rr.Header().Rrtype == rrType && strings.EqualFold(rr.Header().Name, name) {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+func findCNAME(section []dns.RR, name string) *dns.CNAME {
+	for _, rr := range section {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+			return cname
+		}
+	}
+	return nil
+}
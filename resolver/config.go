@@ -7,6 +7,7 @@
 
 	"github.com/safing/portbase/config"
 	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/status"
 )
 
@@ -58,6 +59,10 @@
 	configuredNameServers     config.StringArrayOption
 	cfgOptionNameServersOrder = 0
 
+	CfgOptionAdditionalHostsFileKey   = "dns/additionalHostsFile"
+	additionalHostsFile               config.StringOption
+	cfgOptionAdditionalHostsFileOrder = 34
+
 	CfgOptionNoAssignedNameserversKey   = "dns/noAssignedNameservers"
 	noAssignedNameservers               status.SecurityLevelOptionFunc
 	cfgOptionNoAssignedNameserversOrder = 1
@@ -70,6 +75,34 @@
 	noInsecureProtocols               status.SecurityLevelOptionFunc
 	cfgOptionNoInsecureProtocolsOrder = 3
 
+	CfgOptionValidateDNSSECKey   = "dns/validateDNSSEC"
+	validateDNSSEC               status.SecurityLevelOptionFunc
+	cfgOptionValidateDNSSECOrder = 4
+
+	CfgOptionServeStaleWindowKey   = "dns/serveStaleWindow"
+	serveStaleWindow               config.IntOption
+	cfgOptionServeStaleWindowOrder = 17
+
+	CfgOptionECSIPv4PrefixLengthKey   = "dns/ecsIPv4PrefixLength"
+	ecsIPv4PrefixLength               config.IntOption
+	cfgOptionECSIPv4PrefixLengthOrder = 18
+
+	CfgOptionECSIPv6PrefixLengthKey   = "dns/ecsIPv6PrefixLength"
+	ecsIPv6PrefixLength               config.IntOption
+	cfgOptionECSIPv6PrefixLengthOrder = 19
+
+	CfgOptionLimitQueryTypesKey   = "dns/limitQueryTypes"
+	limitQueryTypes               status.SecurityLevelOptionFunc
+	cfgOptionLimitQueryTypesOrder = 20
+
+	CfgOptionPermittedQueryTypesKey   = "dns/permittedQueryTypes"
+	permittedQueryTypes               config.StringArrayOption
+	cfgOptionPermittedQueryTypesOrder = 21
+
+	CfgOptionMinimalANYResponsesKey   = "dns/minimalANYResponses"
+	minimalANYResponses               status.SecurityLevelOptionFunc
+	cfgOptionMinimalANYResponsesOrder = 22
+
 	CfgOptionDontResolveSpecialDomainsKey   = "dns/dontResolveSpecialDomains"
 	dontResolveSpecialDomains               status.SecurityLevelOptionFunc
 	cfgOptionDontResolveSpecialDomainsOrder = 16
@@ -77,6 +110,163 @@
 	CfgOptionNameserverRetryRateKey   = "dns/nameserverRetryRate"
 	nameserverRetryRate               config.IntOption
 	cfgOptionNameserverRetryRateOrder = 32
+
+	CfgOptionNameserverMaxQueriesKey   = "dns/nameserverMaxQueries"
+	nameserverMaxQueries               config.IntOption
+	cfgOptionNameserverMaxQueriesOrder = 33
+
+	CfgOptionBootstrapNameServersKey   = "dns/bootstrapNameservers"
+	bootstrapNameServers               config.StringArrayOption
+	cfgOptionBootstrapNameServersOrder = 36
+
+	CfgOptionConditionalForwardingKey   = "dns/conditionalForwarding"
+	conditionalForwarding               config.StringArrayOption
+	cfgOptionConditionalForwardingOrder = 37
+
+	CfgOptionRewriteRulesKey   = "dns/rewriteRules"
+	dnsRewriteRules            config.StringArrayOption
+	cfgOptionRewriteRulesOrder = 38
+
+	CfgOptionRewriteTTLKey   = "dns/rewriteTTL"
+	rewriteTTL               config.IntOption
+	cfgOptionRewriteTTLOrder = 39
+
+	CfgOptionUse0x20Key   = "dns/use0x20"
+	use0x20               config.BoolOption
+	cfgOptionUse0x20Order = 23
+
+	CfgOptionStripExtraSectionsKey   = "dns/stripExtraSections"
+	stripExtraSections               config.BoolOption
+	cfgOptionStripExtraSectionsOrder = 40
+
+	CfgOptionThrottleFailedQueriesKey   = "dns/throttleFailedQueries"
+	throttleFailedQueries               config.BoolOption
+	cfgOptionThrottleFailedQueriesOrder = 41
+
+	CfgOptionFailedQueryCooldownKey   = "dns/failedQueryCooldown"
+	failedQueryCooldown               config.IntOption
+	cfgOptionFailedQueryCooldownOrder = 42
+
+	CfgOptionPrefetchDomainsKey   = "dns/prefetchDomains"
+	prefetchDomains               config.IntOption
+	cfgOptionPrefetchDomainsOrder = 24
+
+	CfgOptionPrefetchLeadTimeKey   = "dns/prefetchLeadTime"
+	prefetchLeadTime               config.IntOption
+	cfgOptionPrefetchLeadTimeOrder = 25
+
+	CfgOptionQueryTracingKey   = "dns/queryTracing"
+	queryTracing               config.BoolOption
+	cfgOptionQueryTracingOrder = 26
+
+	CfgOptionQueryTracingHashDomainsKey   = "dns/queryTracingHashDomains"
+	queryTracingHashDomains               config.BoolOption
+	cfgOptionQueryTracingHashDomainsOrder = 27
+
+	CfgOptionMinTTLKey   = "dns/minTTL"
+	minCacheTTL          config.IntOption
+	cfgOptionMinTTLOrder = 28
+
+	CfgOptionMaxTTLKey   = "dns/maxTTL"
+	maxCacheTTL          config.IntOption
+	cfgOptionMaxTTLOrder = 29
+
+	CfgOptionRefreshTTLKey   = "dns/refreshTTL"
+	refreshCacheTTL          config.IntOption
+	cfgOptionRefreshTTLOrder = 30
+
+	CfgOptionTTLSecurityLevelOverridesKey   = "dns/ttlSecurityLevelOverrides"
+	ttlSecurityLevelOverrides               config.StringArrayOption
+	cfgOptionTTLSecurityLevelOverridesOrder = 31
+
+	CfgOptionResolverSelectionKey   = "dns/resolverSelection"
+	resolverSelection               config.StringOption
+	cfgOptionResolverSelectionOrder = 35
+
+	CfgOptionIPVersionPreferenceKey   = "dns/ipVersionPreference"
+	ipVersionPreference               config.StringOption
+	cfgOptionIPVersionPreferenceOrder = 43
+
+	CfgOptionMaxResponseRecordsKey   = "dns/maxResponseRecords"
+	maxResponseRecords               config.IntOption
+	cfgOptionMaxResponseRecordsOrder = 44
+
+	CfgOptionMaxResponseSizeKey   = "dns/maxResponseSize"
+	maxResponseSize               config.IntOption
+	cfgOptionMaxResponseSizeOrder = 45
+
+	CfgOptionRetryTimeoutsKey   = "dns/retryTimeouts"
+	retryTimeouts               status.SecurityLevelOptionFunc
+	cfgOptionRetryTimeoutsOrder = 46
+
+	CfgOptionTimeoutRetriesKey   = "dns/timeoutRetries"
+	timeoutRetries               config.IntOption
+	cfgOptionTimeoutRetriesOrder = 47
+
+	CfgOptionFailingResolverNotificationsKey   = "dns/failingResolverNotifications"
+	failingResolverNotifications               config.BoolOption
+	cfgOptionFailingResolverNotificationsOrder = 48
+
+	CfgOptionSpecialDomainHandlingKey   = "dns/specialDomainHandling"
+	specialDomainHandling               config.StringArrayOption
+	cfgOptionSpecialDomainHandlingOrder = 49
+
+	CfgOptionShuffleAnswersKey   = "dns/shuffleAnswers"
+	shuffleAnswersMode           config.StringOption
+	cfgOptionShuffleAnswersOrder = 50
+
+	CfgOptionTTLFloorsKey   = "dns/ttlFloors"
+	ttlFloors               config.StringArrayOption
+	cfgOptionTTLFloorsOrder = 51
+
+	CfgOptionUpstreamProxyKey   = "dns/upstreamProxy"
+	upstreamProxy               config.StringOption
+	cfgOptionUpstreamProxyOrder = 53
+
+	CfgOptionEDNSUDPSizeKey   = "dns/ednsUDPSize"
+	ednsUDPSize               config.IntOption
+	cfgOptionEDNSUDPSizeOrder = 54
+
+	CfgOptionStripPrivateAnswersKey   = "dns/stripPrivateAnswers"
+	stripPrivateAnswers               status.SecurityLevelOptionFunc
+	cfgOptionStripPrivateAnswersOrder = 55
+
+	CfgOptionSingleLabelNamesKey   = "dns/singleLabelNames"
+	singleLabelNames               config.StringOption
+	cfgOptionSingleLabelNamesOrder = 56
+
+	CfgOptionSearchDomainKey   = "dns/searchDomain"
+	searchDomain               config.StringOption
+	cfgOptionSearchDomainOrder = 57
+)
+
+// Resolver selection strategies.
+const (
+	ResolverSelectionPriority = "priority"
+	ResolverSelectionLatency  = "latency"
+)
+
+// Answer shuffling modes.
+const (
+	ShuffleAnswersNone       = "none"
+	ShuffleAnswersRandom     = "random"
+	ShuffleAnswersRoundRobin = "round-robin"
+)
+
+// Single-label name policies.
+const (
+	SingleLabelNamesAuto    = "auto"
+	SingleLabelNamesResolve = "resolve"
+	SingleLabelNamesRefuse  = "refuse"
+	SingleLabelNamesLocal   = "local"
+	SingleLabelNamesSearch  = "search"
+)
+
+// IP version preferences.
+const (
+	IPVersionBoth         = "both"
+	IPVersionPreferIPv4   = "prefer-ipv4"
+	IPVersionIPv6Disabled = "ipv6-disabled"
 )
 
 func prepConfig() error {
@@ -93,26 +283,29 @@ func prepConfig() error {
 
 - Protocol
 	- "dot": DNS-over-TLS (recommended)  
+	- "doq": DNS-over-QUIC  
 	- "dns": plain old DNS  
 	- "tcp": plain old DNS over TCP
 - IP: always use the IP address and _not_ the domain name!
 - Port: optionally define a custom port
 - Parameters:
 	- "name": give your DNS Server a name that is used for messages and logs
-	- "verify": domain name to verify for "dot", required and only valid for protocol "dot"
+	- "verify": domain name to verify for "dot" and "doq", required and only valid for protocols "dot" and "doq"
 	- "blockedif": detect if the name server blocks a query, options:
 		- "empty": server replies with NXDomain status, but without any other record in any section
 		- "refused": server replies with Refused status
 		- "zeroip": server replies with an IP address, but it is zero
 	- "search": specify prioritized domains/TLDs for this resolver (delimited by ",")
 	- "search-only": use this resolver for domains in the "search" parameter only (no value)
+	- "proxy": connect to the server via the given SOCKS5 proxy, eg. "socks5://127.0.0.1:1080" (tcp, dot and doh only)
+	- "pin": require the server certificate to match the given base64 encoded SHA256 hash of its public key ("spki:hash") or of the certificate ("cert:hash"), multiple pins may be given (dot, doh and doq only)
 `, `"`, "`"),
 		Sensitive:       true,
 		OptType:         config.OptTypeStringArray,
 		ExpertiseLevel:  config.ExpertiseLevelUser,
 		ReleaseLevel:    config.ReleaseLevelStable,
 		DefaultValue:    defaultNameServers,
-		ValidationRegex: fmt.Sprintf("^(%s|%s|%s|%s|%s|%s)://.*", ServerTypeDoT, ServerTypeDoH, ServerTypeDNS, ServerTypeTCP, HTTPSProtocol, TLSProtocol),
+		ValidationRegex: fmt.Sprintf("^(%s|%s|%s|%s|%s|%s|%s)://.*", ServerTypeDoT, ServerTypeDoH, ServerTypeDoQ, ServerTypeDNS, ServerTypeTCP, HTTPSProtocol, TLSProtocol),
 		ValidationFunc:  validateNameservers,
 		Annotations: config.Annotations{
 			config.DisplayHintAnnotation:  config.DisplayHintOrdered,
@@ -180,6 +373,203 @@ func prepConfig() error {
 	}
 	nameserverRetryRate = config.Concurrent.GetAsInt(CfgOptionNameserverRetryRateKey, 300)
 
+	err = config.Register(&config.Option{
+		Name:           "Max Queries per Server",
+		Key:            CfgOptionNameserverMaxQueriesKey,
+		Description:    "Maximum amount of concurrent queries to a single DNS server. Further queries are queued briefly and are sent to the next DNS server if the queue is full. Set to 0 to disable the limit.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   100,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionNameserverMaxQueriesOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[0-9]{1,5}$`,
+	})
+	if err != nil {
+		return err
+	}
+	nameserverMaxQueries = config.Concurrent.GetAsInt(CfgOptionNameserverMaxQueriesKey, 100)
+
+	err = config.Register(&config.Option{
+		Name:           "Upstream Proxy",
+		Key:            CfgOptionUpstreamProxyKey,
+		Description:    "Connect to DNS servers via the given SOCKS5 proxy, eg. \"socks5://127.0.0.1:1080\". The proxy must be given by IP address. This only applies to DNS servers using TCP, DoT or DoH and can be overridden per DNS server with the \"proxy\" parameter.",
+		Sensitive:      true,
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   "",
+		ValidationFunc: validateUpstreamProxy,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionUpstreamProxyOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	upstreamProxy = config.Concurrent.GetAsString(CfgOptionUpstreamProxyKey, "")
+
+	err = config.Register(&config.Option{
+		Name:           "EDNS UDP Buffer Size",
+		Key:            CfgOptionEDNSUDPSizeKey,
+		Description:    "The UDP buffer size advertised to DNS servers using plain DNS. Answers that do not fit are truncated by the DNS server and are then requested again via TCP. The default of 1232 bytes avoids fragmentation on most networks, as recommended by the DNS Flag Day 2020. Values are limited to 512-4096 bytes.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultEDNSUDPSize,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionEDNSUDPSizeOrder,
+			config.UnitAnnotation:         "bytes",
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[0-9]{3,4}$`,
+	})
+	if err != nil {
+		return err
+	}
+	ednsUDPSize = config.Concurrent.GetAsInt(CfgOptionEDNSUDPSizeKey, defaultEDNSUDPSize)
+
+	err = config.Register(&config.Option{
+		Name:           "Additional Hosts File",
+		Key:            CfgOptionAdditionalHostsFileKey,
+		Description:    "Path to an additional hosts file. Names defined in the hosts file of your system and in this file take precedence over all DNS servers. Changes to the files are picked up automatically.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   "",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionAdditionalHostsFileOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	additionalHostsFile = config.Concurrent.GetAsString(CfgOptionAdditionalHostsFileKey, "")
+
+	err = config.Register(&config.Option{
+		Name:           "Server Selection",
+		Key:            CfgOptionResolverSelectionKey,
+		Description:    "Defines in which order the configured DNS servers are used.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   ResolverSelectionPriority,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionResolverSelectionOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Priority",
+				Value:       ResolverSelectionPriority,
+				Description: "Use the DNS servers in the configured order",
+			},
+			{
+				Name:        "Latency",
+				Value:       ResolverSelectionLatency,
+				Description: "Prefer the DNS servers that answer the fastest",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resolverSelection = config.Concurrent.GetAsString(CfgOptionResolverSelectionKey, ResolverSelectionPriority)
+
+	err = config.Register(&config.Option{
+		Name:            "Bootstrap DNS Servers",
+		Key:             CfgOptionBootstrapNameServersKey,
+		Description:     "DNS Servers that are only used to resolve the domains of DNS servers that are configured by domain. They must be configured by IP address. If none are configured, the DNS servers of your system or network are used.",
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: fmt.Sprintf("^(%s|%s|%s|%s|%s)://.*", ServerTypeDoT, ServerTypeDoQ, ServerTypeDNS, ServerTypeTCP, TLSProtocol),
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBootstrapNameServersOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	bootstrapNameServers = config.Concurrent.GetAsStringArray(CfgOptionBootstrapNameServersKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name: "Conditional Forwarding",
+		Key:  CfgOptionConditionalForwardingKey,
+		Description: `Send queries within a domain to specific DNS servers. The most specific domain wins. Queries that do not match any domain are resolved as usual.
+
+Format: "domain=server", eg. "corp.internal=Internal". Multiple servers can be separated by comma.
+
+The server can be the name, ID or URL of a configured DNS server, or one of these groups:
+- "global": all configured DNS servers
+- "local": DNS servers in your local network
+- "system": DNS servers assigned by your system or network
+- "mdns": Multicast DNS`,
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: `^[^=]+=.+$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionConditionalForwardingOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	conditionalForwarding = config.Concurrent.GetAsStringArray(CfgOptionConditionalForwardingKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name: "Rewrite DNS Answers",
+		Key:  CfgOptionRewriteRulesKey,
+		Description: `Answer queries for a domain with fixed IP addresses or another domain, instead of asking a DNS server. Rewritten answers are not cached.
+
+Format: "domain=target", eg. "git.example.com=10.0.0.5". Use "*.example.com" to match all subdomains.
+
+The target can be a comma separated list of IP addresses, or a domain that is then resolved instead.`,
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: `^[^=]+=.+$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRewriteRulesOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	dnsRewriteRules = config.Concurrent.GetAsStringArray(CfgOptionRewriteRulesKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "Rewritten Answer TTL",
+		Key:            CfgOptionRewriteTTLKey,
+		Description:    "The TTL of answers created by rewrite rules.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   60,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRewriteTTLOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[0-9]{1,6}$`,
+	})
+	if err != nil {
+		return err
+	}
+	rewriteTTL = config.Concurrent.GetAsInt(CfgOptionRewriteTTLKey, 60)
+
 	err = config.Register(&config.Option{
 		Name:           "Ignore System/Network Servers",
 		Key:            CfgOptionNoAssignedNameserversKey,
@@ -242,6 +632,26 @@ func prepConfig() error {
 	}
 	noInsecureProtocols = status.SecurityLevelOption(CfgOptionNoInsecureProtocolsKey)
 
+	err = config.Register(&config.Option{
+		Name:           "Validate DNSSEC",
+		Key:            CfgOptionValidateDNSSECKey,
+		Description:    "Validate DNS responses with DNSSEC and block responses that fail validation. Domains that are not signed are still resolved, but are marked as insecure.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   status.SecurityLevelOff,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionValidateDNSSECOrder,
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	validateDNSSEC = status.SecurityLevelOption(CfgOptionValidateDNSSECKey)
+
 	err = config.Register(&config.Option{
 		Name: "Block Unofficial TLDs",
 		Key:  CfgOptionDontResolveSpecialDomainsKey,
@@ -266,29 +676,706 @@ func prepConfig() error {
 	}
 	dontResolveSpecialDomains = status.SecurityLevelOption(CfgOptionDontResolveSpecialDomainsKey)
 
-	return nil
-}
+	err = config.Register(&config.Option{
+		Name:           "Limit Query Types",
+		Key:            CfgOptionLimitQueryTypesKey,
+		Description:    "Block DNS queries with types that are not permitted. Rare query types are often abused for data exfiltration or amplification attacks.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   status.SecurityLevelsHighAndExtreme,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionLimitQueryTypesOrder,
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	limitQueryTypes = status.SecurityLevelOption(CfgOptionLimitQueryTypesKey)
 
-func validateNameservers(value interface{}) error {
-	list, ok := value.([]string)
-	if !ok {
-		return errors.New("invalid type")
+	err = config.Register(&config.Option{
+		Name:           "Permitted Query Types",
+		Key:            CfgOptionPermittedQueryTypesKey,
+		Description:    "DNS query types that are permitted when query types are limited. Use the type name, like \"MX\", or the generic notation, like \"TYPE65\".",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultPermittedQueryTypes,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPermittedQueryTypesOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^([A-Z0-9-]+|TYPE[0-9]{1,5})$`,
+	})
+	if err != nil {
+		return err
 	}
+	permittedQueryTypes = config.Concurrent.GetAsStringArray(CfgOptionPermittedQueryTypesKey, defaultPermittedQueryTypes)
 
-	for i, entry := range list {
-		_, _, err := createResolver(entry, ServerSourceConfigured)
-		if err != nil {
-			return fmt.Errorf("failed to parse DNS server \"%s\" (#%d): %w", entry, i+1, err)
-		}
+	err = config.Register(&config.Option{
+		Name:           "Minimal ANY Responses",
+		Key:            CfgOptionMinimalANYResponsesKey,
+		Description:    "Respond to DNS queries of type ANY with a minimal response as described in RFC8482, instead of forwarding them to a DNS server.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   status.SecurityLevelOff,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMinimalANYResponsesOrder,
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
 	}
+	minimalANYResponses = status.SecurityLevelOption(CfgOptionMinimalANYResponsesKey)
 
-	return nil
-}
+	err = config.Register(&config.Option{
+		Name:           "Strip Private Answers",
+		Key:            CfgOptionStripPrivateAnswersKey,
+		Description:    "Remove IP addresses of the local host and the local network from answers of public DNS servers, in order to protect against DNS rebinding attacks. Answers of DNS servers on the local network are not changed. If no answer is left, the domain is treated as having no records of the queried type.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   status.SecurityLevelOff,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionStripPrivateAnswersOrder,
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	stripPrivateAnswers = status.SecurityLevelOption(CfgOptionStripPrivateAnswersKey)
 
-func formatScopeList(list []string) string {
-	formatted := make([]string, 0, len(list))
-	for _, domain := range list {
-		formatted = append(formatted, strings.TrimRight(domain, "."))
+	err = config.Register(&config.Option{
+		Name:           "Single-Label Names",
+		Key:            CfgOptionSingleLabelNamesKey,
+		Description:    "Defines how queries for single-label names without any dots, like \"myhost\", are handled. These names are ambiguous, as they might refer to a device on the local network or to a top level domain.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   SingleLabelNamesAuto,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionSingleLabelNamesOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Automatic",
+				Value:       SingleLabelNamesAuto,
+				Description: "Refuse in the High and Extreme security levels, otherwise resolve as is",
+			},
+			{
+				Name:        "Resolve",
+				Value:       SingleLabelNamesResolve,
+				Description: "Resolve the name as is",
+			},
+			{
+				Name:        "Refuse",
+				Value:       SingleLabelNamesRefuse,
+				Description: "Refuse to resolve the name",
+			},
+			{
+				Name:        "Local Network",
+				Value:       SingleLabelNamesLocal,
+				Description: "Resolve the name as a .local domain via Multicast DNS",
+			},
+			{
+				Name:        "Search Domain",
+				Value:       SingleLabelNamesSearch,
+				Description: "Append the configured search domain to the name",
+			},
+		},
+	})
+	if err != nil {
+		return err
 	}
-	return strings.Join(formatted, ", ")
+	singleLabelNames = config.Concurrent.GetAsString(CfgOptionSingleLabelNamesKey, SingleLabelNamesAuto)
+
+	err = config.Register(&config.Option{
+		Name:           "Search Domain",
+		Key:            CfgOptionSearchDomainKey,
+		Description:    "Domain that is appended to single-label names, if they are configured to be resolved with the search domain, eg. \"lan\" resolves \"myhost\" as \"myhost.lan\".",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   "",
+		ValidationFunc: validateSearchDomain,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionSearchDomainOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	searchDomain = config.Concurrent.GetAsString(CfgOptionSearchDomainKey, "")
+
+	err = config.Register(&config.Option{
+		Name:           "Randomize Query Name Case",
+		Key:            CfgOptionUse0x20Key,
+		Description:    "Randomly change the case of the letters in DNS queries and check that the DNS server repeats them exactly. This makes spoofing DNS responses harder. Some DNS servers do not support this and will fail.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionUse0x20Order,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	use0x20 = config.Concurrent.GetAsBool(CfgOptionUse0x20Key, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Strip Extra Sections",
+		Key:            CfgOptionStripExtraSectionsKey,
+		Description:    "Remove the authority and additional sections from DNS answers before caching them. This reduces the size of the cache and prevents caching unrelated records, such as glue records. SOA records required for negative caching and DNSSEC records are retained.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionStripExtraSectionsOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	stripExtraSections = config.Concurrent.GetAsBool(CfgOptionStripExtraSectionsKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Throttle Failed Queries",
+		Key:            CfgOptionThrottleFailedQueriesKey,
+		Description:    "When a query fails with all DNS servers, answer repeated identical queries with the same error for a short cooldown, instead of asking all DNS servers again. This protects DNS servers from retry storms of failing domains. Domains that do not exist are not affected.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionThrottleFailedQueriesOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	throttleFailedQueries = config.Concurrent.GetAsBool(CfgOptionThrottleFailedQueriesKey, true)
+
+	err = config.Register(&config.Option{
+		Name:           "Failed Query Cooldown",
+		Key:            CfgOptionFailedQueryCooldownKey,
+		Description:    "Time during which a failed query is answered with the same error, if failed queries are throttled.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   5,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFailedQueryCooldownOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,3}$`,
+	})
+	if err != nil {
+		return err
+	}
+	failedQueryCooldown = config.Concurrent.GetAsInt(CfgOptionFailedQueryCooldownKey, 5)
+
+	err = config.Register(&config.Option{
+		Name:           "Prefetch Popular Domains",
+		Key:            CfgOptionPrefetchDomainsKey,
+		Description:    "Amount of the most frequently queried domains whose records are refreshed in the background before they expire. Set to 0 to disable prefetching.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPrefetchDomainsOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,4}$`,
+	})
+	if err != nil {
+		return err
+	}
+	prefetchDomains = config.Concurrent.GetAsInt(CfgOptionPrefetchDomainsKey, 0)
+
+	err = config.Register(&config.Option{
+		Name:           "Prefetch Lead Time",
+		Key:            CfgOptionPrefetchLeadTimeKey,
+		Description:    "Defines how long before a record would usually be refreshed the prefetching starts.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   60,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPrefetchLeadTimeOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,5}$`,
+	})
+	if err != nil {
+		return err
+	}
+	prefetchLeadTime = config.Concurrent.GetAsInt(CfgOptionPrefetchLeadTimeKey, 60)
+
+	err = config.Register(&config.Option{
+		Name:           "Record Query Traces",
+		Key:            CfgOptionQueryTracingKey,
+		Description:    "Record a structured trace of the most recent DNS queries, including the resolvers that were tried, their errors and timing, and how the cache was used. The traces are only kept in memory.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionQueryTracingOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	queryTracing = config.Concurrent.GetAsBool(CfgOptionQueryTracingKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Hash Domains in Query Traces",
+		Key:            CfgOptionQueryTracingHashDomainsKey,
+		Description:    "Replace the queried domains in query traces with a hash of the domain.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionQueryTracingHashDomainsOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	queryTracingHashDomains = config.Concurrent.GetAsBool(CfgOptionQueryTracingHashDomainsKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Minimum Cache Duration",
+		Key:            CfgOptionMinTTLKey,
+		Description:    "Minimum time DNS records are cached, regardless of the TTL set by the DNS server. Changes only apply to newly cached records.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultMinTTL,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMinTTLOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,6}$`,
+	})
+	if err != nil {
+		return err
+	}
+	minCacheTTL = config.Concurrent.GetAsInt(CfgOptionMinTTLKey, defaultMinTTL)
+
+	err = config.Register(&config.Option{
+		Name:           "Maximum Cache Duration",
+		Key:            CfgOptionMaxTTLKey,
+		Description:    "Maximum time DNS records are cached, regardless of the TTL set by the DNS server. Changes only apply to newly cached records.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultMaxTTL,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxTTLOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,6}$`,
+	})
+	if err != nil {
+		return err
+	}
+	maxCacheTTL = config.Concurrent.GetAsInt(CfgOptionMaxTTLKey, defaultMaxTTL)
+
+	err = config.Register(&config.Option{
+		Name:           "Cache Refresh Duration",
+		Key:            CfgOptionRefreshTTLKey,
+		Description:    "Cached DNS records are refreshed in the background when they expire within this time.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultRefreshTTL,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRefreshTTLOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,6}$`,
+	})
+	if err != nil {
+		return err
+	}
+	refreshCacheTTL = config.Concurrent.GetAsInt(CfgOptionRefreshTTLKey, defaultRefreshTTL)
+
+	err = config.Register(&config.Option{
+		Name: "Cache Durations per Security Level",
+		Key:  CfgOptionTTLSecurityLevelOverridesKey,
+		Description: `Override the cache durations for a security level. Changes only apply to newly cached records.
+
+Format: "level:setting=seconds", eg. "untrusted:max=300". Multiple settings can be separated by comma.
+
+The level is one of "trusted", "untrusted" or "danger". The setting is one of "min", "max" or "refresh".`,
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: `^(trusted|untrusted|danger):(min|max|refresh)=[0-9]{1,6}(,(min|max|refresh)=[0-9]{1,6})*$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTTLSecurityLevelOverridesOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ttlSecurityLevelOverrides = config.Concurrent.GetAsStringArray(CfgOptionTTLSecurityLevelOverridesKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name: "Minimum Cache Durations per Domain",
+		Key:  CfgOptionTTLFloorsKey,
+		Description: `Raise the minimum cache duration for domains with very short TTLs, like some CDNs, in order to stop constant re-resolving. Changes only apply to newly cached records.
+
+Format: "domain=seconds", eg. "akamaiedge.net=30". The domain applies to all its subdomains, the most specific domain wins. The minimum cache duration is only raised, never lowered.`,
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: `^(\*\.)?[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*\.?=[0-9]{1,6}$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTTLFloorsOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ttlFloors = config.Concurrent.GetAsStringArray(CfgOptionTTLFloorsKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "Serve Stale Records",
+		Key:            CfgOptionServeStaleWindowKey,
+		Description:    "Serve expired DNS records immediately for up to the configured time after they expired, while refreshing them in the background. Records that do not exist are never served stale. Set to 0 to only serve expired records when a fresh query fails.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionServeStaleWindowOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,7}$`,
+	})
+	if err != nil {
+		return err
+	}
+	serveStaleWindow = config.Concurrent.GetAsInt(CfgOptionServeStaleWindowKey, 0)
+
+	err = config.Register(&config.Option{
+		Name:           "Client Subnet IPv4 Prefix Length",
+		Key:            CfgOptionECSIPv4PrefixLengthKey,
+		Description:    "Maximum prefix length of IPv4 client subnets that are sent to DNS servers with the EDNS Client Subnet option. Longer client subnets are truncated to protect your privacy.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   24,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionECSIPv4PrefixLengthOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^([0-9]|[12][0-9]|3[0-2])$`,
+	})
+	if err != nil {
+		return err
+	}
+	ecsIPv4PrefixLength = config.Concurrent.GetAsInt(CfgOptionECSIPv4PrefixLengthKey, 24)
+
+	err = config.Register(&config.Option{
+		Name:           "Client Subnet IPv6 Prefix Length",
+		Key:            CfgOptionECSIPv6PrefixLengthKey,
+		Description:    "Maximum prefix length of IPv6 client subnets that are sent to DNS servers with the EDNS Client Subnet option. Longer client subnets are truncated to protect your privacy.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   56,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionECSIPv6PrefixLengthOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^([0-9]|[1-9][0-9]|1[01][0-9]|12[0-8])$`,
+	})
+	if err != nil {
+		return err
+	}
+	ecsIPv6PrefixLength = config.Concurrent.GetAsInt(CfgOptionECSIPv6PrefixLengthKey, 56)
+
+	err = config.Register(&config.Option{
+		Name:           "IP Version Preference",
+		Key:            CfgOptionIPVersionPreferenceKey,
+		Description:    "Defines whether IPv6 addresses (AAAA records) are resolved. Suppressed queries are answered with an empty response by the Portmaster, which is not cached.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   IPVersionBoth,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionIPVersionPreferenceOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "IPv4 and IPv6",
+				Value:       IPVersionBoth,
+				Description: "Resolve IPv4 and IPv6 addresses",
+			},
+			{
+				Name:        "Prefer IPv4",
+				Value:       IPVersionPreferIPv4,
+				Description: "Only resolve IPv6 addresses if the device has a global IPv6 address",
+			},
+			{
+				Name:        "IPv6 Disabled",
+				Value:       IPVersionIPv6Disabled,
+				Description: "Never resolve IPv6 addresses",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ipVersionPreference = config.Concurrent.GetAsString(CfgOptionIPVersionPreferenceKey, IPVersionBoth)
+
+	err = config.Register(&config.Option{
+		Name:           "Answer Order",
+		Key:            CfgOptionShuffleAnswersKey,
+		Description:    "Defines in which order the records of an answer are returned. As many applications only use the first address, changing the order spreads the load between the servers of a domain. Cached answers are not changed.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   ShuffleAnswersNone,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionShuffleAnswersOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Unchanged",
+				Value:       ShuffleAnswersNone,
+				Description: "Return the records in the order of the DNS server",
+			},
+			{
+				Name:        "Random",
+				Value:       ShuffleAnswersRandom,
+				Description: "Shuffle the records of every response",
+			},
+			{
+				Name:        "Round Robin",
+				Value:       ShuffleAnswersRoundRobin,
+				Description: "Rotate the records by one with every response",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	shuffleAnswersMode = config.Concurrent.GetAsString(CfgOptionShuffleAnswersKey, ShuffleAnswersNone)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Records per Response",
+		Key:            CfgOptionMaxResponseRecordsKey,
+		Description:    "Maximum amount of records accepted in a single response of a DNS server. Larger responses are treated as a failure of the DNS server and the next DNS server is asked. Set to 0 to disable the limit.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultMaxResponseRecords,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxResponseRecordsOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[0-9]{1,5}$`,
+	})
+	if err != nil {
+		return err
+	}
+	maxResponseRecords = config.Concurrent.GetAsInt(CfgOptionMaxResponseRecordsKey, defaultMaxResponseRecords)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Response Size",
+		Key:            CfgOptionMaxResponseSizeKey,
+		Description:    "Maximum size of a single response of a DNS server. Larger responses are treated as a failure of the DNS server and the next DNS server is asked. Set to 0 to disable the limit.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultMaxResponseSize,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxResponseSizeOrder,
+			config.UnitAnnotation:         "bytes",
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[0-9]{1,5}$`,
+	})
+	if err != nil {
+		return err
+	}
+	maxResponseSize = config.Concurrent.GetAsInt(CfgOptionMaxResponseSizeKey, defaultMaxResponseSize)
+
+	err = config.Register(&config.Option{
+		Name:           "Retry Timed Out Queries",
+		Key:            CfgOptionRetryTimeoutsKey,
+		Description:    "Retry queries that timed out at the same DNS server a few times, with a short delay in between, before falling back to the next DNS server. This helps with unreliable network connections.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   status.SecurityLevelsAll,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRetryTimeoutsOrder,
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	retryTimeouts = status.SecurityLevelOption(CfgOptionRetryTimeoutsKey)
+
+	err = config.Register(&config.Option{
+		Name:           "Retries of Timed Out Queries",
+		Key:            CfgOptionTimeoutRetriesKey,
+		Description:    fmt.Sprintf("Maximum amount of retries of a timed out query at the same DNS server, if retrying is enabled. Up to %d retries are possible. All attempts at the same DNS server together are limited to %s.", maxTimeoutRetries, timeoutRetryBudget),
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   defaultTimeoutRetries,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTimeoutRetriesOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[0-5]$`,
+	})
+	if err != nil {
+		return err
+	}
+	timeoutRetries = config.Concurrent.GetAsInt(CfgOptionTimeoutRetriesKey, defaultTimeoutRetries)
+
+	err = config.Register(&config.Option{
+		Name:           "Notify About Failing DNS Servers",
+		Key:            CfgOptionFailingResolverNotificationsKey,
+		Description:    "Show a notification when all configured DNS servers fail, as this is usually caused by software interfering with the connections to them. Failing DNS servers are still detected and avoided when this is disabled.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFailingResolverNotificationsOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	failingResolverNotifications = config.Concurrent.GetAsBool(CfgOptionFailingResolverNotificationsKey, true)
+
+	err = config.Register(&config.Option{
+		Name: "Special Domain Handling",
+		Key:  CfgOptionSpecialDomainHandlingKey,
+		Description: `Configure how queries for special-use top level domains are handled, overriding the default handling.
+
+Format: "tld:handling", eg. "onion:forward=Tor" or "test:nxdomain".
+
+The top level domain is one of "onion", "local", "test" or "invalid". The handling is one of:
+- "block": block the query
+- "nxdomain": answer that the domain does not exist
+- "forward=server": send the query to specific DNS servers only, like with conditional forwarding`,
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: `^(onion|local|test|invalid):(block|nxdomain|forward=.+)$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionSpecialDomainHandlingOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	specialDomainHandling = config.Concurrent.GetAsStringArray(CfgOptionSpecialDomainHandlingKey, []string{})
+
+	return nil
+}
+
+func validateNameservers(value interface{}) error {
+	list, ok := value.([]string)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	for i, entry := range list {
+		_, _, err := createResolver(entry, ServerSourceConfigured)
+		if err != nil {
+			return fmt.Errorf("failed to parse DNS server \"%s\" (#%d): %w", entry, i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func validateUpstreamProxy(value interface{}) error {
+	proxyURL, ok := value.(string)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	if proxyURL == "" {
+		return nil
+	}
+	_, err := parseProxyURL(proxyURL)
+	return err
+}
+
+func formatScopeList(list []string) string {
+	formatted := make([]string, 0, len(list))
+	for _, domain := range list {
+		formatted = append(formatted, strings.TrimRight(domain, "."))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func validateSearchDomain(value interface{}) error {
+	domain, ok := value.(string)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	if domain == "" {
+		return nil
+	}
+	if !netutils.IsValidFqdn(normalizeSearchDomain(domain)) {
+		return fmt.Errorf("invalid search domain %q", domain)
+	}
+	return nil
 }
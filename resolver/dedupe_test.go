@@ -0,0 +1,146 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCountingConn struct {
+	BasicResolverConn
+
+	queries atomic.Int32
+	delay   time.Duration
+}
+
+func (tcc *testCountingConn) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	tcc.queries.Add(1)
+	select {
+	case <-time.After(tcc.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	rr, err := dns.NewRR(q.FQDN + " 3600 IN A 192.0.2.2")
+	if err != nil {
+		return nil, err
+	}
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{rr},
+		Resolver: tcc.resolver.Info.Copy(),
+	}, nil
+}
+
+// useTestCountingResolver replaces the resolvers of the internal special use
+// domain with a counting test resolver. The returned function restores them.
+func useTestCountingResolver(delay time.Duration) (conn *testCountingConn, testResolver *Resolver, restore func()) {
+	conn = &testCountingConn{delay: delay}
+	testResolver = &Resolver{
+		Info: &ResolverInfo{Type: ServerTypeDNS, Source: ServerSourceEnv, IP: net.IPv4(192, 0, 2, 53), Port: 53},
+		Conn: conn,
+	}
+	conn.resolver = testResolver
+	conn.init()
+
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{testResolver}
+	activeResolvers[testResolver.Info.ID()] = testResolver
+	resolversLock.Unlock()
+
+	return conn, testResolver, func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		delete(activeResolvers, testResolver.Info.ID())
+		resolversLock.Unlock()
+	}
+}
+
+func TestAsyncRefreshDeduplication(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, testResolver, restore := useTestCountingResolver(200 * time.Millisecond)
+	defer restore()
+
+	// Cache an entry that expires soon.
+	fqdn := "dedupe." + InternalSpecialUseDomain
+	rr, err := dns.NewRR(fqdn + " 10 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := &RRCache{
+		Domain:   fqdn,
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{rr},
+		Expires:  time.Now().Unix() + 10,
+		Resolver: testResolver.Info.Copy(),
+	}
+	if err := cached.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A near-expiry cache hit starts an async refresh.
+	rrCache, err := Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+	if assert.NoError(t, err) {
+		assert.True(t, rrCache.RequestingNew)
+	}
+
+	// A simultaneous cache miss must wait for the async refresh.
+	cacheResetLock.Lock()
+	cacheResetID = "" // Do not trigger a cache reset by repeated queries.
+	cacheResetLock.Unlock()
+	_ = ResetCachedRecord(fqdn, "A") // The entry might not be persisted yet.
+	_, err = getRRCache(fqdn, dns.Type(dns.TypeA), "")
+	assert.Error(t, err, "cache entry should be removed")
+	rrCache, err = Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "192.0.2.2", rrCache.Answer[0].(*dns.A).A.String()) //nolint:forcetypeassert // Checked by test.
+	}
+	assert.Equal(t, int32(1), conn.queries.Load(), "only one upstream query should be sent")
+}
+
+func TestDedupeWait(t *testing.T) {
+	t.Parallel()
+
+	q := &Query{FQDN: "dedupe-wait." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeA)}
+	if !q.check() {
+		t.Fatal("invalid query")
+	}
+	dupKey := q.ID()
+
+	finishFirst := deduplicateRequest(context.Background(), q)
+	if finishFirst == nil {
+		t.Fatal("first request should not wait")
+	}
+
+	// A query with a short dedupe wait proceeds on its own.
+	impatient := *q
+	impatient.DedupeWait = 50 * time.Millisecond
+	started := time.Now()
+	finishSecond := deduplicateRequest(context.Background(), &impatient)
+	assert.NotNil(t, finishSecond, "impatient request should proceed independently")
+	assert.Less(t, time.Since(started), maxRequestTimeout)
+
+	// The independent request must not replace or remove the in-flight one.
+	finishSecond()
+	dupReqLock.Lock()
+	status, ok := dupReqMap[dupKey]
+	dupReqLock.Unlock()
+	if assert.True(t, ok, "in-flight request should still be registered") {
+		assert.False(t, status.superseded)
+	}
+
+	// The in-flight request still cleans up.
+	finishFirst()
+	dupReqLock.Lock()
+	_, ok = dupReqMap[dupKey]
+	dupReqLock.Unlock()
+	assert.False(t, ok, "finished request should be removed")
+}
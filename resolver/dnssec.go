@@ -0,0 +1,550 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+)
+
+// maxDNSSECChainDepth limits how many zones may be walked when chasing the
+// chain of trust of a single record.
+const maxDNSSECChainDepth = 32
+
+// rootTrustAnchors holds the DS records of the root zone key signing keys.
+// See https://data.iana.org/root-anchors/root-anchors.xml
+var rootTrustAnchors = []*dns.DS{
+	{
+		// KSK-2017
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     20326,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	},
+}
+
+var (
+	errNoSignature     = errors.New("no covering signature")
+	errNoTrustedKey    = errors.New("no trusted key")
+	errChainTooLong    = errors.New("chain of trust too long")
+	errMissingDenial   = errors.New("missing authenticated denial of existence")
+	errUnrelatedRecord = errors.New("record does not belong to the answer")
+	errSignatureStale  = errors.New("signature not within validity period")
+)
+
+// dnssecValidator chases the chain of trust for a single query. Validated
+// zone keys are kept for the lifetime of the validator only.
+type dnssecValidator struct {
+	ctx   context.Context
+	q     *Query
+	keys  map[string][]*dns.DNSKEY
+	depth int
+}
+
+// validateRRCacheWithDNSSEC validates the records of the given RRCache and sets the
+// AuthenticatedData or Insecure flag accordingly. It returns an error wrapping
+// ErrBogus if validation fails.
+func validateRRCacheWithDNSSEC(ctx context.Context, q *Query, rrCache *RRCache) error {
+	// Local sources do not support DNSSEC.
+	switch rrCache.Resolver.Source {
+	case ServerSourceEnv, ServerSourceMDNS, ServerSourceHosts:
+		rrCache.Insecure = true
+		return nil
+	}
+
+	v := &dnssecValidator{
+		ctx:  ctx,
+		q:    q,
+		keys: make(map[string][]*dns.DNSKEY),
+	}
+
+	// Validate the answer, which must only hold the records of the queried
+	// name and its CNAME chain.
+	name, found, insecure, err := v.verifyAnswer(rrCache)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrBogus, q.ID(), err)
+	}
+
+	// Negative answers must prove that the records do not exist.
+	if !found && !insecure {
+		insecure, err = v.verifyDenial(name, rrCache.RCode == dns.RcodeNameError, rrCache.Ns)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrBogus, q.ID(), err)
+		}
+	}
+
+	if insecure {
+		rrCache.Insecure = true
+	} else {
+		rrCache.AuthenticatedData = true
+	}
+	log.Tracer(ctx).Tracef("resolver: dnssec validation of %s succeeded (authenticated=%v)", q.ID(), rrCache.AuthenticatedData)
+	return nil
+}
+
+// verifyAnswer validates the RRsets of the answer section. The answer must
+// only hold the records of the queried name and the CNAMEs leading to them. It
+// returns the last name of the CNAME chain and whether records of the queried
+// type were found.
+func (v *dnssecValidator) verifyAnswer(rrCache *RRCache) (name string, found, insecure bool, err error) {
+	rrSets, sigs := splitRRSets(rrCache.Answer)
+	used := make([]bool, len(rrSets))
+
+	// Follow the CNAME chain from the queried name.
+	name = v.q.FQDN
+	for hops := 0; ; hops++ {
+		if hops > maxCNAMEChainDepth {
+			return "", false, false, fmt.Errorf("CNAME chain of %s exceeds %d hops", v.q.FQDN, maxCNAMEChainDepth)
+		}
+
+		var target string
+		for i, rrSet := range rrSets {
+			header := rrSet[0].Header()
+			if used[i] || !strings.EqualFold(header.Name, name) {
+				continue
+			}
+			switch {
+			case header.Rrtype == uint16(v.q.QType) || v.q.QType == dns.Type(dns.TypeANY):
+				found = true
+			case header.Rrtype == dns.TypeCNAME:
+				target = rrSet[0].(*dns.CNAME).Target //nolint:forcetypeassert // Checked by type.
+			default:
+				continue
+			}
+			used[i] = true
+
+			rrSetInsecure, err := v.verifyAnswerRRSet(rrSet, sigs, rrCache.Ns)
+			if err != nil {
+				return "", false, false, fmt.Errorf("failed to validate %s%s: %w", header.Name, dns.Type(header.Rrtype), err)
+			}
+			insecure = insecure || rrSetInsecure
+		}
+
+		if found || target == "" {
+			break
+		}
+		name = target
+	}
+
+	// Check for records that do not belong to the answer.
+	for i, rrSet := range rrSets {
+		if !used[i] {
+			header := rrSet[0].Header()
+			return "", false, false, fmt.Errorf("%w: %s%s", errUnrelatedRecord, header.Name, dns.Type(header.Rrtype))
+		}
+	}
+
+	return name, found, insecure, nil
+}
+
+// verifyAnswerRRSet validates the given RRset of the answer section. If the
+// RRset was expanded from a wildcard, the authority section must prove that
+// the name itself does not exist.
+func (v *dnssecValidator) verifyAnswerRRSet(rrSet []dns.RR, sigs []*dns.RRSIG, authority []dns.RR) (insecure bool, err error) {
+	sig, insecure, err := v.verifyRRSetInZone(rrSet, sigs)
+	if err != nil || insecure {
+		return insecure, err
+	}
+
+	// Check for wildcard expansion. The labels of the signature do not count
+	// the wildcard label.
+	owner := rrSet[0].Header().Name
+	labels := dns.CountLabel(owner)
+	if int(sig.Labels) >= labels ||
+		(int(sig.Labels) == labels-1 && strings.HasPrefix(owner, "*.")) {
+		return false, nil
+	}
+	nsecs, nsec3s, insecure, err := v.verifyDenialRecords(authority)
+	switch {
+	case err != nil:
+		return false, err
+	case insecure:
+		return false, fmt.Errorf("%w: unsigned proof of wildcard expansion", errMissingDenial)
+	}
+	return false, proveWildcardExpansion(owner, int(sig.Labels), nsecs, nsec3s)
+}
+
+// verifyRRSetInZone verifies the given RRset and returns the verifying
+// signature. Unsigned RRsets are only accepted, and reported as insecure, if
+// they are within an insecure zone.
+func (v *dnssecValidator) verifyRRSetInZone(rrSet []dns.RR, sigs []*dns.RRSIG) (sig *dns.RRSIG, insecure bool, err error) {
+	sig, err = v.verifyRRSetSignature(rrSet, sigs)
+	if !errors.Is(err, errNoSignature) {
+		return sig, false, err
+	}
+
+	insecure, err = v.isInsecure(rrSet[0].Header().Name)
+	switch {
+	case err != nil:
+		return nil, false, err
+	case !insecure:
+		return nil, false, errNoSignature
+	}
+	return nil, true, nil
+}
+
+// verifyDenial validates the authority section of a negative answer and
+// checks that it proves that the given name does not exist, or has no records
+// of the queried type.
+func (v *dnssecValidator) verifyDenial(name string, nxDomain bool, authority []dns.RR) (insecure bool, err error) {
+	nsecs, nsec3s, insecure, err := v.verifyDenialRecords(authority)
+	switch {
+	case err != nil:
+		return false, err
+	case insecure:
+		return true, nil
+	case len(nsecs) == 0 && len(nsec3s) == 0:
+		// Without any records, the name must be within an insecure zone.
+		insecure, err = v.isInsecure(name)
+		switch {
+		case err != nil:
+			return false, err
+		case !insecure:
+			return false, errMissingDenial
+		}
+		return true, nil
+	}
+
+	if len(nsecs) > 0 {
+		return false, proveDenialWithNSEC(name, uint16(v.q.QType), nxDomain, nsecs)
+	}
+	return proveDenialWithNSEC3(name, uint16(v.q.QType), nxDomain, nsec3s)
+}
+
+// verifyDenialRecords validates the RRsets of the given authority section and
+// returns the NSEC and NSEC3 records within.
+func (v *dnssecValidator) verifyDenialRecords(authority []dns.RR) (nsecs []*dns.NSEC, nsec3s []*dns.NSEC3, insecure bool, err error) {
+	rrSets, sigs := splitRRSets(authority)
+	for _, rrSet := range rrSets {
+		_, rrSetInsecure, err := v.verifyRRSetInZone(rrSet, sigs)
+		if err != nil {
+			header := rrSet[0].Header()
+			return nil, nil, false, fmt.Errorf("failed to validate %s%s: %w", header.Name, dns.Type(header.Rrtype), err)
+		}
+		if rrSetInsecure {
+			insecure = true
+			continue
+		}
+
+		for _, rr := range rrSet {
+			switch v := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, v)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, v)
+			}
+		}
+	}
+	if insecure {
+		return nil, nil, true, nil
+	}
+	return nsecs, nsec3s, false, nil
+}
+
+// splitRRSets groups the given records into RRsets and returns them
+// separately from the signatures.
+func splitRRSets(section []dns.RR) (rrSets [][]dns.RR, sigs []*dns.RRSIG) {
+	index := make(map[string]int)
+	for _, rr := range section {
+		switch v := rr.(type) {
+		case *dns.RRSIG:
+			sigs = append(sigs, v)
+		case *dns.OPT:
+			// Not part of any RRset.
+		default:
+			key := strings.ToLower(rr.Header().Name) + dns.Type(rr.Header().Rrtype).String()
+			if i, ok := index[key]; ok {
+				rrSets[i] = append(rrSets[i], rr)
+				continue
+			}
+			index[key] = len(rrSets)
+			rrSets = append(rrSets, []dns.RR{rr})
+		}
+	}
+	return rrSets, sigs
+}
+
+// verifyRRSet verifies the given RRset with one of the matching signatures.
+func (v *dnssecValidator) verifyRRSet(rrSet []dns.RR, sigs []*dns.RRSIG) error {
+	_, err := v.verifyRRSetSignature(rrSet, sigs)
+	return err
+}
+
+// verifyRRSetSignature verifies the given RRset with one of the matching
+// signatures and returns the verifying signature.
+func (v *dnssecValidator) verifyRRSetSignature(rrSet []dns.RR, sigs []*dns.RRSIG) (*dns.RRSIG, error) {
+	header := rrSet[0].Header()
+
+	var lastErr error = errNoSignature
+	for _, sig := range sigs {
+		// Find signatures covering this RRset.
+		if sig.TypeCovered != header.Rrtype ||
+			!strings.EqualFold(sig.Header().Name, header.Name) ||
+			!dns.IsSubDomain(sig.SignerName, header.Name) {
+			continue
+		}
+
+		// Get the validated keys of the signing zone.
+		keys, err := v.zoneKeys(sig.SignerName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err := verifyWithKeys(sig, keys, rrSet); err != nil {
+			lastErr = err
+			continue
+		}
+		return sig, nil
+	}
+
+	return nil, lastErr
+}
+
+// verifyWithKeys verifies the RRset with the given signature using one of the
+// given keys.
+func verifyWithKeys(sig *dns.RRSIG, keys []*dns.DNSKEY, rrSet []dns.RR) error {
+	if !sig.ValidityPeriod(time.Now()) {
+		return errSignatureStale
+	}
+
+	var lastErr error = errNoTrustedKey
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, rrSet); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// zoneKeys returns the validated DNSKEYs of the given zone.
+func (v *dnssecValidator) zoneKeys(zone string) ([]*dns.DNSKEY, error) {
+	zone = dns.CanonicalName(zone)
+	if keys, ok := v.keys[zone]; ok {
+		return keys, nil
+	}
+
+	// Prevent endless chasing.
+	v.depth++
+	if v.depth > maxDNSSECChainDepth {
+		return nil, errChainTooLong
+	}
+
+	// Get the trusted DS records of the zone.
+	var dsSet []*dns.DS
+	if zone == "." {
+		dsSet = rootTrustAnchors
+	} else {
+		var err error
+		dsSet, err = v.delegationSigners(zone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get the DNSKEY RRset.
+	rrCache, err := v.lookup(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNSKEY of %s: %w", zone, err)
+	}
+	var (
+		keySet  []dns.RR
+		keys    []*dns.DNSKEY
+		trusted []*dns.DNSKEY
+		sigs    []*dns.RRSIG
+	)
+	for _, rr := range rrCache.Answer {
+		switch v := rr.(type) {
+		case *dns.DNSKEY:
+			keySet = append(keySet, v)
+			keys = append(keys, v)
+			// Check if the key is referenced by a trusted DS record.
+			for _, ds := range dsSet {
+				if ds.KeyTag == v.KeyTag() &&
+					ds.Algorithm == v.Algorithm &&
+					strings.EqualFold(ds.Digest, v.ToDS(ds.DigestType).Digest) {
+					trusted = append(trusted, v)
+					break
+				}
+			}
+		case *dns.RRSIG:
+			if v.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, v)
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("%w for %s", errNoTrustedKey, zone)
+	}
+
+	// Verify the DNSKEY RRset with a trusted key.
+	for _, sig := range sigs {
+		if err = verifyWithKeys(sig, trusted, keySet); err == nil {
+			v.keys[zone] = keys
+			return keys, nil
+		}
+	}
+	if err == nil {
+		err = errNoSignature
+	}
+	return nil, fmt.Errorf("failed to verify DNSKEY of %s: %w", zone, err)
+}
+
+// delegationSigners returns the validated DS records of the given zone.
+func (v *dnssecValidator) delegationSigners(zone string) ([]*dns.DS, error) {
+	rrCache, err := v.lookup(zone, dns.TypeDS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DS of %s: %w", zone, err)
+	}
+	return v.verifyDelegationSigners(zone, rrCache)
+}
+
+// verifyDelegationSigners returns the validated DS records of the given zone
+// from the given DS answer.
+func (v *dnssecValidator) verifyDelegationSigners(zone string, rrCache *RRCache) ([]*dns.DS, error) {
+	rrSets, sigs := splitRRSets(rrCache.Answer)
+	for _, rrSet := range rrSets {
+		if rrSet[0].Header().Rrtype != dns.TypeDS {
+			continue
+		}
+		if err := v.verifyRRSet(rrSet, sigs); err != nil {
+			return nil, fmt.Errorf("failed to verify DS of %s: %w", zone, err)
+		}
+
+		dsSet := make([]*dns.DS, 0, len(rrSet))
+		for _, rr := range rrSet {
+			if ds, ok := rr.(*dns.DS); ok {
+				dsSet = append(dsSet, ds)
+			}
+		}
+		return dsSet, nil
+	}
+
+	return nil, fmt.Errorf("%w for %s", errNoTrustedKey, zone)
+}
+
+// isInsecure walks the given name from the root down and checks if there is
+// an authenticated insecure delegation on the way.
+func (v *dnssecValidator) isInsecure(name string) (bool, error) {
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		rrCache, err := v.lookup(candidate, dns.TypeDS)
+		if err != nil {
+			return false, fmt.Errorf("failed to get DS of %s: %w", candidate, err)
+		}
+
+		// If there is a DS record, the zone is - or at least claims to be - signed.
+		if len(rrCache.Answer) > 0 {
+			if _, err := v.verifyDelegationSigners(candidate, rrCache); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		// Otherwise, check the authenticated denial of existence.
+		delegation, optOut, err := v.checkDenial(candidate, rrCache.Ns)
+		if err != nil {
+			return false, err
+		}
+		if delegation || optOut {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkDenial verifies the NSEC or NSEC3 records of a negative DS answer and
+// returns whether they prove an unsigned delegation.
+func (v *dnssecValidator) checkDenial(name string, authority []dns.RR) (delegation, optOut bool, err error) {
+	rrSets, sigs := splitRRSets(authority)
+
+	var proven bool
+	for _, rrSet := range rrSets {
+		switch rrSet[0].Header().Rrtype {
+		case dns.TypeNSEC, dns.TypeNSEC3:
+		default:
+			continue
+		}
+		if err := v.verifyRRSet(rrSet, sigs); err != nil {
+			return false, false, err
+		}
+		proven = true
+
+		for _, rr := range rrSet {
+			switch nsec := rr.(type) {
+			case *dns.NSEC:
+				if strings.EqualFold(nsec.Header().Name, name) {
+					return isUnsignedDelegation(nsec.TypeBitMap), false, nil
+				}
+			case *dns.NSEC3:
+				switch {
+				case nsec.Match(name):
+					return isUnsignedDelegation(nsec.TypeBitMap), false, nil
+				case nsec.Cover(name) && nsec.Flags&0x01 != 0:
+					// Opt-Out: Unsigned delegations may exist in this span.
+					optOut = true
+				}
+			}
+		}
+	}
+
+	if !proven {
+		return false, false, fmt.Errorf("%w for DS of %s", errMissingDenial, name)
+	}
+	return false, optOut, nil
+}
+
+func isUnsignedDelegation(bitmap []uint16) bool {
+	var hasNS, hasDS, hasSOA bool
+	for _, t := range bitmap {
+		switch t {
+		case dns.TypeNS:
+			hasNS = true
+		case dns.TypeDS:
+			hasDS = true
+		case dns.TypeSOA:
+			hasSOA = true
+		}
+	}
+	return hasNS && !hasDS && !hasSOA
+}
+
+// lookup resolves records required for chasing the chain of trust.
+func (v *dnssecValidator) lookup(fqdn string, qtype uint16) (*RRCache, error) {
+	q := v.q.subQuery(fqdn, dns.Type(qtype))
+	q.dnssecChainQuery = true
+	// The chain of trust is the same for all clients and queries, so it is
+	// always cached and never scoped.
+	q.NoCaching = false
+	q.ECSNetwork = nil
+	q.ClientScope = ""
+	return Resolve(v.ctx, q)
+}
+
+// hasSignatures returns whether the answer or authority section of the
+// RRCache holds signatures. Cached entries without signatures were resolved
+// without DNSSEC records and cannot be used to chase the chain of trust.
+func (rrCache *RRCache) hasSignatures() bool {
+	for _, section := range [][]dns.RR{rrCache.Answer, rrCache.Ns} {
+		for _, rr := range section {
+			if _, ok := rr.(*dns.RRSIG); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,401 @@
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSSECVerifyRRSet(t *testing.T) {
+	t.Parallel()
+
+	// Generate zone key.
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privKey, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create and sign RRset.
+	a, err := dns.NewRR("www.example.com. 3600 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrSet := []dns.RR{a}
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+		KeyTag:     key.KeyTag(),
+		SignerName: key.Hdr.Name,
+		Algorithm:  key.Algorithm,
+	}
+	err = sig.Sign(privKey.(crypto.Signer), rrSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check grouping.
+	rrSets, sigs := splitRRSets([]dns.RR{a, sig})
+	if len(rrSets) != 1 || len(sigs) != 1 {
+		t.Fatalf("unexpected grouping: %d RRsets, %d signatures", len(rrSets), len(sigs))
+	}
+
+	// Check valid signature.
+	if err := verifyWithKeys(sig, []*dns.DNSKEY{key}, rrSet); err != nil {
+		t.Fatalf("valid signature failed verification: %s", err)
+	}
+
+	// Check tampered record.
+	tampered, err := dns.NewRR("www.example.com. 3600 IN A 192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyWithKeys(sig, []*dns.DNSKEY{key}, []dns.RR{tampered}); err == nil {
+		t.Fatal("tampered record passed verification")
+	}
+
+	// Check trust anchor digest.
+	ds := key.ToDS(dns.SHA256)
+	if ds.KeyTag != key.KeyTag() {
+		t.Fatal("DS key tag mismatch")
+	}
+}
+
+func TestDNSSECUnsignedDelegation(t *testing.T) {
+	t.Parallel()
+
+	if !isUnsignedDelegation([]uint16{dns.TypeNS, dns.TypeNSEC, dns.TypeRRSIG}) {
+		t.Error("delegation without DS should be unsigned")
+	}
+	if isUnsignedDelegation([]uint16{dns.TypeNS, dns.TypeDS, dns.TypeRRSIG}) {
+		t.Error("delegation with DS should be signed")
+	}
+	if isUnsignedDelegation([]uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG}) {
+		t.Error("zone apex is not a delegation")
+	}
+}
+
+func TestCheckingDisabled(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, _, restore := useTestCountingResolver(0)
+	defer restore()
+
+	q := &Query{
+		FQDN:             "cd." + InternalSpecialUseDomain,
+		QType:            dns.Type(dns.TypeA),
+		ValidateDNSSEC:   true,
+		CheckingDisabled: true,
+	}
+
+	// Check that DNSSEC records are requested, but not validated.
+	if q.shouldValidateDNSSEC() {
+		t.Error("query with CD bit should not be validated")
+	}
+	request := q.newDNSRequest()
+	if !request.CheckingDisabled {
+		t.Error("CD bit should be set on upstream request")
+	}
+	if opt := request.IsEdns0(); opt == nil || !opt.Do() {
+		t.Error("DNSSEC records should be requested")
+	}
+
+	// Check that the answer is passed through, but not cached.
+	rrCache, err := Resolve(context.Background(), q)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case rrCache.AuthenticatedData:
+		t.Error("answer should not be marked as authenticated")
+	case conn.queries.Load() != 1:
+		t.Errorf("expected 1 upstream query, got %d", conn.queries.Load())
+	}
+	if _, err := getRRCache(q.FQDN, q.QType, q.cacheKeySuffix()); err == nil {
+		t.Error("answer should not be cached")
+	}
+}
+
+// testZone is a DNSSEC signed zone for tests.
+type testZone struct {
+	key     *dns.DNSKEY
+	privKey crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privKey, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testZone{key: key, privKey: privKey.(crypto.Signer)} //nolint:forcetypeassert
+}
+
+// sign returns the given RRset with its signature.
+func (tz *testZone) sign(t *testing.T, rrSet ...dns.RR) []dns.RR {
+	t.Helper()
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrSet[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+		KeyTag:     tz.key.KeyTag(),
+		SignerName: tz.key.Hdr.Name,
+		Algorithm:  tz.key.Algorithm,
+	}
+	if err := sig.Sign(tz.privKey, rrSet); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrSet, sig)
+}
+
+// testZoneConn answers queries from a fixed set of answers and counts the
+// queries per question.
+type testZoneConn struct {
+	BasicResolverConn
+
+	answers map[string]*RRCache
+
+	queriesLock sync.Mutex
+	queries     map[string]int
+}
+
+func (tzc *testZoneConn) Query(_ context.Context, q *Query) (*RRCache, error) {
+	key := dns.CanonicalName(q.FQDN) + q.QType.String()
+
+	tzc.queriesLock.Lock()
+	tzc.queries[key]++
+	tzc.queriesLock.Unlock()
+
+	rrCache := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeNameError,
+		Resolver: tzc.resolver.Info.Copy(),
+	}
+	if answer, ok := tzc.answers[key]; ok {
+		rrCache.RCode = answer.RCode
+		rrCache.Answer = answer.Answer
+		rrCache.Ns = answer.Ns
+	}
+	return rrCache, nil
+}
+
+func (tzc *testZoneConn) queryCount(fqdn string, qtype uint16) int {
+	tzc.queriesLock.Lock()
+	defer tzc.queriesLock.Unlock()
+
+	return tzc.queries[fqdn+dns.Type(qtype).String()]
+}
+
+func TestValidateRRCacheWithDNSSEC(t *testing.T) { //nolint:paralleltest // Changes global resolvers and trust anchors.
+	mustRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+
+	// Create a signed chain of trust: . -> de. -> signed.de.
+	root := newTestZone(t, ".")
+	de := newTestZone(t, "de.")
+	signed := newTestZone(t, "signed.de.")
+	signedSOA := signed.sign(t, mustRR("signed.de. 3600 IN SOA ns.signed.de. hostmaster.signed.de. 1 3600 600 86400 300"))
+	answers := map[string]*RRCache{
+		".DNSKEY":          {Answer: root.sign(t, root.key)},
+		"de.DNSKEY":        {Answer: de.sign(t, de.key)},
+		"de.DS":            {Answer: root.sign(t, de.key.ToDS(dns.SHA256))},
+		"signed.de.DNSKEY": {Answer: signed.sign(t, signed.key)},
+		"signed.de.DS":     {Answer: de.sign(t, signed.key.ToDS(dns.SHA256))},
+		"www.signed.de.A":  {Answer: signed.sign(t, mustRR("www.signed.de. 3600 IN A 192.0.2.1"))},
+		// unsigned.signed.de. is an unsigned delegation.
+		"unsigned.signed.de.DS":    {Ns: signed.sign(t, mustRR("unsigned.signed.de. 3600 IN NSEC v.signed.de. NS RRSIG NSEC"))},
+		"www.unsigned.signed.de.A": {Answer: []dns.RR{mustRR("www.unsigned.signed.de. 3600 IN A 192.0.2.2")}},
+		// The signature of bogus.signed.de. does not match.
+		"bogus.signed.de.A": {Answer: append(
+			[]dns.RR{mustRR("bogus.signed.de. 3600 IN A 192.0.2.3")},
+			signed.sign(t, mustRR("bogus.signed.de. 3600 IN A 192.0.2.4"))[1],
+		)},
+		// A signed record of another name is replayed for replay.signed.de.
+		"replay.signed.de.A": {Answer: signed.sign(t, mustRR("www.signed.de. 3600 IN A 192.0.2.1"))},
+		// wild.signed.de. is expanded from a wildcard, which must be proven.
+		"wild.signed.de.A": {
+			Answer: expandWildcard(signed.sign(t, mustRR("*.signed.de. 3600 IN A 192.0.2.5")), "wild.signed.de."),
+			Ns:     signed.sign(t, mustRR("mail.signed.de. 3600 IN NSEC www.signed.de. A RRSIG NSEC")),
+		},
+		"forged-wild.signed.de.A": {
+			Answer: expandWildcard(signed.sign(t, mustRR("*.signed.de. 3600 IN A 192.0.2.5")), "forged-wild.signed.de."),
+		},
+		// nx.signed.de. does not exist and there is no wildcard.
+		"nx.signed.de.A": {
+			RCode: dns.RcodeNameError,
+			Ns: append(append(signedSOA,
+				signed.sign(t, mustRR("mail.signed.de. 3600 IN NSEC www.signed.de. A RRSIG NSEC"))...),
+				signed.sign(t, mustRR("signed.de. 3600 IN NSEC a.signed.de. NS SOA RRSIG NSEC DNSKEY"))...,
+			),
+		},
+		// www.signed.de. has no AAAA records.
+		"www.signed.de.AAAA": {
+			Ns: append(signedSOA, signed.sign(t, mustRR("www.signed.de. 3600 IN NSEC x.signed.de. A RRSIG NSEC"))...),
+		},
+		// Negative answers with a replayed SOA only are forged.
+		"forged-nx.signed.de.A": {RCode: dns.RcodeNameError, Ns: signedSOA},
+		"www.signed.de.TXT":     {Ns: signedSOA},
+		"mail.signed.de.A":      {Ns: append(signedSOA, signed.sign(t, mustRR("mail.signed.de. 3600 IN NSEC www.signed.de. A RRSIG NSEC"))...)},
+		"forged-nodata.signed.de.A": {
+			Ns: append(signedSOA, signed.sign(t, mustRR("mail.signed.de. 3600 IN NSEC www.signed.de. A RRSIG NSEC"))...),
+		},
+	}
+
+	defer func() {
+		// Remove the cached chain of trust, as the keys are generated per run.
+		for key := range answers {
+			nameEnd := strings.LastIndex(key, ".") + 1
+			_ = ResetCachedRecord(key[:nameEnd], key[nameEnd:])
+		}
+	}()
+
+	previousTrustAnchors := rootTrustAnchors
+	rootTrustAnchors = []*dns.DS{root.key.ToDS(dns.SHA256)}
+	defer func() {
+		rootTrustAnchors = previousTrustAnchors
+	}()
+
+	conn := &testZoneConn{answers: answers, queries: make(map[string]int)}
+	testResolver := &Resolver{
+		Info: &ResolverInfo{Type: ServerTypeDNS, Source: ServerSourceConfigured, IP: net.IPv4(192, 0, 2, 54), Port: 53},
+		Conn: conn,
+	}
+	conn.resolver = testResolver
+	conn.init()
+
+	resolversLock.Lock()
+	previousGlobalResolvers := globalResolvers
+	globalResolvers = []*Resolver{testResolver}
+	activeResolvers[testResolver.Info.ID()] = testResolver
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		globalResolvers = previousGlobalResolvers
+		delete(activeResolvers, testResolver.Info.ID())
+		resolversLock.Unlock()
+	}()
+
+	resolveType := func(fqdn string, qtype uint16) (*RRCache, error) {
+		return Resolve(silencingTraceCtx, &Query{
+			FQDN:           fqdn,
+			QType:          dns.Type(qtype),
+			ValidateDNSSEC: true,
+			NoCaching:      true,
+		})
+	}
+	resolve := func(fqdn string) (*RRCache, error) {
+		return resolveType(fqdn, dns.TypeA)
+	}
+
+	// Check that signed answers are authenticated.
+	rrCache, err := resolve("www.signed.de.")
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case !rrCache.AuthenticatedData || rrCache.Insecure:
+		t.Errorf("answer should be authenticated (authenticated=%v, insecure=%v)", rrCache.AuthenticatedData, rrCache.Insecure)
+	}
+
+	// Check that answers from unsigned zones are marked as insecure.
+	rrCache, err = resolve("www.unsigned.signed.de.")
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case rrCache.AuthenticatedData || !rrCache.Insecure:
+		t.Errorf("answer should be insecure (authenticated=%v, insecure=%v)", rrCache.AuthenticatedData, rrCache.Insecure)
+	}
+
+	// Check that records of the chain of trust are cached and not queried twice.
+	for _, question := range []struct {
+		fqdn  string
+		qtype uint16
+	}{
+		{".", dns.TypeDNSKEY},
+		{"de.", dns.TypeDS},
+		{"de.", dns.TypeDNSKEY},
+		{"signed.de.", dns.TypeDS},
+		{"signed.de.", dns.TypeDNSKEY},
+		{"unsigned.signed.de.", dns.TypeDS},
+	} {
+		if n := conn.queryCount(question.fqdn, question.qtype); n != 1 {
+			t.Errorf("expected 1 upstream query for %s%s, got %d", question.fqdn, dns.Type(question.qtype), n)
+		}
+	}
+
+	// Check that proven wildcard expansions and negative answers are authenticated.
+	for _, question := range []struct {
+		fqdn  string
+		qtype uint16
+		rcode int
+	}{
+		{"wild.signed.de.", dns.TypeA, dns.RcodeSuccess},
+		{"nx.signed.de.", dns.TypeA, dns.RcodeNameError},
+		{"www.signed.de.", dns.TypeAAAA, dns.RcodeSuccess},
+	} {
+		rrCache, err := resolveType(question.fqdn, question.qtype)
+		switch {
+		case err != nil:
+			t.Errorf("%s%s: %s", question.fqdn, dns.Type(question.qtype), err)
+		case rrCache.RCode != question.rcode:
+			t.Errorf("%s%s: expected rcode %s, got %s", question.fqdn, dns.Type(question.qtype), dns.RcodeToString[question.rcode], dns.RcodeToString[rrCache.RCode])
+		case !rrCache.AuthenticatedData || rrCache.Insecure:
+			t.Errorf("%s%s: answer should be authenticated (authenticated=%v, insecure=%v)", question.fqdn, dns.Type(question.qtype), rrCache.AuthenticatedData, rrCache.Insecure)
+		}
+	}
+
+	// Check that answers with invalid signatures, replayed records or missing
+	// proofs are bogus.
+	for _, question := range []struct {
+		fqdn  string
+		qtype uint16
+	}{
+		{"bogus.signed.de.", dns.TypeA},
+		{"replay.signed.de.", dns.TypeA},
+		{"forged-wild.signed.de.", dns.TypeA},
+		{"forged-nx.signed.de.", dns.TypeA},
+		{"www.signed.de.", dns.TypeTXT},
+		{"mail.signed.de.", dns.TypeA},
+		{"forged-nodata.signed.de.", dns.TypeA},
+	} {
+		_, err := resolveType(question.fqdn, question.qtype)
+		if !errors.Is(err, ErrBogus) {
+			t.Errorf("%s%s: expected bogus answer, got %v", question.fqdn, dns.Type(question.qtype), err)
+		}
+	}
+}
+
+// expandWildcard returns the signed wildcard RRset as expanded for the given
+// name.
+func expandWildcard(signedRRSet []dns.RR, name string) []dns.RR {
+	expanded := make([]dns.RR, 0, len(signedRRSet))
+	for _, rr := range signedRRSet {
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		expanded = append(expanded, rr)
+	}
+	return expanded
+}
@@ -0,0 +1,193 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// proveDenialWithNSEC checks that the given validated NSEC records prove that
+// the name does not exist, or that it has no records of the given type.
+// See RFC 4035, Section 5.4.
+func proveDenialWithNSEC(name string, qtype uint16, nxDomain bool, nsecs []*dns.NSEC) error {
+	if !nxDomain {
+		// The name exists, but not with the queried type.
+		for _, nsec := range nsecs {
+			if strings.EqualFold(nsec.Hdr.Name, name) {
+				return checkNoDataBitmap(name, qtype, nsec.TypeBitMap)
+			}
+		}
+	}
+
+	// The name must not exist...
+	var covering *dns.NSEC
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			covering = nsec
+			break
+		}
+	}
+	if covering == nil {
+		return fmt.Errorf("%w: no NSEC covers %s", errMissingDenial, name)
+	}
+	// ... which is enough for empty non-terminals, ...
+	if !nxDomain && dns.IsSubDomain(name, covering.NextDomain) {
+		return nil
+	}
+
+	// ... and no wildcard may be expanded instead.
+	closestEncloser := lastLabels(name, max(
+		dns.CompareDomainName(name, covering.Hdr.Name),
+		dns.CompareDomainName(name, covering.NextDomain),
+	))
+	wildcard := wildcardOf(closestEncloser)
+	for _, nsec := range nsecs {
+		switch {
+		case nxDomain && nsecCovers(nsec, wildcard):
+			return nil
+		case !nxDomain && strings.EqualFold(nsec.Hdr.Name, wildcard):
+			return checkNoDataBitmap(wildcard, qtype, nsec.TypeBitMap)
+		}
+	}
+	return fmt.Errorf("%w: no NSEC denies wildcard %s", errMissingDenial, wildcard)
+}
+
+// proveDenialWithNSEC3 checks that the given validated NSEC3 records prove
+// that the name does not exist, or that it has no records of the given type.
+// Answers that may be an unsigned delegation within an Opt-Out span are
+// reported as insecure. See RFC 5155, Section 8.
+func proveDenialWithNSEC3(name string, qtype uint16, nxDomain bool, nsec3s []*dns.NSEC3) (insecure bool, err error) {
+	if !nxDomain {
+		// The name exists, but not with the queried type.
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(name) {
+				return false, checkNoDataBitmap(name, qtype, nsec3.TypeBitMap)
+			}
+		}
+	}
+
+	// Find the closest encloser and check that the next closer name does not exist.
+	closestEncloser, nextCloser := proveClosestEncloser(name, nsec3s)
+	if closestEncloser == "" {
+		return false, fmt.Errorf("%w: no NSEC3 proves the closest encloser of %s", errMissingDenial, name)
+	}
+	var optOut bool
+	for _, nsec3 := range nsec3s {
+		if nsec3Covers(nsec3, nextCloser) {
+			optOut = nsec3.Flags&0x01 != 0
+			break
+		}
+	}
+
+	// Check that no wildcard may be expanded instead.
+	wildcard := wildcardOf(closestEncloser)
+	for _, nsec3 := range nsec3s {
+		switch {
+		case nxDomain && nsec3Covers(nsec3, wildcard):
+			return optOut, nil
+		case !nxDomain && nsec3.Match(wildcard):
+			return false, checkNoDataBitmap(wildcard, qtype, nsec3.TypeBitMap)
+		}
+	}
+	// Opt-Out spans may hold unsigned delegations without any wildcard proof.
+	if !nxDomain && optOut && qtype == dns.TypeDS {
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: no NSEC3 denies wildcard %s", errMissingDenial, wildcard)
+}
+
+// proveWildcardExpansion checks that the given validated NSEC or NSEC3
+// records prove that the name of a record expanded from a wildcard with the
+// given amount of labels does not exist. See RFC 4035, Section 5.3.4.
+func proveWildcardExpansion(name string, labels int, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) error {
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			return nil
+		}
+	}
+
+	// For NSEC3, the next closer name of the wildcard must be covered.
+	nextCloser := lastLabels(name, labels+1)
+	for _, nsec3 := range nsec3s {
+		if nsec3Covers(nsec3, nextCloser) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: wildcard expansion of %s is not proven", errMissingDenial, name)
+}
+
+// proveClosestEncloser returns the closest existing ancestor of the name that
+// is matched by one of the NSEC3 records and the next closer name below it,
+// which must be covered by another one. See RFC 5155, Section 8.3.
+func proveClosestEncloser(name string, nsec3s []*dns.NSEC3) (closestEncloser, nextCloser string) {
+	labels := dns.CountLabel(name)
+	for i := labels - 1; i >= 0; i-- {
+		candidate := lastLabels(name, i)
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(candidate) {
+				return candidate, lastLabels(name, i+1)
+			}
+		}
+	}
+	return "", ""
+}
+
+// checkNoDataBitmap checks that the type bitmap of a NSEC or NSEC3 record of
+// the existing name holds neither the queried type nor a CNAME.
+func checkNoDataBitmap(name string, qtype uint16, bitmap []uint16) error {
+	for _, t := range bitmap {
+		if t == qtype || t == dns.TypeCNAME {
+			return fmt.Errorf("%w: %s has %s records", errMissingDenial, name, dns.Type(t))
+		}
+	}
+	return nil
+}
+
+// nsecCovers returns whether the name is between the owner and the next name
+// of the NSEC record in canonical order.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// The last NSEC of the zone points back to the apex.
+	return canonicalCompare(owner, name) < 0 && dns.IsSubDomain(next, name)
+}
+
+// nsec3Covers returns whether the hash of the name is between the owner and
+// the next hash of the NSEC3 record. Unlike NSEC3.Cover, names matching the
+// owner are not covered.
+func nsec3Covers(nsec3 *dns.NSEC3, name string) bool {
+	return nsec3.Cover(name) && !nsec3.Match(name)
+}
+
+// canonicalCompare compares the given names in canonical DNS name order.
+// See RFC 4034, Section 6.1.
+func canonicalCompare(a, b string) int {
+	aLabels := dns.SplitDomainName(strings.ToLower(a))
+	bLabels := dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(aLabels) && i <= len(bLabels); i++ {
+		if c := strings.Compare(aLabels[len(aLabels)-i], bLabels[len(bLabels)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(aLabels) - len(bLabels)
+}
+
+// lastLabels returns the name consisting of the last n labels of the name.
+func lastLabels(name string, n int) string {
+	labels := dns.SplitDomainName(name)
+	if n >= len(labels) {
+		return dns.Fqdn(name)
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// wildcardOf returns the wildcard name directly below the given name.
+func wildcardOf(name string) string {
+	if name == "." {
+		return "*."
+	}
+	return "*." + name
+}
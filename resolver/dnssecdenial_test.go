@@ -0,0 +1,105 @@
+package resolver
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCanonicalCompare(t *testing.T) {
+	t.Parallel()
+
+	// Example of RFC 4034, Section 6.1.
+	ordered := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"*.z.example.",
+		"\\200.z.example.",
+	}
+	for i := 1; i < len(ordered); i++ {
+		if canonicalCompare(ordered[i-1], ordered[i]) >= 0 {
+			t.Errorf("%s should be sorted before %s", ordered[i-1], ordered[i])
+		}
+	}
+	if canonicalCompare("Example.", "example.") != 0 {
+		t.Error("names should be compared case insensitively")
+	}
+}
+
+// newTestNSEC3Chain returns a NSEC3 chain of the given zone, which holds the
+// given names with their types.
+func newTestNSEC3Chain(zone string, optOut bool, names map[string][]uint16) []*dns.NSEC3 {
+	hashes := make([]string, 0, len(names))
+	types := make(map[string][]uint16, len(names))
+	for name, bitmap := range names {
+		hash := dns.HashName(name, dns.SHA1, 0, "")
+		hashes = append(hashes, hash)
+		types[hash] = bitmap
+	}
+	sort.Strings(hashes)
+
+	var flags uint8
+	if optOut {
+		flags = 0x01
+	}
+	chain := make([]*dns.NSEC3, 0, len(hashes))
+	for i, hash := range hashes {
+		chain = append(chain, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: hash + "." + zone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			HashLength: 20,
+			TypeBitMap: types[hash],
+		})
+	}
+	return chain
+}
+
+func TestProveDenialWithNSEC3(t *testing.T) {
+	t.Parallel()
+
+	names := map[string][]uint16{
+		"example.com.":     {dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		"www.example.com.": {dns.TypeA, dns.TypeRRSIG},
+	}
+	chain := newTestNSEC3Chain("example.com.", false, names)
+
+	// Check that non-existing names and types are proven.
+	if insecure, err := proveDenialWithNSEC3("nx.example.com.", dns.TypeA, true, chain); err != nil || insecure {
+		t.Errorf("NXDOMAIN should be proven (insecure=%v): %v", insecure, err)
+	}
+	if insecure, err := proveDenialWithNSEC3("www.example.com.", dns.TypeAAAA, false, chain); err != nil || insecure {
+		t.Errorf("NODATA should be proven (insecure=%v): %v", insecure, err)
+	}
+
+	// Check that existing types are not denied.
+	if _, err := proveDenialWithNSEC3("www.example.com.", dns.TypeA, false, chain); !errors.Is(err, errMissingDenial) {
+		t.Errorf("existing type should not be denied, got %v", err)
+	}
+
+	// Check that the closest encloser must be proven.
+	if _, err := proveDenialWithNSEC3("nx.example.com.", dns.TypeA, true, chain[:1]); !errors.Is(err, errMissingDenial) {
+		t.Errorf("missing closest encloser should fail, got %v", err)
+	}
+
+	// Check that wildcards must be denied.
+	names["*.example.com."] = []uint16{dns.TypeA, dns.TypeRRSIG}
+	withWildcard := newTestNSEC3Chain("example.com.", false, names)
+	if _, err := proveDenialWithNSEC3("nx.example.com.", dns.TypeA, true, withWildcard); !errors.Is(err, errMissingDenial) {
+		t.Errorf("existing wildcard should not be denied, got %v", err)
+	}
+	delete(names, "*.example.com.")
+
+	// Check that Opt-Out spans are insecure.
+	optOutChain := newTestNSEC3Chain("example.com.", true, names)
+	if insecure, err := proveDenialWithNSEC3("nx.example.com.", dns.TypeA, true, optOutChain); err != nil || !insecure {
+		t.Errorf("NXDOMAIN in Opt-Out span should be insecure (insecure=%v): %v", insecure, err)
+	}
+}
@@ -0,0 +1,57 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+)
+
+// isDNSSECRecord returns whether rr is a DNSSEC record that is only sent to
+// clients that set the DO bit, unless its type was queried for. See RFC 4035,
+// Section 3.2.1.
+func isDNSSECRecord(rr dns.RR, qType dns.Type) bool {
+	rrType := rr.Header().Rrtype
+	switch rrType {
+	case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+		return rrType != uint16(qType)
+	default:
+		return false
+	}
+}
+
+// removeDNSSECRecords returns the records of the section without DNSSEC
+// records. The section itself is not changed.
+func removeDNSSECRecords(section []dns.RR, qType dns.Type) []dns.RR {
+	kept := make([]dns.RR, 0, len(section))
+	for _, rr := range section {
+		if !isDNSSECRecord(rr, qType) {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
+
+// hasDNSSECRecords returns whether any of the sections holds DNSSEC records.
+func hasDNSSECRecords(qType dns.Type, sections ...[]dns.RR) bool {
+	for _, section := range sections {
+		for _, rr := range section {
+			if isDNSSECRecord(rr, qType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutDNSSECRecords returns a copy of the RRCache without DNSSEC records
+// for clients that did not request them. The validation result is kept. The
+// RRCache itself is returned if there is nothing to remove.
+func (rrCache *RRCache) withoutDNSSECRecords(qType dns.Type) *RRCache {
+	if !hasDNSSECRecords(qType, rrCache.Answer, rrCache.Ns, rrCache.Extra) {
+		return rrCache
+	}
+
+	stripped := rrCache.ShallowCopy()
+	stripped.Answer = removeDNSSECRecords(rrCache.Answer, qType)
+	stripped.Ns = removeDNSSECRecords(rrCache.Ns, qType)
+	stripped.Extra = removeDNSSECRecords(rrCache.Extra, qType)
+	return stripped
+}
@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSignedConn struct {
+	testCountingConn
+}
+
+func (tsc *testSignedConn) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	rrCache, err := tsc.testCountingConn.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := dns.NewRR(q.FQDN + " 3600 IN RRSIG A 13 3 3600 20300101000000 20200101000000 12345 " + q.FQDN + " AAAA")
+	if err != nil {
+		return nil, err
+	}
+	nsec, err := dns.NewRR(q.FQDN + " 3600 IN NSEC next." + q.FQDN + " A RRSIG NSEC")
+	if err != nil {
+		return nil, err
+	}
+	rrCache.Answer = append(rrCache.Answer, sig)
+	rrCache.Ns = []dns.RR{nsec}
+	rrCache.AuthenticatedData = true
+	return rrCache, nil
+}
+
+func countDNSSECRecords(rrCache *RRCache) (count int) {
+	for _, section := range [][]dns.RR{rrCache.Answer, rrCache.Ns, rrCache.Extra} {
+		for _, rr := range section {
+			switch rr.Header().Rrtype {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestStripDNSSECRecords(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	_, testResolver, restore := useTestCountingResolver(0)
+	defer restore()
+	conn := &testSignedConn{}
+	conn.resolver = testResolver
+	conn.init()
+	testResolver.Conn = conn
+
+	fqdn := "strip-dnssec." + InternalSpecialUseDomain
+	_ = ResetCachedRecord(fqdn, "A")
+
+	// Check that a client without the DO bit gets no DNSSEC records, but the
+	// validation result.
+	rrCache, err := Resolve(silencingTraceCtx, &Query{
+		FQDN:               fqdn,
+		QType:              dns.Type(dns.TypeA),
+		StripDNSSECRecords: true,
+	})
+	require.NoError(t, err)
+	assert.Len(t, rrCache.Answer, 1)
+	assert.Empty(t, rrCache.Ns)
+	assert.Zero(t, countDNSSECRecords(rrCache))
+	assert.True(t, rrCache.AuthenticatedData)
+
+	// Check that the full answer was cached.
+	cached, err := GetRRCache(fqdn, dns.Type(dns.TypeA))
+	require.NoError(t, err)
+	assert.Equal(t, 2, countDNSSECRecords(cached))
+
+	// Check that answers from the cache are stripped as well, without changing
+	// the cache.
+	rrCache, err = Resolve(silencingTraceCtx, &Query{
+		FQDN:               fqdn,
+		QType:              dns.Type(dns.TypeA),
+		StripDNSSECRecords: true,
+	})
+	require.NoError(t, err)
+	assert.True(t, rrCache.ServedFromCache)
+	assert.Zero(t, countDNSSECRecords(rrCache))
+	rrCache, err = Resolve(silencingTraceCtx, &Query{
+		FQDN:  fqdn,
+		QType: dns.Type(dns.TypeA),
+	})
+	require.NoError(t, err)
+	assert.True(t, rrCache.ServedFromCache)
+	assert.Equal(t, 2, countDNSSECRecords(rrCache))
+	assert.Equal(t, int32(1), conn.queries.Load())
+}
+
+func TestWithoutDNSSECRecords(t *testing.T) {
+	t.Parallel()
+
+	sig, err := dns.NewRR("example.com. 3600 IN RRSIG A 13 2 3600 20300101000000 20200101000000 12345 example.com. AAAA")
+	require.NoError(t, err)
+	a, err := dns.NewRR("example.com. 3600 IN A 192.0.2.1")
+	require.NoError(t, err)
+	rrCache := &RRCache{
+		Domain:   "example.com.",
+		Question: dns.Type(dns.TypeA),
+		Answer:   []dns.RR{a, sig},
+	}
+
+	// Check that the original is not changed.
+	stripped := rrCache.withoutDNSSECRecords(dns.Type(dns.TypeA))
+	assert.Equal(t, []dns.RR{a}, stripped.Answer)
+	assert.Equal(t, []dns.RR{a, sig}, rrCache.Answer)
+
+	// Check that queried DNSSEC records are kept.
+	assert.Same(t, rrCache, rrCache.withoutDNSSECRecords(dns.Type(dns.TypeRRSIG)))
+}
@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/log"
+)
+
+// queryDrainTimeout defines how long in-flight upstream queries may take to
+// finish when the resolver is shutting down.
+var queryDrainTimeout = 3 * time.Second
+
+var (
+	activeQueries     = new(sync.WaitGroup)
+	activeQueriesLock sync.Mutex
+	drainingQueries   bool
+
+	drainExpired                  = abool.New()
+	abortQueriesCtx, abortQueries = context.WithCancel(context.Background())
+)
+
+// trackQuery registers an in-flight upstream query. The returned context is
+// canceled if the query is still running when the drain window expires.
+// It returns false if the resolver is shutting down and does not accept new
+// queries anymore.
+func trackQuery(ctx context.Context) (queryCtx context.Context, done func(), ok bool) {
+	activeQueriesLock.Lock()
+	defer activeQueriesLock.Unlock()
+
+	if drainingQueries || module.IsStopping() {
+		return nil, nil, false
+	}
+	queries := activeQueries
+	queries.Add(1)
+
+	queryCtx, cancel := context.WithCancel(ctx)
+	stopAbort := context.AfterFunc(abortQueriesCtx, cancel)
+	return queryCtx, func() {
+		stopAbort()
+		cancel()
+		queries.Done()
+	}, true
+}
+
+// drainQueries stops accepting new upstream queries and waits for the
+// in-flight ones, including their deduplicated followers, to finish. Queries
+// still running after queryDrainTimeout are aborted with ErrShuttingDown.
+func drainQueries() {
+	activeQueriesLock.Lock()
+	drainingQueries = true
+	queries := activeQueries
+	activeQueriesLock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		queries.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Debug("resolver: all in-flight queries finished")
+	case <-time.After(queryDrainTimeout):
+		log.Warning("resolver: aborting in-flight queries, as they did not finish in time")
+		activeQueriesLock.Lock()
+		drainExpired.Set()
+		abortQueries()
+		activeQueriesLock.Unlock()
+	}
+}
+
+// resetDrain accepts upstream queries again, after the resolver was stopped.
+func resetDrain() {
+	activeQueriesLock.Lock()
+	defer activeQueriesLock.Unlock()
+
+	// Aborted queries might still be finishing, so do not reuse the waitgroup.
+	activeQueries = new(sync.WaitGroup)
+	drainingQueries = false
+	drainExpired.UnSet()
+	abortQueriesCtx, abortQueries = context.WithCancel(context.Background())
+}
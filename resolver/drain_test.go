@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func isDrainingQueries() bool {
+	activeQueriesLock.Lock()
+	defer activeQueriesLock.Unlock()
+	return drainingQueries
+}
+
+func TestDrainQueries(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, _, restore := useTestCountingResolver(300 * time.Millisecond)
+	defer restore()
+	defer resetDrain()
+
+	previousTimeout := queryDrainTimeout
+	queryDrainTimeout = 5 * time.Second
+	defer func() {
+		queryDrainTimeout = previousTimeout
+	}()
+
+	// Start a slow query.
+	fqdn := "drain." + InternalSpecialUseDomain
+	type result struct {
+		rrCache *RRCache
+		err     error
+	}
+	leader := make(chan result, 1)
+	go func() {
+		rrCache, err := Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+		leader <- result{rrCache, err}
+	}()
+	assert.Eventually(t, func() bool { return conn.queries.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Shut down while the query is in flight.
+	drained := make(chan time.Duration, 1)
+	go func() {
+		started := time.Now()
+		drainQueries()
+		drained <- time.Since(started)
+	}()
+	assert.Eventually(t, isDrainingQueries, time.Second, 5*time.Millisecond)
+
+	// Followers of the in-flight query still get the answer.
+	follower := make(chan result, 1)
+	go func() {
+		rrCache, err := Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+		follower <- result{rrCache, err}
+	}()
+
+	// New queries are not accepted anymore.
+	_, err := Resolve(context.Background(), &Query{FQDN: "drain-new." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeA)})
+	assert.ErrorIs(t, err, ErrShuttingDown)
+
+	// The in-flight query finishes within the drain window.
+	res := <-leader
+	if assert.NoError(t, res.err) {
+		assert.NotEmpty(t, res.rrCache.Answer)
+	}
+	res = <-follower
+	if assert.NoError(t, res.err) {
+		assert.NotEmpty(t, res.rrCache.Answer)
+	}
+	assert.Less(t, <-drained, queryDrainTimeout)
+	assert.False(t, drainExpired.IsSet())
+	assert.Equal(t, int32(1), conn.queries.Load())
+}
+
+func TestDrainQueriesTimeout(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, _, restore := useTestCountingResolver(10 * time.Second)
+	defer restore()
+	defer resetDrain()
+
+	previousTimeout := queryDrainTimeout
+	queryDrainTimeout = 100 * time.Millisecond
+	defer func() {
+		queryDrainTimeout = previousTimeout
+	}()
+
+	// Start a query that does not finish within the drain window.
+	errs := make(chan error, 1)
+	go func() {
+		_, err := Resolve(context.Background(), &Query{FQDN: "drain-slow." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeA)})
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool { return conn.queries.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	// The query is aborted after the drain window.
+	started := time.Now()
+	drainQueries()
+	assert.True(t, drainExpired.IsSet())
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrShuttingDown)
+	case <-time.After(time.Second):
+		t.Fatal("query was not aborted")
+	}
+	assert.Less(t, time.Since(started), time.Second)
+
+	// Queries are accepted again after a restart.
+	resetDrain()
+	_, done, ok := trackQuery(context.Background())
+	if assert.True(t, ok) {
+		done()
+	}
+}
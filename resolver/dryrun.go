@@ -0,0 +1,199 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portmaster/netenv"
+)
+
+// ResolvePlan describes what would happen when resolving a query, without
+// actually querying any resolver or touching the cache.
+type ResolvePlan struct {
+	FQDN  string
+	QType string
+
+	// Blocked signifies that the query would be blocked. BlockReason holds why.
+	Blocked     bool
+	BlockReason string
+	// MinimalANYResponse signifies that the query would be answered with a
+	// minimal response as defined in RFC 8482.
+	MinimalANYResponse bool
+	// SuppressedAAAA signifies that the AAAA query would be answered with an
+	// empty response, because IPv6 addresses are not wanted.
+	SuppressedAAAA bool
+	// Rewritten signifies that the query would be answered by a rewrite rule.
+	Rewritten bool
+	// FlattenCNAME signifies that each hop of a CNAME chain would be resolved
+	// separately.
+	FlattenCNAME bool
+
+	// Cache holds what the cache could provide for the query. It holds one of
+	// the TraceCache* values.
+	Cache string
+	// CacheResolver holds the ID of the resolver that resolved the cached entry.
+	CacheResolver string
+
+	// Source holds the source of the resolvers that would be used.
+	Source string
+	// ForwardingRule holds the domain of the conditional forwarding rule that
+	// applies to the query, if any.
+	ForwardingRule string
+	// TryAll signifies that all resolvers would be asked until one has an answer.
+	TryAll bool
+	// Resolvers holds the resolvers that would be asked, in order.
+	Resolvers []ResolverPlan
+	// Offline signifies that the query would fail, because the device is offline.
+	Offline bool
+	// Throttled signifies that the query would fail, because an identical query
+	// failed recently.
+	Throttled bool
+}
+
+// ResolverPlan describes a resolver that would be asked.
+type ResolverPlan struct {
+	ID   string
+	Name string
+	// Skipped signifies that the resolver would only be asked after all other
+	// resolvers failed, because it is currently failing.
+	Skipped bool
+}
+
+// PlanResolve runs the compliance checks, resolver scoping and routing
+// decisions of the given query and returns what would happen when resolving
+// it. No query is sent and the cache is not changed.
+func PlanResolve(ctx context.Context, q *Query) (*ResolvePlan, error) {
+	// sanity check
+	if q == nil || !q.check() {
+		return nil, ErrInvalid
+	}
+	q.DryRun = true
+
+	plan := &ResolvePlan{
+		FQDN:  q.FQDN,
+		QType: q.QType.String(),
+	}
+
+	// check query compliance
+	if err := q.checkCompliance(); err != nil {
+		if errors.Is(err, errMinimalANYResponse) {
+			plan.MinimalANYResponse = true
+			return plan, nil
+		}
+		plan.Blocked = true
+		plan.BlockReason = err.Error()
+		return plan, nil
+	}
+
+	// check if AAAA queries are suppressed
+	if q.QType == dns.Type(dns.TypeAAAA) && suppressAAAA(ipVersionPreference(), globalIPv6Available()) {
+		plan.SuppressedAAAA = true
+		plan.Cache = TraceCacheDisabled
+		return plan, nil
+	}
+
+	// check for rewrite rules
+	if getRewriteRule(q.FQDN) != nil {
+		plan.Rewritten = true
+		plan.Cache = TraceCacheDisabled
+		plan.Source = ServerSourceRewrite
+		return plan, nil
+	}
+
+	// check for CNAME flattening
+	if q.FlattenCNAME && canFlattenCNAMEs(q.QType) {
+		plan.FlattenCNAME = true
+	}
+
+	// check the cache
+	plan.Cache, plan.CacheResolver = peekCache(ctx, q)
+	if q.CacheOnly {
+		// cache only queries are never sent upstream
+		return plan, nil
+	}
+
+	// get resolvers
+	resolvers, primarySource, tryAll := GetResolversInScope(ctx, q)
+	plan.Source = primarySource
+	plan.TryAll = tryAll
+	resolversLock.RLock()
+	if rule := getForwardingRule(q.dotPrefixedFQDN); rule != nil {
+		plan.ForwardingRule = rule.Suffix
+	}
+	resolversLock.RUnlock()
+	if len(resolvers) == 0 {
+		plan.Blocked = true
+		plan.BlockReason = ErrNoCompliance.Error()
+		if q.ForceResolverID != "" {
+			if _, err := getForcedResolverWithLocking(ctx, q); err != nil {
+				plan.BlockReason = err.Error()
+			}
+		}
+		return plan, nil
+	}
+	for _, resolver := range resolvers {
+		plan.Resolvers = append(plan.Resolvers, ResolverPlan{
+			ID:      resolver.Info.ID(),
+			Name:    resolver.Info.DescriptiveName(),
+			Skipped: q.ForceResolverID == "" && resolver.Conn.IsFailing(),
+		})
+	}
+
+	// check if we are online
+	if netenv.GetOnlineStatus() == netenv.StatusOffline &&
+		primarySource != ServerSourceEnv && primarySource != ServerSourceHosts &&
+		q.FQDN != netenv.DNSTestDomain && !netenv.IsConnectivityDomain(q.FQDN) {
+		plan.Offline = true
+	}
+
+	// check if the query failed recently
+	plan.Throttled = getThrottledQueryError(q) != nil
+
+	return plan, nil
+}
+
+// peekCache returns what the cache could provide for the query, like
+// checkCache, but without any side effects.
+func peekCache(ctx context.Context, q *Query) (decision, resolverID string) {
+	if q.NoCaching ||
+		netenv.IsConnectivityDomain(q.FQDN) ||
+		hostsConn.has(q.FQDN) {
+		return TraceCacheDisabled, ""
+	}
+
+	// Get data from cache.
+	rrCache, err := q.getCachedRRCache()
+	if err != nil {
+		return TraceCacheMiss, ""
+	}
+
+	// Check if the cached entry may be used.
+	resolver := getActiveResolverByIDWithLocking(rrCache.Resolver.ID())
+	switch {
+	case resolver == nil:
+		return TraceCacheMiss, ""
+	case resolver.checkCompliance(ctx, q) != nil:
+		return TraceCacheMiss, ""
+	case q.shouldValidateDNSSEC() && !rrCache.AuthenticatedData && !rrCache.Insecure:
+		return TraceCacheMiss, ""
+	}
+
+	// Check the expiry.
+	switch {
+	case rrCache.Expired() && rrCache.RCode != dns.RcodeSuccess:
+		return TraceCacheMiss, ""
+	case rrCache.Expired():
+		if window := serveStaleWindow(); window > 0 &&
+			time.Now().Unix() < rrCache.Expires+window {
+			return TraceCacheStale, rrCache.Resolver.ID()
+		}
+		return TraceCacheExpired, rrCache.Resolver.ID()
+	case rrCache.ExpiresSoon(getTTLLimits(q.SecurityLevel).refresh):
+		return TraceCacheExpiring, rrCache.Resolver.ID()
+	default:
+		return TraceCacheHit, rrCache.Resolver.ID()
+	}
+}
@@ -0,0 +1,46 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/status"
+)
+
+func TestPlanResolve(t *testing.T) {
+	t.Parallel()
+
+	// Check that a blocked query is reported.
+	plan, err := PlanResolve(context.Background(), &Query{
+		FQDN:          "example.com.",
+		QType:         dns.Type(dns.TypeAXFR),
+		SecurityLevel: status.SecurityLevelHigh,
+	})
+	if assert.NoError(t, err) {
+		assert.True(t, plan.Blocked)
+		assert.Contains(t, plan.BlockReason, "query type not permitted")
+		assert.Empty(t, plan.Resolvers)
+	}
+
+	// Check that a regular query is planned without touching the cache.
+	q := &Query{
+		FQDN:  "dryrun.example.com.",
+		QType: dns.Type(dns.TypeA),
+	}
+	plan, err = PlanResolve(context.Background(), q)
+	if assert.NoError(t, err) {
+		assert.False(t, plan.Blocked)
+		assert.Equal(t, TraceCacheMiss, plan.Cache)
+		assert.Equal(t, "dryrun.example.com.", plan.FQDN)
+		assert.Equal(t, "A", plan.QType)
+	}
+	_, err = getRRCache(q.FQDN, q.QType, q.cacheKeySuffix())
+	assert.Error(t, err, "dry run must not write to the cache")
+
+	// Check that dry run queries are never resolved.
+	_, err = Resolve(context.Background(), q)
+	assert.ErrorIs(t, err, ErrDryRun)
+}
@@ -0,0 +1,64 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DualStackResult holds the result of one address family of a joint A and
+// AAAA resolution.
+type DualStackResult struct {
+	QType   dns.Type
+	RRCache *RRCache
+	Err     error
+}
+
+// ResolveBoth resolves the A and AAAA records of the given domain
+// concurrently. See ResolveBothWithQuery for details.
+func ResolveBoth(ctx context.Context, fqdn string, securityLevel uint8) <-chan *DualStackResult {
+	return ResolveBothWithQuery(ctx, &Query{
+		FQDN:          fqdn,
+		SecurityLevel: securityLevel,
+	})
+}
+
+// ResolveBothWithQuery resolves the A and AAAA records of the domain of the
+// given query concurrently. The query is used as a template, its QType is
+// ignored. Both queries go through Resolve separately, so they are checked,
+// deduplicated and cached individually. The returned channel receives the
+// result of each address family as soon as it is available, so that the
+// faster one can be used first. It is closed after both results were sent.
+// An error in one address family does not affect the other.
+func ResolveBothWithQuery(ctx context.Context, template *Query) <-chan *DualStackResult {
+	results := make(chan *DualStackResult, 2)
+	var wg sync.WaitGroup
+
+	for _, qType := range []dns.Type{dns.Type(dns.TypeA), dns.Type(dns.TypeAAAA)} {
+		// Create a separate query, as Resolve modifies it.
+		q := template.subQuery(template.FQDN, qType)
+		q.FlattenCNAME = template.FlattenCNAME
+		q.StripDNSSECRecords = template.StripDNSSECRecords
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rrCache, err := Resolve(ctx, q)
+			results <- &DualStackResult{
+				QType:   q.QType,
+				RRCache: rrCache,
+				Err:     err,
+			}
+		}()
+	}
+
+	// Close the results channel when both queries finished.
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
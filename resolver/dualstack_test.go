@@ -0,0 +1,24 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveBoth(t *testing.T) {
+	t.Parallel()
+
+	// Resolve an invalid query in order to not depend on the network.
+	results := ResolveBoth(context.Background(), "", 0)
+
+	seen := make(map[dns.Type]bool)
+	for result := range results {
+		assert.ErrorIs(t, result.Err, ErrInvalid)
+		seen[result.QType] = true
+	}
+	assert.True(t, seen[dns.Type(dns.TypeA)], "missing A result")
+	assert.True(t, seen[dns.Type(dns.TypeAAAA)], "missing AAAA result")
+}
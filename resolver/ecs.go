@@ -0,0 +1,201 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// EDNS Client Subnet address families, see RFC 7871.
+const (
+	ecsFamilyIPv4 = 1
+	ecsFamilyIPv6 = 2
+
+	// maxECSScopeEntries limits the number of names that scope prefix lengths
+	// are remembered for.
+	maxECSScopeEntries = 10000
+)
+
+var (
+	// ecsScopes holds the scope prefix lengths that answers for a name were
+	// cached with, so that lookups do not need to check every prefix length.
+	ecsScopes     = make(map[string][]int)
+	ecsScopesLock sync.Mutex
+)
+
+// ecsNetwork returns the client subnet to send upstream, truncated to the
+// configured maximum prefix length for privacy.
+func (q *Query) ecsNetwork() *net.IPNet {
+	if q.ECSNetwork == nil {
+		return nil
+	}
+
+	ip := q.ECSNetwork.IP
+	ones, bits := q.ECSNetwork.Mask.Size()
+	maxOnes := int(ecsIPv6PrefixLength())
+	if ip4 := ip.To4(); ip4 != nil {
+		// IPv4 addresses may be held in 16 bytes with a 128 bit mask.
+		if bits == 8*net.IPv6len {
+			ones -= 8 * (net.IPv6len - net.IPv4len)
+			if ones < 0 {
+				ones = 0
+			}
+		}
+		ip = ip4
+		bits = 8 * net.IPv4len
+		maxOnes = int(ecsIPv4PrefixLength())
+	}
+	if ones > maxOnes {
+		ones = maxOnes
+	}
+
+	return truncateNetwork(ip, ones, bits)
+}
+
+// truncateNetwork returns the network of the given IP with the given prefix
+// length.
+func truncateNetwork(ip net.IP, ones, bits int) *net.IPNet {
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+}
+
+// ecsScopeNetwork returns the network that an answer with the given scope
+// prefix length applies to. The scope is limited to the prefix length of the
+// client subnet, see RFC 7871, Section 7.3.1. Nil is returned if the answer
+// applies to all clients.
+func ecsScopeNetwork(network *net.IPNet, scope int) *net.IPNet {
+	ones, bits := network.Mask.Size()
+	if scope > ones {
+		scope = ones
+	}
+	if scope <= 0 {
+		return nil
+	}
+	return truncateNetwork(network.IP, scope, bits)
+}
+
+// addECSOption adds an EDNS Client Subnet option with the given network to
+// the message.
+func addECSOption(msg *dns.Msg, network *net.IPNet) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(getEDNSUDPSize(), false)
+		opt = msg.IsEdns0()
+	}
+
+	ones, _ := network.Mask.Size()
+	ecs := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecsFamilyIPv6,
+		SourceNetmask: uint8(ones),
+		Address:       network.IP,
+	}
+	if ip4 := network.IP.To4(); ip4 != nil {
+		ecs.Family = ecsFamilyIPv4
+		ecs.Address = ip4
+	}
+	opt.Option = append(opt.Option, ecs)
+}
+
+// applyECSInfo records the returned scope and the network it applies to on
+// the RRCache, so that the answer is cached for all clients within the scope.
+// Answers without a scope apply to all clients, see RFC 7871, Section 7.2.2.
+func (q *Query) applyECSInfo(rrCache *RRCache) {
+	network := q.ecsNetwork()
+	if network == nil {
+		return
+	}
+
+	rrCache.ECSScope = 0
+	rrCache.ECSNetwork = ""
+findScope:
+	for _, rr := range rrCache.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		for _, option := range opt.Option {
+			if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+				rrCache.ECSScope = ecs.SourceScope
+				break findScope
+			}
+		}
+	}
+
+	scope := 0
+	if scopeNetwork := ecsScopeNetwork(network, int(rrCache.ECSScope)); scopeNetwork != nil {
+		rrCache.ECSNetwork = scopeNetwork.String()
+		scope, _ = scopeNetwork.Mask.Size()
+	}
+	q.addECSScope(scope)
+}
+
+// ecsScopesKey returns the key of the query in ecsScopes.
+func (q *Query) ecsScopesKey() string {
+	return NormalizeFQDN(q.FQDN) + q.QType.String() + makeCacheKeySuffix("", q.ClientScope)
+}
+
+// addECSScope remembers that an answer for the query was cached with the
+// given scope prefix length.
+func (q *Query) addECSScope(scope int) {
+	key := q.ecsScopesKey()
+
+	ecsScopesLock.Lock()
+	defer ecsScopesLock.Unlock()
+
+	scopes := ecsScopes[key]
+	for _, known := range scopes {
+		if known == scope {
+			return
+		}
+	}
+	if len(ecsScopes) >= maxECSScopeEntries {
+		ecsScopes = make(map[string][]int)
+	}
+	ecsScopes[key] = append(scopes, scope)
+}
+
+// ecsCacheKeySuffixes returns the cache key suffixes that answers for the
+// query may be cached with, most specific first. Next to the scopes that
+// answers were previously cached with, the full client subnet and the scope
+// of answers for all clients are always checked.
+func (q *Query) ecsCacheKeySuffixes(network *net.IPNet) []string {
+	ones, _ := network.Mask.Size()
+	checkScopes := make([]bool, ones+1)
+	checkScopes[0] = true
+	checkScopes[ones] = true
+
+	ecsScopesLock.Lock()
+	for _, scope := range ecsScopes[q.ecsScopesKey()] {
+		if scope <= ones {
+			checkScopes[scope] = true
+		}
+	}
+	ecsScopesLock.Unlock()
+
+	suffixes := make([]string, 0, 2)
+	for scope := ones; scope >= 0; scope-- {
+		if !checkScopes[scope] {
+			continue
+		}
+		var ecsNetwork string
+		if scopeNetwork := ecsScopeNetwork(network, scope); scopeNetwork != nil {
+			ecsNetwork = scopeNetwork.String()
+		}
+		suffixes = append(suffixes, makeCacheKeySuffix(ecsNetwork, q.ClientScope))
+	}
+	return suffixes
+}
+
+// ecsKeySuffix returns the suffix to add to cache keys for the given client
+// subnet, so that answers for different subnets do not collide.
+func ecsKeySuffix(ecsNetwork string) string {
+	if ecsNetwork == "" {
+		return ""
+	}
+	return "@" + ecsNetwork
+}
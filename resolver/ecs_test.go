@@ -0,0 +1,135 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+)
+
+func TestECSOption(t *testing.T) { //nolint:paralleltest // Changes global config.
+	_, ipv4Net, _ := net.ParseCIDR("192.0.2.123/32")
+	_, ipv6Net, _ := net.ParseCIDR("2001:db8:abcd:ef12::1/128")
+
+	// Check privacy truncation with default settings.
+	q4 := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA), ECSNetwork: ipv4Net}
+	q6 := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA), ECSNetwork: ipv6Net}
+	assert.Equal(t, "192.0.2.0/24", q4.ecsNetwork().String())
+	assert.Equal(t, "2001:db8:abcd:ef00::/56", q6.ecsNetwork().String())
+
+	// Check that the option is added to the request.
+	opt := q4.newDNSRequest().IsEdns0()
+	if assert.NotNil(t, opt) && assert.Len(t, opt.Option, 1) {
+		ecs, ok := opt.Option[0].(*dns.EDNS0_SUBNET)
+		if assert.True(t, ok) {
+			assert.Equal(t, uint16(ecsFamilyIPv4), ecs.Family)
+			assert.Equal(t, uint8(24), ecs.SourceNetmask)
+			assert.True(t, ecs.Address.Equal(net.ParseIP("192.0.2.0")))
+		}
+	}
+
+	// Check that queries for different subnets do not share cache keys.
+	plain := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA)}
+	assert.Equal(t, "example.com.A", plain.ID())
+	assert.Equal(t, "example.com.A@192.0.2.0/24", q4.ID())
+	assert.NotEqual(t, q4.ID(), q6.ID())
+	assert.Nil(t, plain.newDNSRequest().IsEdns0())
+
+	// Check that the returned scope is recorded.
+	rrCache := &RRCache{
+		Extra: []dns.RR{&dns.OPT{
+			Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT},
+			Option: []dns.EDNS0{&dns.EDNS0_SUBNET{
+				Code:        dns.EDNS0SUBNET,
+				Family:      ecsFamilyIPv4,
+				SourceScope: 20,
+			}},
+		}},
+	}
+	q4.applyECSInfo(rrCache)
+	assert.Equal(t, "192.0.0.0/20", rrCache.ECSNetwork)
+	assert.Equal(t, uint8(20), rrCache.ECSScope)
+
+	// Check that the prefix length can be overridden.
+	err := config.SetConfigOption(CfgOptionECSIPv4PrefixLengthKey, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionECSIPv4PrefixLengthKey, 24)
+	}()
+	assert.Equal(t, "192.0.0.0/16", q4.ecsNetwork().String())
+}
+
+func TestECSNetworkIPv4In16Bytes(t *testing.T) {
+	t.Parallel()
+
+	for _, network := range []*net.IPNet{
+		{IP: net.ParseIP("192.0.2.123"), Mask: net.CIDRMask(32, 32)},
+		{IP: net.ParseIP("192.0.2.123"), Mask: net.CIDRMask(128, 128)},
+	} {
+		q := &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA), ECSNetwork: network}
+		assert.Equal(t, "192.0.2.0/24", q.ecsNetwork().String())
+
+		ecs := q.newDNSRequest().IsEdns0().Option[0].(*dns.EDNS0_SUBNET) //nolint:forcetypeassert
+		assert.Equal(t, uint16(ecsFamilyIPv4), ecs.Family)
+		assert.Equal(t, uint8(24), ecs.SourceNetmask)
+	}
+}
+
+func TestECSCacheScope(t *testing.T) {
+	t.Parallel()
+
+	newQuery := func(domain, clientIP string) *Query {
+		return &Query{
+			FQDN:       domain,
+			QType:      dns.Type(dns.TypeA),
+			ECSNetwork: &net.IPNet{IP: net.ParseIP(clientIP), Mask: net.CIDRMask(32, 32)},
+		}
+	}
+	saveAnswer := func(q *Query, hasScope bool, scope uint8) {
+		rrCache := newTestPackingRRCache(t, q.FQDN)
+		if hasScope {
+			rrCache.Extra = append(rrCache.Extra, &dns.OPT{
+				Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT},
+				Option: []dns.EDNS0{&dns.EDNS0_SUBNET{
+					Code:        dns.EDNS0SUBNET,
+					Family:      ecsFamilyIPv4,
+					SourceScope: scope,
+				}},
+			})
+		}
+		q.applyCacheScope(rrCache)
+		require.NoError(t, rrCache.Save())
+	}
+
+	// Check that answers are reused by clients within the returned scope.
+	saveAnswer(newQuery("scope.ecs.example.com.", "198.51.100.1"), true, 16)
+	cached, err := newQuery("scope.ecs.example.com.", "198.51.200.1").getCachedRRCache()
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.0.0/16", cached.ECSNetwork)
+	_, err = newQuery("scope.ecs.example.com.", "198.52.100.1").getCachedRRCache()
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Check that scopes are limited to the client subnet.
+	saveAnswer(newQuery("long.ecs.example.com.", "198.51.100.1"), true, 32)
+	cached, err = newQuery("long.ecs.example.com.", "198.51.100.2").getCachedRRCache()
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.0/24", cached.ECSNetwork)
+	_, err = newQuery("long.ecs.example.com.", "198.51.101.1").getCachedRRCache()
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Check that answers without a scope are reused by all clients.
+	saveAnswer(newQuery("global.ecs.example.com.", "198.51.100.1"), false, 0)
+	cached, err = newQuery("global.ecs.example.com.", "203.0.113.1").getCachedRRCache()
+	require.NoError(t, err)
+	assert.Empty(t, cached.ECSNetwork)
+	cached, err = (&Query{FQDN: "global.ecs.example.com.", QType: dns.Type(dns.TypeA)}).getCachedRRCache()
+	require.NoError(t, err)
+	assert.Empty(t, cached.ECSNetwork)
+}
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// getExtendedError returns the extended DNS error (RFC 8914) of the reply, if
+// it has one.
+func getExtendedError(reply *dns.Msg) *dns.EDNS0_EDE {
+	opt := reply.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, option := range opt.Option {
+		if ede, ok := option.(*dns.EDNS0_EDE); ok {
+			return ede
+		}
+	}
+	return nil
+}
+
+// edeSignalsFiltering returns whether the extended DNS error states that the
+// query was filtered by the resolver.
+func edeSignalsFiltering(ede *dns.EDNS0_EDE) bool {
+	if ede == nil {
+		return false
+	}
+
+	switch ede.InfoCode {
+	case dns.ExtendedErrorCodeBlocked,
+		dns.ExtendedErrorCodeCensored,
+		dns.ExtendedErrorCodeFiltered,
+		dns.ExtendedErrorCodeForgedAnswer:
+		return true
+	default:
+		return false
+	}
+}
+
+// describeExtendedError returns a human readable description of the extended
+// DNS error.
+func describeExtendedError(ede *dns.EDNS0_EDE) string {
+	name, ok := dns.ExtendedErrorCodeToString[ede.InfoCode]
+	if !ok {
+		name = fmt.Sprintf("EDE %d", ede.InfoCode)
+	}
+
+	if ede.ExtraText != "" {
+		return name + " (" + ede.ExtraText + ")"
+	}
+	return name
+}
+
+// checkBlockedUpstream returns a BlockedUpstreamError if the answer was
+// blocked by the upstream resolver, either by signalling filtering via an
+// extended DNS error or as detected by the configured block detection.
+func (resolver *Resolver) checkBlockedUpstream(answer *dns.Msg) error {
+	if resolver.UpstreamBlockDetection == BlockDetectionDisabled {
+		return nil
+	}
+
+	ede := getExtendedError(answer)
+	if edeSignalsFiltering(ede) || resolver.IsBlockedUpstream(answer) {
+		return &BlockedUpstreamError{
+			ResolverName:  resolver.Info.DescriptiveName(),
+			ExtendedError: ede,
+		}
+	}
+	return nil
+}
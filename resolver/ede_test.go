@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEDEReply(t *testing.T, infoCode uint16, extraText string) *dns.Msg {
+	t.Helper()
+
+	reply := new(dns.Msg)
+	reply.SetQuestion("ede.example.com.", dns.TypeA)
+	reply.Response = true
+	reply.SetEdns0(1232, false)
+	opt := reply.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+		InfoCode:  infoCode,
+		ExtraText: extraText,
+	})
+
+	// Send the reply through the wire format to parse the option like a
+	// real upstream response.
+	packed, err := reply.Pack()
+	require.NoError(t, err)
+	parsed := new(dns.Msg)
+	require.NoError(t, parsed.Unpack(packed))
+	return parsed
+}
+
+func TestGetExtendedError(t *testing.T) {
+	t.Parallel()
+
+	// No EDNS0 at all.
+	plain := new(dns.Msg)
+	plain.SetQuestion("ede.example.com.", dns.TypeA)
+	assert.Nil(t, getExtendedError(plain))
+
+	// EDNS0 without EDE.
+	plain.SetEdns0(1232, false)
+	assert.Nil(t, getExtendedError(plain))
+
+	// EDE with extra text.
+	ede := getExtendedError(newTestEDEReply(t, dns.ExtendedErrorCodeFiltered, "parental control"))
+	require.NotNil(t, ede)
+	assert.Equal(t, dns.ExtendedErrorCodeFiltered, ede.InfoCode)
+	assert.Equal(t, "parental control", ede.ExtraText)
+	assert.Equal(t, "Filtered (parental control)", describeExtendedError(ede))
+
+	// Unknown code without extra text.
+	ede = getExtendedError(newTestEDEReply(t, 4000, ""))
+	require.NotNil(t, ede)
+	assert.Equal(t, "EDE 4000", describeExtendedError(ede))
+}
+
+func TestExtendedErrorBlocking(t *testing.T) {
+	t.Parallel()
+
+	resolver := &Resolver{
+		Info: &ResolverInfo{
+			Type: ServerTypeDNS,
+			Name: "EDE Test",
+			IP:   []byte{192, 0, 2, 1},
+			Port: 53,
+		},
+		UpstreamBlockDetection: BlockDetectionZeroIP,
+	}
+
+	// Filtering codes are reported as blocked.
+	for _, code := range []uint16{
+		dns.ExtendedErrorCodeBlocked,
+		dns.ExtendedErrorCodeCensored,
+		dns.ExtendedErrorCodeFiltered,
+		dns.ExtendedErrorCodeForgedAnswer,
+	} {
+		err := resolver.checkBlockedUpstream(newTestEDEReply(t, code, "policy"))
+		assert.ErrorIs(t, err, ErrBlocked, "code %d", code)
+
+		var blockedErr *BlockedUpstreamError
+		require.True(t, errors.As(err, &blockedErr), "code %d", code)
+		require.NotNil(t, blockedErr.ExtendedError)
+		assert.Equal(t, code, blockedErr.ExtendedError.InfoCode)
+		assert.Equal(t, "policy", blockedErr.ExtendedError.ExtraText)
+		assert.Contains(t, err.Error(), "(policy)")
+	}
+
+	// Other codes are not.
+	for _, code := range []uint16{
+		dns.ExtendedErrorCodeStaleAnswer,
+		dns.ExtendedErrorCodeDNSBogus,
+		dns.ExtendedErrorCodeNotReady,
+	} {
+		assert.NoError(t, resolver.checkBlockedUpstream(newTestEDEReply(t, code, "")), "code %d", code)
+	}
+
+	// Block detection can be disabled.
+	disabled := *resolver
+	disabled.UpstreamBlockDetection = BlockDetectionDisabled
+	assert.NoError(t, disabled.checkBlockedUpstream(newTestEDEReply(t, dns.ExtendedErrorCodeBlocked, "")))
+}
@@ -0,0 +1,35 @@
+package resolver
+
+import (
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultEDNSUDPSize = 1232 // DNS Flag Day 2020 recommendation
+	minEDNSUDPSize     = dns.MinMsgSize
+	maxEDNSUDPSize     = dns.DefaultMsgSize
+)
+
+// getEDNSUDPSize returns the configured EDNS UDP buffer size, limited to
+// sensible values.
+func getEDNSUDPSize() uint16 {
+	size := ednsUDPSize()
+	switch {
+	case size < minEDNSUDPSize:
+		return minEDNSUDPSize
+	case size > maxEDNSUDPSize:
+		return maxEDNSUDPSize
+	default:
+		return uint16(size)
+	}
+}
+
+// setEDNSUDPSize advertises the configured EDNS UDP buffer size in the
+// message, adding an OPT record if there is none.
+func setEDNSUDPSize(msg *dns.Msg) {
+	if opt := msg.IsEdns0(); opt != nil {
+		opt.SetUDPSize(getEDNSUDPSize())
+		return
+	}
+	msg.SetEdns0(getEDNSUDPSize(), false)
+}
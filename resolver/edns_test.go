@@ -0,0 +1,134 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTruncatingDNSServer starts a DNS server that answers with truncated
+// responses over UDP and with full responses over TCP on the same port.
+func startTruncatingDNSServer(t *testing.T) (address string, udpSizes chan uint16) {
+	t.Helper()
+
+	// Find a port that is free for both UDP and TCP.
+	var (
+		packetConn net.PacketConn
+		listener   net.Listener
+		err        error
+	)
+	for i := 0; i < 10; i++ {
+		packetConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listener, err = net.Listen("tcp", packetConn.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		_ = packetConn.Close()
+	}
+	require.NoError(t, err)
+
+	udpSizes = make(chan uint16, 10)
+	reply := func(w dns.ResponseWriter, request *dns.Msg, truncated bool) {
+		msg := new(dns.Msg)
+		msg.SetReply(request)
+		if truncated {
+			msg.Truncated = true
+		} else {
+			rr, _ := dns.NewRR(request.Question[0].Name + " 60 IN A 192.0.2.1")
+			msg.Answer = append(msg.Answer, rr)
+		}
+		_ = w.WriteMsg(msg)
+	}
+	udpServer := &dns.Server{
+		PacketConn: packetConn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+			if opt := request.IsEdns0(); opt != nil {
+				udpSizes <- opt.UDPSize()
+			}
+			reply(w, request, true)
+		}),
+	}
+	tcpServer := &dns.Server{
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+			reply(w, request, false)
+		}),
+	}
+	for _, server := range []*dns.Server{udpServer, tcpServer} {
+		server := server
+		go func() {
+			_ = server.ActivateAndServe()
+		}()
+		t.Cleanup(func() {
+			_ = server.Shutdown()
+		})
+	}
+
+	return packetConn.LocalAddr().String(), udpSizes
+}
+
+func TestTruncatedRetry(t *testing.T) {
+	t.Parallel()
+
+	address, udpSizes := startTruncatingDNSServer(t)
+	_, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+
+	resolversLock.Lock()
+	resolver, _, err := createResolver("dns://127.0.0.1:"+port, ServerSourceConfigured)
+	resolversLock.Unlock()
+	require.NoError(t, err)
+
+	// Check that truncated responses are retried over TCP.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rrCache, err := resolver.Conn.Query(ctx, &Query{FQDN: "truncated.example.com.", QType: dns.Type(dns.TypeA)})
+	require.NoError(t, err)
+	if assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "192.0.2.1", rrCache.Answer[0].(*dns.A).A.String())
+	}
+
+	// Check that the configured buffer size is advertised.
+	select {
+	case size := <-udpSizes:
+		assert.Equal(t, uint16(defaultEDNSUDPSize), size)
+	default:
+		t.Fatal("no EDNS buffer size advertised")
+	}
+
+	// Check that the retry respects the context deadline.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = resolver.Conn.Query(ctx, &Query{FQDN: "canceled.example.com.", QType: dns.Type(dns.TypeA)})
+	assert.Error(t, err)
+}
+
+func TestEDNSUDPSize(t *testing.T) {
+	t.Parallel()
+
+	// Check that an existing OPT record is updated.
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+	setEDNSUDPSize(msg)
+	if opt := msg.IsEdns0(); assert.NotNil(t, opt) {
+		assert.Equal(t, getEDNSUDPSize(), opt.UDPSize())
+		assert.True(t, opt.Do())
+	}
+	assert.Len(t, msg.Extra, 1)
+
+	// Check that an OPT record is added.
+	msg = new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	setEDNSUDPSize(msg)
+	if opt := msg.IsEdns0(); assert.NotNil(t, opt) {
+		assert.Equal(t, getEDNSUDPSize(), opt.UDPSize())
+		assert.False(t, opt.Do())
+	}
+}
@@ -0,0 +1,341 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStubConn is a resolver connection that returns a fixed reply without
+// using the network.
+type testStubConn struct {
+	resolver *Resolver
+
+	// rcode is the rcode of the reply, if err is nil.
+	rcode int
+	// err is returned instead of a reply, if set.
+	err error
+
+	queries  atomic.Int32
+	failures atomic.Int32
+	resets   atomic.Int32
+	failing  atomic.Bool
+}
+
+func (tsc *testStubConn) Query(_ context.Context, q *Query) (*RRCache, error) {
+	tsc.queries.Add(1)
+	if tsc.err != nil {
+		return nil, tsc.err
+	}
+
+	rrCache := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    tsc.rcode,
+		Resolver: tsc.resolver.Info.Copy(),
+	}
+	if tsc.rcode == dns.RcodeSuccess {
+		rr, err := dns.NewRR(fmt.Sprintf("%s 3600 IN A %s", q.FQDN, tsc.resolver.Info.IP))
+		if err != nil {
+			return nil, err
+		}
+		rrCache.Answer = []dns.RR{rr}
+	}
+	return rrCache, nil
+}
+
+func (tsc *testStubConn) ReportFailure() {
+	tsc.failures.Add(1)
+}
+
+func (tsc *testStubConn) IsFailing() bool {
+	return tsc.failing.Load()
+}
+
+func (tsc *testStubConn) ResetFailure() {
+	tsc.resets.Add(1)
+}
+
+// testStub describes the behavior of a stub resolver.
+type testStub struct {
+	rcode   int
+	err     error
+	failing bool
+}
+
+// newTestResolverSet returns a resolver with a stub connection for every
+// given stub, in order.
+func newTestResolverSet(stubs ...testStub) ([]*Resolver, []*testStubConn) {
+	resolvers := make([]*Resolver, 0, len(stubs))
+	conns := make([]*testStubConn, 0, len(stubs))
+	for i, stub := range stubs {
+		conn := &testStubConn{rcode: stub.rcode, err: stub.err}
+		conn.failing.Store(stub.failing)
+		resolver := &Resolver{
+			Info: &ResolverInfo{
+				Type:   ServerTypeDNS,
+				Source: ServerSourceEnv,
+				IP:     net.IPv4(192, 0, 2, byte(100+i)),
+				Port:   53,
+			},
+			Conn: conn,
+		}
+		conn.resolver = resolver
+		resolvers = append(resolvers, resolver)
+		conns = append(conns, conn)
+	}
+	return resolvers, conns
+}
+
+func TestResolveWithResolversFailover(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		stubs    []testStub
+		answerBy int
+		queries  []int32
+		failures []int32
+		warnings int
+		err      error
+	}{
+		{
+			name:     "first answers",
+			stubs:    []testStub{{}, {}},
+			answerBy: 0,
+			queries:  []int32{1, 0},
+			failures: []int32{0, 0},
+		},
+		{
+			name:     "failure falls back",
+			stubs:    []testStub{{err: ErrFailure}, {}},
+			answerBy: 1,
+			queries:  []int32{1, 1},
+			failures: []int32{1, 0},
+			warnings: 1,
+		},
+		{
+			name:     "timeout falls back",
+			stubs:    []testStub{{err: ErrTimeout}, {err: ErrFailure}, {}},
+			answerBy: 2,
+			queries:  []int32{1, 1, 1},
+			failures: []int32{1, 1, 0},
+			warnings: 2,
+		},
+		{
+			name:     "refused falls back without failure",
+			stubs:    []testStub{{rcode: dns.RcodeRefused}, {}},
+			answerBy: 1,
+			queries:  []int32{1, 1},
+			failures: []int32{0, 0},
+			warnings: 1,
+		},
+		{
+			name:     "unavailable source falls back without failure",
+			stubs:    []testStub{{err: ErrSourceUnavailable}, {}},
+			answerBy: 1,
+			queries:  []int32{1, 1},
+			failures: []int32{0, 0},
+			warnings: 1,
+		},
+		{
+			name:     "failing resolver is skipped",
+			stubs:    []testStub{{failing: true}, {}},
+			answerBy: 1,
+			queries:  []int32{0, 1},
+			failures: []int32{0, 0},
+		},
+		{
+			name:     "failing resolvers are used as last resort",
+			stubs:    []testStub{{failing: true}, {failing: true}},
+			answerBy: 0,
+			queries:  []int32{1, 0},
+			failures: []int32{0, 0},
+		},
+		{
+			name:     "blocked stops",
+			stubs:    []testStub{{err: ErrBlocked}, {}},
+			queries:  []int32{1, 0},
+			failures: []int32{0, 0},
+			err:      ErrBlocked,
+		},
+		{
+			name:     "all fail",
+			stubs:    []testStub{{err: ErrFailure}, {err: ErrTimeout}},
+			queries:  []int32{2, 2},
+			failures: []int32{2, 2},
+			err:      ErrTimeout,
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		domain := fmt.Sprintf("failover-%d.example.com.", i)
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resolvers, conns := newTestResolverSet(tc.stubs...)
+			rrCache, err := resolveWithResolvers(silencingTraceCtx, &Query{
+				FQDN:      domain,
+				QType:     dns.Type(dns.TypeA),
+				NoCaching: true,
+			}, nil, resolvers, ServerSourceEnv, false)
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, resolvers[tc.answerBy].Info.ID(), rrCache.Resolver.ID())
+				assert.Equal(t, int32(1), conns[tc.answerBy].resets.Load())
+				assert.Len(t, rrCache.Warnings, tc.warnings)
+			}
+			for idx, conn := range conns {
+				assert.Equal(t, tc.queries[idx], conn.queries.Load(), "queries of resolver %d", idx)
+				assert.Equal(t, tc.failures[idx], conn.failures.Load(), "failures of resolver %d", idx)
+			}
+		})
+	}
+}
+
+func TestResolveWithResolversTryAll(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		stubs    []testStub
+		tryAll   bool
+		rcode    int
+		answerBy int
+		queries  []int32
+	}{
+		{
+			name:     "nxdomain is accepted",
+			stubs:    []testStub{{rcode: dns.RcodeNameError}, {}},
+			rcode:    dns.RcodeNameError,
+			answerBy: 0,
+			queries:  []int32{1, 0},
+		},
+		{
+			name:     "nxdomain tries next",
+			stubs:    []testStub{{rcode: dns.RcodeNameError}, {}},
+			tryAll:   true,
+			rcode:    dns.RcodeSuccess,
+			answerBy: 1,
+			queries:  []int32{1, 1},
+		},
+		{
+			name:     "nxdomain everywhere",
+			stubs:    []testStub{{rcode: dns.RcodeNameError}, {rcode: dns.RcodeNameError}},
+			tryAll:   true,
+			rcode:    dns.RcodeNameError,
+			answerBy: 1,
+			queries:  []int32{2, 2},
+		},
+		{
+			name:     "answer stops",
+			stubs:    []testStub{{}, {rcode: dns.RcodeNameError}},
+			tryAll:   true,
+			rcode:    dns.RcodeSuccess,
+			answerBy: 0,
+			queries:  []int32{1, 0},
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		domain := fmt.Sprintf("tryall-%d.example.com.", i)
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resolvers, conns := newTestResolverSet(tc.stubs...)
+			rrCache, err := resolveWithResolvers(silencingTraceCtx, &Query{
+				FQDN:      domain,
+				QType:     dns.Type(dns.TypeA),
+				NoCaching: true,
+			}, nil, resolvers, ServerSourceEnv, tc.tryAll)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.rcode, rrCache.RCode)
+			assert.Equal(t, resolvers[tc.answerBy].Info.ID(), rrCache.Resolver.ID())
+			for idx, conn := range conns {
+				assert.Equal(t, tc.queries[idx], conn.queries.Load(), "queries of resolver %d", idx)
+			}
+		})
+	}
+}
+
+func TestResolveWithResolversBackupCache(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		stubs  []testStub
+		backup bool
+	}{
+		{
+			name:   "fresh answer replaces backup",
+			stubs:  []testStub{{}},
+			backup: false,
+		},
+		{
+			name:   "failure serves backup",
+			stubs:  []testStub{{err: ErrFailure}, {err: ErrTimeout}},
+			backup: true,
+		},
+		{
+			name:   "nxdomain replaces backup",
+			stubs:  []testStub{{rcode: dns.RcodeNameError}},
+			backup: false,
+		},
+		{
+			name:   "uncacheable answer serves backup",
+			stubs:  []testStub{{rcode: dns.RcodeFormatError}},
+			backup: true,
+		},
+		{
+			name:   "server failure serves backup",
+			stubs:  []testStub{{rcode: dns.RcodeServerFailure}},
+			backup: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc
+		domain := fmt.Sprintf("backup-%d.example.com.", i)
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rr, err := dns.NewRR(domain + " 3600 IN A 192.0.2.1")
+			require.NoError(t, err)
+			oldCache := &RRCache{
+				Domain:   domain,
+				Question: dns.Type(dns.TypeA),
+				RCode:    dns.RcodeSuccess,
+				Answer:   []dns.RR{rr},
+			}
+
+			resolvers, _ := newTestResolverSet(tc.stubs...)
+			rrCache, err := resolveWithResolvers(silencingTraceCtx, &Query{
+				FQDN:      domain,
+				QType:     dns.Type(dns.TypeA),
+				NoCaching: true,
+			}, oldCache, resolvers, ServerSourceEnv, false)
+			require.NoError(t, err)
+
+			if tc.backup {
+				assert.Same(t, oldCache, rrCache)
+				assert.True(t, rrCache.IsBackup)
+				assert.NotEmpty(t, rrCache.Warnings)
+			} else {
+				assert.NotSame(t, oldCache, rrCache)
+				assert.False(t, rrCache.IsBackup)
+				assert.Equal(t, resolvers[0].Info.ID(), rrCache.Resolver.ID())
+			}
+		})
+	}
+}
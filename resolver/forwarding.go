@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+)
+
+// Resolver groups that may be used as conditional forwarding targets.
+const (
+	forwardingGroupGlobal = "global"
+	forwardingGroupLocal  = "local"
+	forwardingGroupSystem = "system"
+	forwardingGroupMDNS   = "mdns"
+)
+
+// forwardingRule routes all queries within a domain suffix to specific resolvers.
+type forwardingRule struct {
+	// Suffix is the dot-prefixed domain suffix, eg. ".corp.internal.".
+	Suffix    string
+	Resolvers []*Resolver
+}
+
+// forwardingRules holds all conditional forwarding rules, longest suffix
+// first. Protected by resolversLock.
+var forwardingRules []*forwardingRule
+
+// loadForwardingRules parses the configured conditional forwarding rules.
+// Must be called with resolversLock held and after all resolvers are loaded.
+func loadForwardingRules(entries []string) (rules []*forwardingRule) {
+	for _, entry := range entries {
+		rule, err := parseForwardingRule(entry)
+		if err != nil {
+			log.Warningf("resolver: ignoring conditional forwarding rule %q: %s", entry, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	// Sort by suffix length, so that the longest matching suffix is found first.
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Suffix) > len(rules[j].Suffix)
+	})
+	return rules
+}
+
+// parseForwardingRule parses a rule in the format "suffix=target[,target]".
+// A target can be a resolver group, or the name, ID or config URL of a resolver.
+func parseForwardingRule(entry string) (*forwardingRule, error) {
+	suffix, targets, ok := strings.Cut(entry, "=")
+	if !ok {
+		return nil, fmt.Errorf("missing target, expected format suffix=target")
+	}
+
+	// Check and normalize suffix.
+	suffix = strings.ToLower(strings.Trim(strings.TrimSpace(suffix), "."))
+	if _, ok := dns.IsDomainName(suffix); !ok || suffix == "" {
+		return nil, fmt.Errorf("invalid domain suffix %q", suffix)
+	}
+
+	rule := &forwardingRule{
+		Suffix: "." + suffix + ".",
+	}
+	for _, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		resolvers := getForwardingTarget(target)
+		if len(resolvers) == 0 {
+			return nil, fmt.Errorf("unknown target %q", target)
+		}
+		rule.Resolvers = append(rule.Resolvers, resolvers...)
+	}
+
+	return rule, nil
+}
+
+// getForwardingTarget returns the resolvers of the given target.
+func getForwardingTarget(target string) []*Resolver {
+	switch target {
+	case forwardingGroupGlobal:
+		return globalResolvers
+	case forwardingGroupLocal:
+		return localResolvers
+	case forwardingGroupSystem:
+		return systemResolvers
+	case forwardingGroupMDNS:
+		return mDNSResolvers
+	}
+
+	var resolvers []*Resolver
+	for _, resolver := range globalResolvers {
+		if target == resolver.Info.Name ||
+			target == resolver.Info.ID() ||
+			target == resolver.ConfigURL {
+			resolvers = append(resolvers, resolver)
+		}
+	}
+	return resolvers
+}
+
+// getForwardingRule returns the rule with the longest matching suffix.
+// Must be called with resolversLock held.
+func getForwardingRule(dotPrefixedFQDN string) *forwardingRule {
+	for _, rule := range forwardingRules {
+		if strings.HasSuffix(dotPrefixedFQDN, rule.Suffix) {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestConditionalForwarding(t *testing.T) { //nolint:paralleltest // Changes global config.
+	err := config.SetConfigOption(CfgOptionNameServersKey, []string{
+		"dns://192.0.2.53:53?name=Internal",
+		"dot://9.9.9.9:853?verify=dns.quad9.net&name=Quad9",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = config.SetConfigOption(CfgOptionConditionalForwardingKey, []string{
+		"corp.internal=Internal",
+		"mdns.corp.internal.=mdns",
+		"example.org=unknown",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionNameServersKey, defaultNameServers)
+		_ = config.SetConfigOption(CfgOptionConditionalForwardingKey, []string{})
+		loadResolvers()
+	}()
+	loadResolvers()
+
+	resolversLock.RLock()
+	assert.Len(t, forwardingRules, 2, "rules with unknown targets should be ignored")
+	resolversLock.RUnlock()
+
+	getResolvers := func(fqdn string, localOnly bool) []*Resolver {
+		q := &Query{
+			FQDN:               fqdn,
+			QType:              dns.Type(dns.TypeA),
+			LocalResolversOnly: localOnly,
+		}
+		if !q.check() {
+			t.Fatal("invalid query")
+		}
+		resolvers, _, _ := GetResolversInScope(context.Background(), q)
+		return resolvers
+	}
+
+	// Check that the matching rules are used.
+	resolvers := getResolvers("host.corp.internal.", false)
+	if assert.Len(t, resolvers, 1) {
+		assert.Equal(t, "Internal", resolvers[0].Info.Name)
+	}
+	resolvers = getResolvers("corp.internal.", false)
+	if assert.Len(t, resolvers, 1) {
+		assert.Equal(t, "Internal", resolvers[0].Info.Name)
+	}
+
+	// Check that the longest suffix wins.
+	resolvers = getResolvers("host.mdns.corp.internal.", false)
+	if assert.Len(t, resolvers, 1) {
+		assert.Equal(t, ServerTypeMDNS, resolvers[0].Info.Type)
+	}
+
+	// Check that unmatched queries use the default scope.
+	resolvers = getResolvers("example.com.", false)
+	assert.NotEmpty(t, resolvers)
+	resolvers = getResolvers("corpinternal.", false)
+	assert.NotEmpty(t, resolvers)
+
+	// Check that only local resolvers are permitted, if requested.
+	assert.Empty(t, getResolvers("host.corp.internal.", true))
+	assert.Len(t, getResolvers("host.mdns.corp.internal.", true), 1)
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"time"
+)
+
+// ResolverHealth holds the health status of a single resolver.
+type ResolverHealth struct {
+	ID     string
+	Name   string
+	Type   string
+	Source string
+
+	// Failing is set if the resolver is currently not used because it failed.
+	Failing bool
+	// Circuit holds the circuit breaker state of the resolver, if it has one.
+	Circuit string
+
+	// LastSuccess and LastFailure hold the time of the last successful and
+	// failed query. They are zero if there was none yet.
+	LastSuccess time.Time
+	LastFailure time.Time
+
+	// EWMALatency holds the exponentially weighted moving average of the
+	// latency of the resolver.
+	EWMALatency time.Duration
+	// InFlight holds the number of queries currently in flight to the resolver.
+	InFlight int
+}
+
+// healthReporter is implemented by resolver connections that track the time
+// of their last success and failure.
+type healthReporter interface {
+	lastResults() (lastSuccess, lastFailure time.Time)
+}
+
+// lastResults returns the time of the last reported success and failure.
+func (brc *BasicResolverConn) lastResults() (lastSuccess, lastFailure time.Time) {
+	brc.failLock.Lock()
+	defer brc.failLock.Unlock()
+
+	return brc.lastSuccess, brc.lastFailure
+}
+
+// HealthSnapshot returns the current health status of all configured
+// resolvers, in the order of the resolver list. It only reads the existing
+// bookkeeping and is cheap to call regularly.
+func HealthSnapshot() []ResolverHealth {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	snapshot := make([]ResolverHealth, 0, len(globalResolvers))
+	for _, resolver := range globalResolvers {
+		health := ResolverHealth{
+			ID:      resolver.Info.ID(),
+			Name:    resolver.Info.Name,
+			Type:    resolver.Info.Type,
+			Source:  resolver.Info.Source,
+			Failing: resolver.Conn.IsFailing(),
+		}
+		if stats, ok := resolverStatsMap.Load(resolver.Info.ID()); ok {
+			health.EWMALatency = time.Duration(stats.(*resolverStats).ewmaLatency.Load()) //nolint:forcetypeassert // Only this type is stored.
+		}
+		if cb, ok := resolver.Conn.(circuitBreaker); ok {
+			health.Circuit = cb.CircuitState()
+		}
+		if hr, ok := resolver.Conn.(healthReporter); ok {
+			health.LastSuccess, health.LastFailure = hr.lastResults()
+		}
+		if conn, ok := resolver.Conn.(interface{ InFlightQueries() int }); ok {
+			health.InFlight = conn.InFlightQueries()
+		}
+		snapshot = append(snapshot, health)
+	}
+
+	return snapshot
+}
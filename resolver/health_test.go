@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthSnapshot(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	resolversLock.Lock()
+	testResolver, _, err := createResolver("dns://192.0.2.53:53?name=Health", ServerSourceConfigured)
+	require.NoError(t, err)
+	previousGlobalResolvers := globalResolvers
+	globalResolvers = []*Resolver{testResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		globalResolvers = previousGlobalResolvers
+		resolversLock.Unlock()
+	}()
+
+	snapshot := HealthSnapshot()
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, testResolver.Info.ID(), snapshot[0].ID)
+	assert.Equal(t, "Health", snapshot[0].Name)
+	assert.Equal(t, ServerTypeDNS, snapshot[0].Type)
+	assert.Equal(t, CircuitClosed, snapshot[0].Circuit)
+	assert.False(t, snapshot[0].Failing)
+	assert.True(t, snapshot[0].LastSuccess.IsZero())
+	assert.True(t, snapshot[0].LastFailure.IsZero())
+
+	// Check that a reported failure is reflected.
+	testResolver.Conn.ReportFailure()
+	snapshot = HealthSnapshot()
+	require.Len(t, snapshot, 1)
+	assert.False(t, snapshot[0].LastFailure.IsZero())
+	assert.True(t, snapshot[0].LastSuccess.IsZero())
+
+	// Check that an opened circuit is reflected.
+	brc := &testResolver.Conn.(*PlainResolver).BasicResolverConn //nolint:forcetypeassert // Created as plain resolver.
+	for i := 0; i <= FailThreshold; i++ {
+		brc.recordFailure()
+	}
+	snapshot = HealthSnapshot()
+	require.Len(t, snapshot, 1)
+	assert.True(t, snapshot[0].Failing)
+	assert.Equal(t, CircuitOpen, snapshot[0].Circuit)
+
+	// Check that a success is reflected.
+	testResolver.Conn.ResetFailure()
+	snapshot = HealthSnapshot()
+	require.Len(t, snapshot, 1)
+	assert.False(t, snapshot[0].Failing)
+	assert.False(t, snapshot[0].LastSuccess.IsZero())
+}
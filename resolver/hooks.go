@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+)
+
+// HookFunc is called before a query is resolved upstream. It may answer the
+// query by returning handled=true together with the RRCache or error to
+// return. Otherwise, the query is passed on to the next hook and finally
+// resolved upstream. Hooks may modify the query when passing it on.
+// Answers of hooks are not cached.
+type HookFunc func(ctx context.Context, q *Query) (rrCache *RRCache, handled bool, err error)
+
+// PostHookFunc is called with the result of resolving a query upstream,
+// before it is cached. It returns the RRCache and error to continue with,
+// which usually are the ones it received.
+type PostHookFunc func(ctx context.Context, q *Query, rrCache *RRCache, err error) (*RRCache, error)
+
+var (
+	hooks     []HookFunc
+	postHooks []PostHookFunc
+	hooksLock sync.RWMutex
+)
+
+// AddHook registers a hook that is called before queries are resolved
+// upstream. Hooks are called in the order they were added, until the first
+// one handles the query.
+func AddHook(hook HookFunc) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	hooks = append(hooks, hook)
+}
+
+// AddPostHook registers a hook that is called after queries were resolved
+// upstream. Post hooks are called in the order they were added, each with the
+// result of the previous one.
+func AddPostHook(hook PostHookFunc) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	postHooks = append(postHooks, hook)
+}
+
+// runHooks runs the hooks until one handles the query.
+func runHooks(ctx context.Context, q *Query) (rrCache *RRCache, handled bool, err error) {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+
+	for _, hook := range hooks {
+		rrCache, handled, err = hook(ctx, q)
+		if handled {
+			if rrCache == nil && err == nil {
+				// Defensive: A handled query must have a result.
+				err = ErrNotFound
+			}
+			return rrCache, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// runPostHooks runs all post hooks on the result of the query.
+func runPostHooks(ctx context.Context, q *Query, rrCache *RRCache, err error) (*RRCache, error) {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+
+	for _, hook := range postHooks {
+		rrCache, err = hook(ctx, q, rrCache, err)
+	}
+	return rrCache, err
+}
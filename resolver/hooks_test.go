@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) { //nolint:paralleltest // Changes global resolvers and hooks.
+	conn, _, restore := useTestCountingResolver(0)
+	defer restore()
+
+	hooksLock.Lock()
+	previousHooks, previousPostHooks := hooks, postHooks
+	hooksLock.Unlock()
+	defer func() {
+		hooksLock.Lock()
+		hooks, postHooks = previousHooks, previousPostHooks
+		hooksLock.Unlock()
+	}()
+
+	handledFQDN := "hooked." + InternalSpecialUseDomain
+	var passedThrough []string
+	AddHook(func(_ context.Context, q *Query) (*RRCache, bool, error) {
+		// Ignore queries of the online status checks.
+		if strings.HasSuffix(q.FQDN, InternalSpecialUseDomain) {
+			passedThrough = append(passedThrough, q.FQDN)
+		}
+		return nil, false, nil
+	})
+	AddHook(func(_ context.Context, q *Query) (*RRCache, bool, error) {
+		if q.FQDN != handledFQDN {
+			return nil, false, nil
+		}
+		return &RRCache{
+			Domain:   q.FQDN,
+			Question: q.QType,
+			RCode:    dns.RcodeNameError,
+			Resolver: envResolver.Info.Copy(),
+		}, true, nil
+	})
+	AddHook(func(_ context.Context, q *Query) (*RRCache, bool, error) {
+		if q.FQDN != handledFQDN {
+			return nil, false, nil
+		}
+		t.Error("hook after the handling hook should not be called")
+		return nil, true, ErrBlocked
+	})
+	AddPostHook(func(_ context.Context, _ *Query, rrCache *RRCache, err error) (*RRCache, error) {
+		if err == nil {
+			rrCache.Answer = append(rrCache.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: rrCache.Domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   []byte{192, 0, 2, 3},
+			})
+		}
+		return rrCache, err
+	})
+
+	// The first hook handling the query wins.
+	rrCache, err := Resolve(context.Background(), &Query{FQDN: handledFQDN, QType: dns.Type(dns.TypeA)})
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeNameError, rrCache.RCode)
+	assert.Empty(t, rrCache.Answer, "post hooks should not run on handled queries")
+	assert.Equal(t, int32(0), conn.queries.Load())
+
+	// Unhandled queries are resolved and passed to post hooks.
+	fqdn := "not-hooked." + InternalSpecialUseDomain
+	rrCache, err = Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA), NoCaching: true})
+	require.NoError(t, err)
+	assert.Len(t, rrCache.Answer, 2)
+	assert.Equal(t, int32(1), conn.queries.Load())
+	assert.Equal(t, []string{handledFQDN, fqdn}, passedThrough)
+}
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+// suppressedAAAATTL is the TTL of the empty answers to suppressed AAAA queries.
+// It is kept short, as IPv6 connectivity may become available at any time.
+const suppressedAAAATTL = 60
+
+var (
+	globalIPv6           bool
+	globalIPv6Lock       sync.Mutex
+	globalIPv6ChangeFlag = netenv.GetNetworkChangedFlag()
+
+	// getAssignedGlobalAddresses is replaced in tests.
+	getAssignedGlobalAddresses = netenv.GetAssignedGlobalAddresses
+)
+
+// globalIPv6Available returns whether the device has a global IPv6 address.
+// Loopback and link-local addresses do not count, as they cannot be used to
+// connect to the Internet. The result is checked again after the network
+// changed.
+func globalIPv6Available() bool {
+	globalIPv6Lock.Lock()
+	defer globalIPv6Lock.Unlock()
+
+	if globalIPv6ChangeFlag.IsSet() {
+		globalIPv6ChangeFlag.Refresh()
+
+		_, ipv6, err := getAssignedGlobalAddresses()
+		if err != nil {
+			// Do not suppress AAAA queries if unsure.
+			log.Warningf("resolver: failed to check for global IPv6 addresses: %s", err)
+			globalIPv6 = true
+		} else {
+			globalIPv6 = len(ipv6) > 0
+		}
+	}
+	return globalIPv6
+}
+
+// suppressAAAA returns whether AAAA queries should be answered locally with an
+// empty response, instead of being sent upstream.
+func suppressAAAA(preference string, ipv6Available bool) bool {
+	switch preference {
+	case IPVersionIPv6Disabled:
+		return true
+	case IPVersionPreferIPv4:
+		return !ipv6Available
+	default:
+		return false
+	}
+}
+
+// suppressedAAAAResponse returns an empty, but successful (NODATA) response
+// to the given AAAA query. It must not be cached, so that AAAA records are
+// resolved again as soon as IPv6 is wanted.
+func suppressedAAAAResponse(q *Query) *RRCache {
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Expires:  time.Now().Add(suppressedAAAATTL * time.Second).Unix(),
+		Resolver: envResolver.Info.Copy(),
+	}
+}
@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/netenv"
+)
+
+func TestSuppressAAAA(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, suppressAAAA(IPVersionBoth, false))
+	assert.False(t, suppressAAAA(IPVersionBoth, true))
+	assert.True(t, suppressAAAA(IPVersionPreferIPv4, false))
+	assert.False(t, suppressAAAA(IPVersionPreferIPv4, true))
+	assert.True(t, suppressAAAA(IPVersionIPv6Disabled, false))
+	assert.True(t, suppressAAAA(IPVersionIPv6Disabled, true))
+}
+
+func TestIPv6Disabled(t *testing.T) { //nolint:paralleltest // Changes global config.
+	err := config.SetConfigOption(CfgOptionIPVersionPreferenceKey, IPVersionIPv6Disabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionIPVersionPreferenceKey, IPVersionBoth)
+	}()
+
+	fqdn := "ipv6-disabled.example.com."
+	rrCache, err := Resolve(context.Background(), &Query{
+		FQDN:  fqdn,
+		QType: dns.Type(dns.TypeAAAA),
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, dns.RcodeSuccess, rrCache.RCode)
+		assert.Empty(t, rrCache.Answer)
+		assert.False(t, rrCache.Expired())
+	}
+
+	// The empty answer is not cached.
+	_, err = getRRCache(fqdn, dns.Type(dns.TypeAAAA), "")
+	assert.Error(t, err)
+}
+
+func TestGlobalIPv6Available(t *testing.T) { //nolint:paralleltest // Changes the global IPv6 detection.
+	previousGetter, previousFlag := getAssignedGlobalAddresses, globalIPv6ChangeFlag
+	defer func() {
+		getAssignedGlobalAddresses, globalIPv6ChangeFlag = previousGetter, previousFlag
+	}()
+	networkChanged := utils.NewBroadcastFlag()
+
+	// Check that the addresses of the device are used.
+	globalIPv6ChangeFlag = networkChanged.NewFlag()
+	_, ipv6, err := netenv.GetAssignedGlobalAddresses()
+	if assert.NoError(t, err) {
+		assert.Equal(t, len(ipv6) > 0, globalIPv6Available())
+	}
+
+	// Check that IPv4 is preferred without a global IPv6 address.
+	var assigned []net.IP
+	var assignedErr error
+	getAssignedGlobalAddresses = func() ([]net.IP, []net.IP, error) {
+		return []net.IP{net.IPv4(192, 0, 2, 1)}, assigned, assignedErr
+	}
+	networkChanged.NotifyAndReset()
+	assert.False(t, globalIPv6Available())
+	assert.True(t, suppressAAAA(IPVersionPreferIPv4, globalIPv6Available()))
+
+	// Check that the detection is only repeated after the network changed.
+	assigned = []net.IP{net.ParseIP("2001:db8::1")}
+	assert.False(t, globalIPv6Available())
+	networkChanged.NotifyAndReset()
+	assert.True(t, globalIPv6Available())
+	assert.False(t, suppressAAAA(IPVersionPreferIPv4, globalIPv6Available()))
+
+	// Check that AAAA queries are not suppressed if the detection fails.
+	assigned, assignedErr = nil, errors.New("test error")
+	networkChanged.NotifyAndReset()
+	assert.True(t, globalIPv6Available())
+}
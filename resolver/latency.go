@@ -0,0 +1,104 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/safing/portbase/rng"
+)
+
+const (
+	// latencyEWMAWeight is the weight of a new measurement in the moving
+	// average of the latency, in percent.
+	latencyEWMAWeight = 20
+
+	// latencyExplorationRate is the chance of trying a random resolver first,
+	// in percent. This allows temporarily slow resolvers to recover.
+	latencyExplorationRate = 5
+)
+
+// latencyFailurePenalty is the minimum latency recorded for a query that
+// timed out or failed, so that failing resolvers move back in the order.
+var latencyFailurePenalty = defaultRequestTimeout
+
+// recordLatency updates the moving average of the latency of the resolver.
+func recordLatency(resolver *Resolver, latency time.Duration) {
+	stats := getResolverStats(resolver)
+	for {
+		old := stats.ewmaLatency.Load()
+		updated := int64(latency)
+		if old > 0 {
+			updated = (old*(100-latencyEWMAWeight) + int64(latency)*latencyEWMAWeight) / 100
+		}
+		if stats.ewmaLatency.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// recordFailedLatency updates the moving average of the latency of the
+// resolver after a failed query, if the error means that the resolver failed.
+// The elapsed time is recorded, but at least latencyFailurePenalty.
+func recordFailedLatency(resolver *Resolver, elapsed time.Duration, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrBlocked),
+		errors.Is(err, ErrContinue),
+		errors.Is(err, ErrOffline),
+		errors.Is(err, ErrShuttingDown),
+		errors.Is(err, context.Canceled):
+		// The resolver did not fail or was not asked.
+		return
+	}
+
+	if elapsed < latencyFailurePenalty {
+		elapsed = latencyFailurePenalty
+	}
+	recordLatency(resolver, elapsed)
+}
+
+// getLatency returns the moving average of the latency of the resolver, or
+// zero if it was not measured yet.
+func getLatency(resolver *Resolver) time.Duration {
+	stats, ok := resolverStatsMap.Load(resolver.Info.ID())
+	if !ok {
+		return 0
+	}
+	return time.Duration(stats.(*resolverStats).ewmaLatency.Load()) //nolint:forcetypeassert // Only this type is stored.
+}
+
+// sortResolversByLatency sorts the given resolvers by their latency, fastest
+// first. Resolvers without measurements are put first, so that they get
+// measured. Occasionally, a random resolver is moved to the front instead.
+func sortResolversByLatency(resolvers []*Resolver) {
+	if len(resolvers) < 2 {
+		return
+	}
+
+	latencies := make(map[*Resolver]time.Duration, len(resolvers))
+	for _, resolver := range resolvers {
+		latencies[resolver] = getLatency(resolver)
+	}
+	sort.SliceStable(resolvers, func(i, j int) bool {
+		return latencies[resolvers[i]] < latencies[resolvers[j]]
+	})
+
+	// Explore a random resolver from time to time.
+	chance, err := rng.Number(99)
+	if err != nil || chance >= latencyExplorationRate {
+		return
+	}
+	explore := 1
+	if len(resolvers) > 2 {
+		pick, err := rng.Number(uint64(len(resolvers) - 2))
+		if err != nil {
+			return
+		}
+		explore += int(pick)
+	}
+	explored := resolvers[explore]
+	copy(resolvers[1:explore+1], resolvers[:explore])
+	resolvers[0] = explored
+}
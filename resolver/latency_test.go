@@ -0,0 +1,140 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyEWMA(t *testing.T) {
+	t.Parallel()
+
+	resolver := &Resolver{
+		Info: &ResolverInfo{
+			Type:   ServerTypeDNS,
+			Source: "test-ewma",
+			Port:   53,
+		},
+	}
+
+	recordLatency(resolver, 100*time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, getLatency(resolver))
+	recordLatency(resolver, 200*time.Millisecond)
+	assert.Equal(t, 120*time.Millisecond, getLatency(resolver))
+}
+
+func TestFailedLatency(t *testing.T) {
+	t.Parallel()
+
+	resolver := &Resolver{
+		Info: &ResolverInfo{
+			Type:   ServerTypeDNS,
+			Source: "test-failed-latency",
+			Port:   53,
+		},
+	}
+	recordLatency(resolver, 100*time.Millisecond)
+
+	// Check that answers that are not failures do not change the latency.
+	recordFailedLatency(resolver, time.Millisecond, ErrRefused)
+	recordFailedLatency(resolver, time.Millisecond, ErrBlocked)
+	assert.Equal(t, 100*time.Millisecond, getLatency(resolver))
+
+	// Check that failures are recorded with at least the penalty.
+	recordFailedLatency(resolver, time.Millisecond, ErrTimeout)
+	assert.Equal(t, (80*time.Millisecond)+latencyFailurePenalty/5, getLatency(resolver))
+
+	// Check that a resolver that keeps timing out is sorted last.
+	fast := &Resolver{
+		Info: &ResolverInfo{
+			Type:   ServerTypeDNS,
+			Source: "test-failed-latency-fast",
+			Port:   53,
+		},
+	}
+	recordLatency(fast, 50*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		recordFailedLatency(resolver, 10*time.Second, ErrFailure)
+	}
+	assert.Greater(t, getLatency(resolver), getLatency(fast))
+}
+
+func TestSortResolversByLatency(t *testing.T) {
+	t.Parallel()
+
+	newResolver := func(name string, latency time.Duration) *Resolver {
+		resolver := &Resolver{
+			Info: &ResolverInfo{
+				Type:   ServerTypeDNS,
+				Source: "test-sort-" + name,
+				Port:   53,
+			},
+		}
+		if latency > 0 {
+			recordLatency(resolver, latency)
+		}
+		return resolver
+	}
+	slow := newResolver("slow", 300*time.Millisecond)
+	fast := newResolver("fast", 10*time.Millisecond)
+	medium := newResolver("medium", 50*time.Millisecond)
+	unknown := newResolver("unknown", 0)
+
+	// Check that the fastest resolvers come first most of the time and that
+	// other resolvers are explored occasionally.
+	var sortedCnt, exploredCnt int
+	for i := 0; i < 1000; i++ {
+		resolvers := []*Resolver{slow, fast, medium, unknown}
+		sortResolversByLatency(resolvers)
+		assert.Len(t, resolvers, 4)
+		if resolvers[0] == unknown && resolvers[1] == fast && resolvers[2] == medium && resolvers[3] == slow {
+			sortedCnt++
+		} else {
+			exploredCnt++
+		}
+	}
+	assert.Greater(t, sortedCnt, 900)
+	assert.Greater(t, exploredCnt, 0)
+}
+
+func TestServerFailureLatency(t *testing.T) {
+	t.Parallel()
+
+	resolvers, _ := newTestResolverSet(testStub{rcode: dns.RcodeServerFailure}, testStub{})
+	// Use separate IPs, as the statistics are kept per resolver ID.
+	resolvers[0].Info.IP = net.IPv4(198, 51, 100, 11)
+	resolvers[1].Info.IP = net.IPv4(198, 51, 100, 12)
+	servFail, working := resolvers[0], resolvers[1]
+
+	for i := 0; i < 5; i++ {
+		for _, resolver := range resolvers {
+			_, _ = resolveWithResolvers(silencingTraceCtx, &Query{
+				FQDN:      "latency.example.com.",
+				QType:     dns.Type(dns.TypeA),
+				NoCaching: true,
+			}, nil, []*Resolver{resolver}, ServerSourceEnv, false)
+		}
+	}
+
+	// Check that server failures are neither counted as successes nor make
+	// the resolver look fast.
+	stats := getResolverStats(servFail)
+	assert.Equal(t, uint64(0), stats.successes.Load())
+	assert.NotZero(t, stats.failures.Load())
+	assert.Equal(t, stats.queries.Load(), stats.failures.Load())
+	assert.GreaterOrEqual(t, getLatency(servFail), latencyFailurePenalty)
+
+	// Check that the resolver that fails loses the latency ranking.
+	var workingFirst int
+	for i := 0; i < 100; i++ {
+		sorted := []*Resolver{servFail, working}
+		sortResolversByLatency(sorted)
+		if sorted[0] == working {
+			workingFirst++
+		}
+	}
+	assert.Greater(t, workingFirst, 80)
+}
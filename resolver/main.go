@@ -21,7 +21,7 @@
 var module *modules.Module
 
 func init() {
-	module = modules.Register("resolver", prep, start, nil, "base", "netenv")
+	module = modules.Register("resolver", prep, start, stop, "base", "netenv")
 }
 
 func prep() error {
@@ -39,6 +39,13 @@ func prep() error {
 }
 
 func start() error {
+	// accept queries again, if the module was restarted
+	resetDrain()
+
+	if err := registerMetrics(); err != nil {
+		return err
+	}
+
 	// load resolvers from config and environment
 	loadResolvers()
 
@@ -58,13 +65,13 @@ func(_ context.Context, _ interface{}) error {
 	}
 
 	// reload after config change
-	prevNameservers := strings.Join(configuredNameServers(), " ")
+	prevNameservers := getResolverConfigState()
 	err = module.RegisterEventHook(
 		"config",
 		"config change",
 		"update nameservers",
 		func(_ context.Context, _ interface{}) error {
-			newNameservers := strings.Join(configuredNameServers(), " ")
+			newNameservers := getResolverConfigState()
 			if newNameservers != prevNameservers {
 				prevNameservers = newNameservers
 
@@ -84,12 +91,31 @@ func(_ context.Context, _ interface{}) error {
 		listenToMDNS,
 	)
 
+	module.StartWorker("verify dns cache", verifyNameCache)
+	module.StartServiceWorker("hosts file watcher", 0, hostsFileWatcher)
+	module.StartServiceWorker("dns prefetcher", 0, prefetcher)
 	module.StartServiceWorker("name record delayed cache writer", 0, recordDatabase.DelayedCacheWriter)
 	module.StartServiceWorker("ip info delayed cache writer", 0, ipInfoDatabase.DelayedCacheWriter)
 
 	return nil
 }
 
+func stop() error {
+	// give in-flight queries a chance to finish
+	drainQueries()
+	return nil
+}
+
+// getResolverConfigState returns all configuration that requires reloading
+// the resolvers, in order to detect changes.
+func getResolverConfigState() string {
+	return strings.Join(configuredNameServers(), " ") + "\n" +
+		strings.Join(bootstrapNameServers(), " ") + "\n" +
+		strings.Join(conditionalForwarding(), " ") + "\n" +
+		strings.Join(specialDomainHandling(), " ") + "\n" +
+		strings.Join(dnsRewriteRules(), " ")
+}
+
 var localAddrFactory func(network string) net.Addr
 
 // SetLocalAddrFactory supplies the intel package with a function to get permitted local addresses for connections.
@@ -110,9 +136,32 @@ func getLocalAddr(network string) net.Addr {
 	failingResolverNotification     *notifications.Notification
 	failingResolverNotificationSet  = abool.New()
 	failingResolverNotificationLock sync.Mutex
+
+	failingResolverNotificationsDisabled = abool.New()
 )
 
+// SetFailingResolverNotifications enables or disables the notification about
+// failing resolvers, in addition to the respective setting. This is intended
+// for embedders that inform users on their own. Disabling the notification
+// removes a currently shown one. Failing resolvers are still tracked.
+func SetFailingResolverNotifications(enabled bool) {
+	failingResolverNotificationsDisabled.SetTo(!enabled)
+	if !enabled {
+		resetFailingResolversNotification()
+	}
+}
+
+// failingResolverNotificationsEnabled returns whether the notification about
+// failing resolvers may be shown.
+func failingResolverNotificationsEnabled() bool {
+	return failingResolverNotificationsDisabled.IsNotSet() && failingResolverNotifications()
+}
+
 func notifyAboutFailingResolvers(err error) {
+	if !failingResolverNotificationsEnabled() {
+		return
+	}
+
 	failingResolverNotificationLock.Lock()
 	defer failingResolverNotificationLock.Unlock()
 	failingResolverNotificationSet.Set()
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/metrics"
+)
+
+func registerMetrics() error {
+	_, err := metrics.NewGauge(
+		"resolver/queries/inflight/total",
+		nil,
+		getInFlightQueries,
+		&metrics.Options{
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = metrics.NewFetchingCounter(
+		"resolver/cache/lookups/total",
+		map[string]string{"result": "hit"},
+		cacheHits.Load,
+		&metrics.Options{
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = metrics.NewFetchingCounter(
+		"resolver/cache/lookups/total",
+		map[string]string{"result": "miss"},
+		cacheMisses.Load,
+		&metrics.Options{
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = metrics.NewGauge(
+		"resolver/queries/deduplicated/active",
+		nil,
+		func() float64 {
+			dupReqLock.Lock()
+			defer dupReqLock.Unlock()
+			return float64(len(dupReqMap))
+		},
+		&metrics.Options{
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		})
+
+	return err
+}
+
+func getInFlightQueries() float64 {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	var total int
+	for _, resolver := range activeResolvers {
+		if conn, ok := resolver.Conn.(interface{ InFlightQueries() int }); ok {
+			total += conn.InFlightQueries()
+		}
+	}
+	return float64(total)
+}
@@ -2,9 +2,14 @@
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/miekg/dns"
 
 	"github.com/safing/portbase/api"
 	"github.com/safing/portbase/database"
@@ -34,6 +39,8 @@
 	})
 
 	nameRecordsKeyPrefix = "cache:intel/nameRecord/"
+
+	errNameRecordCorrupted = errors.New("record is corrupted (checksum mismatch)")
 )
 
 // NameRecord is helper struct to RRCache to better save data to the database.
@@ -44,12 +51,33 @@ type NameRecord struct {
 	Domain   string
 	Question string
 	RCode    int
-	Answer   []string
-	Ns       []string
-	Extra    []string
 	Expires  int64
 
+	// Packed holds the answer, authority and additional sections in DNS wire
+	// format with name compression. The records are only decoded when the
+	// NameRecord is converted to an RRCache.
+	Packed []byte `json:",omitempty"`
+
+	// Answer, Ns and Extra hold the sections in presentation format. They are
+	// used instead of Packed if the records could not be packed, and by
+	// records saved by earlier versions.
+	Answer []string `json:",omitempty"`
+	Ns     []string `json:",omitempty"`
+	Extra  []string `json:",omitempty"`
+
 	Resolver *ResolverInfo
+
+	AuthenticatedData bool
+	Insecure          bool
+
+	ECSNetwork string
+	ECSScope   uint8
+
+	ClientScope string
+
+	// Checksum holds a checksum over all other fields in order to detect
+	// corrupted records.
+	Checksum string
 }
 
 // IsValid returns whether the NameRecord is valid and may be used. Otherwise,
@@ -65,8 +93,86 @@ func (nameRecord *NameRecord) IsValid() bool {
 	}
 }
 
+// makeNameRecordKey returns the database key of the NameRecord for the given
+// domain and question. The domain is normalized, so that all spellings of a
+// domain share the same cache entry.
 func makeNameRecordKey(domain string, question string) string {
-	return nameRecordsKeyPrefix + domain + question
+	return nameRecordsKeyPrefix + NormalizeFQDN(domain) + question
+}
+
+// checksum returns a checksum over the contents of the NameRecord.
+func (nameRecord *NameRecord) checksum() string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00", nameRecord.Domain, nameRecord.Question, nameRecord.RCode, nameRecord.Expires)
+	for _, section := range [][]string{nameRecord.Answer, nameRecord.Ns, nameRecord.Extra} {
+		for _, rr := range section {
+			_, _ = fmt.Fprintf(h, "%s\x00", rr)
+		}
+		_, _ = h.Write([]byte{0xFF})
+	}
+	if len(nameRecord.Packed) > 0 {
+		_, _ = h.Write(nameRecord.Packed)
+		_, _ = h.Write([]byte{0xFF})
+	}
+	if nameRecord.Resolver != nil {
+		_, _ = fmt.Fprintf(h, "%s\x00", nameRecord.Resolver.ID())
+	}
+	_, _ = fmt.Fprintf(h, "%t\x00%t\x00%s\x00%d\x00%s", nameRecord.AuthenticatedData, nameRecord.Insecure, nameRecord.ECSNetwork, nameRecord.ECSScope, nameRecord.ClientScope)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// packSections packs the given sections into DNS wire format. It returns nil
+// if there are no records.
+func packSections(answer, ns, extra []dns.RR) ([]byte, error) {
+	if len(answer) == 0 && len(ns) == 0 && len(extra) == 0 {
+		return nil, nil
+	}
+
+	msg := &dns.Msg{
+		Compress: true,
+		Answer:   answer,
+		Ns:       ns,
+		Extra:    extra,
+	}
+	return msg.Pack()
+}
+
+// sections decodes and returns the answer, authority and additional sections.
+func (nameRecord *NameRecord) sections() (answer, ns, extra []dns.RR, err error) {
+	if len(nameRecord.Packed) > 0 {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(nameRecord.Packed); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to unpack records: %w", err)
+		}
+		return msg.Answer, msg.Ns, msg.Extra, nil
+	}
+
+	for _, entry := range nameRecord.Answer {
+		answer = parseRR(answer, entry)
+	}
+	for _, entry := range nameRecord.Ns {
+		ns = parseRR(ns, entry)
+	}
+	for _, entry := range nameRecord.Extra {
+		extra = parseRR(extra, entry)
+	}
+	return answer, ns, extra, nil
+}
+
+// checkIntegrity returns an error if the NameRecord is invalid or corrupted.
+// Records saved by earlier versions have no checksum and are not checked.
+func (nameRecord *NameRecord) checkIntegrity() error {
+	switch {
+	case !nameRecord.IsValid():
+		return errors.New("record is invalid (outdated format)")
+	case nameRecord.Checksum == "":
+		// Saved before checksums were introduced.
+		return nil
+	case nameRecord.Checksum != nameRecord.checksum():
+		return errNameRecordCorrupted
+	default:
+		return nil
+	}
 }
 
 // GetNameRecord gets a NameRecord from the database.
@@ -78,17 +184,29 @@ func GetNameRecord(domain, question string) (*NameRecord, error) {
 		return nil, err
 	}
 
+	// Disregard records that were flushed, but are still waiting in the write
+	// cache of the database.
+	if isFlushedCacheRecord(domain, r.Meta().Modified) {
+		return nil, database.ErrNotFound
+	}
+
+	return parseNameRecord(r)
+}
+
+// parseNameRecord converts the given record to a NameRecord and checks its
+// integrity.
+func parseNameRecord(r record.Record) (*NameRecord, error) {
 	// Unwrap record if it's wrapped.
 	if r.IsWrapped() {
 		// only allocate a new struct, if we need it
 		newNR := &NameRecord{}
-		err = record.Unwrap(r, newNR)
+		err := record.Unwrap(r, newNR)
 		if err != nil {
 			return nil, err
 		}
 		// Check if the record is valid.
-		if !newNR.IsValid() {
-			return nil, errors.New("record is invalid (outdated format)")
+		if err := newNR.checkIntegrity(); err != nil {
+			return nil, err
 		}
 
 		return newNR, nil
@@ -100,21 +218,29 @@ func GetNameRecord(domain, question string) (*NameRecord, error) {
 		return nil, fmt.Errorf("record not of type *NameRecord, but %T", r)
 	}
 	// Check if the record is valid.
-	if !newNR.IsValid() {
-		return nil, errors.New("record is invalid (outdated format)")
+	if err := newNR.checkIntegrity(); err != nil {
+		return nil, err
 	}
 
 	return newNR, nil
 }
 
-// ResetCachedRecord deletes a NameRecord from the cache database.
+// ResetCachedRecord deletes a NameRecord from the cache database. The domain
+// is normalized with NormalizeFQDN.
 func ResetCachedRecord(domain, question string) error {
+	// Delete the entry before clearing the caches, as entries that are still
+	// waiting in the write cache are only found in the read cache.
+	key := makeNameRecordKey(domain, question)
+	err := recordDatabase.Delete(key)
+
 	// In order to properly delete an entry, we must also clear the caches.
 	recordDatabase.FlushCache()
 	recordDatabase.ClearCache()
 
-	key := makeNameRecordKey(domain, question)
-	return recordDatabase.Delete(key)
+	if err == nil {
+		emitCacheEvent(CacheEventReset, domain, question)
+	}
+	return err
 }
 
 // Save saves the NameRecord to the database.
@@ -123,13 +249,75 @@ func (nameRecord *NameRecord) Save() error {
 		return errors.New("could not save NameRecord, missing Domain and/or Question")
 	}
 
-	nameRecord.SetKey(makeNameRecordKey(nameRecord.Domain, nameRecord.Question))
+	nameRecord.SetKey(makeNameRecordKey(nameRecord.Domain, nameRecord.Question+makeCacheKeySuffix(nameRecord.ECSNetwork, nameRecord.ClientScope)))
+	nameRecord.Checksum = nameRecord.checksum()
 	nameRecord.UpdateMeta()
 	nameRecord.Meta().SetAbsoluteExpiry(nameRecord.Expires + databaseOvertime)
 
 	return recordDatabase.PutNew(nameRecord)
 }
 
+// verifyNameCache checks all persisted name records on startup. It removes
+// any records that are corrupted or whose resolver does not exist anymore, so
+// that all remaining records can be used right away.
+func verifyNameCache(ctx context.Context) error {
+	it, err := recordDatabase.Query(query.New(nameRecordsKeyPrefix))
+	if err != nil {
+		return fmt.Errorf("failed to query dns cache: %w", err)
+	}
+
+	var (
+		remove                      []string
+		valid, expired, expiresSoon int
+		now                         = time.Now().Unix()
+		refreshTTL                  = int64(getTTLLimits(0).refresh)
+	)
+	for r := range it.Next {
+		// Parse and check record integrity.
+		nameRecord, err := parseNameRecord(r)
+		if err != nil {
+			log.Tracer(ctx).Debugf("resolver: removing dns cache entry %s: %s", r.Key(), err)
+			remove = append(remove, r.Key())
+			continue
+		}
+
+		// Check if the resolver still exists.
+		if getActiveResolverByIDWithLocking(nameRecord.Resolver.ID()) == nil {
+			log.Tracer(ctx).Tracef("resolver: removing dns cache entry %s: source server %q has been removed", r.Key(), nameRecord.Resolver.ID())
+			remove = append(remove, r.Key())
+			continue
+		}
+
+		// Count expiry state for the summary.
+		valid++
+		switch {
+		case nameRecord.Expires <= now:
+			expired++
+		case nameRecord.Expires <= now+refreshTTL:
+			expiresSoon++
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to iterate over dns cache: %w", err)
+	}
+
+	// Remove entries after iterating, in order to not interfere with the query.
+	for _, key := range remove {
+		if err := recordDatabase.Delete(key); err != nil {
+			log.Tracer(ctx).Debugf("resolver: failed to remove dns cache entry %s: %s", key, err)
+		}
+	}
+	if len(remove) > 0 {
+		recordDatabase.ClearCache()
+	}
+
+	log.Tracer(ctx).Debugf(
+		"resolver: verified dns cache: %d usable entries (%d expired, %d expire soon), removed %d entries",
+		valid, expired, expiresSoon, len(remove),
+	)
+	return nil
+}
+
 // clearNameCacheHandler is an API handler that clears all dns caches from the database.
 func clearNameCacheHandler(ar *api.Request) (msg string, err error) {
 	log.Info("resolver: user requested dns cache clearing via action")
@@ -1,6 +1,17 @@
 package resolver
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/database"
+)
 
 func TestNameRecordStorage(t *testing.T) {
 	t.Parallel()
@@ -30,3 +41,278 @@ func TestNameRecordStorage(t *testing.T) {
 		t.Fatal("mismatch")
 	}
 }
+
+func TestNameRecordKeyNormalization(t *testing.T) { //nolint:paralleltest // Clears the record cache.
+	rrCache := &RRCache{
+		Domain:   "Normalize-Test.Example.COM",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Expires:  time.Now().Unix() + 60,
+		Resolver: envResolver.Info.Copy(),
+	}
+	if err := rrCache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// All spellings of the domain hit the same entry.
+	for _, domain := range []string{"Example.COM", "example.com."} {
+		domain = "normalize-test." + domain
+		if _, err := GetRRCache(domain, dns.Type(dns.TypeA)); err != nil {
+			t.Errorf("failed to get %s from cache: %s", domain, err)
+		}
+	}
+	if NormalizeFQDN("Normalize-Test.Example.COM") != "normalize-test.example.com." {
+		t.Error("domain not normalized")
+	}
+
+	// Resetting a differently spelled domain removes the entry.
+	if err := ResetCachedRecord("NORMALIZE-TEST.example.com", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetRRCache("normalize-test.example.com.", dns.Type(dns.TypeA)); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected entry to be removed, got %v", err)
+	}
+}
+
+func TestNameRecordIntegrity(t *testing.T) { //nolint:paralleltest // Clears the record cache.
+	testDomain := "integrity-check.example.com."
+
+	// Save a valid record and a record of an unknown resolver.
+	validRecord := &NameRecord{
+		Domain:   testDomain,
+		Question: "A",
+		Answer:   []string{testDomain + "\t60\tIN\tA\t192.0.2.1"},
+		Resolver: envResolver.Info.Copy(),
+	}
+	orphanedRecord := &NameRecord{
+		Domain:   testDomain,
+		Question: "AAAA",
+		Resolver: &ResolverInfo{
+			Type:   ServerTypeDNS,
+			Source: "removed-resolver",
+		},
+	}
+	// Write records directly to storage, as only persisted records are verified.
+	db := database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+	for _, nameRecord := range []*NameRecord{validRecord, orphanedRecord} {
+		nameRecord.SetKey(makeNameRecordKey(nameRecord.Domain, nameRecord.Question))
+		nameRecord.Checksum = nameRecord.checksum()
+		nameRecord.UpdateMeta()
+		if err := db.Put(nameRecord); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Save a record of an earlier version without checksum.
+	legacyRecord := &NameRecord{
+		Domain:   testDomain,
+		Question: "TXT",
+		Resolver: envResolver.Info.Copy(),
+	}
+	legacyRecord.SetKey(makeNameRecordKey(legacyRecord.Domain, legacyRecord.Question))
+	legacyRecord.UpdateMeta()
+	if err := db.Put(legacyRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that orphaned records are removed.
+	if err := verifyNameCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetNameRecord(testDomain, "A"); err != nil {
+		t.Fatalf("valid record was removed: %s", err)
+	}
+	if _, err := GetNameRecord(testDomain, "AAAA"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("orphaned record was not removed: %v", err)
+	}
+	if _, err := GetNameRecord(testDomain, "TXT"); err != nil {
+		t.Fatalf("record without checksum was removed: %s", err)
+	}
+
+	// Check that corrupted records are detected.
+	validRecord.Answer[0] = testDomain + "\t60\tIN\tA\t192.0.2.2"
+	if _, err := parseNameRecord(validRecord); !errors.Is(err, errNameRecordCorrupted) {
+		t.Fatalf("corrupted record was not detected: %v", err)
+	}
+
+	// Check that records saved before checksums were introduced are kept.
+	validRecord.Checksum = ""
+	if _, err := parseNameRecord(validRecord); err != nil {
+		t.Fatalf("record without checksum was rejected: %s", err)
+	}
+}
+
+func newTestPackingRRCache(tb testing.TB, domain string) *RRCache {
+	tb.Helper()
+
+	rrCache := &RRCache{
+		Domain:   domain,
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Expires:  time.Now().Unix() + 3600,
+		Resolver: envResolver.Info.Copy(),
+	}
+	for _, entry := range []string{
+		domain + " 300 IN CNAME edge." + domain,
+		"edge." + domain + " 300 IN A 192.0.2.1",
+		"edge." + domain + " 300 IN A 192.0.2.2",
+		"edge." + domain + " 300 IN A 192.0.2.3",
+		"edge." + domain + " 300 IN AAAA 2001:db8::1",
+	} {
+		rrCache.Answer = parseRR(rrCache.Answer, entry)
+	}
+	for _, entry := range []string{
+		"example.com. 86400 IN NS ns1.example.com.",
+		"example.com. 86400 IN NS ns2.example.com.",
+	} {
+		rrCache.Ns = parseRR(rrCache.Ns, entry)
+	}
+	for _, entry := range []string{
+		"ns1.example.com. 86400 IN A 192.0.2.53",
+		"ns2.example.com. 86400 IN AAAA 2001:db8::53",
+	} {
+		rrCache.Extra = parseRR(rrCache.Extra, entry)
+	}
+	if len(rrCache.Answer) != 5 || len(rrCache.Ns) != 2 || len(rrCache.Extra) != 2 {
+		tb.Fatal("failed to parse test records")
+	}
+	return rrCache
+}
+
+// toTextNameRecord returns the NameRecord of the RRCache with the records in
+// presentation format, as saved by earlier versions.
+func toTextNameRecord(rrCache *RRCache) *NameRecord {
+	nameRecord := rrCache.ToNameRecord()
+	nameRecord.Packed = nil
+	nameRecord.Answer = toPresentationFormat(toNameRecordSection(rrCache.Answer))
+	nameRecord.Ns = toPresentationFormat(toNameRecordSection(rrCache.Ns))
+	nameRecord.Extra = toPresentationFormat(toNameRecordSection(rrCache.Extra))
+	return nameRecord
+}
+
+func sectionStrings(sections ...[]dns.RR) (s []string) {
+	for _, section := range sections {
+		for _, rr := range section {
+			s = append(s, rr.String())
+		}
+		s = append(s, "--")
+	}
+	return s
+}
+
+func TestPackedNameRecord(t *testing.T) {
+	t.Parallel()
+
+	rrCache := newTestPackingRRCache(t, "packed.example.com.")
+	rrCache.Extra = append(rrCache.Extra, &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}})
+	expected := sectionStrings(rrCache.Answer, rrCache.Ns, rrCache.Extra[:2])
+
+	// Check that records are packed and smaller than in presentation format.
+	nameRecord := rrCache.ToNameRecord()
+	if len(nameRecord.Packed) == 0 || len(nameRecord.Answer) > 0 {
+		t.Fatal("records were not packed")
+	}
+	packedJSON, err := json.Marshal(nameRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	textJSON, err := json.Marshal(toTextNameRecord(rrCache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packedJSON) >= len(textJSON) {
+		t.Errorf("packed record (%d bytes) is not smaller than text record (%d bytes)", len(packedJSON), len(textJSON))
+	}
+
+	// Check that the records are restored from the cache, without the OPT record.
+	if err := rrCache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := GetRRCache(rrCache.Domain, rrCache.Question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sectionStrings(cached.Answer, cached.Ns, cached.Extra); !reflect.DeepEqual(expected, got) {
+		t.Errorf("cached records differ:\nexpected %v\ngot      %v", expected, got)
+	}
+
+	// Check that the cached entry can be used as usual.
+	if cached.Expired() {
+		t.Error("cached entry should not be expired")
+	}
+	if ips := cached.ExportAllARecords(); len(ips) != 4 {
+		t.Errorf("expected 4 IPs, got %v", ips)
+	}
+	cached.Clean(defaultMinTTL, defaultMaxTTL)
+	if ttl := cached.Answer[0].Header().Ttl; ttl != 17 {
+		t.Errorf("expected TTL of 17 after cleaning, got %d", ttl)
+	}
+
+	// Check that records in presentation format can still be read.
+	answer, ns, extra, err := toTextNameRecord(rrCache).sections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sectionStrings(answer, ns, extra); !reflect.DeepEqual(expected, got) {
+		t.Errorf("text records differ:\nexpected %v\ngot      %v", expected, got)
+	}
+
+	// Check that broken packed records are detected.
+	nameRecord.Packed = nameRecord.Packed[:len(nameRecord.Packed)-3]
+	if _, _, _, err := nameRecord.sections(); err == nil {
+		t.Error("truncated records should fail to unpack")
+	}
+}
+
+func BenchmarkNameRecordEncoding(b *testing.B) {
+	rrCache := newTestPackingRRCache(b, "encoding.example.com.")
+
+	for _, format := range []struct {
+		name     string
+		toRecord func(*RRCache) *NameRecord
+	}{
+		{"text", toTextNameRecord},
+		{"packed", (*RRCache).ToNameRecord},
+	} {
+		format := format
+		b.Run(format.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			var size int
+			for i := 0; i < b.N; i++ {
+				data, err := json.Marshal(format.toRecord(rrCache))
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/record")
+		})
+	}
+}
+
+func BenchmarkNameRecordDecoding(b *testing.B) {
+	rrCache := newTestPackingRRCache(b, "decoding.example.com.")
+
+	for _, format := range []struct {
+		name       string
+		nameRecord *NameRecord
+	}{
+		{"text", toTextNameRecord(rrCache)},
+		{"packed", rrCache.ToNameRecord()},
+	} {
+		format := format
+		b.Run(format.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := format.nameRecord.sections(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxParallelism limits how many resolvers are queried at the same time.
+const maxParallelism = 8
+
+// raceResult holds the result of a query that was sent to multiple resolvers
+// in parallel.
+type raceResult struct {
+	started time.Time
+	rrCache *RRCache
+	err     error
+	// cancelled is set if the query was cancelled, because another resolver
+	// answered first.
+	cancelled bool
+}
+
+// getParallelism returns how many resolvers may be queried at the same time.
+func getParallelism(q *Query) int {
+	switch {
+	case q.Parallelism < 1:
+		return 1
+	case q.Parallelism > maxParallelism:
+		return maxParallelism
+	default:
+		return q.Parallelism
+	}
+}
+
+// raceResolvers sends the query to all given resolvers in parallel and waits
+// until the first one answers successfully or all of them failed. The queries
+// that are still in flight are then cancelled and their results are marked as
+// such. Results are returned for all given resolvers.
+func raceResolvers(ctx context.Context, q *Query, resolvers []*Resolver, tryAll bool) map[*Resolver]*raceResult {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type finishedQuery struct {
+		resolver *Resolver
+		result   *raceResult
+	}
+	finished := make(chan finishedQuery, len(resolvers))
+	for _, resolver := range resolvers {
+		go func(resolver *Resolver) {
+			started := time.Now()
+			rrCache, err := queryResolver(raceCtx, resolver, q)
+			finished <- finishedQuery{
+				resolver: resolver,
+				result: &raceResult{
+					started: started,
+					rrCache: rrCache,
+					err:     err,
+				},
+			}
+		}(resolver)
+	}
+
+	results := make(map[*Resolver]*raceResult, len(resolvers))
+	for range resolvers {
+		f := <-finished
+		results[f.resolver] = f.result
+		if f.result.won(tryAll) {
+			break
+		}
+	}
+
+	// Mark the queries that are still in flight as cancelled.
+	for _, resolver := range resolvers {
+		if _, ok := results[resolver]; !ok {
+			results[resolver] = &raceResult{cancelled: true}
+		}
+	}
+
+	return results
+}
+
+// won returns whether the result ends the race.
+func (result *raceResult) won(tryAll bool) bool {
+	switch {
+	case result.err != nil || result.rrCache == nil:
+		return false
+	case rcodeToError(result.rrCache.RCode) != nil:
+		return false
+	case tryAll && result.rrCache.RCode != dns.RcodeSuccess:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDelayedResolver(ip net.IP, delay time.Duration) (*testCountingConn, *Resolver) {
+	conn := &testCountingConn{delay: delay}
+	testResolver := &Resolver{
+		Info: &ResolverInfo{Type: ServerTypeDNS, Source: ServerSourceEnv, IP: ip, Port: 53},
+		Conn: conn,
+	}
+	conn.resolver = testResolver
+	conn.init()
+	return conn, testResolver
+}
+
+func TestParallelResolving(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	slowConn, slowResolver := newTestDelayedResolver(net.IPv4(192, 0, 2, 53), 300*time.Millisecond)
+	fastConn, fastResolver := newTestDelayedResolver(net.IPv4(192, 0, 2, 54), 10*time.Millisecond)
+
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{slowResolver, fastResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		resolversLock.Unlock()
+	}()
+
+	resolve := func(q *Query) (*RRCache, error) {
+		if !q.check() {
+			return nil, ErrInvalid
+		}
+		return resolveAndCache(silencingTraceCtx, q, nil)
+	}
+
+	// Sequentially, the first resolver answers.
+	rrCache, err := resolve(&Query{
+		FQDN:      "sequential.parallel." + InternalSpecialUseDomain,
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, slowResolver.Info.ID(), rrCache.Resolver.ID())
+	}
+	assert.Equal(t, int32(0), fastConn.queries.Load())
+
+	// In parallel, the fast resolver wins and the slow one is cancelled.
+	started := time.Now()
+	rrCache, err = resolve(&Query{
+		FQDN:        "parallel." + InternalSpecialUseDomain,
+		QType:       dns.Type(dns.TypeA),
+		NoCaching:   true,
+		Parallelism: 2,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, fastResolver.Info.ID(), rrCache.Resolver.ID())
+	}
+	assert.Less(t, time.Since(started), 300*time.Millisecond)
+	assert.Equal(t, int32(2), slowConn.queries.Load())
+	assert.Equal(t, int32(1), fastConn.queries.Load())
+
+	// Cancelled resolvers are not marked as failing.
+	assert.False(t, slowConn.IsFailing())
+	assert.False(t, fastConn.IsFailing())
+}
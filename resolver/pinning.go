@@ -0,0 +1,127 @@
+package resolver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPinMismatch wraps ErrFailure and is returned when the certificate of a
+// resolver does not match any of its configured pins.
+var ErrPinMismatch = fmt.Errorf("%w: certificate pin mismatch", ErrFailure)
+
+// Pin types.
+const (
+	pinTypeSPKI = "spki"
+	pinTypeCert = "cert"
+)
+
+// certPin is a SHA256 hash of either the subject public key info or the
+// whole certificate of a resolver.
+type certPin struct {
+	spki bool
+	hash [sha256.Size]byte
+}
+
+// pinSupported returns whether the certificates of resolvers of the given type
+// can be pinned.
+func pinSupported(resolverType string) bool {
+	switch resolverType {
+	case ServerTypeDoT, ServerTypeDoH, ServerTypeDoQ:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseCertPins parses the given pins. Every value may hold multiple pins
+// separated by commas. A pin has the format "spki:<hash>" or "cert:<hash>",
+// where the hash is the base64 encoded SHA256 hash of the subject public key
+// info or the DER encoded certificate, respectively.
+func parseCertPins(values []string) ([]certPin, error) {
+	var pins []certPin
+	for _, value := range values {
+		for _, pinValue := range strings.Split(value, ",") {
+			pinType, encodedHash, ok := strings.Cut(strings.TrimSpace(pinValue), ":")
+			if !ok {
+				return nil, fmt.Errorf("pin %q is missing the type", pinValue)
+			}
+
+			pin := certPin{}
+			switch pinType {
+			case pinTypeSPKI:
+				pin.spki = true
+			case pinTypeCert:
+			default:
+				return nil, fmt.Errorf("pin type %q invalid, must be %s or %s", pinType, pinTypeSPKI, pinTypeCert)
+			}
+
+			hash, err := decodePinHash(encodedHash)
+			if err != nil {
+				return nil, fmt.Errorf("pin %q invalid: %w", pinValue, err)
+			}
+			copy(pin.hash[:], hash)
+			pins = append(pins, pin)
+		}
+	}
+
+	return pins, nil
+}
+
+// decodePinHash decodes a base64 encoded SHA256 hash. Both the standard and
+// the URL-safe encoding are accepted. Plus signs that were decoded to spaces
+// in the query string of the config URL are restored.
+func decodePinHash(encodedHash string) ([]byte, error) {
+	encodedHash = strings.ReplaceAll(encodedHash, " ", "+")
+
+	hash, err := base64.StdEncoding.DecodeString(encodedHash)
+	if err != nil {
+		hash, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(encodedHash, "="))
+		if err != nil {
+			return nil, errors.New("hash is not base64 encoded")
+		}
+	}
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("hash has %d bytes instead of %d", len(hash), sha256.Size)
+	}
+	return hash, nil
+}
+
+// addCertPinning adds the pin verification of the resolver to the TLS config,
+// if the resolver has pins configured.
+func (resolver *Resolver) addCertPinning(tlsConfig *tls.Config) *tls.Config {
+	if len(resolver.pins) > 0 {
+		tlsConfig.VerifyConnection = resolver.verifyCertPins
+	}
+	return tlsConfig
+}
+
+// verifyCertPins checks if any of the certificates presented by the server
+// matches any of the pins of the resolver. It is called after the regular
+// certificate verification.
+func (resolver *Resolver) verifyCertPins(state tls.ConnectionState) error {
+	for _, cert := range state.PeerCertificates {
+		spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		certHash := sha256.Sum256(cert.Raw)
+		for _, pin := range resolver.pins {
+			if (pin.spki && pin.hash == spkiHash) ||
+				(!pin.spki && pin.hash == certHash) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: certificate of %s does not match any of its pins", ErrPinMismatch, resolver.Info.DescriptiveName())
+}
+
+// connectFailureReason returns the error a failed connection to a resolver is
+// reported with.
+func connectFailureReason(err error) error {
+	if errors.Is(err, ErrPinMismatch) {
+		return ErrPinMismatch
+	}
+	return ErrFailure
+}
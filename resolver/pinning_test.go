@@ -0,0 +1,140 @@
+package resolver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestTLSServer starts a TLS server with a self-signed certificate and
+// returns its address and certificate.
+func startTestTLSServer(t *testing.T) (string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.example.com"},
+		DNSNames:     []string{"dns.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake() //nolint:forcetypeassert // TLS listener.
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), cert
+}
+
+func TestCertPinning(t *testing.T) {
+	t.Parallel()
+
+	address, cert := startTestTLSServer(t)
+	spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	certHash := sha256.Sum256(cert.Raw)
+	otherHash := sha256.Sum256([]byte("other"))
+	spkiPin := "spki:" + base64.StdEncoding.EncodeToString(spkiHash[:])
+	certPin := "cert:" + base64.StdEncoding.EncodeToString(certHash[:])
+	otherPin := "spki:" + base64.StdEncoding.EncodeToString(otherHash[:])
+
+	handshake := func(pins ...string) error {
+		t.Helper()
+
+		parsed, err := parseCertPins(pins)
+		require.NoError(t, err)
+		resolver := &Resolver{
+			Info: &ResolverInfo{Type: ServerTypeDoT, Domain: "dns.example.com"},
+			pins: parsed,
+		}
+
+		// Skip the regular verification, as the certificate is self-signed.
+		conn, err := tls.Dial("tcp", address, resolver.addCertPinning(&tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         "dns.example.com",
+			InsecureSkipVerify: true, //nolint:gosec // Testing the pinning only.
+		}))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// Check that matching pins are accepted.
+	assert.NoError(t, handshake(spkiPin))
+	assert.NoError(t, handshake(certPin))
+
+	// Check that any of multiple pins may match, for rotation.
+	assert.NoError(t, handshake(otherPin, spkiPin))
+	assert.NoError(t, handshake(otherPin+","+certPin))
+
+	// Check that a mismatching pin fails the handshake.
+	err := handshake(otherPin)
+	assert.ErrorIs(t, err, ErrPinMismatch)
+	assert.ErrorIs(t, err, ErrFailure)
+	assert.ErrorIs(t, connectFailureReason(err), ErrPinMismatch)
+}
+
+func TestCertPinParameter(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	resolversLock.Lock()
+	defer resolversLock.Unlock()
+
+	hash := sha256.Sum256([]byte("pin"))
+	encoded := base64.StdEncoding.EncodeToString(hash[:])
+
+	// Check that multiple pins are parsed.
+	r, _, err := createResolver("dot://9.9.9.9:853?verify=dns.quad9.net&pin=spki:"+base64.URLEncoding.EncodeToString(hash[:])+"&pin=cert:"+encoded, ServerSourceConfigured)
+	require.NoError(t, err)
+	if assert.Len(t, r.pins, 2) {
+		assert.True(t, r.pins[0].spki)
+		assert.Equal(t, hash, r.pins[0].hash)
+		assert.False(t, r.pins[1].spki)
+		assert.Equal(t, hash, r.pins[1].hash)
+	}
+
+	// Check that pins are only supported by resolvers using TLS.
+	_, _, err = createResolver("dns://9.9.9.9:53?pin=spki:"+encoded, ServerSourceConfigured)
+	assert.Error(t, err)
+
+	// Check that invalid pins are rejected.
+	_, _, err = createResolver("dot://9.9.9.9:853?verify=dns.quad9.net&pin="+encoded, ServerSourceConfigured)
+	assert.Error(t, err)
+	_, _, err = createResolver("dot://9.9.9.9:853?verify=dns.quad9.net&pin=spki:AAAA", ServerSourceConfigured)
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+const (
+	prefetchCheckInterval = 10 * time.Second
+	prefetchDecayInterval = 10 * time.Minute
+
+	// prefetchMaxTracked limits the amount of tracked queries.
+	prefetchMaxTracked = 10000
+)
+
+type prefetchEntry struct {
+	q     *Query
+	count uint64
+}
+
+var (
+	prefetchCandidates     = make(map[string]*prefetchEntry)
+	prefetchCandidatesLock sync.Mutex
+)
+
+// recordPrefetchCandidate counts a query for the prefetcher.
+func recordPrefetchCandidate(q *Query) {
+	if prefetchDomains() <= 0 || q.NoCaching || q.CheckingDisabled || q.dnssecChainQuery {
+		return
+	}
+
+	prefetchCandidatesLock.Lock()
+	defer prefetchCandidatesLock.Unlock()
+
+	key := q.ID()
+	entry, ok := prefetchCandidates[key]
+	if !ok {
+		if len(prefetchCandidates) >= prefetchMaxTracked {
+			return
+		}
+		entry = &prefetchEntry{}
+		prefetchCandidates[key] = entry
+	}
+	entry.count++
+
+	// Save the latest query parameters, so that the compliance checks reflect
+	// the current settings.
+	entry.q = q.subQuery(q.FQDN, q.QType)
+	entry.q.ICANNSpace = q.ICANNSpace
+	entry.q.DomainRoot = q.DomainRoot
+	// Prefetches must query upstream in order to refresh the cache.
+	entry.q.CacheOnly = false
+	entry.q.check()
+}
+
+// getPrefetchCandidates returns the n most frequently queried queries.
+func getPrefetchCandidates(n int) []*Query {
+	prefetchCandidatesLock.Lock()
+	entries := make([]*prefetchEntry, 0, len(prefetchCandidates))
+	for _, entry := range prefetchCandidates {
+		entries = append(entries, entry)
+	}
+	prefetchCandidatesLock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].count > entries[j].count
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	queries := make([]*Query, 0, len(entries))
+	for _, entry := range entries {
+		queries = append(queries, entry.q)
+	}
+	return queries
+}
+
+// decayPrefetchCandidates halves all counters and removes queries that were
+// not requested recently.
+func decayPrefetchCandidates() {
+	prefetchCandidatesLock.Lock()
+	defer prefetchCandidatesLock.Unlock()
+
+	for key, entry := range prefetchCandidates {
+		entry.count /= 2
+		if entry.count == 0 {
+			delete(prefetchCandidates, key)
+		}
+	}
+}
+
+// prefetcher refreshes the records of the most frequently queried domains
+// shortly before they expire.
+func prefetcher(ctx context.Context) error {
+	checkTicker := time.NewTicker(prefetchCheckInterval)
+	defer checkTicker.Stop()
+	decayTicker := time.NewTicker(prefetchDecayInterval)
+	defer decayTicker.Stop()
+
+	for {
+		select {
+		case <-checkTicker.C:
+			prefetch(ctx)
+		case <-decayTicker.C:
+			decayPrefetchCandidates()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func prefetch(ctx context.Context) {
+	n := int(prefetchDomains())
+	if n <= 0 {
+		return
+	}
+
+	// Do not query failing resolvers while offline.
+	if netenv.GetOnlineStatus() == netenv.StatusOffline {
+		return
+	}
+
+	lead := int64(getTTLLimits(0).refresh) + prefetchLeadTime()
+	now := time.Now().Unix()
+	for _, q := range getPrefetchCandidates(n) {
+		if ctx.Err() != nil || module.IsStopping() {
+			return
+		}
+
+		// Check if the record expires soon.
+		rrCache, err := q.getCachedRRCache()
+		if err != nil || rrCache.Expires <= now || rrCache.Expires > now+lead {
+			continue
+		}
+
+		// Check if the query is still compliant with the current settings.
+		if err := q.checkCompliance(); err != nil {
+			continue
+		}
+
+		prefetchQuery(ctx, q)
+	}
+}
+
+func prefetchQuery(ctx context.Context, q *Query) {
+	tracingCtx, tracer := log.AddTracer(ctx)
+	defer tracer.Submit()
+
+	// Skip if the query is already being resolved.
+	markRequestFinished := deduplicateRequest(tracingCtx, q)
+	if markRequestFinished == nil {
+		return
+	}
+	defer markRequestFinished()
+
+	tracer.Tracef("resolver: prefetching %s", q.ID())
+	_, err := resolveAndCache(tracingCtx, q, nil)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrOffline), errors.Is(err, ErrShuttingDown):
+	default:
+		tracer.Debugf("resolver: failed to prefetch %s: %s", q.ID(), err)
+	}
+}
@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestPrefetchCandidates(t *testing.T) { //nolint:paralleltest // Changes global config.
+	newQuery := func(fqdn string) *Query {
+		return &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)}
+	}
+
+	// Check that nothing is tracked when prefetching is disabled.
+	recordPrefetchCandidate(newQuery("disabled.prefetch.example.com."))
+	assert.Empty(t, getPrefetchCandidates(10))
+
+	err := config.SetConfigOption(CfgOptionPrefetchDomainsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionPrefetchDomainsKey, 0)
+		prefetchCandidatesLock.Lock()
+		prefetchCandidates = make(map[string]*prefetchEntry)
+		prefetchCandidatesLock.Unlock()
+	}()
+
+	// Record queries with different frequency.
+	for i := 0; i < 5; i++ {
+		recordPrefetchCandidate(newQuery("popular.prefetch.example.com."))
+	}
+	for i := 0; i < 3; i++ {
+		recordPrefetchCandidate(newQuery("medium.prefetch.example.com."))
+	}
+	recordPrefetchCandidate(newQuery("rare.prefetch.example.com."))
+	noCaching := newQuery("nocaching.prefetch.example.com.")
+	noCaching.NoCaching = true
+	recordPrefetchCandidate(noCaching)
+
+	// Check that the most popular queries are returned.
+	candidates := getPrefetchCandidates(2)
+	if assert.Len(t, candidates, 2) {
+		assert.Equal(t, "popular.prefetch.example.com.", candidates[0].FQDN)
+		assert.Equal(t, "medium.prefetch.example.com.", candidates[1].FQDN)
+		assert.Equal(t, ".popular.prefetch.example.com.", candidates[0].dotPrefixedFQDN)
+	}
+	assert.Len(t, getPrefetchCandidates(10), 3)
+
+	// Check that rarely queried domains are removed.
+	decayPrefetchCandidates()
+	assert.Len(t, getPrefetchCandidates(10), 2)
+}
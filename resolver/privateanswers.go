@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// filterPrivateAnswers removes A and AAAA records pointing to the local host
+// or the local network from answers of public resolvers, if enabled. If no
+// records are left, the answer becomes a NODATA answer. Queries to local
+// resolvers only and answers of local resolvers are not changed.
+func filterPrivateAnswers(ctx context.Context, q *Query, rrCache *RRCache) {
+	if !stripPrivateAnswers(q.SecurityLevel) ||
+		q.LocalResolversOnly ||
+		!isPublicResolver(rrCache.Resolver) {
+		return
+	}
+
+	var removed int
+	answer := make([]dns.RR, 0, len(rrCache.Answer))
+	for _, rr := range rrCache.Answer {
+		if isPrivateAddressRecord(rr) {
+			removed++
+			continue
+		}
+		answer = append(answer, rr)
+	}
+	if removed == 0 {
+		return
+	}
+
+	log.Tracer(ctx).Infof("resolver: removed %d private IPs from answer for %s", removed, q.ID())
+	rrCache.Answer = answer
+	rrCache.addWarning("removed %d private IPs from the answer of a public resolver", removed)
+}
+
+// isPublicResolver returns whether the resolver is a DNS server on the
+// Internet.
+func isPublicResolver(info *ResolverInfo) bool {
+	if info == nil {
+		return false
+	}
+
+	switch info.Source {
+	case ServerSourceEnv, ServerSourceHosts, ServerSourceMDNS, ServerSourceRewrite:
+		return false
+	}
+	return info.IPScope.IsGlobal()
+}
+
+// isPrivateAddressRecord returns whether the record is an A or AAAA record
+// pointing to the local host or the local network.
+func isPrivateAddressRecord(rr dns.RR) bool {
+	var scope netutils.IPScope
+	switch v := rr.(type) {
+	case *dns.A:
+		scope = netutils.GetIPScope(v.A)
+	case *dns.AAAA:
+		scope = netutils.GetIPScope(v.AAAA)
+	default:
+		return false
+	}
+	return scope.IsLocalhost() || scope.IsLAN()
+}
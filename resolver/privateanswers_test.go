@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/status"
+)
+
+func TestFilterPrivateAnswers(t *testing.T) { //nolint:paralleltest // Changes global config.
+	require.NoError(t, config.SetConfigOption(CfgOptionStripPrivateAnswersKey, status.SecurityLevelsAll))
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionStripPrivateAnswersKey, status.SecurityLevelOff)
+	}()
+
+	publicResolver := &ResolverInfo{
+		Type:    ServerTypeDoT,
+		Source:  ServerSourceConfigured,
+		IP:      net.IPv4(9, 9, 9, 9),
+		IPScope: netutils.Global,
+	}
+	newRRCache := func(resolver *ResolverInfo, records ...string) *RRCache {
+		rrCache := &RRCache{
+			Domain:   "rebind.example.com.",
+			Question: dns.Type(dns.TypeA),
+			RCode:    dns.RcodeSuccess,
+			Resolver: resolver,
+		}
+		for _, record := range records {
+			rr, err := dns.NewRR(record)
+			require.NoError(t, err)
+			rrCache.Answer = append(rrCache.Answer, rr)
+		}
+		return rrCache
+	}
+	q := &Query{FQDN: "rebind.example.com.", QType: dns.Type(dns.TypeA), SecurityLevel: status.SecurityLevelNormal}
+
+	// Check that private IPs are removed from public answers.
+	rrCache := newRRCache(publicResolver,
+		"rebind.example.com. 60 IN A 127.0.0.1",
+		"rebind.example.com. 60 IN A 192.168.1.1",
+		"rebind.example.com. 60 IN A 1.1.1.1",
+	)
+	filterPrivateAnswers(context.Background(), q, rrCache)
+	if assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "1.1.1.1", rrCache.Answer[0].(*dns.A).A.String())
+	}
+	assert.NotEmpty(t, rrCache.Warnings)
+
+	// Check that an answer with only private IPs becomes NODATA.
+	rrCache = newRRCache(publicResolver,
+		"rebind.example.com. 60 IN A 127.0.0.1",
+		"rebind.example.com. 60 IN A 192.168.1.1",
+	)
+	filterPrivateAnswers(context.Background(), q, rrCache)
+	assert.Empty(t, rrCache.Answer)
+	assert.Equal(t, dns.RcodeSuccess, rrCache.RCode)
+
+	// Check that answers of local resolvers are not changed.
+	localResolver := &ResolverInfo{
+		Type:    ServerTypeDNS,
+		Source:  ServerSourceOperatingSystem,
+		IP:      net.IPv4(192, 168, 1, 1),
+		IPScope: netutils.SiteLocal,
+	}
+	rrCache = newRRCache(localResolver, "rebind.example.com. 60 IN A 192.168.1.1")
+	filterPrivateAnswers(context.Background(), q, rrCache)
+	assert.Len(t, rrCache.Answer, 1)
+
+	envResolver := &ResolverInfo{
+		Type:    ServerTypeEnv,
+		Source:  ServerSourceEnv,
+		IPScope: netutils.Global,
+	}
+	rrCache = newRRCache(envResolver, "rebind.example.com. 60 IN A 127.0.0.1")
+	filterPrivateAnswers(context.Background(), q, rrCache)
+	assert.Len(t, rrCache.Answer, 1)
+
+	// Check that queries to local resolvers only are not changed.
+	localQuery := &Query{FQDN: "rebind.example.com.", QType: dns.Type(dns.TypeA), LocalResolversOnly: true, SecurityLevel: status.SecurityLevelNormal}
+	rrCache = newRRCache(publicResolver, "rebind.example.com. 60 IN A 127.0.0.1")
+	filterPrivateAnswers(context.Background(), localQuery, rrCache)
+	assert.Len(t, rrCache.Answer, 1)
+
+	// Check that nothing is removed if disabled.
+	require.NoError(t, config.SetConfigOption(CfgOptionStripPrivateAnswersKey, status.SecurityLevelOff))
+	rrCache = newRRCache(publicResolver, "rebind.example.com. 60 IN A 127.0.0.1")
+	filterPrivateAnswers(context.Background(), q, rrCache)
+	assert.Len(t, rrCache.Answer, 1)
+}
+
+func TestFilterPrivateAnswersFromCache(t *testing.T) { //nolint:paralleltest // Changes global config and resolvers.
+	publicResolver := &Resolver{
+		Info: &ResolverInfo{
+			Type:    ServerTypeDoT,
+			Source:  ServerSourceConfigured,
+			IP:      net.IPv4(9, 9, 9, 10),
+			Port:    853,
+			IPScope: netutils.Global,
+		},
+	}
+	resolversLock.Lock()
+	activeResolvers[publicResolver.Info.ID()] = publicResolver
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		delete(activeResolvers, publicResolver.Info.ID())
+		resolversLock.Unlock()
+	}()
+
+	// Cache an answer while stripping private answers is disabled.
+	rr, err := dns.NewRR("cached-rebind.example.com. 3600 IN A 192.168.1.1")
+	require.NoError(t, err)
+	rrCache := &RRCache{
+		Domain:   "cached-rebind.example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{rr},
+		Expires:  time.Now().Unix() + 3600,
+		Resolver: publicResolver.Info.Copy(),
+	}
+	require.NoError(t, rrCache.Save())
+	q := &Query{FQDN: "cached-rebind.example.com.", QType: dns.Type(dns.TypeA), SecurityLevel: status.SecurityLevelNormal}
+	cached := checkCache(context.Background(), q)
+	require.NotNil(t, cached)
+	assert.Len(t, cached.Answer, 1)
+
+	// Check that private IPs are also removed from cached answers.
+	require.NoError(t, config.SetConfigOption(CfgOptionStripPrivateAnswersKey, status.SecurityLevelsAll))
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionStripPrivateAnswersKey, status.SecurityLevelOff)
+	}()
+	cached = checkCache(context.Background(), q)
+	require.NotNil(t, cached)
+	assert.Empty(t, cached.Answer)
+	assert.NotEmpty(t, cached.Warnings)
+}
@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// Supported proxy schemes.
+const (
+	proxySchemeSOCKS5  = "socks5"
+	proxySchemeSOCKS5H = "socks5h"
+)
+
+// proxySupported returns whether connections to resolvers of the given type
+// can be made via a proxy. SOCKS5 proxies are only used for TCP connections.
+func proxySupported(resolverType string) bool {
+	switch resolverType {
+	case ServerTypeTCP, ServerTypeDoT, ServerTypeDoH:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseProxyURL parses and checks the given proxy URL.
+// The proxy must be given by IP address, as resolving its domain would
+// require the resolver that is supposed to use the proxy.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", proxyURL, err)
+	}
+
+	switch u.Scheme {
+	case proxySchemeSOCKS5, proxySchemeSOCKS5H:
+	default:
+		return nil, fmt.Errorf("proxy scheme %q invalid, only %s is supported", u.Scheme, proxySchemeSOCKS5)
+	}
+	if net.ParseIP(u.Hostname()) == nil {
+		return nil, fmt.Errorf("proxy host %q must be an IP address", u.Hostname())
+	}
+
+	return u, nil
+}
+
+// getProxy returns the proxy to connect to the resolver with, or nil if the
+// resolver is connected to directly.
+func (resolver *Resolver) getProxy() (*url.URL, error) {
+	switch {
+	case resolver.proxy != nil:
+		return resolver.proxy, nil
+	case !proxySupported(resolver.Info.Type):
+		return nil, nil
+	}
+
+	// Fall back to the global proxy.
+	globalProxy := upstreamProxy()
+	if globalProxy == "" {
+		return nil, nil
+	}
+	return parseProxyURL(globalProxy)
+}
+
+// dialServer connects to the given address of the resolver using the given
+// dialer. If a proxy is configured, the connection is made via the proxy.
+// If the resolver is bound to a source, the connection to the server or proxy
+// is made from it. The context bounds the whole dial, including the proxy
+// handshake.
+func (resolver *Resolver) dialServer(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	proxyURL, err := resolver.getProxy()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get proxy for %s: %s", ErrFailure, resolver.Info.DescriptiveName(), err)
+	}
+	if proxyURL == nil {
+		dialer, err = resolver.bindDialer(dialer, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	dialer, err = resolver.bindDialer(dialer, network, proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyDialer, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create proxy dialer for %s: %s", ErrFailure, resolver.Info.DescriptiveName(), err)
+	}
+	contextDialer, ok := proxyDialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("%w: proxy dialer for %s does not support contexts", ErrFailure, resolver.Info.DescriptiveName())
+	}
+
+	conn, err := contextDialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, proxyDialError(ctx, resolver, err)
+	}
+	return conn, nil
+}
+
+// proxyDialError converts an error of dialing via a proxy into an error that
+// is handled by resolver failover.
+func proxyDialError(ctx context.Context, resolver *Resolver, err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return ctx.Err()
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: failed to connect to %s via proxy: %s", ErrTimeout, resolver.Info.DescriptiveName(), err)
+	default:
+		return fmt.Errorf("%w: failed to connect to %s via proxy: %s", connectFailureReason(err), resolver.Info.DescriptiveName(), err)
+	}
+}
@@ -0,0 +1,234 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSOCKS5Server is a minimal SOCKS5 server that supports unauthenticated
+// CONNECT requests to IPv4 addresses.
+type testSOCKS5Server struct {
+	listener net.Listener
+
+	// stall makes the server accept connections without ever answering.
+	stall bool
+	// refuse makes the server reply to CONNECT requests with a failure.
+	refuse bool
+
+	lock    sync.Mutex
+	targets []string
+}
+
+func newTestSOCKS5Server(t *testing.T) *testSOCKS5Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	server := &testSOCKS5Server{listener: listener}
+	go server.serve()
+	return server
+}
+
+func (s *testSOCKS5Server) URL() string {
+	return "socks5://" + s.listener.Addr().String()
+}
+
+func (s *testSOCKS5Server) Targets() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.targets...)
+}
+
+func (s *testSOCKS5Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testSOCKS5Server) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if s.stall {
+		_, _ = io.Copy(io.Discard, conn)
+		return
+	}
+
+	// Read greeting and select "no authentication".
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// Read CONNECT request for an IPv4 address.
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(conn, request); err != nil || request[3] != 1 {
+		return
+	}
+	target := net.JoinHostPort(
+		net.IP(request[4:8]).String(),
+		strconv.Itoa(int(binary.BigEndian.Uint16(request[8:10]))),
+	)
+	s.lock.Lock()
+	s.targets = append(s.targets, target)
+	s.lock.Unlock()
+
+	if s.refuse {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() {
+		_ = upstream.Close()
+	}()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func startTestTCPDNSServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(request)
+			rr, _ := dns.NewRR(request.Question[0].Name + " 60 IN A 192.0.2.1")
+			reply.Answer = append(reply.Answer, rr)
+			_ = w.WriteMsg(reply)
+		}),
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	return listener.Addr().String()
+}
+
+func TestProxyQuery(t *testing.T) {
+	t.Parallel()
+
+	proxyServer := newTestSOCKS5Server(t)
+	serverAddress := startTestTCPDNSServer(t)
+
+	resolversLock.Lock()
+	resolver, _, err := createResolver("tcp://"+serverAddress+"?proxy="+proxyServer.URL(), ServerSourceConfigured)
+	resolversLock.Unlock()
+	require.NoError(t, err)
+
+	// Check that the query is answered via the proxy.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rrCache, err := resolver.Conn.Query(ctx, &Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA)})
+	require.NoError(t, err)
+	if assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "192.0.2.1", rrCache.Answer[0].(*dns.A).A.String())
+	}
+	assert.Equal(t, []string{serverAddress}, proxyServer.Targets())
+}
+
+func TestProxyDialErrors(t *testing.T) {
+	t.Parallel()
+
+	dial := func(ctx context.Context, proxyServer *testSOCKS5Server) error {
+		resolversLock.Lock()
+		resolver, _, err := createResolver("tcp://192.0.2.53:53?proxy="+proxyServer.URL(), ServerSourceConfigured)
+		resolversLock.Unlock()
+		require.NoError(t, err)
+
+		conn, err := resolver.dialServer(ctx, &net.Dialer{}, "tcp", resolver.ServerAddress)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+
+	// Check that refused connections are reported as failures.
+	refusingProxy := newTestSOCKS5Server(t)
+	refusingProxy.refuse = true
+	err := dial(context.Background(), refusingProxy)
+	assert.ErrorIs(t, err, ErrFailure)
+	assert.Equal(t, []string{"192.0.2.53:53"}, refusingProxy.Targets())
+
+	// Check that the context deadline bounds the proxied dial.
+	stallingProxy := newTestSOCKS5Server(t)
+	stallingProxy.stall = true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = dial(ctx, stallingProxy)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, time.Since(started), 2*time.Second)
+
+	// Check that canceled queries are not reported as failures.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = dial(ctx, stallingProxy)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrFailure)
+}
+
+func TestProxyParameter(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	resolversLock.Lock()
+	defer resolversLock.Unlock()
+
+	r, _, err := createResolver("dot://9.9.9.9:853?verify=dns.quad9.net&proxy=socks5://127.0.0.1:1080", ServerSourceConfigured)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:1080", r.proxy.Host)
+
+	// Check that UDP based resolvers cannot use a proxy.
+	_, _, err = createResolver("dns://9.9.9.9:53?proxy=socks5://127.0.0.1:1080", ServerSourceConfigured)
+	assert.Error(t, err)
+	_, _, err = createResolver("doq://94.140.14.14?verify=dns.adguard-dns.com&proxy=socks5://127.0.0.1:1080", ServerSourceConfigured)
+	assert.Error(t, err)
+
+	// Check that invalid proxies are rejected.
+	_, _, err = createResolver("tcp://9.9.9.9:53?proxy=http://127.0.0.1:8080", ServerSourceConfigured)
+	assert.Error(t, err)
+	_, _, err = createResolver("tcp://9.9.9.9:53?proxy=socks5://proxy.example.com:1080", ServerSourceConfigured)
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/rng"
+)
+
+// randomizeQNameCase randomly changes the case of all letters in the given
+// name, as described in draft-vixie-dnsext-dns0x20. This adds entropy to
+// queries, which makes off-path spoofing of responses harder.
+func randomizeQNameCase(name string) string {
+	randomBits, err := rng.Bytes(len(name)/8 + 1)
+	if err != nil {
+		return name
+	}
+
+	randomized := []byte(name)
+	for i, c := range randomized {
+		if randomBits[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		switch {
+		case c >= 'a' && c <= 'z':
+			randomized[i] = c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z':
+			randomized[i] = c - 'A' + 'a'
+		}
+	}
+	return string(randomized)
+}
+
+// checkQNameCase checks if the reply repeats the question name exactly as
+// sent, in order to detect spoofed responses when the case was randomized.
+// Afterwards, the names in the reply are restored to the name of the query.
+func checkQNameCase(q *Query, sentName string, reply *dns.Msg) error {
+	// Nothing to check if the case was not randomized.
+	if sentName == q.FQDN {
+		return nil
+	}
+
+	if len(reply.Question) == 0 || reply.Question[0].Name != sentName {
+		return fmt.Errorf("%w: question name case mismatch in response, it might be spoofed", ErrFailure)
+	}
+
+	// Restore names, so that the randomized case does not leak into the cache.
+	reply.Question[0].Name = q.FQDN
+	for _, section := range [][]dns.RR{reply.Answer, reply.Ns, reply.Extra} {
+		for _, rr := range section {
+			if rr.Header().Name == sentName {
+				rr.Header().Name = q.FQDN
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package resolver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestQNameCase(t *testing.T) { //nolint:paralleltest // Changes global config.
+	q := &Query{
+		FQDN:  "abcdefghijklmnopqrstuvwxyz.abcdefghijklmnopqrstuvwxyz.example.com.",
+		QType: dns.Type(dns.TypeA),
+	}
+
+	// Check that the case is only randomized when enabled.
+	assert.Equal(t, q.FQDN, q.newDNSRequest().Question[0].Name)
+	err := config.SetConfigOption(CfgOptionUse0x20Key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionUse0x20Key, false)
+	}()
+	sentName := q.newDNSRequest().Question[0].Name
+	assert.NotEqual(t, q.FQDN, sentName)
+	assert.True(t, strings.EqualFold(q.FQDN, sentName))
+
+	// Check that a response with a different case is rejected.
+	spoofed := new(dns.Msg)
+	spoofed.SetQuestion(q.FQDN, dns.TypeA)
+	assert.ErrorIs(t, checkQNameCase(q, sentName, spoofed), ErrFailure)
+
+	// Check that a matching response is accepted and the names are restored.
+	reply := new(dns.Msg)
+	reply.SetQuestion(sentName, dns.TypeA)
+	rr, err := dns.NewRR(sentName + " 60 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.Answer = append(reply.Answer, rr)
+	if assert.NoError(t, checkQNameCase(q, sentName, reply)) {
+		assert.Equal(t, q.FQDN, reply.Question[0].Name)
+		assert.Equal(t, q.FQDN, reply.Answer[0].Header().Name)
+	}
+}
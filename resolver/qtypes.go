@@ -0,0 +1,74 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// errMinimalANYResponse is returned by the compliance check when an ANY query
+// should be answered with a minimal response.
+var errMinimalANYResponse = errors.New("minimal ANY response")
+
+// minimalANYResponseTTL is the TTL of the synthesized HINFO record.
+const minimalANYResponseTTL = 3600
+
+// defaultPermittedQueryTypes holds the query types that are permitted by
+// default when query types are limited. It includes the types required for
+// DNSSEC validation and the service binding types used by browsers.
+var defaultPermittedQueryTypes = []string{
+	"A",
+	"AAAA",
+	"CNAME",
+	"MX",
+	"NS",
+	"PTR",
+	"SOA",
+	"SRV",
+	"TXT",
+	"CAA",
+	"SVCB",
+	"HTTPS",
+	"DS",
+	"DNSKEY",
+	"RRSIG",
+	"NSEC",
+	"NSEC3",
+}
+
+// queryTypePermitted returns whether the given query type is in the list of
+// permitted query types.
+func queryTypePermitted(qType dns.Type) bool {
+	name := qType.String()
+	generic := fmt.Sprintf("TYPE%d", qType)
+	for _, permitted := range permittedQueryTypes() {
+		if strings.EqualFold(permitted, name) || strings.EqualFold(permitted, generic) {
+			return true
+		}
+	}
+	return false
+}
+
+// minimalANYResponse returns a synthesized response to an ANY query as
+// described in RFC8482, Section 4.2.
+func minimalANYResponse(q *Query) *RRCache {
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Answer: []dns.RR{&dns.HINFO{
+			Hdr: dns.RR_Header{
+				Name:   q.FQDN,
+				Rrtype: dns.TypeHINFO,
+				Class:  dns.ClassINET,
+				Ttl:    minimalANYResponseTTL,
+			},
+			Cpu: "RFC8482",
+		}},
+		Expires:  time.Now().Add(minimalANYResponseTTL * time.Second).Unix(),
+		Resolver: envResolver.Info.Copy(),
+	}
+}
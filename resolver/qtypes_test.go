@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/status"
+)
+
+func TestQueryTypeCompliance(t *testing.T) { //nolint:paralleltest // Changes global config.
+	check := func(qType uint16, securityLevel uint8) error {
+		q := &Query{
+			FQDN:          "example.com.",
+			QType:         dns.Type(qType),
+			SecurityLevel: securityLevel,
+		}
+		if !q.check() {
+			t.Fatal("invalid query")
+		}
+		return q.checkCompliance()
+	}
+
+	// Rare query types are refused at a high security level.
+	assert.ErrorIs(t, check(dns.TypeANY, status.SecurityLevelHigh), ErrQTypeBlocked)
+	assert.ErrorIs(t, check(dns.TypeAXFR, status.SecurityLevelHigh), ErrQTypeBlocked)
+	assert.ErrorIs(t, check(dns.TypeNAPTR, status.SecurityLevelHigh), ErrBlocked)
+	assert.NoError(t, check(dns.TypeA, status.SecurityLevelHigh))
+	assert.NoError(t, check(dns.TypeHTTPS, status.SecurityLevelHigh))
+	assert.NoError(t, check(dns.TypeSVCB, status.SecurityLevelHigh))
+	assert.NoError(t, check(dns.TypeDNSKEY, status.SecurityLevelHigh))
+
+	// Generic type notation is supported.
+	err := config.SetConfigOption(CfgOptionPermittedQueryTypesKey, []string{"A", "TYPE65"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionPermittedQueryTypesKey, defaultPermittedQueryTypes)
+	}()
+	assert.NoError(t, check(dns.TypeHTTPS, status.SecurityLevelHigh))
+	assert.ErrorIs(t, check(dns.TypeSVCB, status.SecurityLevelHigh), ErrQTypeBlocked)
+	assert.ErrorIs(t, check(dns.TypeMX, status.SecurityLevelHigh), ErrQTypeBlocked)
+
+	// ANY queries are answered minimally, if enabled.
+	err = config.SetConfigOption(CfgOptionMinimalANYResponsesKey, status.SecurityLevelsAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionMinimalANYResponsesKey, status.SecurityLevelOff)
+	}()
+	rrCache, err := Resolve(context.Background(), &Query{
+		FQDN:          "example.com.",
+		QType:         dns.Type(dns.TypeANY),
+		SecurityLevel: status.SecurityLevelHigh,
+	})
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 1) {
+		hinfo, ok := rrCache.Answer[0].(*dns.HINFO)
+		if assert.True(t, ok) {
+			assert.Equal(t, "RFC8482", hinfo.Cpu)
+		}
+	}
+}
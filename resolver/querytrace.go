@@ -0,0 +1,279 @@
+package resolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// queryTraceBufferSize defines how many query traces are kept.
+	queryTraceBufferSize = 1000
+	// maxQueryTraceHops limits how many hops are recorded per query trace.
+	maxQueryTraceHops = 64
+)
+
+// Cache decisions recorded in query traces.
+const (
+	TraceCacheDisabled = "disabled"
+	TraceCacheMiss     = "miss"
+	TraceCacheHit      = "hit"
+	TraceCacheExpiring = "expiring"
+	TraceCacheStale    = "stale"
+	TraceCacheExpired  = "expired"
+)
+
+// QueryTrace holds a structured trace of a resolved query.
+type QueryTrace struct {
+	FQDN     string
+	QType    string
+	Started  time.Time
+	Duration time.Duration
+
+	// Cache holds what the cache could provide for the query.
+	Cache string
+	// Deduplicated signifies that the query waited for a duplicate query.
+	Deduplicated bool
+	// Hops holds every query sent to a resolver, including CNAME hops.
+	Hops []QueryTraceHop
+
+	// RCode holds the response code of the final answer.
+	RCode string
+	// Resolver holds the ID of the resolver that provided the final answer.
+	Resolver string
+	// Error holds the error of the query, if it failed.
+	Error string
+
+	lock sync.Mutex
+}
+
+// QueryTraceHop holds the trace of a single query to a resolver.
+type QueryTraceHop struct {
+	FQDN     string
+	Resolver string
+	Started  time.Time
+	Duration time.Duration
+	RCode    string
+	Error    string
+	// Skipped signifies that the resolver was skipped, because its circuit was
+	// open.
+	Skipped bool
+}
+
+type queryTraceContextKey struct{}
+
+var (
+	queryTraces      [queryTraceBufferSize]*QueryTrace
+	queryTracesNext  int
+	queryTracesCount int
+	queryTracesLock  sync.Mutex
+)
+
+// addQueryTrace adds a query trace to the context, if query tracing is
+// enabled. If the context already holds a query trace, for example when
+// resolving CNAME hops, it is reused and the returned trace is nil, as only
+// the creator of a trace may submit it.
+func addQueryTrace(ctx context.Context, q *Query) (context.Context, *QueryTrace) {
+	if !queryTracing() || getQueryTrace(ctx) != nil {
+		return ctx, nil
+	}
+
+	trace := &QueryTrace{
+		FQDN:    q.FQDN,
+		QType:   q.QType.String(),
+		Started: time.Now(),
+	}
+	return context.WithValue(ctx, queryTraceContextKey{}, trace), trace
+}
+
+// getQueryTrace returns the query trace of the context, if there is one.
+func getQueryTrace(ctx context.Context) *QueryTrace {
+	trace, _ := ctx.Value(queryTraceContextKey{}).(*QueryTrace)
+	return trace
+}
+
+// setCache records the cache decision. The last decision is kept, as the
+// cache is checked again after waiting for a duplicate query.
+func (trace *QueryTrace) setCache(decision string) {
+	if trace == nil {
+		return
+	}
+
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+
+	trace.Cache = decision
+}
+
+// traceCacheDecision returns the cache decision for the given cache lookup
+// result.
+func traceCacheDecision(rrCache *RRCache) string {
+	switch {
+	case rrCache == nil:
+		return TraceCacheMiss
+	case rrCache.ServedStale:
+		return TraceCacheStale
+	case rrCache.Expired():
+		return TraceCacheExpired
+	case rrCache.RequestingNew:
+		return TraceCacheExpiring
+	default:
+		return TraceCacheHit
+	}
+}
+
+// markDeduplicated records that the query waited for a duplicate query.
+func (trace *QueryTrace) markDeduplicated() {
+	if trace == nil {
+		return
+	}
+
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+
+	trace.Deduplicated = true
+}
+
+// addHop records a query to a resolver.
+func (trace *QueryTrace) addHop(q *Query, resolver *Resolver, started time.Time, rrCache *RRCache, err error) {
+	if trace == nil {
+		return
+	}
+
+	hop := QueryTraceHop{
+		FQDN:     q.FQDN,
+		Resolver: resolver.Info.ID(),
+		Started:  started,
+		Duration: time.Since(started),
+	}
+	if rrCache != nil {
+		hop.RCode = dns.RcodeToString[rrCache.RCode]
+	}
+	if err != nil {
+		hop.Error = err.Error()
+	}
+	trace.appendHop(hop)
+}
+
+// addSkippedHop records that a resolver was skipped.
+func (trace *QueryTrace) addSkippedHop(q *Query, resolver *Resolver) {
+	if trace == nil {
+		return
+	}
+
+	trace.appendHop(QueryTraceHop{
+		FQDN:     q.FQDN,
+		Resolver: resolver.Info.ID(),
+		Started:  time.Now(),
+		Skipped:  true,
+	})
+}
+
+func (trace *QueryTrace) appendHop(hop QueryTraceHop) {
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+
+	if len(trace.Hops) < maxQueryTraceHops {
+		trace.Hops = append(trace.Hops, hop)
+	}
+}
+
+// submit records the outcome of the query and adds the trace to the buffer.
+func (trace *QueryTrace) submit(rrCache *RRCache, err error) {
+	if trace == nil {
+		return
+	}
+
+	trace.lock.Lock()
+	trace.Duration = time.Since(trace.Started)
+	if rrCache != nil {
+		trace.RCode = dns.RcodeToString[rrCache.RCode]
+		if rrCache.Resolver != nil {
+			trace.Resolver = rrCache.Resolver.ID()
+		}
+	}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	if trace.Cache == "" {
+		trace.Cache = TraceCacheDisabled
+	}
+	if queryTracingHashDomains() {
+		trace.hashDomains()
+	}
+	trace.lock.Unlock()
+
+	queryTracesLock.Lock()
+	defer queryTracesLock.Unlock()
+
+	queryTraces[queryTracesNext] = trace
+	queryTracesNext = (queryTracesNext + 1) % queryTraceBufferSize
+	if queryTracesCount < queryTraceBufferSize {
+		queryTracesCount++
+	}
+}
+
+// hashDomains replaces all queried domains in the trace with their hash.
+// The lock must be held.
+func (trace *QueryTrace) hashDomains() {
+	fqdn := trace.FQDN
+	trace.FQDN = hashDomain(fqdn)
+	trace.Error = strings.ReplaceAll(trace.Error, fqdn, trace.FQDN)
+
+	for i := range trace.Hops {
+		hop := &trace.Hops[i]
+		hopFQDN := hop.FQDN
+		hop.FQDN = hashDomain(hopFQDN)
+		hop.Error = strings.ReplaceAll(hop.Error, hopFQDN, hop.FQDN)
+	}
+}
+
+func hashDomain(fqdn string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(fqdn)))
+	return hex.EncodeToString(sum[:])
+}
+
+// copy returns a copy of the trace.
+func (trace *QueryTrace) copy() *QueryTrace {
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+
+	return &QueryTrace{
+		FQDN:         trace.FQDN,
+		QType:        trace.QType,
+		Started:      trace.Started,
+		Duration:     trace.Duration,
+		Cache:        trace.Cache,
+		Deduplicated: trace.Deduplicated,
+		Hops:         append([]QueryTraceHop(nil), trace.Hops...),
+		RCode:        trace.RCode,
+		Resolver:     trace.Resolver,
+		Error:        trace.Error,
+	}
+}
+
+// RecentQueries returns the traces of the last n resolved queries, newest
+// first. Queries are only traced if query tracing is enabled.
+func RecentQueries(n int) []*QueryTrace {
+	queryTracesLock.Lock()
+	defer queryTracesLock.Unlock()
+
+	if n > queryTracesCount {
+		n = queryTracesCount
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	traces := make([]*QueryTrace, 0, n)
+	for i := 1; i <= n; i++ {
+		trace := queryTraces[(queryTracesNext-i+queryTraceBufferSize)%queryTraceBufferSize]
+		traces = append(traces, trace.copy())
+	}
+	return traces
+}
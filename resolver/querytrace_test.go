@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestQueryTracing(t *testing.T) { //nolint:paralleltest // Changes global config.
+	q := &Query{FQDN: "trace.example.com.", QType: dns.Type(dns.TypeA)}
+	resolver := &Resolver{Info: &ResolverInfo{Type: ServerTypeDNS, IP: []byte{192, 0, 2, 1}, Port: 53}}
+
+	// Check that nothing is traced by default.
+	ctx, trace := addQueryTrace(context.Background(), q)
+	assert.Nil(t, trace)
+	assert.Nil(t, getQueryTrace(ctx))
+
+	// Enable tracing.
+	err := config.SetConfigOption(CfgOptionQueryTracingKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionQueryTracingKey, false)
+		_ = config.SetConfigOption(CfgOptionQueryTracingHashDomainsKey, false)
+	}()
+
+	// Trace a query.
+	ctx, trace = addQueryTrace(context.Background(), q)
+	if !assert.NotNil(t, trace) {
+		return
+	}
+	_, nested := addQueryTrace(ctx, q)
+	assert.Nil(t, nested, "nested queries should reuse the trace")
+	getQueryTrace(ctx).setCache(traceCacheDecision(nil))
+	trace.addSkippedHop(q, resolver)
+	trace.addHop(q, resolver, time.Now(), nil, errors.New("query for trace.example.com. failed"))
+	trace.addHop(q, resolver, time.Now(), &RRCache{RCode: dns.RcodeSuccess}, nil)
+	trace.submit(&RRCache{RCode: dns.RcodeSuccess, Resolver: resolver.Info}, nil)
+
+	traces := RecentQueries(1)
+	if assert.Len(t, traces, 1) {
+		assert.Equal(t, "trace.example.com.", traces[0].FQDN)
+		assert.Equal(t, TraceCacheMiss, traces[0].Cache)
+		assert.Equal(t, "NOERROR", traces[0].RCode)
+		assert.Equal(t, resolver.Info.ID(), traces[0].Resolver)
+		if assert.Len(t, traces[0].Hops, 3) {
+			assert.True(t, traces[0].Hops[0].Skipped)
+			assert.NotEmpty(t, traces[0].Hops[1].Error)
+			assert.Equal(t, "NOERROR", traces[0].Hops[2].RCode)
+		}
+	}
+
+	// Check that the domain is hashed, if enabled.
+	err = config.SetConfigOption(CfgOptionQueryTracingHashDomainsKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, trace = addQueryTrace(context.Background(), q)
+	trace.submit(nil, errors.New("query for trace.example.com. failed"))
+	traces = RecentQueries(2)
+	if assert.Len(t, traces, 2) {
+		assert.Equal(t, hashDomain(q.FQDN), traces[0].FQDN)
+		assert.NotContains(t, traces[0].Error, q.FQDN)
+		assert.Equal(t, TraceCacheDisabled, traces[0].Cache)
+		assert.Equal(t, q.FQDN, traces[1].FQDN)
+	}
+
+	// Check that the buffer is bounded.
+	for i := 0; i < queryTraceBufferSize+10; i++ {
+		_, trace = addQueryTrace(context.Background(), q)
+		trace.submit(nil, nil)
+	}
+	assert.Len(t, RecentQueries(queryTraceBufferSize*2), queryTraceBufferSize)
+}
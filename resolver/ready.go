@@ -0,0 +1,33 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/safing/portmaster/netenv"
+)
+
+// readyProbeInterval defines the pause between readiness probes.
+const readyProbeInterval = 2 * time.Second
+
+// Ready waits until at least one compliant resolver answers a probe query for
+// the DNS test domain, or the context is canceled. Probes do not mark
+// resolvers as failing, so that resolvers that are still warming up are not
+// penalized.
+func Ready(ctx context.Context) error {
+	for {
+		_, ok, err := probeConnectivity(ctx, netenv.DNSTestDomain, true)
+		if ok && !errors.Is(err, ErrNoCompliance) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-module.Stopping():
+			return ErrShuttingDown
+		case <-time.After(readyProbeInterval):
+		}
+	}
+}
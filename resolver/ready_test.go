@@ -0,0 +1,108 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProbeConn struct {
+	BasicResolverConn
+
+	fail     atomic.Bool
+	failErr  error
+	rcode    int
+	failures atomic.Int32
+}
+
+func (tpc *testProbeConn) Query(_ context.Context, q *Query) (*RRCache, error) {
+	if tpc.fail.Load() {
+		if tpc.failErr != nil {
+			return nil, tpc.failErr
+		}
+		return nil, ErrTimeout
+	}
+
+	rr, err := dns.NewRR(q.FQDN + " 60 IN A 192.0.2.1")
+	if err != nil {
+		return nil, err
+	}
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    tpc.rcode,
+		Answer:   []dns.RR{rr},
+		Resolver: tpc.resolver.Info.Copy(),
+	}, nil
+}
+
+func (tpc *testProbeConn) ReportFailure() {
+	tpc.failures.Add(1)
+}
+
+func newTestProbeResolver(source string, ip net.IP) (*testProbeConn, *Resolver) {
+	conn := &testProbeConn{}
+	conn.fail.Store(true)
+	testResolver := &Resolver{
+		Info: &ResolverInfo{Type: ServerTypeDNS, Source: source, IP: ip, Port: 53},
+		Conn: conn,
+	}
+	conn.resolver = testResolver
+	conn.init()
+	return conn, testResolver
+}
+
+func TestReady(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, testResolver := newTestProbeResolver(ServerSourceConfigured, net.IPv4(192, 0, 2, 54))
+
+	resolversLock.Lock()
+	previousGlobalResolvers := globalResolvers
+	globalResolvers = []*Resolver{testResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		globalResolvers = previousGlobalResolvers
+		resolversLock.Unlock()
+	}()
+
+	// Check that Ready waits while no resolver answers.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, Ready(ctx), context.DeadlineExceeded)
+
+	// Check that an answering resolver is detected.
+	conn.fail.Store(false)
+	assert.NoError(t, Ready(context.Background()))
+}
+
+func TestProbeDoesNotReportFailure(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	// Use an internal domain, so that the online status checks running in the
+	// background do not query the test resolver.
+	// Fail with an error that is reported regardless of the online status.
+	conn, testResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 55))
+	conn.failErr = ErrBootstrapFailed
+
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{testResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		resolversLock.Unlock()
+	}()
+
+	fqdn := "probe." + InternalSpecialUseDomain
+	_, ok, _ := probeConnectivity(context.Background(), fqdn, true)
+	assert.False(t, ok)
+	assert.Equal(t, int32(0), conn.failures.Load())
+
+	_, ok, _ = probeConnectivity(context.Background(), fqdn, false)
+	assert.False(t, ok)
+	assert.NotEqual(t, int32(0), conn.failures.Load())
+}
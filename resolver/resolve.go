@@ -48,13 +48,30 @@
 	ErrInvalid = fmt.Errorf("%w: invalid request", ErrNotFound)
 	// ErrNoCompliance wraps ErrBlocked and is returned when no resolvers were able to comply with the current settings.
 	ErrNoCompliance = fmt.Errorf("%w: no compliant resolvers for this query", ErrBlocked)
+	// ErrBogus wraps ErrBlocked and is returned when DNSSEC validation failed.
+	ErrBogus = fmt.Errorf("%w: dnssec validation failed", ErrBlocked)
+	// ErrQTypeBlocked wraps ErrBlocked and is returned when the query type is not permitted.
+	ErrQTypeBlocked = fmt.Errorf("%w: query type not permitted", ErrBlocked)
+	// ErrBootstrapFailed wraps ErrFailure and is returned when the domain of a DNS server could not be resolved via the bootstrap servers.
+	ErrBootstrapFailed = fmt.Errorf("%w: failed to bootstrap DNS server", ErrFailure)
+	// ErrRefused wraps ErrContinue and is returned when a resolver refused to answer a query.
+	ErrRefused = fmt.Errorf("%w: query refused", ErrContinue)
+	// ErrServerFailure wraps ErrFailure and is returned when a resolver failed to answer a query.
+	ErrServerFailure = fmt.Errorf("%w: server failure", ErrFailure)
+	// ErrSourceUnavailable wraps ErrOffline and is returned when the source address or interface a resolver is bound to is not available.
+	ErrSourceUnavailable = fmt.Errorf("%w: source of resolver not available", ErrOffline)
+	// ErrDryRun wraps ErrInvalid and is returned when resolving a dry run query.
+	ErrDryRun = fmt.Errorf("%w: dry run queries must be planned with PlanResolve", ErrInvalid)
 )
 
 const (
-	minTTL     = 60 // 1 Minute
-	refreshTTL = minTTL / 2
-	minMDnsTTL = 60           // 1 Minute
-	maxTTL     = 24 * 60 * 60 // 24 hours
+	defaultMinTTL     = 60 // 1 Minute
+	defaultRefreshTTL = defaultMinTTL / 2
+	defaultMaxTTL     = 24 * 60 * 60 // 24 hours
+	minMDnsTTL        = 60           // 1 Minute
+	maxTTLSetting     = 7 * 24 * 60 * 60
+
+	maxRRCacheWarnings = 10
 )
 
 var (
@@ -72,9 +89,14 @@ type dedupeStatus struct {
 // has been blocked by the upstream server.
 type BlockedUpstreamError struct {
 	ResolverName string
+	// ExtendedError holds the extended DNS error of the response, if any.
+	ExtendedError *dns.EDNS0_EDE
 }
 
 func (blocked *BlockedUpstreamError) Error() string {
+	if blocked.ExtendedError != nil {
+		return fmt.Sprintf("%s by upstream DNS resolver %s: %s", ErrBlocked, blocked.ResolverName, describeExtendedError(blocked.ExtendedError))
+	}
 	return fmt.Sprintf("%s by upstream DNS resolver %s", ErrBlocked, blocked.ResolverName)
 }
 
@@ -91,6 +113,52 @@ type Query struct {
 	NoCaching          bool
 	IgnoreFailing      bool
 	LocalResolversOnly bool
+	// FlattenCNAME follows CNAME chains of A, AAAA, SVCB and HTTPS queries and
+	// returns the final records together with the intermediate CNAMEs.
+	FlattenCNAME bool
+	// ValidateDNSSEC requests DNSSEC validation regardless of the security level.
+	ValidateDNSSEC bool
+	// CheckingDisabled signifies that the client does its own DNSSEC validation.
+	// DNSSEC records are requested with the CD bit set, no validation is done
+	// and the answer is neither marked as authenticated nor cached.
+	CheckingDisabled bool
+	// StripDNSSECRecords signifies that the client did not set the DO bit.
+	// RRSIG, NSEC and NSEC3 records are removed from the returned answer,
+	// unless they were queried for. The validation result is kept and the
+	// full answer is still cached.
+	StripDNSSECRecords bool
+	// DryRun signifies that the query must not be sent or cached. Use
+	// PlanResolve to get a description of what would happen instead.
+	DryRun bool
+	// ECSNetwork is the client subnet to send upstream with the EDNS Client
+	// Subnet option. It is truncated to the configured prefix lengths.
+	ECSNetwork *net.IPNet
+	// ClientScope separates cached answers of different clients. Queries with
+	// the same client scope share the cache and deduplication.
+	ClientScope string
+	// PerResolverTimeout limits how long each resolver may take to answer. When
+	// exceeded, the resolver is treated as timed out and the next resolver is
+	// asked. Zero means that only the context limits the query.
+	PerResolverTimeout time.Duration
+	// Parallelism is the amount of resolvers that are queried at the same
+	// time. The first successful answer is used and the queries to the other
+	// resolvers are cancelled. Zero or one means that resolvers are queried
+	// one after another.
+	Parallelism int
+	// ForceResolverID pins the query to the active resolver with this ID. The
+	// resolver is queried even if it is failing, but must still comply with
+	// the query. Forced queries are never cached.
+	ForceResolverID string
+	// DedupeWait limits how long the query waits for an in-flight duplicate
+	// query to complete. When exceeded, the query is sent independently,
+	// without replacing the in-flight query. Zero means that the global
+	// request timeout is used.
+	DedupeWait time.Duration
+	// CacheOnly signifies that the query must only be answered from the cache,
+	// including stale entries within the serve stale window. If there is no
+	// usable entry, ErrNotFound is returned instead of querying upstream.
+	// Refreshing cached entries in the background is still permitted.
+	CacheOnly bool
 
 	// ICANNSpace signifies if the domain is within ICANN managed domain space.
 	ICANNSpace bool
@@ -99,46 +167,127 @@ type Query struct {
 
 	// internal
 	dotPrefixedFQDN string
+	// unqualifiedFQDN holds the original name of a single-label query that was
+	// qualified with another domain.
+	unqualifiedFQDN string
+	// dnssecChainQuery is set for queries that chase the chain of trust.
+	dnssecChainQuery bool
+	// probe is set for readiness probes, which must not mark resolvers as
+	// failing.
+	probe bool
 }
 
 // ID returns the ID of the query consisting of the domain and question type.
 func (q *Query) ID() string {
-	return q.FQDN + q.QType.String()
+	return q.FQDN + q.QType.String() + q.cacheKeySuffix()
 }
 
 // InitPublicSuffixData initializes the public suffix data.
 func (q *Query) InitPublicSuffixData() {
+	q.DomainRoot, q.ICANNSpace = EffectiveTLDPlusOne(q.FQDN)
+}
+
+// subQuery returns a new query for the given domain and type, which is
+// resolved on behalf of q and therefore uses the same settings. FlattenCNAME
+// and StripDNSSECRecords are not copied, as they apply to the answer of q.
+// The public suffix data is not copied, as it depends on the domain.
+func (q *Query) subQuery(fqdn string, qType dns.Type) *Query {
+	return &Query{
+		FQDN:               fqdn,
+		QType:              qType,
+		SecurityLevel:      q.SecurityLevel,
+		NoCaching:          q.NoCaching,
+		IgnoreFailing:      q.IgnoreFailing,
+		LocalResolversOnly: q.LocalResolversOnly,
+		ValidateDNSSEC:     q.ValidateDNSSEC,
+		CheckingDisabled:   q.CheckingDisabled,
+		DryRun:             q.DryRun,
+		ECSNetwork:         q.ECSNetwork,
+		ClientScope:        q.ClientScope,
+		PerResolverTimeout: q.PerResolverTimeout,
+		Parallelism:        q.Parallelism,
+		ForceResolverID:    q.ForceResolverID,
+		DedupeWait:         q.DedupeWait,
+		CacheOnly:          q.CacheOnly,
+		probe:              q.probe,
+	}
+}
+
+// NormalizeFQDN returns the given domain in lowercase and with a trailing dot.
+// Cache entries are always stored and looked up with the normalized domain.
+func NormalizeFQDN(name string) string {
+	return dns.Fqdn(strings.ToLower(name))
+}
+
+// EffectiveTLDPlusOne returns the effective TLD+1 of the given FQDN, ie. the
+// domain directly below its public suffix, and whether the domain is within
+// ICANN managed domain space. The root is returned in FQDN format and is empty
+// if the FQDN is a public suffix itself.
+func EffectiveTLDPlusOne(fqdn string) (root string, icann bool) {
+	fqdn = dns.Fqdn(fqdn)
+
 	// Get public suffix and derive if domain is in ICANN space.
-	suffix, icann := publicsuffix.PublicSuffix(strings.TrimSuffix(q.FQDN, "."))
-	if icann || strings.Contains(suffix, ".") {
-		q.ICANNSpace = true
+	suffix, icann := publicsuffix.PublicSuffix(strings.TrimSuffix(fqdn, "."))
+	if strings.Contains(suffix, ".") {
+		icann = true
 	}
 	// Override some cases.
 	switch suffix {
 	case "example":
-		q.ICANNSpace = true // Defined by ICANN.
+		icann = true // Defined by ICANN.
 	case "invalid":
-		q.ICANNSpace = true // Defined by ICANN.
+		icann = true // Defined by ICANN.
 	case "local":
-		q.ICANNSpace = true // Defined by ICANN.
+		icann = true // Defined by ICANN.
 	case "localhost":
-		q.ICANNSpace = true // Defined by ICANN.
+		icann = true // Defined by ICANN.
 	case "onion":
-		q.ICANNSpace = false // Defined by ICANN, but special.
+		icann = false // Defined by ICANN, but special.
 	case "test":
-		q.ICANNSpace = true // Defined by ICANN.
+		icann = true // Defined by ICANN.
 	}
 	// Add suffix to adhere to FQDN format.
 	suffix += "."
 
-	switch {
-	case len(q.FQDN) == len(suffix):
-		// We are at or below the domain root, reset.
-		q.DomainRoot = ""
-	case len(q.FQDN) > len(suffix):
-		domainRootStart := strings.LastIndex(q.FQDN[:len(q.FQDN)-len(suffix)-1], ".") + 1
-		q.DomainRoot = q.FQDN[domainRootStart:]
+	if len(fqdn) <= len(suffix) {
+		// We are at or below the domain root.
+		return "", icann
+	}
+	rootStart := strings.LastIndex(fqdn[:len(fqdn)-len(suffix)-1], ".") + 1
+	return fqdn[rootStart:], icann
+}
+
+// shouldValidateDNSSEC returns whether the response to the query must be
+// validated with DNSSEC.
+func (q *Query) shouldValidateDNSSEC() bool {
+	if q.dnssecChainQuery || q.CheckingDisabled {
+		return false
+	}
+	return q.ValidateDNSSEC || validateDNSSEC(q.SecurityLevel)
+}
+
+// wantsDNSSECRecords returns whether DNSSEC records should be requested from
+// upstream for the query.
+func (q *Query) wantsDNSSECRecords() bool {
+	return q.dnssecChainQuery || q.CheckingDisabled || q.shouldValidateDNSSEC()
+}
+
+// newDNSRequest creates a new DNS request message for the query.
+func (q *Query) newDNSRequest() *dns.Msg {
+	dnsQuery := new(dns.Msg)
+	dnsQuery.SetQuestion(q.FQDN, uint16(q.QType))
+	if q.wantsDNSSECRecords() {
+		dnsQuery.SetEdns0(getEDNSUDPSize(), true)
+		// Validation is done locally or by the client.
+		dnsQuery.CheckingDisabled = true
+	}
+	if network := q.ecsNetwork(); network != nil {
+		addECSOption(dnsQuery, network)
 	}
+	if use0x20() {
+		dnsQuery.Question[0].Name = randomizeQNameCase(q.FQDN)
+	}
+	return dnsQuery
 }
 
 // check runs sanity checks and does some initialization. Returns whether the query passed the basic checks.
@@ -155,15 +304,64 @@ func (q *Query) check() (ok bool) {
 		q.dotPrefixedFQDN = "." + q.FQDN
 	}
 
+	// do not mix answers of forced resolvers with the cache
+	if q.ForceResolverID != "" {
+		q.NoCaching = true
+	}
+
+	// never leak reverse lookups of private addresses to public resolvers
+	if q.isPrivateReverseQuery() {
+		q.LocalResolversOnly = true
+	}
+
 	return true
 }
 
 // Resolve resolves the given query for a domain and type and returns a RRCache object or nil, if the query failed.
+// Errors are returned as a *ResolveError, which carries a stable code for display in user interfaces.
 func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
+	// attach a stable code to errors
+	defer func() {
+		err = newResolveError(err, "")
+	}()
+
 	// sanity check
 	if q == nil || !q.check() {
 		return nil, ErrInvalid
 	}
+	if q.DryRun {
+		return nil, ErrDryRun
+	}
+
+	// attach the public suffix data to the answer
+	if q.DomainRoot == "" {
+		q.InitPublicSuffixData()
+	}
+	defer func() {
+		if rrCache != nil {
+			rrCache.ICANNSpace = q.ICANNSpace
+			rrCache.DomainRoot = q.DomainRoot
+		}
+	}()
+
+	// never mark answers as authenticated, if the client validates itself
+	if q.CheckingDisabled {
+		defer func() {
+			if rrCache != nil {
+				rrCache.AuthenticatedData = false
+			}
+		}()
+	}
+
+	// remove DNSSEC records the client did not ask for from a copy, so that
+	// the cached answer stays complete
+	if q.StripDNSSECRecords {
+		defer func() {
+			if rrCache != nil {
+				rrCache = rrCache.withoutDNSSECRecords(q.QType)
+			}
+		}()
+	}
 
 	// log
 	// try adding a context tracer
@@ -171,15 +369,66 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 	defer tracer.Submit()
 	log.Tracer(ctx).Tracef("resolver: resolving %s%s", q.FQDN, q.QType)
 
+	// add a structured query trace, if enabled
+	ctx, trace := addQueryTrace(ctx, q)
+	defer func() {
+		trace.submit(rrCache, err)
+	}()
+
+	// answer qualified single-label names for the name the client asked for
+	defer func() {
+		if rrCache != nil && q.unqualifiedFQDN != "" {
+			rrCache = rrCache.withAliasFrom(q.unqualifiedFQDN)
+		}
+	}()
+
 	// check query compliance
 	if err = q.checkCompliance(); err != nil {
+		switch {
+		case errors.Is(err, errMinimalANYResponse):
+			return minimalANYResponse(q), nil
+		case errors.Is(err, errBlocklistSinkhole):
+			log.Tracer(ctx).Tracef("resolver: sinkholing blocklisted query %s", q.ID())
+			return blocklistSinkholeResponse(q), nil
+		}
 		return nil, err
 	}
 
+	// answer AAAA queries locally, if IPv6 addresses are not wanted
+	if q.QType == dns.Type(dns.TypeAAAA) && suppressAAAA(ipVersionPreference(), globalIPv6Available()) {
+		log.Tracer(ctx).Tracef("resolver: suppressing AAAA query for %s", q.FQDN)
+		return suppressedAAAAResponse(q), nil
+	}
+
+	// apply rewrite rules, which are never cached
+	if rule := getRewriteRule(q.FQDN); rule != nil {
+		return rewriteQuery(ctx, q, rule)
+	}
+
+	// count query for prefetching
+	recordPrefetchCandidate(q)
+
+	// follow and flatten CNAME chains, if requested
+	if q.FlattenCNAME && canFlattenCNAMEs(q.QType) {
+		return resolveAndFlattenCNAMEs(ctx, q)
+	}
+
+	// only use the cache, if requested
+	if q.CacheOnly {
+		if !q.NoCaching {
+			rrCache = checkCache(ctx, q)
+			if rrCache != nil && (!rrCache.Expired() || rrCache.ServedStale) {
+				return rrCache, nil
+			}
+		}
+		log.Tracer(ctx).Tracef("resolver: no usable cache for cache only query %s", q.ID())
+		return nil, ErrNotFound
+	}
+
 	// check the cache
 	if !q.NoCaching {
 		rrCache = checkCache(ctx, q)
-		if rrCache != nil && !rrCache.Expired() {
+		if rrCache != nil && (!rrCache.Expired() || rrCache.ServedStale) {
 			return rrCache, nil
 		}
 
@@ -187,8 +436,9 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 		markRequestFinished := deduplicateRequest(ctx, q)
 		if markRequestFinished == nil {
 			// we waited for another request, recheck the cache!
+			getQueryTrace(ctx).markDeduplicated()
 			rrCache = checkCache(ctx, q)
-			if rrCache != nil && !rrCache.Expired() {
+			if rrCache != nil && (!rrCache.Expired() || rrCache.ServedStale) {
 				return rrCache, nil
 			}
 			log.Tracer(ctx).Debugf("resolver: waited for another %s%s query, but cache missed!", q.FQDN, q.QType)
@@ -199,17 +449,40 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 		}
 	}
 
+	// check if the query failed recently
+	if err := getThrottledQueryError(q); err != nil {
+		if rrCache != nil {
+			log.Tracer(ctx).Debugf("resolver: serving backup cache of %s because query failed recently: %s", q.ID(), err)
+			rrCache.IsBackup = true
+			rrCache.addWarning("served backup cache, because the query failed recently")
+			return rrCache, nil
+		}
+		log.Tracer(ctx).Debugf("resolver: not resolving %s, because query failed recently: %s", q.ID(), err)
+		return nil, err
+	}
+
 	return resolveAndCache(ctx, q, rrCache)
 }
 
-func checkCache(ctx context.Context, q *Query) *RRCache {
+func checkCache(ctx context.Context, q *Query) (rrCache *RRCache) {
 	// Never ask cache for connectivity domains.
 	if netenv.IsConnectivityDomain(q.FQDN) {
 		return nil
 	}
 
+	// Never ask cache for domains defined in the hosts files, as they take precedence.
+	if hostsConn.has(q.FQDN) {
+		return nil
+	}
+
+	// Record whether the cache could be used.
+	defer func() {
+		recordCacheLookup(rrCache != nil && (!rrCache.Expired() || rrCache.ServedStale))
+		getQueryTrace(ctx).setCache(traceCacheDecision(rrCache))
+	}()
+
 	// Get data from cache.
-	rrCache, err := GetRRCache(q.FQDN, q.QType)
+	rrCache, err := q.getCachedRRCache()
 	// Return if entry is not in cache.
 	if err != nil {
 		if !errors.Is(err, database.ErrNotFound) {
@@ -232,9 +505,19 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 		return nil
 	}
 
+	// Check if the cached entry satisfies DNSSEC requirements.
+	if q.shouldValidateDNSSEC() && !rrCache.AuthenticatedData && !rrCache.Insecure {
+		log.Tracer(ctx).Debugf("resolver: ignoring cached entry for %s%s because it was not validated with DNSSEC", q.FQDN, q.QType.String())
+		return nil
+	}
+	if q.dnssecChainQuery && !rrCache.hasSignatures() {
+		log.Tracer(ctx).Debugf("resolver: ignoring cached entry for %s%s because it has no DNSSEC records", q.FQDN, q.QType.String())
+		return nil
+	}
+
 	// Check if we want to reset the cache for this entry.
 	if shouldResetCache(q) {
-		err := ResetCachedRecord(q.FQDN, q.QType.String())
+		err := ResetCachedRecord(q.FQDN, q.QType.String()+makeCacheKeySuffix(rrCache.ECSNetwork, rrCache.ClientScope))
 		switch {
 		case err == nil:
 			log.Tracer(ctx).Tracef("resolver: cache for %s%s was reset", q.FQDN, q.QType)
@@ -246,18 +529,42 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 		return nil
 	}
 
+	// Remove private IPs from public answers, as the cached entry may have
+	// been resolved by a query with other settings.
+	filterPrivateAnswers(ctx, q, rrCache)
+
 	// Check if the cache has already expired.
 	// We still return the cache, if it isn't NXDomain, as it will be used if the
 	// new query fails.
 	if rrCache.Expired() {
-		if rrCache.RCode == dns.RcodeSuccess {
-			return rrCache
+		emitCacheEvent(CacheEventExpired, q.FQDN, q.QType.String())
+		if rrCache.RCode != dns.RcodeSuccess {
+			return nil
 		}
-		return nil
+
+		// Serve stale entries within the grace window immediately, while
+		// refreshing them async. See RFC 8767.
+		if window := serveStaleWindow(); window > 0 &&
+			time.Now().Unix() < rrCache.Expires+window {
+			rrCache.ServedStale = true
+			rrCache.RequestingNew = true
+			rrCache.addWarning("served stale cache, while refreshing")
+
+			log.Tracer(ctx).Tracef(
+				"resolver: cache for %s expired %s ago, serving stale and refreshing async now",
+				q.ID(),
+				time.Since(time.Unix(rrCache.Expires, 0)).Round(time.Second),
+			)
+			refreshAsync(q)
+		}
+
+		return rrCache
 	}
 
+	emitCacheEvent(CacheEventServed, q.FQDN, q.QType.String())
+
 	// Check if the cache will expire soon and start an async request.
-	if rrCache.ExpiresSoon() {
+	if rrCache.ExpiresSoon(getTTLLimits(q.SecurityLevel).refresh) {
 		// Set flag that we are refreshing this entry.
 		rrCache.RequestingNew = true
 
@@ -266,20 +573,7 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 			q.ID(),
 			time.Until(time.Unix(rrCache.Expires, 0)).Round(time.Second),
 		)
-
-		// resolve async
-		module.StartWorker("resolve async", func(asyncCtx context.Context) error {
-			tracingCtx, tracer := log.AddTracer(asyncCtx)
-			defer tracer.Submit()
-			tracer.Tracef("resolver: resolving %s async", q.ID())
-			_, err := resolveAndCache(tracingCtx, q, nil)
-			if err != nil {
-				tracer.Warningf("resolver: async query for %s failed: %s", q.ID(), err)
-			} else {
-				tracer.Infof("resolver: async query for %s succeeded", q.ID())
-			}
-			return nil
-		})
+		refreshAsync(q)
 
 		return rrCache
 	}
@@ -291,6 +585,32 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 	return rrCache
 }
 
+// refreshAsync resolves the given query in a new worker in order to refresh
+// the cache.
+func refreshAsync(q *Query) {
+	module.StartWorker("resolve async", func(asyncCtx context.Context) error {
+		tracingCtx, tracer := log.AddTracer(asyncCtx)
+		defer tracer.Submit()
+
+		// Collapse with inline queries for the same record.
+		markRequestFinished := deduplicateRequest(tracingCtx, q)
+		if markRequestFinished == nil {
+			tracer.Tracef("resolver: skipping async query for %s, as a duplicate query completed", q.ID())
+			return nil
+		}
+		defer markRequestFinished()
+
+		tracer.Tracef("resolver: resolving %s async", q.ID())
+		_, err := resolveAndCache(tracingCtx, q, nil)
+		if err != nil {
+			tracer.Warningf("resolver: async query for %s failed: %s", q.ID(), err)
+		} else {
+			tracer.Infof("resolver: async query for %s succeeded", q.ID())
+		}
+		return nil
+	})
+}
+
 func deduplicateRequest(ctx context.Context, q *Query) (finishRequest func()) {
 	// create identifier key
 	dupKey := q.ID()
@@ -309,6 +629,13 @@ func deduplicateRequest(ctx context.Context, q *Query) (finishRequest func()) {
 		if time.Now().Before(status.waitUntil) {
 			dupReqLock.Unlock()
 
+			// the caller might not want to wait as long as the global timeout
+			wait := maxRequestTimeout
+			independent := q.DedupeWait > 0 && q.DedupeWait < maxRequestTimeout
+			if independent {
+				wait = q.DedupeWait
+			}
+
 			// log that we are waiting
 			log.Tracer(ctx).Tracef("resolver: waiting for duplicate query for %s to complete", dupKey)
 			// wait
@@ -316,7 +643,13 @@ func deduplicateRequest(ctx context.Context, q *Query) (finishRequest func()) {
 			case <-status.completed:
 				// done!
 				return nil
-			case <-time.After(maxRequestTimeout):
+			case <-time.After(wait):
+				if independent {
+					// proceed on our own, the in-flight request stays registered
+					// and cleans up after itself
+					log.Tracer(ctx).Tracef("resolver: stopped waiting for duplicate query for %s, resolving independently", dupKey)
+					return func() {}
+				}
 				// something went wrong with the query, retry
 				goto retry
 			case <-ctx.Done():
@@ -353,15 +686,61 @@ func deduplicateRequest(ctx context.Context, q *Query) (finishRequest func()) {
 	}
 }
 
-func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache *RRCache, err error) { //nolint:gocognit,gocyclo
+func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache *RRCache, err error) {
+	// let hooks answer the query first
+	if rrCache, handled, err := runHooks(ctx, q); handled {
+		log.Tracer(ctx).Tracef("resolver: query for %s was handled by a hook", q.ID())
+		return rrCache, err
+	}
+
+	// stop accepting new upstream queries when shutting down
+	ctx, done, ok := trackQuery(ctx)
+	if !ok {
+		return nil, ErrShuttingDown
+	}
+	defer done()
+
 	// get resolvers
 	resolvers, primarySource, tryAll := GetResolversInScope(ctx, q)
 	if len(resolvers) == 0 {
+		if q.ForceResolverID != "" {
+			if _, err := getForcedResolverWithLocking(ctx, q); err != nil {
+				return nil, err
+			}
+		}
+		if q.isPrivateReverseQuery() {
+			return nil, fmt.Errorf("%w: no local resolvers for reverse lookup of private address", ErrNoCompliance)
+		}
 		return nil, ErrNoCompliance
 	}
 
+	return resolveWithResolvers(ctx, q, oldCache, resolvers, primarySource, tryAll)
+}
+
+// resolveWithResolvers resolves the query with the given resolvers, in order,
+// and caches the result. It fails over to the next resolver as needed and
+// serves oldCache as a backup if all of them fail. The resolvers are passed in
+// instead of being taken from the current scope, so that the failover logic
+// can also be used with a fixed set of resolvers.
+func resolveWithResolvers( //nolint:gocognit,gocyclo
+	ctx context.Context,
+	q *Query,
+	oldCache *RRCache,
+	resolvers []*Resolver,
+	primarySource string,
+	tryAll bool,
+) (rrCache *RRCache, err error) {
+	// attach the resolver that failed to errors
+	var failedResolver *Resolver
+	defer func() {
+		if err != nil && failedResolver != nil {
+			err = newResolveError(err, failedResolver.Info.ID())
+		}
+	}()
+
 	// check if we are online
-	if netenv.GetOnlineStatus() == netenv.StatusOffline && primarySource != ServerSourceEnv {
+	if netenv.GetOnlineStatus() == netenv.StatusOffline &&
+		primarySource != ServerSourceEnv && primarySource != ServerSourceHosts {
 		if q.FQDN != netenv.DNSTestDomain && !netenv.IsConnectivityDomain(q.FQDN) {
 			// we are offline and this is not an online check query
 			return oldCache, ErrOffline
@@ -370,27 +749,86 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 	}
 
 	// start resolving
+	trace := getQueryTrace(ctx)
+	var warnings []string
 
 	var i int
+	parallelism := getParallelism(q)
 	// once with skipping recently failed resolvers, once without
 resolveLoop:
 	for i = 0; i < 2; i++ {
-		for _, resolver := range resolvers {
-			if module.IsStopping() {
+		skipResolver := func(resolver *Resolver) bool {
+			return i == 0 && q.ForceResolverID == "" && !resolver.allowQuery()
+		}
+		var raced map[*Resolver]*raceResult
+
+		for idx, resolver := range resolvers {
+			if drainExpired.IsSet() {
 				return nil, ErrShuttingDown
 			}
+			result, isRaced := raced[resolver]
 
-			// check if resolver failed recently (on first run)
-			if i == 0 && resolver.Conn.IsFailing() {
-				log.Tracer(ctx).Tracef("resolver: skipping resolver %s, because it failed recently", resolver)
+			// check if the circuit of the resolver permits a query (on first run)
+			if !isRaced && skipResolver(resolver) {
+				log.Tracer(ctx).Tracef("resolver: skipping resolver %s, because its circuit is open", resolver)
+				trace.addSkippedHop(q, resolver)
 				continue
 			}
 
+			// query the next resolvers in parallel, if configured
+			if !isRaced && parallelism > 1 {
+				racers := []*Resolver{resolver}
+				for _, next := range resolvers[idx+1:] {
+					if len(racers) >= parallelism {
+						break
+					}
+					if !skipResolver(next) {
+						racers = append(racers, next)
+					}
+				}
+				if len(racers) > 1 {
+					log.Tracer(ctx).Tracef("resolver: sending query for %s to %d resolvers in parallel", q.ID(), len(racers))
+					raced = raceResolvers(ctx, q, racers, tryAll)
+					result, isRaced = raced[resolver]
+				}
+			}
+
 			// resolve
-			log.Tracer(ctx).Tracef("resolver: sending query for %s to %s", q.ID(), resolver.Info.ID())
-			rrCache, err = resolver.Conn.Query(ctx, q)
+			var queryStarted time.Time
+			if isRaced {
+				if result.cancelled {
+					log.Tracer(ctx).Tracef("resolver: cancelled query to %s, because another resolver answered first", resolver.Info.ID())
+					continue
+				}
+				queryStarted, rrCache, err = result.started, result.rrCache, result.err
+			} else {
+				log.Tracer(ctx).Tracef("resolver: sending query for %s to %s", q.ID(), resolver.Info.ID())
+				queryStarted = time.Now()
+				rrCache, err = queryResolver(ctx, resolver, q)
+			}
+			// Check the rcode first, as failures are also reported in a reply.
+			if err == nil && rrCache != nil {
+				err = rcodeToError(rrCache.RCode)
+			}
+			recordQuery(resolver, queryStarted, rrCache, err)
+			trace.addHop(q, resolver, queryStarted, rrCache, err)
+			if err == nil {
+				recordLatency(resolver, time.Since(queryStarted))
+			} else {
+				recordFailedLatency(resolver, time.Since(queryStarted), err)
+			}
+			if rrCache != nil {
+				q.applyCacheScope(rrCache)
+			}
+			if err == nil && rrCache != nil {
+				err = checkAliasLoops(rrCache)
+			}
 			if err != nil {
+				failedResolver = resolver
 				switch {
+				case drainExpired.IsSet():
+					// the query was aborted, because the resolver is shutting down
+					return nil, ErrShuttingDown
 				case errors.Is(err, ErrNotFound):
 					// NXDomain, or similar
 					if tryAll {
@@ -400,6 +838,24 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 				case errors.Is(err, ErrBlocked):
 					// some resolvers might also block
 					return nil, err
+				case errors.Is(err, ErrBootstrapFailed):
+					// the server could not be reached, report the actual reason
+					if !q.probe {
+						resolver.Conn.ReportFailure()
+					}
+					log.Tracer(ctx).Debugf("resolver: %s", err)
+					warnings = append(warnings, fmt.Sprintf("resolver %s could not be bootstrapped, fell back", resolver.Info.ID()))
+					continue
+				case errors.Is(err, ErrRefused):
+					// the resolver does not serve this query, but is not broken
+					log.Tracer(ctx).Debugf("resolver: query to %s was refused", resolver.Info.ID())
+					warnings = append(warnings, fmt.Sprintf("resolver %s refused the query, fell back", resolver.Info.ID()))
+					continue
+				case errors.Is(err, ErrSourceUnavailable):
+					// the resolver cannot be reached from its source, but is not broken
+					log.Tracer(ctx).Debugf("resolver: %s", err)
+					warnings = append(warnings, fmt.Sprintf("source of resolver %s is not available, fell back", resolver.Info.ID()))
+					continue
 				case netenv.GetOnlineStatus() == netenv.StatusOffline &&
 					q.FQDN != netenv.DNSTestDomain &&
 					!netenv.IsConnectivityDomain(q.FQDN):
@@ -408,8 +864,11 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 				case errors.Is(err, ErrContinue):
 					continue
 				case errors.Is(err, ErrTimeout):
-					resolver.Conn.ReportFailure()
+					if !q.probe {
+						resolver.Conn.ReportFailure()
+					}
 					log.Tracer(ctx).Debugf("resolver: query to %s timed out", resolver.Info.ID())
+					warnings = append(warnings, fmt.Sprintf("resolver %s timed out, fell back", resolver.Info.ID()))
 					continue
 				case errors.Is(err, context.Canceled):
 					return nil, err
@@ -418,8 +877,11 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 				case errors.Is(err, ErrShuttingDown):
 					return nil, err
 				default:
-					resolver.Conn.ReportFailure()
+					if !q.probe {
+						resolver.Conn.ReportFailure()
+					}
 					log.Tracer(ctx).Debugf("resolver: query to %s failed: %s", resolver.Info.ID(), err)
+					warnings = append(warnings, fmt.Sprintf("resolver %s failed, fell back", resolver.Info.ID()))
 					continue
 				}
 			}
@@ -433,6 +895,7 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 			}
 
 			// Report a successful connection.
+			failedResolver = nil
 			resolver.Conn.ResetFailure()
 			// Reset failing resolvers notification, if querying in global scope.
 			if primarySource == ServerSourceConfigured {
@@ -449,17 +912,46 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 		if i > 1 {
 			err = fmt.Errorf("all %d query-compliant resolvers failed, last error: %w", len(resolvers), err)
 
-			if primarySource == ServerSourceConfigured &&
-				netenv.Online() && CompatSelfCheckIsFailing() {
+			switch {
+			case q.probe:
+				// Probes do not affect the failing resolvers notification.
+			case primarySource == ServerSourceConfigured &&
+				netenv.Online() && CompatSelfCheckIsFailing():
 				notifyAboutFailingResolvers(err)
-			} else {
+			default:
 				resetFailingResolversNotification()
 			}
+
+			// Throttle the query, unless it is an online check.
+			if !q.probe && !errors.Is(err, ErrNotFound) &&
+				q.FQDN != netenv.DNSTestDomain && !netenv.IsConnectivityDomain(q.FQDN) {
+				throttleFailedQuery(q, err)
+			}
 		}
 	} else if rrCache == nil /* defensive */ {
 		err = ErrNotFound
 	}
 
+	// Validate response with DNSSEC.
+	if err == nil && q.shouldValidateDNSSEC() {
+		err = validateRRCacheWithDNSSEC(ctx, q, rrCache)
+		if err != nil {
+			log.Tracer(ctx).Warningf("resolver: %s", err)
+			return nil, newResolveError(err, rrCache.Resolver.ID())
+		}
+	}
+
+	// Remove private IPs from public answers.
+	if err == nil {
+		filterPrivateAnswers(ctx, q, rrCache)
+	}
+
+	// Run post hooks.
+	rrCache, err = runPostHooks(ctx, q, rrCache, err)
+	if err == nil && rrCache == nil /* defensive */ {
+		err = ErrNotFound
+	}
+
 	// Check if we want to use an older cache instead.
 	if oldCache != nil {
 		oldCache.IsBackup = true
@@ -468,10 +960,12 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 		case err != nil:
 			// There was an error during resolving, return the old cache entry instead.
 			log.Tracer(ctx).Debugf("resolver: serving backup cache of %s because query failed: %s", q.ID(), err)
+			oldCache.addWarning("served backup cache, because the query failed")
 			return oldCache, nil
 		case !rrCache.Cacheable():
 			// The new result is NXDomain, return the old cache entry instead.
 			log.Tracer(ctx).Debugf("resolver: serving backup cache of %s because fresh response is NXDomain", q.ID())
+			oldCache.addWarning("served backup cache, because the fresh response is NXDomain")
 			return oldCache, nil
 		}
 	}
@@ -481,11 +975,21 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 		return nil, err
 	}
 
+	// Add warnings from falling back to other resolvers.
+	for _, warning := range warnings {
+		rrCache.addWarning("%s", warning)
+	}
+
 	// Adjust TTLs.
-	rrCache.Clean(minTTL)
+	limits := getTTLLimits(q.SecurityLevel)
+	if rrCache.Resolver != nil && rrCache.Resolver.Type == ServerTypeMDNS {
+		// Devices on the local network come and go.
+		limits.min = minMDnsTTL
+	}
+	rrCache.Clean(limits.min, limits.max)
 
 	// Save the new entry if cache is enabled and the record may be cached.
-	if !q.NoCaching && rrCache.Cacheable() {
+	if !q.NoCaching && !q.CheckingDisabled && rrCache.Cacheable() {
 		err = rrCache.Save()
 		if err != nil {
 			log.Tracer(ctx).Warningf("resolver: failed to cache RR for %s: %s", q.ID(), err)
@@ -495,6 +999,22 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 	return rrCache, nil
 }
 
+// rcodeToError returns the error for a reply with the given rcode, or nil if
+// the reply is an answer. REFUSED replies mean that the resolver does not
+// serve the query and are reported as ErrRefused, while SERVFAIL replies mean
+// that the resolver failed and are reported as ErrServerFailure. Other rcodes
+// are treated as answers.
+func rcodeToError(rcode int) error {
+	switch rcode {
+	case dns.RcodeRefused:
+		return ErrRefused
+	case dns.RcodeServerFailure:
+		return ErrServerFailure
+	default:
+		return nil
+	}
+}
+
 var (
 	cacheResetLock    sync.Mutex
 	cacheResetID      string
@@ -523,6 +1043,51 @@ func shouldResetCache(q *Query) (reset bool) {
 	return false
 }
 
+// queryResolver sends the query to the given resolver. If the query has a per
+// resolver timeout, exceeding it is reported as ErrTimeout instead of as an
+// expired context, so that the next resolver is asked.
+// Timed out queries are retried as configured. The per resolver timeout and
+// the retry budget cover all attempts.
+func queryResolver(ctx context.Context, resolver *Resolver, q *Query) (*RRCache, error) {
+	retries := getTimeoutRetries(q)
+	timeout := q.PerResolverTimeout
+	if retries > 0 && (timeout <= 0 || timeout > timeoutRetryBudget) {
+		timeout = timeoutRetryBudget
+	}
+
+	queryCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	perResolverTimeoutErr := func() error {
+		return fmt.Errorf("%w: no answer from %s within %s", ErrTimeout, resolver.Info.ID(), timeout)
+	}
+
+	for attempt := 0; ; attempt++ {
+		rrCache, err := resolver.Conn.Query(queryCtx, q)
+		if err != nil && queryCtx.Err() != nil && ctx.Err() == nil {
+			return nil, perResolverTimeoutErr()
+		}
+		if attempt >= retries || !isTimeoutError(err) {
+			return rrCache, err
+		}
+
+		// Wait a bit before retrying, but not beyond any deadline.
+		backoff := timeoutRetryBackoff(attempt)
+		log.Tracer(ctx).Tracef("resolver: query to %s timed out, retrying in %s", resolver.Info.ID(), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-queryCtx.Done():
+			if ctx.Err() == nil {
+				return nil, perResolverTimeoutErr()
+			}
+			return nil, err
+		}
+	}
+}
+
 func init() {
 	netenv.DNSTestQueryFunc = testConnectivity
 }
@@ -530,10 +1095,17 @@ func init() {
 // testConnectivity test if resolving a query succeeds and returns whether the
 // query itself succeeded, separate from interpreting the result.
 func testConnectivity(ctx context.Context, fdqn string) (ips []net.IP, ok bool, err error) {
+	return probeConnectivity(ctx, fdqn, false)
+}
+
+// probeConnectivity is like testConnectivity, but can be used as a probe,
+// which does not mark resolvers as failing.
+func probeConnectivity(ctx context.Context, fdqn string, probe bool) (ips []net.IP, ok bool, err error) {
 	q := &Query{
 		FQDN:      fdqn,
 		QType:     dns.Type(dns.TypeA),
 		NoCaching: true,
+		probe:     probe,
 	}
 	if !q.check() {
 		return nil, false, ErrInvalid
@@ -560,6 +1132,8 @@ func testConnectivity(ctx context.Context, fdqn string) (ips []net.IP, ok bool,
 		return nil, true, err
 	case errors.Is(err, ErrNoCompliance):
 		return nil, true, err
+	case errors.Is(err, ErrRefused):
+		return nil, true, err
 	default:
 		return nil, false, err
 	}
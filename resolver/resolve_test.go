@@ -0,0 +1,211 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/notifications"
+)
+
+func TestRcodeToError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rcode int
+		want  error
+	}{
+		{dns.RcodeSuccess, nil},
+		{dns.RcodeNameError, nil},
+		{dns.RcodeNotImplemented, nil},
+		{dns.RcodeRefused, ErrRefused},
+		{dns.RcodeServerFailure, ErrServerFailure},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rcodeToError(tt.rcode), dns.RcodeToString[tt.rcode])
+	}
+
+	assert.True(t, errors.Is(ErrRefused, ErrContinue), "refused queries should continue with the next resolver")
+	assert.True(t, errors.Is(ErrServerFailure, ErrFailure), "server failures should count as failures")
+}
+
+func TestRefusedDoesNotReportFailure(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	refusingConn, refusingResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 56))
+	refusingConn.fail.Store(false)
+	refusingConn.rcode = dns.RcodeRefused
+	answeringConn, answeringResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 57))
+	answeringConn.fail.Store(false)
+
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{refusingResolver, answeringResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		resolversLock.Unlock()
+	}()
+
+	q := &Query{
+		FQDN:      "refused." + InternalSpecialUseDomain,
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	}
+	require.True(t, q.check())
+
+	rrCache, err := resolveAndCache(context.Background(), q, nil)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeSuccess, rrCache.RCode)
+	assert.Equal(t, answeringResolver.Info.ID(), rrCache.Resolver.ID())
+	assert.Equal(t, int32(0), refusingConn.failures.Load())
+}
+
+func TestForceResolver(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	conn, testResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 58))
+	conn.fail.Store(false)
+	for i := 0; i <= FailThreshold; i++ {
+		conn.recordFailure()
+	}
+	require.False(t, testResolver.allowQuery(), "circuit should be open")
+
+	resolversLock.Lock()
+	activeResolvers[testResolver.Info.ID()] = testResolver
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		delete(activeResolvers, testResolver.Info.ID())
+		resolversLock.Unlock()
+	}()
+
+	// The forced resolver is used, even though it is failing.
+	q := &Query{
+		FQDN:            "forced." + InternalSpecialUseDomain,
+		QType:           dns.Type(dns.TypeA),
+		ForceResolverID: testResolver.Info.ID(),
+	}
+	require.True(t, q.check())
+	assert.True(t, q.NoCaching, "forced queries should not be cached")
+
+	resolvers, _, tryAll := GetResolversInScope(context.Background(), q)
+	assert.Equal(t, []*Resolver{testResolver}, resolvers)
+	assert.False(t, tryAll)
+
+	rrCache, err := resolveAndCache(context.Background(), q, nil)
+	require.NoError(t, err)
+	assert.Equal(t, testResolver.Info.ID(), rrCache.Resolver.ID())
+
+	// Unknown resolvers are reported.
+	q = &Query{
+		FQDN:            "forced." + InternalSpecialUseDomain,
+		QType:           dns.Type(dns.TypeA),
+		ForceResolverID: "dns://192.0.2.59:53",
+	}
+	require.True(t, q.check())
+	_, err = resolveAndCache(context.Background(), q, nil)
+	require.ErrorIs(t, err, ErrNoCompliance)
+	assert.Contains(t, err.Error(), "does not exist")
+
+	// Non-compliant resolvers are reported.
+	q = &Query{
+		FQDN:               "forced." + InternalSpecialUseDomain,
+		QType:              dns.Type(dns.TypeA),
+		LocalResolversOnly: true,
+		ForceResolverID:    testResolver.Info.ID(),
+	}
+	require.True(t, q.check())
+	_, err = resolveAndCache(context.Background(), q, nil)
+	require.ErrorIs(t, err, ErrNoCompliance)
+	assert.Contains(t, err.Error(), errNotLocal.Error())
+}
+
+func TestFailingResolverNotifications(t *testing.T) { //nolint:paralleltest // Changes global config.
+	const eventID = "resolver:all-configured-resolvers-failed"
+	notificationShown := func() bool {
+		failingResolverNotificationLock.Lock()
+		defer failingResolverNotificationLock.Unlock()
+		return failingResolverNotification != nil || notifications.Get(eventID) != nil
+	}
+	allFailedErr := errors.New("all 2 query-compliant resolvers failed")
+
+	// Disabled programmatically.
+	SetFailingResolverNotifications(false)
+	defer SetFailingResolverNotifications(true)
+	notifyAboutFailingResolvers(allFailedErr)
+	assert.False(t, notificationShown())
+
+	// Disabled by config.
+	SetFailingResolverNotifications(true)
+	require.NoError(t, config.SetConfigOption(CfgOptionFailingResolverNotificationsKey, false))
+	defer func() {
+		assert.NoError(t, config.SetConfigOption(CfgOptionFailingResolverNotificationsKey, true))
+	}()
+	notifyAboutFailingResolvers(allFailedErr)
+	assert.False(t, notificationShown())
+
+	// Enabled, disabling removes the shown notification.
+	require.NoError(t, config.SetConfigOption(CfgOptionFailingResolverNotificationsKey, true))
+	notifyAboutFailingResolvers(allFailedErr)
+	assert.True(t, notificationShown())
+	SetFailingResolverNotifications(false)
+	assert.False(t, notificationShown())
+}
+
+func TestSubQuery(t *testing.T) {
+	t.Parallel()
+
+	// Fields that are not inherited by sub queries.
+	notCopied := map[string]struct{}{
+		"FlattenCNAME":       {},
+		"StripDNSSECRecords": {},
+		"ICANNSpace":         {},
+		"DomainRoot":         {},
+		"dotPrefixedFQDN":    {},
+		"unqualifiedFQDN":    {},
+		"dnssecChainQuery":   {},
+	}
+
+	// Set every field of the query, so that newly added fields are checked too.
+	q := &Query{}
+	value := reflect.ValueOf(q).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		field = reflect.NewAt(field.Type(), field.Addr().UnsafePointer()).Elem()
+		switch field.Kind() { //nolint:exhaustive // Only the kinds used by Query.
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.String:
+			field.SetString("set")
+		case reflect.Int, reflect.Int64:
+			field.SetInt(1)
+		case reflect.Uint8, reflect.Uint16:
+			field.SetUint(1)
+		case reflect.Ptr:
+			field.Set(reflect.New(field.Type().Elem()))
+		default:
+			t.Fatalf("unsupported kind %s of field %s", field.Kind(), value.Type().Field(i).Name)
+		}
+	}
+
+	sub := q.subQuery("sub.example.com.", dns.Type(dns.TypeAAAA))
+	assert.Equal(t, "sub.example.com.", sub.FQDN)
+	assert.Equal(t, dns.Type(dns.TypeAAAA), sub.QType)
+	subValue := reflect.ValueOf(sub).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if name == "FQDN" || name == "QType" {
+			continue
+		}
+		if _, ok := notCopied[name]; ok {
+			assert.True(t, subValue.Field(i).IsZero(), "field %s must not be copied", name)
+			continue
+		}
+		assert.False(t, subValue.Field(i).IsZero(), "field %s is not copied", name)
+	}
+}
@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+)
+
+// Stable codes of resolve errors, for use by user interfaces.
+const (
+	ErrorCodeNotFound               = "not-found"
+	ErrorCodeInvalid                = "invalid"
+	ErrorCodeDryRun                 = "dry-run"
+	ErrorCodeBlocked                = "blocked"
+	ErrorCodeBlocklisted            = "blocklisted"
+	ErrorCodeTestDomainsDisabled    = "test-domains-disabled"
+	ErrorCodeSpecialDomainsDisabled = "special-domains-disabled"
+	ErrorCodeNoCompliance           = "no-compliance"
+	ErrorCodeBogus                  = "dnssec-bogus"
+	ErrorCodeQTypeBlocked           = "qtype-blocked"
+	ErrorCodeLocalhost              = "localhost"
+	ErrorCodeOffline                = "offline"
+	ErrorCodeSourceUnavailable      = "source-unavailable"
+	ErrorCodeTimeout                = "timeout"
+	ErrorCodeCanceled               = "canceled"
+	ErrorCodeRefused                = "refused"
+	ErrorCodeNoAnswer               = "no-answer"
+	ErrorCodeBootstrapFailed        = "bootstrap-failed"
+	ErrorCodeServerFailure          = "server-failure"
+	ErrorCodeShuttingDown           = "shutting-down"
+	ErrorCodeFailure                = "failure"
+)
+
+// resolveErrorCodes maps errors to their code and message. More specific
+// errors must be listed before the errors they wrap.
+var resolveErrorCodes = []struct {
+	err     error
+	code    string
+	message string
+}{
+	{ErrDryRun, ErrorCodeDryRun, "Dry run queries cannot be resolved."},
+	{ErrInvalid, ErrorCodeInvalid, "The query is invalid."},
+	{ErrBlocklisted, ErrorCodeBlocklisted, "The domain is on a blocklist."},
+	{errBlocklistedNXDomain, ErrorCodeBlocklisted, "The domain is on a blocklist."},
+	{ErrTestDomainsDisabled, ErrorCodeTestDomainsDisabled, "Test domains are disabled."},
+	{ErrSpecialDomainsDisabled, ErrorCodeSpecialDomainsDisabled, "Special service domains are disabled."},
+	{ErrNoCompliance, ErrorCodeNoCompliance, "No DNS server complies with the current settings."},
+	{ErrBogus, ErrorCodeBogus, "The answer failed DNSSEC validation."},
+	{ErrQTypeBlocked, ErrorCodeQTypeBlocked, "The query type is not permitted."},
+	{ErrBlocked, ErrorCodeBlocked, "The query was blocked."},
+	{ErrNotFound, ErrorCodeNotFound, "The domain does not exist."},
+	{ErrLocalhost, ErrorCodeLocalhost, "The domain refers to this device."},
+	{ErrSourceUnavailable, ErrorCodeSourceUnavailable, "The network interface of the DNS server is not available."},
+	{ErrOffline, ErrorCodeOffline, "The device is offline."},
+	{ErrTimeout, ErrorCodeTimeout, "The DNS server did not answer in time."},
+	{context.DeadlineExceeded, ErrorCodeTimeout, "The DNS server did not answer in time."},
+	{context.Canceled, ErrorCodeCanceled, "The query was canceled."},
+	{ErrRefused, ErrorCodeRefused, "The DNS server refused the query."},
+	{ErrContinue, ErrorCodeNoAnswer, "The DNS server has no answer."},
+	{ErrBootstrapFailed, ErrorCodeBootstrapFailed, "The address of the DNS server could not be resolved."},
+	{ErrServerFailure, ErrorCodeServerFailure, "The DNS server failed to answer."},
+	{ErrShuttingDown, ErrorCodeShuttingDown, "The resolver is shutting down."},
+}
+
+// ResolveError is returned by Resolve. It carries a stable code and a human
+// readable message for display in user interfaces and wraps the underlying
+// error, so that errors.Is can still be used to check for the basic errors.
+type ResolveError struct {
+	// Code is a stable, machine readable code of the error.
+	Code string
+	// Message describes the error in terms suitable for users.
+	Message string
+	// ResolverID is the ID of the resolver that failed, if any.
+	ResolverID string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (re *ResolveError) Error() string {
+	return re.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (re *ResolveError) Unwrap() error {
+	return re.Err
+}
+
+// newResolveError wraps the given error in a ResolveError. Errors that are
+// nil or already are a ResolveError are returned as is.
+func newResolveError(err error, resolverID string) error {
+	if err == nil {
+		return nil
+	}
+	var resolveErr *ResolveError
+	if errors.As(err, &resolveErr) {
+		return err
+	}
+
+	resolveErr = &ResolveError{
+		Code:       ErrorCodeFailure,
+		Message:    "The query failed.",
+		ResolverID: resolverID,
+		Err:        err,
+	}
+	for _, entry := range resolveErrorCodes {
+		if errors.Is(err, entry.err) {
+			resolveErr.Code = entry.code
+			resolveErr.Message = entry.message
+			break
+		}
+	}
+	return resolveErr
+}
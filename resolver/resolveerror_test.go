@@ -0,0 +1,94 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portmaster/status"
+)
+
+func requireResolveError(t *testing.T, err error, code string) *ResolveError {
+	t.Helper()
+
+	var resolveErr *ResolveError
+	require.ErrorAs(t, err, &resolveErr)
+	assert.Equal(t, code, resolveErr.Code)
+	assert.NotEmpty(t, resolveErr.Message)
+	return resolveErr
+}
+
+func TestResolveErrorCodes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		err   error
+		code  string
+		basic error
+	}{
+		{ErrTestDomainsDisabled, ErrorCodeTestDomainsDisabled, ErrBlocked},
+		{fmt.Errorf("%w: .onion domains are blocked", ErrSpecialDomainsDisabled), ErrorCodeSpecialDomainsDisabled, ErrBlocked},
+		{ErrNoCompliance, ErrorCodeNoCompliance, ErrBlocked},
+		{ErrBlocklisted, ErrorCodeBlocklisted, ErrBlocked},
+		{errBlocklistedNXDomain, ErrorCodeBlocklisted, ErrNotFound},
+		{ErrInvalid, ErrorCodeInvalid, ErrNotFound},
+		{ErrNotFound, ErrorCodeNotFound, ErrNotFound},
+		{ErrSourceUnavailable, ErrorCodeSourceUnavailable, ErrOffline},
+		{ErrRefused, ErrorCodeRefused, ErrContinue},
+		{ErrServerFailure, ErrorCodeServerFailure, ErrFailure},
+		{errors.New("something broke"), ErrorCodeFailure, nil},
+	}
+
+	for _, tc := range testCases {
+		err := newResolveError(tc.err, "")
+		requireResolveError(t, err, tc.code)
+		assert.Equal(t, tc.err.Error(), err.Error())
+		assert.ErrorIs(t, err, tc.err)
+		if tc.basic != nil {
+			assert.ErrorIs(t, err, tc.basic)
+		}
+
+		// Check that errors are not wrapped twice.
+		assert.Same(t, err, newResolveError(err, "other"))
+	}
+	assert.NoError(t, newResolveError(nil, ""))
+}
+
+func TestResolveReturnsResolveError(t *testing.T) {
+	t.Parallel()
+
+	// Check that compliance errors carry a code.
+	q := &Query{
+		FQDN:          "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion.",
+		QType:         dns.Type(dns.TypeA),
+		SecurityLevel: status.SecurityLevelNormal,
+	}
+	require.True(t, q.check())
+	_, err := Resolve(silencingTraceCtx, q)
+	assert.ErrorIs(t, err, ErrBlocked)
+	assert.ErrorIs(t, err, ErrSpecialDomainsDisabled)
+	requireResolveError(t, err, ErrorCodeSpecialDomainsDisabled)
+
+	_, err = Resolve(silencingTraceCtx, &Query{
+		FQDN:   "example.invalid.",
+		QType:  dns.Type(dns.TypeA),
+		DryRun: true,
+	})
+	assert.ErrorIs(t, err, ErrInvalid)
+	requireResolveError(t, err, ErrorCodeDryRun)
+
+	// Check that the resolver that failed is reported.
+	resolvers, _ := newTestResolverSet(testStub{err: ErrBlocked})
+	_, err = resolveWithResolvers(silencingTraceCtx, &Query{
+		FQDN:      "resolve-error.example.com.",
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	}, nil, resolvers, ServerSourceEnv, false)
+	assert.ErrorIs(t, err, ErrBlocked)
+	resolveErr := requireResolveError(t, err, ErrorCodeBlocked)
+	assert.Equal(t, resolvers[0].Info.ID(), resolveErr.ResolverID)
+}
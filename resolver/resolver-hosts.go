@@ -0,0 +1,249 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+const hostsFileCheckInterval = 10 * time.Second
+
+var (
+	hostsConn     = &hostsResolverConn{}
+	hostsResolver = &Resolver{
+		ConfigURL: ServerSourceHosts,
+		Info: &ResolverInfo{
+			Type:    ServerTypeHosts,
+			Source:  ServerSourceHosts,
+			IPScope: netutils.HostLocal,
+		},
+		Conn: hostsConn,
+	}
+	hostsResolvers = []*Resolver{hostsResolver}
+)
+
+// hostsEntry holds all IPs of a name found in the hosts files.
+type hostsEntry struct {
+	ipv4 []net.IP
+	ipv6 []net.IP
+}
+
+// hostsFileState holds the state of a hosts file in order to detect changes.
+type hostsFileState struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+type hostsResolverConn struct {
+	sync.RWMutex
+
+	entries map[string]*hostsEntry
+	files   []hostsFileState
+}
+
+// systemHostsFile returns the path of the hosts file of the operating system.
+func systemHostsFile() string {
+	if runtime.GOOS == "windows" {
+		systemRoot := os.Getenv("SystemRoot")
+		if systemRoot == "" {
+			systemRoot = `C:\Windows`
+		}
+		return filepath.Join(systemRoot, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// hostsFiles returns the paths of all hosts files that should be loaded.
+func hostsFiles() []string {
+	paths := []string{systemHostsFile()}
+	if additional := additionalHostsFile(); additional != "" {
+		paths = append(paths, additional)
+	}
+	return paths
+}
+
+// hostsFileWatcher checks the hosts files for changes and reloads them.
+func hostsFileWatcher(ctx context.Context) error {
+	ticker := time.NewTicker(hostsFileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if hostsConn.changed(hostsFiles()) {
+			hostsConn.load(hostsFiles())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// changed returns whether any of the given hosts files changed since they
+// were last loaded.
+func (hrc *hostsResolverConn) changed(paths []string) bool {
+	hrc.RLock()
+	defer hrc.RUnlock()
+
+	if len(paths) != len(hrc.files) {
+		return true
+	}
+	for i, path := range paths {
+		if hrc.files[i] != statHostsFile(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func statHostsFile(path string) hostsFileState {
+	state := hostsFileState{path: path}
+	if info, err := os.Stat(path); err == nil {
+		state.modTime = info.ModTime()
+		state.size = info.Size()
+	}
+	return state
+}
+
+// load (re)loads all given hosts files.
+func (hrc *hostsResolverConn) load(paths []string) {
+	entries := make(map[string]*hostsEntry)
+	files := make([]hostsFileState, 0, len(paths))
+
+	for _, path := range paths {
+		files = append(files, statHostsFile(path))
+
+		file, err := os.Open(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warningf("resolver: failed to open hosts file %s: %s", path, err)
+			}
+			continue
+		}
+		parseHostsFile(file, entries)
+		_ = file.Close()
+	}
+
+	hrc.Lock()
+	defer hrc.Unlock()
+
+	hrc.entries = entries
+	hrc.files = files
+	log.Debugf("resolver: loaded %d names from hosts files", len(entries))
+}
+
+// parseHostsFile parses hosts file data and adds the found entries.
+func parseHostsFile(r io.Reader, entries map[string]*hostsEntry) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Remove comments.
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// Parse IP, ignoring any zone.
+		ipField := fields[0]
+		if i := strings.IndexByte(ipField, '%'); i >= 0 {
+			ipField = ipField[:i]
+		}
+		ip := net.ParseIP(ipField)
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			fqdn := dns.Fqdn(strings.ToLower(name))
+			// Localhost is handled separately, see RFC6761.
+			if fqdn == localhostDomain[1:] || strings.HasSuffix(fqdn, localhostDomain) {
+				continue
+			}
+			if _, ok := dns.IsDomainName(fqdn); !ok {
+				continue
+			}
+
+			entry, ok := entries[fqdn]
+			if !ok {
+				entry = &hostsEntry{}
+				entries[fqdn] = entry
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				entry.ipv4 = append(entry.ipv4, ip4)
+			} else {
+				entry.ipv6 = append(entry.ipv6, ip)
+			}
+		}
+	}
+}
+
+// has returns whether the given name is defined in the hosts files.
+func (hrc *hostsResolverConn) has(fqdn string) bool {
+	hrc.RLock()
+	defer hrc.RUnlock()
+
+	_, ok := hrc.entries[fqdn]
+	return ok
+}
+
+func (hrc *hostsResolverConn) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	hrc.RLock()
+	entry, ok := hrc.entries[q.FQDN]
+	hrc.RUnlock()
+	if !ok {
+		return nil, ErrContinue
+	}
+
+	// Disable caching, as the hosts files are always available.
+	q.NoCaching = true
+
+	// Collect IPs for the requested type.
+	var ips []net.IP
+	switch uint16(q.QType) {
+	case dns.TypeA:
+		ips = entry.ipv4
+	case dns.TypeAAAA:
+		ips = entry.ipv6
+	}
+
+	records, err := netutils.IPsToRRs(q.FQDN, ips)
+	if err != nil {
+		log.Tracer(ctx).Warningf("resolver: failed to create hosts file response to %s: %s", q.ID(), err)
+	}
+
+	// Respond with an empty answer if there are no IPs of the requested type,
+	// as the name itself exists.
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Answer:   records,
+		Resolver: hostsResolver.Info.Copy(),
+	}, nil
+}
+
+func (hrc *hostsResolverConn) ReportFailure() {}
+
+func (hrc *hostsResolverConn) IsFailing() bool {
+	return false
+}
+
+func (hrc *hostsResolverConn) ResetFailure() {}
@@ -0,0 +1,93 @@
+package resolver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+var testHostsFile = `# Test hosts file
+127.0.0.1	localhost
+::1		localhost ip6-localhost
+
+192.0.2.1	example.test Alias.Example.Test # comment
+192.0.2.2	example.test
+2001:db8::1	example.test
+fe80::1%lo0	link.example.test
+invalid		broken.example.test
+`
+
+func TestParseHostsFile(t *testing.T) {
+	t.Parallel()
+
+	entries := make(map[string]*hostsEntry)
+	parseHostsFile(strings.NewReader(testHostsFile), entries)
+
+	// Localhost must be handled by the localhost path.
+	assert.NotContains(t, entries, "localhost.")
+	assert.NotContains(t, entries, "broken.example.test.")
+	assert.Contains(t, entries, "ip6-localhost.")
+
+	// Multiple IPs per name and aliases.
+	if assert.Contains(t, entries, "example.test.") {
+		assert.Len(t, entries["example.test."].ipv4, 2)
+		assert.Len(t, entries["example.test."].ipv6, 1)
+	}
+	if assert.Contains(t, entries, "alias.example.test.") {
+		assert.Len(t, entries["alias.example.test."].ipv4, 1)
+	}
+	if assert.Contains(t, entries, "link.example.test.") {
+		assert.Len(t, entries["link.example.test."].ipv6, 1)
+	}
+}
+
+func TestHostsResolverQuery(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(testHostsFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	hrc := &hostsResolverConn{}
+	assert.True(t, hrc.changed([]string{path}))
+	hrc.load([]string{path})
+	assert.False(t, hrc.changed([]string{path}))
+
+	// IPv4
+	rrCache, err := hrc.Query(context.Background(), &Query{FQDN: "example.test.", QType: dns.Type(dns.TypeA)})
+	if assert.NoError(t, err) {
+		assert.Len(t, rrCache.Answer, 2)
+	}
+
+	// IPv6
+	rrCache, err = hrc.Query(context.Background(), &Query{FQDN: "example.test.", QType: dns.Type(dns.TypeAAAA)})
+	if assert.NoError(t, err) {
+		assert.Len(t, rrCache.Answer, 1)
+	}
+
+	// No IPs of requested type.
+	rrCache, err = hrc.Query(context.Background(), &Query{FQDN: "alias.example.test.", QType: dns.Type(dns.TypeAAAA)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, dns.RcodeSuccess, rrCache.RCode)
+		assert.Empty(t, rrCache.Answer)
+	}
+
+	// Unknown names are passed on.
+	_, err = hrc.Query(context.Background(), &Query{FQDN: "unknown.example.test.", QType: dns.Type(dns.TypeA)})
+	assert.ErrorIs(t, err, ErrContinue)
+
+	// Changes are detected.
+	if err := os.WriteFile(path, []byte("192.0.2.3 other.example.test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, hrc.changed([]string{path}))
+	hrc.load([]string{path})
+	assert.True(t, hrc.has("other.example.test."))
+	assert.False(t, hrc.has("example.test."))
+}
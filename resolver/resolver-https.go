@@ -5,7 +5,7 @@
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -35,18 +35,31 @@ func (tq *HTTPSQuery) MakeCacheRecord(reply *dns.Msg, resolverInfo *ResolverInfo
 		Ns:       reply.Ns,
 		Extra:    reply.Extra,
 		Resolver: resolverInfo.Copy(),
+
+		ExtendedError: getExtendedError(reply),
 	}
 }
 
 // NewHTTPSResolver returns a new HTTPSResolver.
 func NewHTTPSResolver(resolver *Resolver) *HTTPSResolver {
+	dialer := &net.Dialer{}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
+		TLSClientConfig: resolver.addCertPinning(&tls.Config{
 			MinVersion: tls.VersionTLS12,
 			ServerName: resolver.Info.Domain,
 			// TODO: use portbase rng
-		},
+		}),
 		IdleConnTimeout: 3 * time.Minute,
+		// Connect to the bootstrapped address, while keeping the domain in the
+		// request URL for the Host header. Connections are made via the
+		// configured proxy, if any.
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			serverAddress, err := resolver.getServerAddress(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return resolver.dialServer(ctx, dialer, network, serverAddress)
+		},
 	}
 
 	client := &http.Client{Transport: tr}
@@ -62,8 +75,14 @@ func NewHTTPSResolver(resolver *Resolver) *HTTPSResolver {
 
 // Query executes the given query against the resolver.
 func (hr *HTTPSResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
-	dnsQuery := new(dns.Msg)
-	dnsQuery.SetQuestion(q.FQDN, uint16(q.QType))
+	// Wait for a free query slot.
+	releaseSlot, err := hr.acquireQuerySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSlot()
+
+	dnsQuery := q.newDNSRequest()
 
 	// Pack query and convert to base64 string
 	buf, err := dnsQuery.Pack()
@@ -95,7 +114,7 @@ func (hr *HTTPSResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 	}()
 
 	// Try to read the result
-	body, err := io.ReadAll(resp.Body)
+	body, err := readResponseBody(resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +126,21 @@ func (hr *HTTPSResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 		return nil, err
 	}
 
+	// Check if the response matches the randomized question name.
+	if err := checkQNameCase(q, dnsQuery.Question[0].Name, reply); err != nil {
+		return nil, err
+	}
+
+	// Check if the response exceeds the configured limits.
+	if err := checkResponseSize(reply); err != nil {
+		return nil, err
+	}
+
+	// Check if the reply was blocked upstream.
+	if err := hr.resolver.checkBlockedUpstream(reply); err != nil {
+		return nil, err
+	}
+
 	newRecord := &RRCache{
 		Domain:   q.FQDN,
 		Question: q.QType,
@@ -115,6 +149,8 @@ func (hr *HTTPSResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 		Ns:       reply.Ns,
 		Extra:    reply.Extra,
 		Resolver: hr.resolver.Info.Copy(),
+
+		ExtendedError: getExtendedError(reply),
 	}
 
 	// TODO: check if reply.Answer is valid
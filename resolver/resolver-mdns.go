@@ -2,7 +2,6 @@
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -40,6 +39,8 @@
 		Conn: &mDNSResolverConn{},
 	}
 	mDNSResolvers = []*Resolver{mDNSResolver}
+
+	errMDNSUnavailable = fmt.Errorf("%w: mdns connections not initialized", ErrContinue)
 )
 
 type mDNSResolverConn struct{}
@@ -292,7 +293,7 @@ func handleMDNSMessages(ctx context.Context, messages chan *dns.Msg) error { //n
 
 			var questionID string
 			if saveFullRequest {
-				rrCache.Clean(minMDnsTTL)
+				rrCache.Clean(minMDnsTTL, getTTLLimits(0).max)
 				err := rrCache.Save()
 				if err != nil {
 					log.Warningf("resolver: failed to cache RR %s: %s", rrCache.Domain, err)
@@ -315,12 +316,12 @@ func handleMDNSMessages(ctx context.Context, messages chan *dns.Msg) error { //n
 				}
 				rrCache = &RRCache{
 					Domain:   v.Header().Name,
-					Question: dns.Type(v.Header().Class),
+					Question: dns.Type(v.Header().Rrtype),
 					RCode:    dns.RcodeSuccess,
 					Answer:   []dns.RR{v},
 					Resolver: mDNSResolver.Info.Copy(),
 				}
-				rrCache.Clean(minMDnsTTL)
+				rrCache.Clean(minMDnsTTL, getTTLLimits(0).max)
 				err := rrCache.Save()
 				if err != nil {
 					log.Warningf("resolver: failed to cache RR %s: %s", rrCache.Domain, err)
@@ -359,9 +360,9 @@ func listenForDNSPackets(ctx context.Context, conn *net.UDPConn, messages chan *
 }
 
 func queryMulticastDNS(ctx context.Context, q *Query) (*RRCache, error) {
-	// check for active connections
+	// check for active connections, there might be no usable interface
 	if unicast4Conn == nil && unicast6Conn == nil {
-		return nil, errors.New("unicast mdns connections not initialized")
+		return nil, errMDNSUnavailable
 	}
 
 	// trace log
@@ -375,16 +376,22 @@ func queryMulticastDNS(ctx context.Context, q *Query) (*RRCache, error) {
 	dnsQuery.RecursionDesired = false
 
 	// create response channel
-	response := make(chan *RRCache)
+	// The handler does not block on sending, so buffer the response.
+	response := make(chan *RRCache, 1)
 
-	// save question
+	// save question, the lock must not be held while waiting for the response
 	questionsLock.Lock()
-	defer questionsLock.Unlock()
 	questions[dnsQuery.MsgHdr.Id] = &savedQuestion{
 		question: dnsQuery.Question[0],
 		expires:  time.Now().Add(10 * time.Second),
 		response: response,
 	}
+	questionsLock.Unlock()
+	defer func() {
+		questionsLock.Lock()
+		defer questionsLock.Unlock()
+		delete(questions, dnsQuery.MsgHdr.Id)
+	}()
 
 	// pack qeury
 	buf, err := dnsQuery.Pack()
@@ -0,0 +1,37 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDNSScavengedRecords(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages := make(chan *dns.Msg)
+	go func() {
+		_ = handleMDNSMessages(ctx, messages)
+	}()
+
+	// Announcements without a question are saved per record type.
+	rr, err := dns.NewRR("scavenged.local. 120 IN AAAA 2001:db8::1")
+	require.NoError(t, err)
+	announcement := new(dns.Msg)
+	announcement.Response = true
+	announcement.Answer = []dns.RR{rr}
+	messages <- announcement
+
+	assert.Eventually(t, func() bool {
+		rrCache, err := GetRRCache("scavenged.local.", dns.Type(dns.TypeAAAA))
+		return err == nil && len(rrCache.Answer) == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = GetRRCache("scavenged.local.", dns.Type(dns.TypeA))
+	assert.Error(t, err, "AAAA record should not be saved as A record")
+}
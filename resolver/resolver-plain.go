@@ -37,9 +37,16 @@ func NewPlainResolver(resolver *Resolver) *PlainResolver {
 
 // Query executes the given query against the resolver.
 func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	// Wait for a free query slot.
+	releaseSlot, err := pr.acquireQuerySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSlot()
+
 	// create query
-	dnsQuery := new(dns.Msg)
-	dnsQuery.SetQuestion(q.FQDN, uint16(q.QType))
+	dnsQuery := q.newDNSRequest()
+	setEDNSUDPSize(dnsQuery)
 
 	// get timeout from context and config
 	var timeout time.Duration
@@ -53,17 +60,34 @@ func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 	}
 
 	// create client
+	localAddr, err := pr.resolver.bindLocalAddr(getLocalAddr("udp"), "udp", pr.resolver.ServerAddress)
+	if err != nil {
+		return nil, err
+	}
 	dnsClient := &dns.Client{
 		Timeout: timeout,
 		Dialer: &net.Dialer{
 			Timeout:   timeout,
-			LocalAddr: getLocalAddr("udp"),
+			LocalAddr: localAddr,
 		},
 	}
 
 	// query server
-	reply, ttl, err := dnsClient.Exchange(dnsQuery, pr.resolver.ServerAddress)
+	reply, ttl, err := dnsClient.ExchangeContext(ctx, dnsQuery, pr.resolver.ServerAddress)
 	log.Tracer(ctx).Tracef("resolver: query took %s", ttl)
+
+	// retry over TCP if the response was truncated
+	if err == nil && reply.Truncated {
+		log.Tracer(ctx).Tracef("resolver: response from %s was truncated, retrying over TCP", pr.resolver.Info.DescriptiveName())
+		dnsClient.Net = "tcp"
+		dnsClient.Dialer.LocalAddr, err = pr.resolver.bindLocalAddr(getLocalAddr("tcp"), "tcp", pr.resolver.ServerAddress)
+		if err != nil {
+			return nil, err
+		}
+		reply, ttl, err = dnsClient.ExchangeContext(ctx, dnsQuery, pr.resolver.ServerAddress)
+		log.Tracer(ctx).Tracef("resolver: tcp query took %s", ttl)
+	}
+
 	// error handling
 	if err != nil {
 		// Hint network environment at failed connection if err is not a timeout.
@@ -75,9 +99,19 @@ func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 		return nil, err
 	}
 
+	// check if the response matches the randomized question name
+	if err := checkQNameCase(q, dnsQuery.Question[0].Name, reply); err != nil {
+		return nil, err
+	}
+
+	// check if the response exceeds the configured limits
+	if err := checkResponseSize(reply); err != nil {
+		return nil, err
+	}
+
 	// check if blocked
-	if pr.resolver.IsBlockedUpstream(reply) {
-		return nil, &BlockedUpstreamError{pr.resolver.Info.DescriptiveName()}
+	if err := pr.resolver.checkBlockedUpstream(reply); err != nil {
+		return nil, err
 	}
 
 	// hint network environment at successful connection
@@ -91,6 +125,8 @@ func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 		Ns:       reply.Ns,
 		Extra:    reply.Extra,
 		Resolver: pr.resolver.Info.Copy(),
+
+		ExtendedError: getExtendedError(reply),
 	}
 
 	// TODO: check if reply.Answer is valid
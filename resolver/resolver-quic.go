@@ -0,0 +1,371 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/netenv"
+)
+
+const (
+	quicConnectionEstablishmentTimeout = 3 * time.Second
+	quicMaxIdleTimeout                 = 30 * time.Second
+
+	// DoQ error codes as defined in RFC 9250, Section 4.3.
+	doqNoError          quic.ApplicationErrorCode = 0x0
+	doqInternalError    quic.ApplicationErrorCode = 0x1
+	doqRequestCancelled quic.StreamErrorCode      = 0x3
+)
+
+// QUICResolver is a resolver using DNS-over-QUIC (RFC 9250). Every query is
+// sent on a new stream of a single, shared QUIC connection.
+type QUICResolver struct {
+	BasicResolverConn
+
+	// tlsConfig holds the TLS configuration of the resolver. The contained
+	// session cache enables session resumption and 0-RTT.
+	tlsConfig *tls.Config
+	// quicConfig holds the QUIC configuration of the resolver.
+	quicConfig *quic.Config
+	// resolverConn holds the current connection to the DNS server.
+	resolverConn *quicResolverConn
+	// dialGroup makes concurrent queries share a single dial.
+	dialGroup singleflight.Group
+	// quicNetworkChangedFlag is used to re-establish the connection after a
+	// network change.
+	quicNetworkChangedFlag *utils.Flag
+}
+
+// quicResolverConn represents a single QUIC connection to an upstream DNS server.
+type quicResolverConn struct {
+	conn      quic.EarlyConnection
+	transport *quic.Transport
+	udpConn   *net.UDPConn
+}
+
+// close closes the QUIC connection and the underlying socket.
+func (qrc *quicResolverConn) close(code quic.ApplicationErrorCode, reason string) {
+	_ = qrc.conn.CloseWithError(code, reason)
+	_ = qrc.transport.Close()
+	_ = qrc.udpConn.Close()
+}
+
+// alive returns whether the connection is still usable.
+func (qrc *quicResolverConn) alive() bool {
+	select {
+	case <-qrc.conn.Context().Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// NewQUICResolver returns a new QUICResolver.
+func NewQUICResolver(resolver *Resolver) *QUICResolver {
+	newResolver := &QUICResolver{
+		BasicResolverConn: BasicResolverConn{
+			resolver: resolver,
+		},
+		tlsConfig: resolver.addCertPinning(&tls.Config{
+			MinVersion:         tls.VersionTLS13,
+			ServerName:         resolver.Info.Domain,
+			NextProtos:         []string{"doq"},
+			ClientSessionCache: tls.NewLRUClientSessionCache(4),
+			// TODO: use portbase rng
+		}),
+		quicConfig: &quic.Config{
+			HandshakeIdleTimeout: quicConnectionEstablishmentTimeout,
+			MaxIdleTimeout:       quicMaxIdleTimeout,
+			KeepAlivePeriod:      quicMaxIdleTimeout / 2,
+		},
+		quicNetworkChangedFlag: netenv.GetNetworkChangedFlag(),
+	}
+	newResolver.BasicResolverConn.init()
+	return newResolver
+}
+
+// getResolverConn returns the current connection, if it is still usable.
+func (qr *QUICResolver) getResolverConn() *quicResolverConn {
+	qr.Lock()
+	defer qr.Unlock()
+
+	// Drop the connection if the network changed, as the path to the server
+	// most probably changed with it.
+	if qr.quicNetworkChangedFlag.IsSet() {
+		qr.quicNetworkChangedFlag.Refresh()
+		if qr.resolverConn != nil {
+			log.Debugf("resolver: re-establishing connection to %s because of network change", qr.resolver.Info.DescriptiveName())
+			qr.resolverConn.close(doqNoError, "network changed")
+			qr.resolverConn = nil
+		}
+	}
+
+	// Check if we have a usable connection.
+	if qr.resolverConn != nil {
+		if qr.resolverConn.alive() {
+			return qr.resolverConn
+		}
+		qr.resolverConn.close(doqNoError, "")
+		qr.resolverConn = nil
+	}
+	return nil
+}
+
+func (qr *QUICResolver) getOrCreateResolverConn(ctx context.Context) (*quicResolverConn, error) {
+	if resolverConn := qr.getResolverConn(); resolverConn != nil {
+		return resolverConn, nil
+	}
+
+	// Dial without holding the lock, so that a slow handshake does not block
+	// other users of the resolver. Concurrent queries wait for the same dial.
+	dialResult := qr.dialGroup.DoChan("", func() (interface{}, error) {
+		// Check again, as a dial may have finished in the meantime.
+		if resolverConn := qr.getResolverConn(); resolverConn != nil {
+			return resolverConn, nil
+		}
+
+		resolverConn, err := qr.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		qr.Lock()
+		defer qr.Unlock()
+		qr.resolverConn = resolverConn
+		return resolverConn, nil
+	})
+
+	select {
+	case result := <-dialResult:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*quicResolverConn), nil //nolint:forcetypeassert // Only one type is returned.
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dial establishes a new connection to the DNS server.
+func (qr *QUICResolver) dial(ctx context.Context) (*quicResolverConn, error) {
+	// Check if we are shutting down before dialing!
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-module.Stopping():
+		return nil, ErrShuttingDown
+	default:
+	}
+
+	// Get server address, bootstrap if needed.
+	serverAddress, err := qr.resolver.getServerAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	serverAddr, err := net.ResolveUDPAddr("udp", serverAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse server address of %s: %s", ErrFailure, qr.resolver.Info.DescriptiveName(), err)
+	}
+
+	// Create socket with an authenticated local address.
+	boundAddr, err := qr.resolver.bindLocalAddr(getLocalAddr("udp"), "udp", serverAddress)
+	if err != nil {
+		return nil, err
+	}
+	var localAddr *net.UDPAddr
+	if addr, ok := boundAddr.(*net.UDPAddr); ok {
+		localAddr = addr
+	}
+	udpConn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create socket for %s: %s", ErrFailure, qr.resolver.Info.DescriptiveName(), err)
+	}
+
+	// Connect to server.
+	// Dialing early allows sending queries in 0-RTT data, if the server supports it.
+	transport := &quic.Transport{Conn: udpConn}
+	dialCtx, cancel := context.WithTimeout(ctx, quicConnectionEstablishmentTimeout)
+	defer cancel()
+	conn, err := transport.DialEarly(dialCtx, serverAddr, qr.tlsConfig, qr.quicConfig)
+	if err != nil {
+		_ = transport.Close()
+		_ = udpConn.Close()
+
+		// Hint network environment at failed connection.
+		netenv.ReportFailedConnection()
+
+		log.Debugf("resolver: failed to connect to %s: %s", qr.resolver.Info.DescriptiveName(), err)
+		return nil, fmt.Errorf("%w: failed to connect to %s: %s", connectFailureReason(err), qr.resolver.Info.DescriptiveName(), err)
+	}
+
+	// Hint network environment at successful connection.
+	netenv.ReportSuccessfulConnection()
+
+	// Log that a connection to the resolver was established.
+	log.Debugf(
+		"resolver: connected to %s",
+		qr.resolver.Info.DescriptiveName(),
+	)
+
+	return &quicResolverConn{
+		conn:      conn,
+		transport: transport,
+		udpConn:   udpConn,
+	}, nil
+}
+
+// dropResolverConn closes and removes the given connection, if it is still the current one.
+func (qr *QUICResolver) dropResolverConn(resolverConn *quicResolverConn) {
+	qr.Lock()
+	defer qr.Unlock()
+
+	if qr.resolverConn == resolverConn {
+		qr.resolverConn.close(doqInternalError, "")
+		qr.resolverConn = nil
+	}
+}
+
+// Query executes the given query against the resolver.
+func (qr *QUICResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	// Wait for a free query slot.
+	releaseSlot, err := qr.acquireQuerySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSlot()
+
+	// Create query request.
+	// The message ID must be zero, see RFC 9250, Section 4.2.1.
+	dnsQuery := q.newDNSRequest()
+	dnsQuery.Id = 0
+
+	// Pack query and prefix with length.
+	packed, err := dnsQuery.Pack()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+
+	// Send query, retry once with a fresh connection if the current one broke.
+	var reply *dns.Msg
+	for i := 0; i < 2; i++ {
+		var resolverConn *quicResolverConn
+		resolverConn, err = qr.getOrCreateResolverConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		reply, err = qr.exchange(ctx, resolverConn, buf)
+		if err == nil {
+			break
+		}
+
+		// Return immediately if the query itself was canceled.
+		if ctx.Err() != nil || errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+
+		// Otherwise, the connection is most likely broken.
+		log.Tracer(ctx).Debugf("resolver: query to %s failed, dropping connection: %s", qr.resolver.Info.DescriptiveName(), err)
+		qr.dropResolverConn(resolverConn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the response matches the randomized question name.
+	if err := checkQNameCase(q, dnsQuery.Question[0].Name, reply); err != nil {
+		return nil, err
+	}
+
+	// Check if the response exceeds the configured limits.
+	if err := checkResponseSize(reply); err != nil {
+		return nil, err
+	}
+
+	// Check if the reply was blocked upstream.
+	if err := qr.resolver.checkBlockedUpstream(reply); err != nil {
+		return nil, err
+	}
+
+	// Create RRCache from reply and return it.
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    reply.Rcode,
+		Answer:   reply.Answer,
+		Ns:       reply.Ns,
+		Extra:    reply.Extra,
+		Resolver: qr.resolver.Info.Copy(),
+
+		ExtendedError: getExtendedError(reply),
+	}, nil
+}
+
+// exchange sends the query on a new stream and reads the response.
+func (qr *QUICResolver) exchange(ctx context.Context, resolverConn *quicResolverConn, query []byte) (*dns.Msg, error) {
+	stream, err := resolverConn.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open stream: %s", ErrFailure, err)
+	}
+
+	// Apply deadline and cancel the stream when the context is canceled.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultRequestTimeout)
+	}
+	_ = stream.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelRead(doqRequestCancelled)
+		stream.CancelWrite(doqRequestCancelled)
+	})
+	defer stop()
+
+	// Write query and signal that there is no more data.
+	if _, err := stream.Write(query); err != nil {
+		return nil, qr.streamError(ctx, err)
+	}
+	_ = stream.Close()
+
+	// Read length-prefixed response.
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		return nil, qr.streamError(ctx, err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return nil, qr.streamError(ctx, err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		return nil, fmt.Errorf("%w: failed to unpack reply: %s", ErrFailure, err)
+	}
+	return reply, nil
+}
+
+func (qr *QUICResolver) streamError(ctx context.Context, err error) error {
+	var netErr net.Error
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	default:
+		return fmt.Errorf("%w: %s", ErrFailure, err)
+	}
+}
@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICDialWithoutLock(t *testing.T) {
+	t.Parallel()
+
+	// Start a server that never answers, so that the handshake hangs.
+	silentConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = silentConn.Close()
+	}()
+
+	resolversLock.Lock()
+	resolver, _, err := createResolver("doq://"+silentConn.LocalAddr().String()+"?verify=dns.example.com", ServerSourceConfigured)
+	resolversLock.Unlock()
+	require.NoError(t, err)
+	qr, ok := resolver.Conn.(*QUICResolver)
+	require.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	dialErrs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := qr.getOrCreateResolverConn(ctx)
+			dialErrs <- err
+		}()
+	}
+
+	// Check that the resolver is not locked while dialing.
+	time.Sleep(100 * time.Millisecond)
+	unlocked := make(chan struct{})
+	go func() {
+		qr.Lock()
+		defer qr.Unlock()
+		close(unlocked)
+	}()
+	select {
+	case <-unlocked:
+	case err := <-dialErrs:
+		t.Fatalf("dial finished before the handshake timed out: %s", err)
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("resolver is locked while dialing")
+	}
+
+	assert.Error(t, <-dialErrs)
+	assert.Error(t, <-dialErrs)
+}
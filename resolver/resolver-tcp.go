@@ -63,6 +63,9 @@ type tcpResolverConn struct {
 type tcpQuery struct {
 	Query    *Query
 	Response chan *dns.Msg
+
+	// qname holds the question name as sent to the server.
+	qname string
 }
 
 // MakeCacheRecord creates an RRCache record from a reply.
@@ -75,6 +78,8 @@ func (tq *tcpQuery) MakeCacheRecord(reply *dns.Msg, resolverInfo *ResolverInfo)
 		Ns:       reply.Ns,
 		Extra:    reply.Extra,
 		Resolver: resolverInfo.Copy(),
+
+		ExtendedError: getExtendedError(reply),
 	}
 }
 
@@ -97,11 +102,11 @@ func NewTCPResolver(resolver *Resolver) *TCPResolver {
 // UseTLS enabled TLS for the TCPResolver. TLS settings must be correctly configured in the Resolver.
 func (tr *TCPResolver) UseTLS() *TCPResolver {
 	tr.dnsClient.Net = "tcp-tls"
-	tr.dnsClient.TLSConfig = &tls.Config{
+	tr.dnsClient.TLSConfig = tr.resolver.addCertPinning(&tls.Config{
 		MinVersion: tls.VersionTLS12,
 		ServerName: tr.resolver.Info.Domain,
 		// TODO: use portbase rng
-	}
+	})
 	return tr
 }
 
@@ -142,14 +147,20 @@ func (tr *TCPResolver) getOrCreateResolverConn(ctx context.Context) (*tcpResolve
 		KeepAlive: defaultClientTTL,
 	}
 
+	// Get server address, bootstrap if needed.
+	serverAddress, err := tr.resolver.getServerAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to server.
-	conn, err := tr.dnsClient.Dial(tr.resolver.ServerAddress)
+	conn, err := tr.dial(ctx, serverAddress)
 	if err != nil {
 		// Hint network environment at failed connection.
 		netenv.ReportFailedConnection()
 
 		log.Debugf("resolver: failed to connect to %s: %s", tr.resolver.Info.DescriptiveName(), err)
-		return nil, fmt.Errorf("%w: failed to connect to %s: %s", ErrFailure, tr.resolver.Info.DescriptiveName(), err)
+		return nil, err
 	}
 
 	// Hint network environment at successful connection.
@@ -183,8 +194,54 @@ func (tr *TCPResolver) getOrCreateResolverConn(ctx context.Context) (*tcpResolve
 	return resolverConn, nil
 }
 
+// dial connects to the server. If a proxy is configured, the connection is
+// made via the proxy and TLS is established on top of the proxied connection.
+func (tr *TCPResolver) dial(ctx context.Context, serverAddress string) (*dns.Conn, error) {
+	proxyURL, err := tr.resolver.getProxy()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get proxy for %s: %s", ErrFailure, tr.resolver.Info.DescriptiveName(), err)
+	}
+	if proxyURL == nil {
+		tr.dnsClient.Dialer, err = tr.resolver.bindDialer(tr.dnsClient.Dialer, "tcp", serverAddress)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := tr.dnsClient.Dial(serverAddress)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to connect to %s: %s", connectFailureReason(err), tr.resolver.Info.DescriptiveName(), err)
+		}
+		return conn, nil
+	}
+
+	// Bound the proxied dial by the connection establishment timeout too.
+	dialCtx, cancel := context.WithTimeout(ctx, tcpConnectionEstablishmentTimeout)
+	defer cancel()
+
+	netConn, err := tr.resolver.dialServer(dialCtx, tr.dnsClient.Dialer, "tcp", serverAddress)
+	if err != nil {
+		return nil, err
+	}
+	if tr.dnsClient.TLSConfig != nil {
+		tlsConn := tls.Client(netConn, tr.dnsClient.TLSConfig)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			_ = netConn.Close()
+			return nil, proxyDialError(dialCtx, tr.resolver, err)
+		}
+		netConn = tlsConn
+	}
+
+	return &dns.Conn{Conn: netConn}, nil
+}
+
 // Query executes the given query against the resolver.
 func (tr *TCPResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	// Wait for a free query slot.
+	releaseSlot, err := tr.acquireQuerySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSlot()
+
 	// Get resolver connection.
 	resolverConn, err := tr.getOrCreateResolverConn(ctx)
 	if err != nil {
@@ -227,9 +284,19 @@ func (tr *TCPResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
 		return nil, ErrFailure
 	}
 
+	// Check if the response matches the randomized question name.
+	if err := checkQNameCase(q, tq.qname, reply); err != nil {
+		return nil, err
+	}
+
+	// Check if the response exceeds the configured limits.
+	if err := checkResponseSize(reply); err != nil {
+		return nil, err
+	}
+
 	// Check if the reply was blocked upstream.
-	if tr.resolver.IsBlockedUpstream(reply) {
-		return nil, &BlockedUpstreamError{tr.resolver.Info.DescriptiveName()}
+	if err := tr.resolver.checkBlockedUpstream(reply); err != nil {
+		return nil, err
 	}
 
 	// Create RRCache from reply and return it.
@@ -296,8 +363,8 @@ func (trc *tcpResolverConn) handler(workerCtx context.Context) error {
 			// Handle DNS query request.
 
 			// Create dns request message.
-			msg := &dns.Msg{}
-			msg.SetQuestion(tq.Query.FQDN, uint16(tq.Query.QType))
+			msg := tq.Query.newDNSRequest()
+			tq.qname = msg.Question[0].Name
 
 			// Assign a unique message ID.
 			trc.assignUniqueID(msg)
@@ -376,13 +443,21 @@ func (trc *tcpResolverConn) handleQueryResponse(msg *dns.Msg) {
 	}
 
 	// If caching is disabled for this query, we are done.
-	if tq.Query.NoCaching {
+	if tq.Query.NoCaching || tq.Query.CheckingDisabled {
+		return
+	}
+
+	// Do not cache responses that might be spoofed.
+	if err := checkQNameCase(tq.Query, tq.qname, msg); err != nil {
+		log.Debugf("resolver: not caching late reply from %s: %s", trc.resolverInfo.DescriptiveName(), err)
 		return
 	}
 
 	// Otherwise, we can persist the answer in case the request is repeated.
 	rrCache := tq.MakeCacheRecord(msg, trc.resolverInfo)
-	rrCache.Clean(minTTL)
+	tq.Query.applyCacheScope(rrCache)
+	limits := getTTLLimits(tq.Query.SecurityLevel)
+	rrCache.Clean(limits.min, limits.max)
 	err := rrCache.Save()
 	if err != nil {
 		log.Warningf(
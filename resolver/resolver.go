@@ -4,6 +4,7 @@
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"sync"
 	"time"
 
@@ -17,17 +18,23 @@
 
 // DNS Resolver Attributes.
 const (
-	ServerTypeDNS  = "dns"
-	ServerTypeTCP  = "tcp"
-	ServerTypeDoT  = "dot"
-	ServerTypeDoH  = "doh"
-	ServerTypeMDNS = "mdns"
-	ServerTypeEnv  = "env"
+	ServerTypeDNS     = "dns"
+	ServerTypeTCP     = "tcp"
+	ServerTypeDoT     = "dot"
+	ServerTypeDoH     = "doh"
+	ServerTypeDoQ     = "doq"
+	ServerTypeMDNS    = "mdns"
+	ServerTypeEnv     = "env"
+	ServerTypeHosts   = "hosts"
+	ServerTypeRewrite = "rewrite"
 
 	ServerSourceConfigured      = "config"
 	ServerSourceOperatingSystem = "system"
 	ServerSourceMDNS            = "mdns"
 	ServerSourceEnv             = "env"
+	ServerSourceHosts           = "hosts"
+	ServerSourceBootstrap       = "bootstrap"
+	ServerSourceRewrite         = "rewrite"
 )
 
 // DNS resolver scheme aliases.
@@ -39,12 +46,34 @@
 // FailThreshold is amount of errors a resolvers must experience in order to be regarded as failed.
 var FailThreshold = 20
 
+// Circuit breaker states of a resolver.
+const (
+	// CircuitClosed signifies that the resolver is used normally.
+	CircuitClosed = "closed"
+	// CircuitOpen signifies that the resolver failed and is not used.
+	CircuitOpen = "open"
+	// CircuitHalfOpen signifies that a single probe query is sent to the
+	// resolver in order to check if it recovered.
+	CircuitHalfOpen = "half-open"
+)
+
+const (
+	// circuitMaxBackoff is the maximum duration a circuit stays open.
+	circuitMaxBackoff = 1 * time.Hour
+	// circuitProbeTimeout is the duration after which another probe query is
+	// permitted, if the previous probe did not succeed or fail.
+	circuitProbeTimeout = 10 * time.Second
+)
+
 // Resolver holds information about an active resolver.
 type Resolver struct {
 	// Server config url (and ID)
 	// Supported parameters:
 	// - `verify=domain`: verify domain (dot only)
 	// - `name=name`: human readable name for resolver
+	// - `proxy=socks5://ip:port`: connect via a SOCKS5 proxy (tcp, dot and doh only)
+	// - `pin=spki:hash` or `pin=cert:hash`: pin the certificate of the server (dot, doh and doq only)
+	// - `source=ip` or `source=interface`: connect from the given local IP address or network interface
 	// - `blockedif=empty`: how to detect if the dns service blocked something
 	//	- `empty`: NXDomain result, but without any other record in any section
 	//  - `refused`: Request was refused
@@ -71,6 +100,22 @@ type Resolver struct {
 	SearchOnly bool
 	Path       string
 
+	// bootstrap holds the bootstrapped IP address of the server, if the
+	// server is configured by domain only.
+	bootstrap *bootstrapState
+
+	// proxy holds the proxy to connect to the server with. If nil, the
+	// globally configured proxy is used, if any.
+	proxy *url.URL
+
+	// pins holds the certificate pins of the server. If set, the server must
+	// present a certificate matching any of them.
+	pins []certPin
+
+	// source holds the local address or interface to connect to the server
+	// from. If nil, the default route is used.
+	source *resolverSource
+
 	// logic interface
 	Conn ResolverConn `json:"-"`
 }
@@ -83,11 +128,11 @@ type ResolverInfo struct {
 	Name string
 
 	// Type describes the type of the resolver.
-	// Possible values include dns, tcp, dot, doh, mdns, env.
+	// Possible values include dns, tcp, dot, doh, doq, mdns, env, hosts, rewrite.
 	Type string
 
 	// Source describes where the resolver configuration came from.
-	// Possible values include config, system, mdns, env.
+	// Possible values include config, system, mdns, env, hosts, rewrite.
 	Source string
 
 	// IP is the IP address of the resolver
@@ -116,6 +161,10 @@ func (info *ResolverInfo) ID() string {
 			info.id = ServerTypeMDNS
 		case ServerTypeEnv:
 			info.id = ServerTypeEnv
+		case ServerTypeHosts:
+			info.id = ServerTypeHosts
+		case ServerTypeRewrite:
+			info.id = ServerTypeRewrite
 		case ServerTypeDoH:
 			info.id = fmt.Sprintf( //nolint:nosprintfhostport // Not used as URL.
 				"https://%s:%d#%s",
@@ -130,6 +179,13 @@ func (info *ResolverInfo) ID() string {
 				info.Port,
 				info.Source,
 			)
+		case ServerTypeDoQ:
+			info.id = fmt.Sprintf( //nolint:nosprintfhostport // Not used as URL.
+				"doq://%s:%d#%s",
+				info.Domain,
+				info.Port,
+				info.Source,
+			)
 		default:
 			info.id = fmt.Sprintf(
 				"%s://%s:%d#%s",
@@ -152,6 +208,10 @@ func (info *ResolverInfo) DescriptiveName() string {
 		return "MDNS"
 	case info.Type == ServerTypeEnv:
 		return "Portmaster Environment"
+	case info.Type == ServerTypeHosts:
+		return "Hosts File"
+	case info.Type == ServerTypeRewrite:
+		return "Rewrite Rule"
 	case info.Name != "":
 		return fmt.Sprintf(
 			"%s (%s)",
@@ -206,6 +266,20 @@ func (resolver *Resolver) String() string {
 	return resolver.Info.DescriptiveName()
 }
 
+// circuitBreaker is implemented by resolver connections with a circuit breaker.
+type circuitBreaker interface {
+	allowQuery() bool
+	CircuitState() string
+}
+
+// allowQuery returns whether a query may be sent to the resolver.
+func (resolver *Resolver) allowQuery() bool {
+	if cb, ok := resolver.Conn.(circuitBreaker); ok {
+		return cb.allowQuery()
+	}
+	return !resolver.Conn.IsFailing()
+}
+
 // ResolverConn is an interface to implement different types of query backends.
 type ResolverConn interface { //nolint:golint // TODO
 	Query(ctx context.Context, q *Query) (*RRCache, error)
@@ -220,48 +294,124 @@ type BasicResolverConn struct {
 
 	resolver *Resolver
 
-	failing      *abool.AtomicBool
-	failingUntil time.Time
-	fails        int
-	failLock     sync.Mutex
+	// failing is set while the circuit is not closed.
+	failing *abool.AtomicBool
+	// fails holds the amount of consecutive failures.
+	fails    int
+	failLock sync.Mutex
+
+	circuitState       string
+	circuitOpenUntil   time.Time
+	circuitOpens       int
+	circuitProbeActive bool
+	circuitProbeStart  time.Time
+
+	// lastSuccess and lastFailure hold the time of the last reported success
+	// and failure. They are guarded by the failLock.
+	lastSuccess time.Time
+	lastFailure time.Time
 
 	networkChangedFlag *utils.Flag
+
+	inFlight        int
+	queued          int
+	inFlightLock    sync.Mutex
+	inFlightRelease chan struct{}
 }
 
 // init initializes the basic resolver connection.
 func (brc *BasicResolverConn) init() {
 	brc.failing = abool.New()
+	brc.circuitState = CircuitClosed
 	brc.networkChangedFlag = netenv.GetNetworkChangedFlag()
+	brc.inFlightRelease = make(chan struct{})
 }
 
 // ReportFailure reports that an error occurred with this resolver.
 func (brc *BasicResolverConn) ReportFailure() {
+	brc.failLock.Lock()
+	brc.lastFailure = time.Now()
+	brc.failLock.Unlock()
+
 	// Don't mark resolver as failed if we are offline.
 	if !netenv.Online() {
 		return
 	}
 
+	brc.recordFailure()
+
+	// Report to netenv that a configured server failed.
+	if brc.resolver.Info.Source == ServerSourceConfigured {
+		netenv.ConnectedToDNS.UnSet()
+	}
+}
+
+// recordFailure counts a failure and opens the circuit if needed.
+func (brc *BasicResolverConn) recordFailure() {
 	brc.failLock.Lock()
 	defer brc.failLock.Unlock()
 
-	brc.fails++
-	if brc.fails > FailThreshold {
-		brc.failing.Set()
-		brc.failingUntil = time.Now().Add(time.Duration(nameserverRetryRate()) * time.Second)
-		brc.fails = 0
+	switch brc.circuitState {
+	case CircuitHalfOpen:
+		// The probe failed, open the circuit again with a longer backoff.
+		brc.openCircuit()
+	case CircuitOpen:
+		// Failures of queries that were sent before the circuit opened.
+	default:
+		brc.fails++
+		if brc.fails > FailThreshold {
+			brc.openCircuit()
+		}
+	}
+}
 
-		// Refresh the network changed flag in order to only regard changes after
-		// the fail.
-		brc.networkChangedFlag.Refresh()
+// openCircuit opens the circuit for an exponentially increasing duration.
+// The failLock must be held.
+func (brc *BasicResolverConn) openCircuit() {
+	backoff := time.Duration(nameserverRetryRate()) * time.Second
+	maxBackoff := circuitMaxBackoff
+	if backoff > maxBackoff {
+		maxBackoff = backoff
+	}
+	for i := 0; i < brc.circuitOpens && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
 	}
 
-	// Report to netenv that a configured server failed.
-	if brc.resolver.Info.Source == ServerSourceConfigured {
-		netenv.ConnectedToDNS.UnSet()
+	brc.circuitState = CircuitOpen
+	brc.circuitOpenUntil = time.Now().Add(backoff)
+	brc.circuitOpens++
+	brc.circuitProbeActive = false
+	brc.fails = 0
+	brc.failing.Set()
+
+	// Refresh the network changed flag in order to only regard changes after
+	// the fail.
+	brc.networkChangedFlag.Refresh()
+}
+
+// closeCircuit fully resets the circuit. The failLock must be held.
+func (brc *BasicResolverConn) closeCircuit() {
+	brc.circuitState = CircuitClosed
+	brc.circuitOpens = 0
+	brc.circuitProbeActive = false
+	brc.fails = 0
+	brc.failing.UnSet()
+}
+
+// checkNetworkChange closes the circuit if the network changed since it was
+// opened. The failLock must be held.
+func (brc *BasicResolverConn) checkNetworkChange() {
+	if brc.networkChangedFlag.IsSet() {
+		brc.networkChangedFlag.Refresh()
+		brc.closeCircuit()
 	}
 }
 
-// IsFailing returns if this resolver is currently failing.
+// IsFailing returns if this resolver is currently failing, ie. if its circuit
+// does not permit any queries.
 func (brc *BasicResolverConn) IsFailing() bool {
 	// Check if not failing.
 	if !brc.failing.IsSet() {
@@ -272,27 +422,135 @@ func (brc *BasicResolverConn) IsFailing() bool {
 	defer brc.failLock.Unlock()
 
 	// Reset failure status if the network changed since the last query.
-	if brc.networkChangedFlag.IsSet() {
-		brc.networkChangedFlag.Refresh()
-		brc.fails = 0
-		brc.failing.UnSet()
+	brc.checkNetworkChange()
+
+	switch brc.circuitState {
+	case CircuitOpen:
+		return time.Now().Before(brc.circuitOpenUntil)
+	case CircuitHalfOpen:
+		return brc.circuitProbeActive && time.Since(brc.circuitProbeStart) < circuitProbeTimeout
+	default:
 		return false
 	}
+}
+
+// allowQuery returns whether a query may be sent to the resolver. When the
+// open circuit expires, it is half-opened and a single probe query is permitted.
+func (brc *BasicResolverConn) allowQuery() bool {
+	// Check if not failing.
+	if !brc.failing.IsSet() {
+		return true
+	}
+
+	brc.failLock.Lock()
+	defer brc.failLock.Unlock()
+
+	// Reset failure status if the network changed since the last query.
+	brc.checkNetworkChange()
+
+	switch brc.circuitState {
+	case CircuitOpen:
+		if time.Now().Before(brc.circuitOpenUntil) {
+			return false
+		}
+		brc.circuitState = CircuitHalfOpen
+	case CircuitHalfOpen:
+		if brc.circuitProbeActive && time.Since(brc.circuitProbeStart) < circuitProbeTimeout {
+			return false
+		}
+	default:
+		return true
+	}
 
-	// Check if we are still
-	return time.Now().Before(brc.failingUntil)
+	// Permit a single probe query.
+	brc.circuitProbeActive = true
+	brc.circuitProbeStart = time.Now()
+	return true
+}
+
+// CircuitState returns the current circuit breaker state of the resolver.
+func (brc *BasicResolverConn) CircuitState() string {
+	brc.failLock.Lock()
+	defer brc.failLock.Unlock()
+
+	return brc.circuitState
 }
 
 // ResetFailure resets the failure status.
 func (brc *BasicResolverConn) ResetFailure() {
-	if brc.failing.SetToIf(true, false) {
-		brc.failLock.Lock()
-		defer brc.failLock.Unlock()
+	brc.failLock.Lock()
+	brc.lastSuccess = time.Now()
+	if brc.failing.IsSet() {
+		brc.closeCircuit()
+	} else {
 		brc.fails = 0
 	}
+	brc.failLock.Unlock()
 
 	// Report to netenv that a configured server succeeded.
 	if brc.resolver.Info.Source == ServerSourceConfigured {
 		netenv.ConnectedToDNS.Set()
 	}
 }
+
+// acquireQuerySlot waits until the number of in-flight queries is below the
+// configured limit and reserves a slot for a new query. If too many queries
+// are already waiting, ErrContinue is returned, so that the next resolver is
+// asked instead. The returned function must be called when the query is done.
+func (brc *BasicResolverConn) acquireQuerySlot(ctx context.Context) (release func(), err error) {
+	limit := int(nameserverMaxQueries())
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	brc.inFlightLock.Lock()
+	for brc.inFlight >= limit {
+		// Check if the queue is full.
+		if brc.queued >= limit {
+			brc.inFlightLock.Unlock()
+			return nil, fmt.Errorf("%w: too many queries in flight to %s", ErrContinue, brc.resolver.Info.DescriptiveName())
+		}
+
+		// Wait for a slot to be released.
+		brc.queued++
+		released := brc.inFlightRelease
+		brc.inFlightLock.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-module.Stopping():
+			err = ErrShuttingDown
+		}
+
+		brc.inFlightLock.Lock()
+		brc.queued--
+		if err != nil {
+			brc.inFlightLock.Unlock()
+			return nil, err
+		}
+	}
+	brc.inFlight++
+	brc.inFlightLock.Unlock()
+
+	return brc.releaseQuerySlot, nil
+}
+
+func (brc *BasicResolverConn) releaseQuerySlot() {
+	brc.inFlightLock.Lock()
+	defer brc.inFlightLock.Unlock()
+
+	brc.inFlight--
+	// Wake up all waiting queries.
+	close(brc.inFlightRelease)
+	brc.inFlightRelease = make(chan struct{})
+}
+
+// InFlightQueries returns the number of queries currently in flight to the resolver.
+func (brc *BasicResolverConn) InFlightQueries() int {
+	brc.inFlightLock.Lock()
+	defer brc.inFlightLock.Unlock()
+
+	return brc.inFlight
+}
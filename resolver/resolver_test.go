@@ -3,13 +3,16 @@
 import (
 	"context"
 	"flag"
+	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/log"
 )
 
@@ -157,4 +160,322 @@ func testSuffix(t *testing.T, fqdn, domainRoot string, icannSpace bool) {
 	q.InitPublicSuffixData()
 	assert.Equal(t, domainRoot, q.DomainRoot)
 	assert.Equal(t, icannSpace, q.ICANNSpace)
+
+	root, icann := EffectiveTLDPlusOne(fqdn)
+	assert.Equal(t, domainRoot, root)
+	assert.Equal(t, icannSpace, icann)
+}
+
+func TestEffectiveTLDPlusOne(t *testing.T) {
+	t.Parallel()
+
+	// Multi-label public suffixes.
+	for fqdn, expectedRoot := range map[string]string{
+		"www.bbc.co.uk.":             "bbc.co.uk.",
+		"co.uk.":                     "",
+		"a.b.example.com.au.":        "example.com.au.",
+		"com.au.":                    "",
+		"shop.example.co.jp.":        "example.co.jp.",
+		"deep.sub.domain.gov.uk.":    "domain.gov.uk.",
+		"www.some.city.kawasaki.jp.": "city.kawasaki.jp.",
+		"x.y.kawasaki.jp.":           "x.y.kawasaki.jp.",
+	} {
+		root, icann := EffectiveTLDPlusOne(fqdn)
+		assert.Equal(t, expectedRoot, root, fqdn)
+		assert.True(t, icann, fqdn)
+	}
+
+	// Domains without the trailing dot are accepted.
+	root, icann := EffectiveTLDPlusOne("www.bbc.co.uk")
+	assert.Equal(t, "bbc.co.uk.", root)
+	assert.True(t, icann)
+
+	// Private multi-label suffixes are not in ICANN space by themselves, but
+	// still count as public suffix.
+	root, _ = EffectiveTLDPlusOne("www.foo.blogspot.co.uk.")
+	assert.Equal(t, "foo.blogspot.co.uk.", root)
+}
+
+func TestPublicSuffixOnResult(t *testing.T) { //nolint:paralleltest // Saves to the global cache.
+	domain := "www.public-suffix.co.uk."
+	saveTestRecord(t, domain, dns.RcodeSuccess, time.Now().Add(time.Hour).Unix(), domain+" 17 IN A 192.0.2.1")
+
+	rrCache, err := Resolve(silencingTraceCtx, &Query{FQDN: domain, QType: dns.Type(dns.TypeA)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "public-suffix.co.uk.", rrCache.DomainRoot)
+		assert.True(t, rrCache.ICANNSpace)
+
+		copied := rrCache.ShallowCopy()
+		assert.Equal(t, rrCache.DomainRoot, copied.DomainRoot)
+		assert.Equal(t, rrCache.ICANNSpace, copied.ICANNSpace)
+	}
+}
+
+func TestServeStale(t *testing.T) { //nolint:paralleltest // Changes global config.
+	staleDomain := "stale.serve-stale.example.com."
+	nxDomain := "nx.serve-stale.example.com."
+	expired := time.Now().Add(-time.Hour).Unix()
+	saveTestRecord(t, staleDomain, dns.RcodeSuccess, expired, staleDomain+" 17 IN A 192.0.2.1")
+	saveTestRecord(t, nxDomain, dns.RcodeNameError, expired)
+
+	// Without a grace window, expired records are only backups.
+	rrCache := checkCache(silencingTraceCtx, &Query{FQDN: staleDomain, QType: dns.Type(dns.TypeA)})
+	if assert.NotNil(t, rrCache) {
+		assert.False(t, rrCache.ServedStale)
+	}
+
+	// Within the grace window, expired records are served stale.
+	assert.NoError(t, config.SetConfigOption(CfgOptionServeStaleWindowKey, 86400))
+	defer func() {
+		assert.NoError(t, config.SetConfigOption(CfgOptionServeStaleWindowKey, 0))
+	}()
+	rrCache = checkCache(silencingTraceCtx, &Query{FQDN: staleDomain, QType: dns.Type(dns.TypeA)})
+	if assert.NotNil(t, rrCache) {
+		assert.True(t, rrCache.ServedStale)
+		assert.True(t, rrCache.RequestingNew)
+	}
+
+	// NXDomain is never served stale.
+	rrCache = checkCache(silencingTraceCtx, &Query{FQDN: nxDomain, QType: dns.Type(dns.TypeA)})
+	assert.Nil(t, rrCache)
+}
+
+func TestFlattenCNAME(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Now().Add(time.Hour).Unix()
+	saveTestRecord(t, "a.flatten.example.com.", dns.RcodeSuccess, expires,
+		"a.flatten.example.com. 17 IN CNAME b.flatten.example.com.",
+	)
+	saveTestRecord(t, "b.flatten.example.com.", dns.RcodeSuccess, expires,
+		"b.flatten.example.com. 17 IN CNAME c.flatten.example.com.",
+		"c.flatten.example.com. 17 IN A 192.0.2.1",
+	)
+	saveTestRecordOfType(t, "h.flatten.example.com.", dns.Type(dns.TypeHTTPS), dns.RcodeSuccess, expires,
+		"h.flatten.example.com. 17 IN CNAME i.flatten.example.com.",
+	)
+	saveTestRecordOfType(t, "i.flatten.example.com.", dns.Type(dns.TypeHTTPS), dns.RcodeSuccess, expires,
+		`i.flatten.example.com. 17 IN HTTPS 1 . alpn="h3,h2"`,
+	)
+	saveTestRecord(t, "x.flatten.example.com.", dns.RcodeSuccess, expires,
+		"x.flatten.example.com. 17 IN CNAME y.flatten.example.com.",
+	)
+	saveTestRecord(t, "y.flatten.example.com.", dns.RcodeSuccess, expires,
+		"y.flatten.example.com. 17 IN CNAME x.flatten.example.com.",
+	)
+
+	// Follow chain over multiple hops.
+	rrCache, err := Resolve(silencingTraceCtx, &Query{
+		FQDN:         "a.flatten.example.com.",
+		QType:        dns.Type(dns.TypeA),
+		FlattenCNAME: true,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "a.flatten.example.com.", rrCache.Domain)
+		if assert.Len(t, rrCache.Answer, 3) {
+			assert.IsType(t, &dns.CNAME{}, rrCache.Answer[0])
+			assert.IsType(t, &dns.CNAME{}, rrCache.Answer[1])
+			assert.IsType(t, &dns.A{}, rrCache.Answer[2])
+		}
+	}
+
+	// Follow chain of HTTPS queries.
+	rrCache, err = Resolve(silencingTraceCtx, &Query{
+		FQDN:         "h.flatten.example.com.",
+		QType:        dns.Type(dns.TypeHTTPS),
+		FlattenCNAME: true,
+	})
+	if assert.NoError(t, err) {
+		if assert.Len(t, rrCache.Answer, 2) {
+			assert.IsType(t, &dns.CNAME{}, rrCache.Answer[0])
+			assert.IsType(t, &dns.HTTPS{}, rrCache.Answer[1])
+		}
+	}
+
+	// Detect loops.
+	_, err = Resolve(silencingTraceCtx, &Query{
+		FQDN:         "x.flatten.example.com.",
+		QType:        dns.Type(dns.TypeA),
+		FlattenCNAME: true,
+	})
+	assert.ErrorIs(t, err, ErrFailure)
+}
+
+func TestAliasLoops(t *testing.T) {
+	t.Parallel()
+
+	check := func(records ...string) error {
+		rrCache := &RRCache{Domain: "a.loop.example.com."}
+		for _, record := range records {
+			rr, err := dns.NewRR(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rrCache.Answer = append(rrCache.Answer, rr)
+		}
+		return checkAliasLoops(rrCache)
+	}
+
+	// Valid chains.
+	assert.NoError(t, check(
+		"a.loop.example.com. 17 IN CNAME b.loop.example.com.",
+		"b.loop.example.com. 17 IN CNAME c.loop.example.com.",
+		"c.loop.example.com. 17 IN A 192.0.2.1",
+	))
+	assert.NoError(t, check(
+		"loop.example.com. 17 IN DNAME loop.example.net.",
+		"a.loop.example.com. 17 IN CNAME a.loop.example.net.",
+	))
+
+	// Direct self-loop.
+	assert.ErrorIs(t, check(
+		"a.loop.example.com. 17 IN CNAME A.loop.example.com.",
+	), ErrFailure)
+
+	// Two-record cycle.
+	assert.ErrorIs(t, check(
+		"a.loop.example.com. 17 IN CNAME b.loop.example.com.",
+		"b.loop.example.com. 17 IN CNAME a.loop.example.com.",
+	), ErrFailure)
+
+	// DNAME into its own subtree.
+	assert.ErrorIs(t, check(
+		"loop.example.com. 17 IN DNAME sub.loop.example.com.",
+	), ErrFailure)
+}
+
+func TestCacheOnly(t *testing.T) { //nolint:paralleltest // Changes global config.
+	freshDomain := "fresh.cache-only.example.com."
+	staleDomain := "stale.cache-only.example.com."
+	saveTestRecord(t, freshDomain, dns.RcodeSuccess, time.Now().Add(time.Hour).Unix(), freshDomain+" 17 IN A 192.0.2.1")
+	saveTestRecord(t, staleDomain, dns.RcodeSuccess, time.Now().Add(-time.Hour).Unix(), staleDomain+" 17 IN A 192.0.2.1")
+
+	// Fresh entries are served.
+	rrCache, err := Resolve(silencingTraceCtx, &Query{FQDN: freshDomain, QType: dns.Type(dns.TypeA), CacheOnly: true})
+	if assert.NoError(t, err) {
+		assert.Len(t, rrCache.Answer, 1)
+	}
+
+	// Expired entries are only served within the serve stale window.
+	_, err = Resolve(silencingTraceCtx, &Query{FQDN: staleDomain, QType: dns.Type(dns.TypeA), CacheOnly: true})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, config.SetConfigOption(CfgOptionServeStaleWindowKey, 86400))
+	defer func() {
+		assert.NoError(t, config.SetConfigOption(CfgOptionServeStaleWindowKey, 0))
+	}()
+	rrCache, err = Resolve(silencingTraceCtx, &Query{FQDN: staleDomain, QType: dns.Type(dns.TypeA), CacheOnly: true})
+	if assert.NoError(t, err) {
+		assert.True(t, rrCache.ServedStale)
+	}
+
+	// Missing entries are not resolved.
+	_, err = Resolve(silencingTraceCtx, &Query{FQDN: "missing.cache-only.example.com.", QType: dns.Type(dns.TypeA), CacheOnly: true})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = getRRCache("missing.cache-only.example.com.", dns.Type(dns.TypeA), "")
+	assert.Error(t, err, "missing entry should not be resolved")
+}
+
+// saveTestRecord saves the given records to the cache as an answer to an A
+// query, attributed to the first active global resolver.
+func saveTestRecord(t *testing.T, domain string, rcode int, expires int64, records ...string) {
+	t.Helper()
+
+	saveTestRecordOfType(t, domain, dns.Type(dns.TypeA), rcode, expires, records...)
+}
+
+// saveTestRecordOfType saves the given records to the cache as an answer to a
+// query of the given type, attributed to the first active global resolver.
+func saveTestRecordOfType(t *testing.T, domain string, qtype dns.Type, rcode int, expires int64, records ...string) {
+	t.Helper()
+
+	// Get an active resolver to attribute the cached records to.
+	resolversLock.RLock()
+	if len(globalResolvers) == 0 {
+		resolversLock.RUnlock()
+		t.Skip("no active resolvers")
+	}
+	resolverInfo := globalResolvers[0].Info
+	resolversLock.RUnlock()
+
+	rrCache := &RRCache{
+		Domain:   domain,
+		Question: qtype,
+		RCode:    rcode,
+		Expires:  expires,
+		Resolver: resolverInfo,
+	}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrCache.Answer = append(rrCache.Answer, rr)
+	}
+	if err := rrCache.ToNameRecord().Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testHangingConn struct {
+	BasicResolverConn
+
+	failures atomic.Int32
+}
+
+func (thc *testHangingConn) Query(ctx context.Context, _ *Query) (*RRCache, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (thc *testHangingConn) ReportFailure() {
+	thc.failures.Add(1)
+}
+
+func TestPerResolverTimeout(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	hangingConn := &testHangingConn{}
+	hangingResolver := &Resolver{
+		Info: &ResolverInfo{Type: ServerTypeDNS, Source: ServerSourceEnv, IP: net.IPv4(192, 0, 2, 56), Port: 53},
+		Conn: hangingConn,
+	}
+	hangingConn.resolver = hangingResolver
+	hangingConn.init()
+	answeringConn, answeringResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 57))
+	answeringConn.fail.Store(false)
+
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{hangingResolver, answeringResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		resolversLock.Unlock()
+	}()
+
+	// Check that the hanging resolver is skipped after the per resolver timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	rrCache, err := Resolve(ctx, &Query{
+		FQDN:               "timeout." + InternalSpecialUseDomain,
+		QType:              dns.Type(dns.TypeA),
+		NoCaching:          true,
+		PerResolverTimeout: 50 * time.Millisecond,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, answeringResolver.Info.ID(), rrCache.Resolver.ID())
+	}
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, int32(1), hangingConn.failures.Load())
+
+	// Check that the context still limits the whole query without a per
+	// resolver timeout.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Resolve(ctx, &Query{
+		FQDN:      "timeout." + InternalSpecialUseDomain,
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 }
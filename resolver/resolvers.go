@@ -35,6 +35,9 @@ type Scope struct {
 	parameterSearch     = "search"
 	parameterSearchOnly = "search-only"
 	parameterPath       = "path"
+	parameterProxy      = "proxy"
+	parameterPin        = "pin"
+	parameterSource     = "source"
 )
 
 var (
@@ -87,6 +90,8 @@ func resolverConnFactory(resolver *Resolver) ResolverConn {
 		return NewTCPResolver(resolver).UseTLS()
 	case ServerTypeDoH:
 		return NewHTTPSResolver(resolver)
+	case ServerTypeDoQ:
+		return NewQUICResolver(resolver)
 	case ServerTypeDNS:
 		return NewPlainResolver(resolver)
 	default:
@@ -105,7 +110,7 @@ func createResolver(resolverURL, source string) (*Resolver, bool, error) {
 	}
 
 	switch u.Scheme {
-	case ServerTypeDNS, ServerTypeDoT, ServerTypeDoH, ServerTypeTCP:
+	case ServerTypeDNS, ServerTypeDoT, ServerTypeDoH, ServerTypeDoQ, ServerTypeTCP:
 	case HTTPSProtocol:
 		u.Scheme = ServerTypeDoH
 	case TLSProtocol:
@@ -180,6 +185,40 @@ func createResolver(resolverURL, source string) (*Resolver, bool, error) {
 		}
 	}
 
+	// Parse proxy.
+	if query.Has(parameterProxy) {
+		if !proxySupported(newResolver.Info.Type) {
+			return nil, false, fmt.Errorf("%s is only supported by TCP, DoT and DoH servers", parameterProxy)
+		}
+		newResolver.proxy, err = parseProxyURL(query.Get(parameterProxy))
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Parse certificate pins.
+	if query.Has(parameterPin) {
+		if !pinSupported(newResolver.Info.Type) {
+			return nil, false, fmt.Errorf("%s is only supported by DoT, DoH and DoQ servers", parameterPin)
+		}
+		newResolver.pins, err = parseCertPins(query[parameterPin])
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Parse source.
+	if query.Has(parameterSource) {
+		newResolver.source, err = parseResolverSource(query.Get(parameterSource))
+		if err != nil {
+			return nil, false, err
+		}
+		if newResolver.source.ip != nil && newResolver.Info.IP != nil &&
+			(newResolver.source.ip.To4() == nil) != (newResolver.Info.IP.To4() == nil) {
+			return nil, false, fmt.Errorf("source IP %s and resolver IP %s must be of the same IP version", newResolver.source.ip, newResolver.Info.IP)
+		}
+	}
+
 	newResolver.Conn = resolverConnFactory(newResolver)
 	return newResolver, false, nil
 }
@@ -188,7 +227,7 @@ func checkAndSetResolverParamters(u *url.URL, resolver *Resolver) error {
 	// Check if we are using domain name and if it's in a valid scheme
 	ip := net.ParseIP(u.Hostname())
 	hostnameIsDomaion := (ip == nil)
-	if ip == nil && u.Scheme != ServerTypeDoH && u.Scheme != ServerTypeDoT {
+	if ip == nil && u.Scheme != ServerTypeDoH && u.Scheme != ServerTypeDoT && u.Scheme != ServerTypeDoQ {
 		return fmt.Errorf("resolver IP %q is invalid", u.Hostname())
 	}
 
@@ -210,7 +249,10 @@ func checkAndSetResolverParamters(u *url.URL, resolver *Resolver) error {
 			parameterBlockedIf,
 			parameterSearch,
 			parameterSearchOnly,
-			parameterPath:
+			parameterPath,
+			parameterProxy,
+			parameterPin,
+			parameterSource:
 			// Known key, continue.
 		default:
 			// Unknown key, abort.
@@ -221,7 +263,7 @@ func checkAndSetResolverParamters(u *url.URL, resolver *Resolver) error {
 	resolver.Info.Domain = query.Get(parameterVerify)
 	paramterServerIP := query.Get(parameterIP)
 
-	if u.Scheme == ServerTypeDoT || u.Scheme == ServerTypeDoH {
+	if u.Scheme == ServerTypeDoT || u.Scheme == ServerTypeDoH || u.Scheme == ServerTypeDoQ {
 		// Check if IP and Domain are set correctly
 		switch {
 		case hostnameIsDomaion && resolver.Info.Domain != "":
@@ -243,6 +285,7 @@ func checkAndSetResolverParamters(u *url.URL, resolver *Resolver) error {
 		case hostnameIsDomaion && resolver.Info.Domain == "" && paramterServerIP == "": // only domain
 			resolver.Info.Domain = u.Hostname()
 			resolver.ServerAddress = net.JoinHostPort(resolver.Info.Domain, strconv.Itoa(int(port)))
+			resolver.bootstrap = &bootstrapState{}
 		}
 
 		if ip == nil {
@@ -251,7 +294,7 @@ func checkAndSetResolverParamters(u *url.URL, resolver *Resolver) error {
 
 	} else {
 		if resolver.Info.Domain != "" {
-			return fmt.Errorf("domain verification is only supported by DoT and DoH servers")
+			return fmt.Errorf("domain verification is only supported by DoT, DoH and DoQ servers")
 		}
 		resolver.ServerAddress = net.JoinHostPort(ip.String(), strconv.Itoa(int(resolver.Info.Port)))
 	}
@@ -276,7 +319,7 @@ func parsePortFromURL(url *url.URL) (uint16, error) {
 			port = 53
 		case url.Scheme == ServerTypeDoH:
 			port = 443
-		case url.Scheme == ServerTypeDoT:
+		case url.Scheme == ServerTypeDoT, url.Scheme == ServerTypeDoQ:
 			port = 853
 		default:
 			return 0, fmt.Errorf("cannot determine port for %q", url.Scheme)
@@ -334,8 +377,11 @@ func getConfiguredResolvers(list []string) (resolvers []*Resolver) {
 	return resolvers
 }
 
+// systemNameservers returns the nameservers assigned by the system.
+var systemNameservers = netenv.Nameservers
+
 func getSystemResolvers() (resolvers []*Resolver) {
-	for _, nameserver := range netenv.Nameservers() {
+	for _, nameserver := range systemNameservers() {
 		serverURL := fmt.Sprintf("dns://%s", formatIPAndPort(nameserver.IP, 53))
 		resolver, skip, err := createResolver(serverURL, ServerSourceOperatingSystem)
 		if err != nil {
@@ -409,6 +455,13 @@ func loadResolvers() {
 
 	// assing resolvers to scopes
 	setScopedResolvers(globalResolvers)
+	// bootstrap resolvers fall back to the system resolvers set above
+	bootstrapResolvers = getBootstrapResolvers()
+	forwardingRules = loadForwardingRules(append(
+		append([]string{}, conditionalForwarding()...),
+		specialDomainForwardingRules()...,
+	))
+	rewriteRules = loadRewriteRules(dnsRewriteRules())
 
 	// set active resolvers (for cache validation)
 	// reset
@@ -1,9 +1,13 @@
 package resolver
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
 )
 
 func TestCheckResolverSearchScope(t *testing.T) {
@@ -42,3 +46,134 @@ func TestCheckResolverSearchScope(t *testing.T) {
 	assert.NoError(t, checkSearchScope("b.a.doesnotexist"))
 	assert.NoError(t, checkSearchScope("c.b.a.doesnotexist"))
 }
+
+func TestCreateDoQResolver(t *testing.T) {
+	t.Parallel()
+
+	r, _, err := createResolver("doq://dns.adguard-dns.com?ip=94.140.14.14&name=AdGuard", ServerSourceConfigured)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ServerTypeDoQ, r.Info.Type)
+		assert.Equal(t, uint16(853), r.Info.Port)
+		assert.Equal(t, "dns.adguard-dns.com", r.Info.Domain)
+		assert.Equal(t, "94.140.14.14:853", r.ServerAddress)
+		assert.IsType(t, &QUICResolver{}, r.Conn)
+	}
+
+	// should fail (domain verification required)
+	_, _, err = createResolver("doq://94.140.14.14", ServerSourceConfigured)
+	assert.Error(t, err)
+}
+
+func TestQuerySlotLimit(t *testing.T) { //nolint:paralleltest // Changes global config.
+	assert.NoError(t, config.SetConfigOption(CfgOptionNameserverMaxQueriesKey, 1))
+	defer func() {
+		assert.NoError(t, config.SetConfigOption(CfgOptionNameserverMaxQueriesKey, 100))
+	}()
+
+	brc := &BasicResolverConn{
+		resolver: &Resolver{
+			Info: &ResolverInfo{
+				Type:   ServerTypeDNS,
+				Source: ServerSourceConfigured,
+			},
+		},
+	}
+	brc.init()
+
+	// First query gets a slot.
+	release, err := brc.acquireQuerySlot(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1, brc.InFlightQueries())
+
+	// Second query is queued until the first is done.
+	queued := make(chan error)
+	go func() {
+		releaseQueued, err := brc.acquireQuerySlot(context.Background())
+		if err == nil {
+			releaseQueued()
+		}
+		queued <- err
+	}()
+	assert.Eventually(t, func() bool {
+		brc.inFlightLock.Lock()
+		defer brc.inFlightLock.Unlock()
+		return brc.queued == 1
+	}, time.Second, time.Millisecond)
+
+	// Third query is rejected, as the queue is full.
+	_, err = brc.acquireQuerySlot(context.Background())
+	assert.ErrorIs(t, err, ErrContinue)
+
+	// Queued query gets the slot when the first is done.
+	release()
+	assert.NoError(t, <-queued)
+	assert.Equal(t, 0, brc.InFlightQueries())
+
+	// Queued query must respect the context.
+	release, err = brc.acquireQuerySlot(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = brc.acquireQuerySlot(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	brc := &BasicResolverConn{
+		resolver: &Resolver{
+			Info: &ResolverInfo{
+				Type:   ServerTypeDNS,
+				Source: ServerSourceConfigured,
+			},
+		},
+	}
+	brc.init()
+
+	// The circuit opens after too many consecutive failures.
+	for i := 0; i < FailThreshold; i++ {
+		brc.recordFailure()
+	}
+	assert.Equal(t, CircuitClosed, brc.CircuitState())
+	assert.True(t, brc.allowQuery())
+	brc.recordFailure()
+	assert.Equal(t, CircuitOpen, brc.CircuitState())
+	assert.True(t, brc.IsFailing())
+	assert.False(t, brc.allowQuery())
+	firstBackoff := time.Until(brc.circuitOpenUntil)
+
+	// After the backoff, a single probe query is permitted.
+	brc.circuitOpenUntil = time.Now()
+	assert.False(t, brc.IsFailing())
+	assert.True(t, brc.allowQuery())
+	assert.Equal(t, CircuitHalfOpen, brc.CircuitState())
+	assert.True(t, brc.IsFailing())
+	assert.False(t, brc.allowQuery())
+
+	// A failed probe opens the circuit with a longer backoff.
+	brc.recordFailure()
+	assert.Equal(t, CircuitOpen, brc.CircuitState())
+	assert.Greater(t, time.Until(brc.circuitOpenUntil), firstBackoff)
+
+	// A successful probe fully resets the circuit.
+	brc.circuitOpenUntil = time.Now()
+	assert.True(t, brc.allowQuery())
+	brc.ResetFailure()
+	assert.Equal(t, CircuitClosed, brc.CircuitState())
+	assert.False(t, brc.IsFailing())
+	assert.Equal(t, 0, brc.circuitOpens)
+
+	// Successes reset the consecutive failure count.
+	for i := 0; i < FailThreshold; i++ {
+		brc.recordFailure()
+	}
+	brc.ResetFailure()
+	brc.recordFailure()
+	assert.Equal(t, CircuitClosed, brc.CircuitState())
+}
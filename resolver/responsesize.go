@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultMaxResponseRecords = 512
+	defaultMaxResponseSize    = 32768
+)
+
+// ErrResponseTooLarge wraps ErrFailure and is returned when a response exceeds
+// the configured limits.
+var ErrResponseTooLarge = fmt.Errorf("%w: response too large", ErrFailure)
+
+// checkResponseSize checks if the reply exceeds the configured maximum amount
+// of records or the maximum size.
+func checkResponseSize(reply *dns.Msg) error {
+	if limit := int(maxResponseRecords()); limit > 0 {
+		if records := len(reply.Answer) + len(reply.Ns) + len(reply.Extra); records > limit {
+			return fmt.Errorf("%w: %d records exceed the limit of %d", ErrResponseTooLarge, records, limit)
+		}
+	}
+
+	if limit := int(maxResponseSize()); limit > 0 {
+		if size := reply.Len(); size > limit {
+			return fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrResponseTooLarge, size, limit)
+		}
+	}
+
+	return nil
+}
+
+// readResponseBody reads a raw response, but stops as soon as it exceeds the
+// configured maximum size.
+func readResponseBody(r io.Reader) ([]byte, error) {
+	limit := maxResponseSize()
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, limit)
+	}
+	return body, nil
+}
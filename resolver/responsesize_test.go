@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestResponseSize(t *testing.T) { //nolint:paralleltest // Changes global config.
+	newReply := func(records int) *dns.Msg {
+		reply := new(dns.Msg)
+		reply.SetQuestion("size.example.com.", dns.TypeA)
+		for i := 0; i < records; i++ {
+			rr, err := dns.NewRR(fmt.Sprintf("size.example.com. 60 IN A 10.0.%d.%d", i/256, i%256))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply.Answer = append(reply.Answer, rr)
+		}
+		return reply
+	}
+
+	// Check the default record limit.
+	assert.NoError(t, checkResponseSize(newReply(10)))
+	err := checkResponseSize(newReply(defaultMaxResponseRecords + 1))
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+	assert.ErrorIs(t, err, ErrFailure, "oversized responses should be treated as a failure")
+
+	// Check the size limit.
+	err = config.SetConfigOption(CfgOptionMaxResponseSizeKey, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionMaxResponseSizeKey, defaultMaxResponseSize)
+	}()
+	assert.NoError(t, checkResponseSize(newReply(1)))
+	assert.ErrorIs(t, checkResponseSize(newReply(20)), ErrResponseTooLarge)
+
+	// Check that raw responses are not read beyond the limit.
+	body, err := readResponseBody(bytes.NewReader(make([]byte, 200)))
+	if assert.NoError(t, err) {
+		assert.Len(t, body, 200)
+	}
+	_, err = readResponseBody(strings.NewReader(strings.Repeat("x", 201)))
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+}
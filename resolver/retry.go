@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/safing/portbase/rng"
+)
+
+const (
+	defaultTimeoutRetries = 2
+	maxTimeoutRetries     = 5
+)
+
+var (
+	// timeoutRetryBaseBackoff is the backoff before the first retry of a timed
+	// out query. It doubles with every further retry.
+	timeoutRetryBaseBackoff = 50 * time.Millisecond
+
+	// timeoutRetryBudget is the maximum time spent on a query at the same
+	// resolver, including all retries. It is below maxRequestTimeout, so that
+	// deduplicated queries waiting for the result do not give up first.
+	timeoutRetryBudget = maxRequestTimeout - time.Second
+)
+
+// getTimeoutRetries returns how often a timed out query may be retried at the
+// same resolver.
+func getTimeoutRetries(q *Query) int {
+	if !retryTimeouts(q.SecurityLevel) {
+		return 0
+	}
+
+	retries := int(timeoutRetries())
+	switch {
+	case retries < 0:
+		return 0
+	case retries > maxTimeoutRetries:
+		return maxTimeoutRetries
+	default:
+		return retries
+	}
+}
+
+// timeoutRetryBackoff returns the backoff before the retry following the
+// given attempt. Up to half of the backoff is added as jitter, so that
+// retries of concurrent queries are spread out.
+func timeoutRetryBackoff(attempt int) time.Duration {
+	backoff := timeoutRetryBaseBackoff << attempt
+
+	jitter, err := rng.Number(uint64(backoff / 2))
+	if err != nil {
+		return backoff
+	}
+	return backoff + time.Duration(jitter)
+}
+
+// isTimeoutError returns whether the error signifies that the resolver did
+// not answer in time.
+func isTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrTimeout) {
+		return true
+	}
+
+	var nErr net.Error
+	return errors.As(err, &nErr) && nErr.Timeout()
+}
@@ -0,0 +1,127 @@
+package resolver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/status"
+)
+
+type testFlakyConn struct {
+	testCountingConn
+
+	timeouts atomic.Int32
+}
+
+func (tfc *testFlakyConn) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	if tfc.timeouts.Add(-1) >= 0 {
+		tfc.queries.Add(1)
+		return nil, ErrTimeout
+	}
+	return tfc.testCountingConn.Query(ctx, q)
+}
+
+// useTestFlakyResolver is like useTestCountingResolver, but the resolver
+// times out the given amount of times before answering.
+func useTestFlakyResolver(timeouts int32) (conn *testFlakyConn, testResolver *Resolver, restore func()) {
+	_, testResolver, restore = useTestCountingResolver(0)
+	conn = &testFlakyConn{}
+	conn.timeouts.Store(timeouts)
+	conn.resolver = testResolver
+	conn.init()
+	testResolver.Conn = conn
+	return conn, testResolver, restore
+}
+
+func TestTimeoutRetry(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	previousBackoff := timeoutRetryBaseBackoff
+	timeoutRetryBaseBackoff = 5 * time.Millisecond
+	defer func() {
+		timeoutRetryBaseBackoff = previousBackoff
+	}()
+
+	// A resolver that times out once is retried and not marked as failing.
+	conn, testResolver, restore := useTestFlakyResolver(1)
+	defer restore()
+	q := &Query{
+		FQDN:          "retry." + InternalSpecialUseDomain,
+		QType:         dns.Type(dns.TypeA),
+		SecurityLevel: status.SecurityLevelNormal,
+		NoCaching:     true,
+	}
+	rrCache, err := Resolve(silencingTraceCtx, q)
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, rrCache.Answer)
+	}
+	assert.Equal(t, int32(2), conn.queries.Load())
+	assert.False(t, testResolver.Conn.IsFailing())
+
+	// Retries do not register as additional queries for deduplication.
+	dupReqLock.Lock()
+	_, ok := dupReqMap[q.ID()]
+	dupReqLock.Unlock()
+	assert.False(t, ok)
+
+	// Retries are limited.
+	conn.timeouts.Store(10)
+	conn.queries.Store(0)
+	_, err = queryResolver(silencingTraceCtx, testResolver, q)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, int32(defaultTimeoutRetries+1), conn.queries.Load())
+
+	// Retries respect the per resolver timeout.
+	timeoutRetryBaseBackoff = time.Second
+	conn.timeouts.Store(10)
+	conn.queries.Store(0)
+	started := time.Now()
+	_, err = queryResolver(silencingTraceCtx, testResolver, &Query{
+		FQDN:               q.FQDN,
+		QType:              q.QType,
+		SecurityLevel:      q.SecurityLevel,
+		PerResolverTimeout: 20 * time.Millisecond,
+	})
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, int32(1), conn.queries.Load())
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+
+	// Retries are bounded by the retry budget.
+	assert.Less(t, timeoutRetryBudget, maxRequestTimeout)
+	previousBudget := timeoutRetryBudget
+	timeoutRetryBudget = 150 * time.Millisecond
+	timeoutRetryBaseBackoff = 100 * time.Millisecond
+	conn.timeouts.Store(10)
+	conn.queries.Store(0)
+	started = time.Now()
+	_, err = queryResolver(silencingTraceCtx, testResolver, q)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.LessOrEqual(t, conn.queries.Load(), int32(2))
+	assert.Less(t, time.Since(started), 300*time.Millisecond)
+	timeoutRetryBudget = previousBudget
+
+	// Retries respect the deadline of the context.
+	conn.timeouts.Store(10)
+	ctx, cancel := context.WithTimeout(silencingTraceCtx, 20*time.Millisecond)
+	defer cancel()
+	started = time.Now()
+	_, err = queryResolver(ctx, testResolver, q)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+	timeoutRetryBaseBackoff = 5 * time.Millisecond
+
+	// Retries can be disabled.
+	assert.NoError(t, config.SetConfigOption(CfgOptionRetryTimeoutsKey, status.SecurityLevelOff))
+	defer func() {
+		assert.NoError(t, config.SetConfigOption(CfgOptionRetryTimeoutsKey, status.SecurityLevelsAll))
+	}()
+	conn.timeouts.Store(1)
+	conn.queries.Store(0)
+	_, err = queryResolver(silencingTraceCtx, testResolver, q)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, int32(1), conn.queries.Load())
+}
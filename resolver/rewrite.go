@@ -0,0 +1,207 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// maxRewriteDepth limits how many CNAME rewrites are followed for a query.
+const maxRewriteDepth = 8
+
+// rewriteRule defines the answer for a domain, instead of asking a resolver.
+type rewriteRule struct {
+	// Domain is the domain of the rule. If Wildcard is set, it is dot-prefixed
+	// and matches all subdomains.
+	Domain   string
+	Wildcard bool
+
+	IPv4  []net.IP
+	IPv6  []net.IP
+	CNAME string
+}
+
+type rewriteDepthContextKey struct{}
+
+var (
+	// rewriteRules holds all rewrite rules, exact domains first and then
+	// wildcards with the longest suffix first. Protected by resolversLock.
+	rewriteRules []*rewriteRule
+
+	rewriteResolverInfo = &ResolverInfo{
+		Type:    ServerTypeRewrite,
+		Source:  ServerSourceRewrite,
+		IPScope: netutils.HostLocal,
+	}
+)
+
+// loadRewriteRules parses the configured rewrite rules.
+func loadRewriteRules(entries []string) (rules []*rewriteRule) {
+	for _, entry := range entries {
+		rule, err := parseRewriteRule(entry)
+		if err != nil {
+			log.Warningf("resolver: ignoring rewrite rule %q: %s", entry, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	// Sort exact domains first, then by length, so that the most specific rule
+	// is found first.
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Wildcard != rules[j].Wildcard {
+			return !rules[i].Wildcard
+		}
+		return len(rules[i].Domain) > len(rules[j].Domain)
+	})
+	return rules
+}
+
+// parseRewriteRule parses a rule in the format "domain=target". The domain
+// may start with "*." to match all subdomains. The target is either a comma
+// separated list of IP addresses, or a domain to return as a CNAME.
+func parseRewriteRule(entry string) (*rewriteRule, error) {
+	domain, target, ok := strings.Cut(entry, "=")
+	if !ok {
+		return nil, fmt.Errorf("missing target, expected format domain=target")
+	}
+	rule := &rewriteRule{}
+
+	// Check and normalize domain.
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if strings.HasPrefix(domain, "*.") {
+		rule.Wildcard = true
+		domain = strings.TrimPrefix(domain, "*.")
+	}
+	domain = strings.Trim(domain, ".")
+	if !netutils.IsValidFqdn(domain + ".") {
+		return nil, fmt.Errorf("invalid domain %q", domain)
+	}
+	if rule.Wildcard {
+		rule.Domain = "." + domain + "."
+	} else {
+		rule.Domain = domain + "."
+	}
+
+	// Check if the target is a list of IPs.
+	target = strings.TrimSpace(target)
+	var (
+		ipv4, ipv6 []net.IP
+		isIPList   = true
+	)
+	for _, ipString := range strings.Split(target, ",") {
+		ip := net.ParseIP(strings.TrimSpace(ipString))
+		switch {
+		case ip == nil:
+			isIPList = false
+		case ip.To4() != nil:
+			ipv4 = append(ipv4, ip.To4())
+		default:
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	if isIPList {
+		rule.IPv4, rule.IPv6 = ipv4, ipv6
+		return rule, nil
+	}
+
+	// Otherwise, the target must be a domain.
+	target = strings.ToLower(strings.Trim(target, ".")) + "."
+	if !netutils.IsValidFqdn(target) {
+		return nil, fmt.Errorf("invalid target %q, expected IP addresses or a domain", target)
+	}
+	rule.CNAME = target
+
+	return rule, nil
+}
+
+// getRewriteRule returns the most specific rewrite rule for the given domain.
+// Acquires resolversLock.
+func getRewriteRule(fqdn string) *rewriteRule {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	fqdn = strings.ToLower(fqdn)
+	for _, rule := range rewriteRules {
+		if rule.Wildcard {
+			if strings.HasSuffix(fqdn, rule.Domain) {
+				return rule
+			}
+		} else if fqdn == rule.Domain {
+			return rule
+		}
+	}
+	return nil
+}
+
+// rewriteQuery creates the answer of the given rewrite rule for the query.
+// CNAME rewrites are resolved further.
+func rewriteQuery(ctx context.Context, q *Query, rule *rewriteRule) (*RRCache, error) {
+	ttl := uint32(rewriteTTL())
+	rrCache := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Expires:  time.Now().Unix() + int64(ttl),
+		Resolver: rewriteResolverInfo.Copy(),
+	}
+	header := dns.RR_Header{
+		Name:  q.FQDN,
+		Class: dns.ClassINET,
+		Ttl:   ttl,
+	}
+
+	// Answer with the static IPs.
+	if rule.CNAME == "" {
+		switch uint16(q.QType) {
+		case dns.TypeA:
+			header.Rrtype = dns.TypeA
+			for _, ip := range rule.IPv4 {
+				rrCache.Answer = append(rrCache.Answer, &dns.A{Hdr: header, A: ip})
+			}
+		case dns.TypeAAAA:
+			header.Rrtype = dns.TypeAAAA
+			for _, ip := range rule.IPv6 {
+				rrCache.Answer = append(rrCache.Answer, &dns.AAAA{Hdr: header, AAAA: ip})
+			}
+		}
+		log.Tracer(ctx).Tracef("resolver: rewrote %s to static answer", q.ID())
+		return rrCache, nil
+	}
+
+	// Answer with the CNAME.
+	header.Rrtype = dns.TypeCNAME
+	rrCache.Answer = append(rrCache.Answer, &dns.CNAME{Hdr: header, Target: rule.CNAME})
+	log.Tracer(ctx).Tracef("resolver: rewrote %s to CNAME %s", q.ID(), rule.CNAME)
+	if uint16(q.QType) == dns.TypeCNAME {
+		return rrCache, nil
+	}
+
+	// Check for loops.
+	depth, _ := ctx.Value(rewriteDepthContextKey{}).(int)
+	if depth >= maxRewriteDepth {
+		return nil, fmt.Errorf("%w: rewrite of %s exceeds %d CNAME rewrites, possible loop", ErrFailure, q.ID(), maxRewriteDepth)
+	}
+
+	// Resolve the target.
+	targetRRCache, err := Resolve(context.WithValue(ctx, rewriteDepthContextKey{}, depth+1), q.subQuery(rule.CNAME, q.QType))
+	if err != nil {
+		return nil, err
+	}
+	rrCache.RCode = targetRRCache.RCode
+	rrCache.Answer = append(rrCache.Answer, targetRRCache.Answer...)
+	rrCache.Ns = targetRRCache.Ns
+	if targetRRCache.Expires < rrCache.Expires {
+		rrCache.Expires = targetRRCache.Expires
+	}
+
+	return rrCache, nil
+}
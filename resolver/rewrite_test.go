@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRewriteRule(t *testing.T) {
+	t.Parallel()
+
+	rule, err := parseRewriteRule("Git.Example.com=10.0.0.5, fd00::5")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "git.example.com.", rule.Domain)
+		assert.False(t, rule.Wildcard)
+		assert.Len(t, rule.IPv4, 1)
+		assert.Len(t, rule.IPv6, 1)
+		assert.Empty(t, rule.CNAME)
+	}
+
+	rule, err = parseRewriteRule("*.example.com=internal.example.net.")
+	if assert.NoError(t, err) {
+		assert.Equal(t, ".example.com.", rule.Domain)
+		assert.True(t, rule.Wildcard)
+		assert.Equal(t, "internal.example.net.", rule.CNAME)
+	}
+
+	_, err = parseRewriteRule("example.com")
+	assert.Error(t, err)
+	_, err = parseRewriteRule("example.com=not a domain")
+	assert.Error(t, err)
+}
+
+func TestRewriteRules(t *testing.T) { //nolint:paralleltest // Changes global rewrite rules.
+	resolversLock.Lock()
+	previousRewriteRules := rewriteRules
+	rewriteRules = loadRewriteRules([]string{
+		"*.rewrite.example.com=10.0.0.1",
+		"static.rewrite.example.com=10.0.0.5,fd00::5",
+		"alias.rewrite.example.com=static.rewrite.example.com",
+		"loop1.rewrite.example.com=loop2.rewrite.example.com",
+		"loop2.rewrite.example.com=loop1.rewrite.example.com",
+	})
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		rewriteRules = previousRewriteRules
+		resolversLock.Unlock()
+	}()
+
+	resolve := func(fqdn string, qType uint16) (*RRCache, error) {
+		return Resolve(context.Background(), &Query{FQDN: fqdn, QType: dns.Type(qType)})
+	}
+
+	// Check static answers, where the exact domain wins over the wildcard.
+	rrCache, err := resolve("static.rewrite.example.com.", dns.TypeAAAA)
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "fd00::5", rrCache.Answer[0].(*dns.AAAA).AAAA.String()) //nolint:forcetypeassert // Checked by test.
+		assert.Equal(t, ServerSourceRewrite, rrCache.Resolver.Source)
+	}
+	rrCache, err = resolve("other.rewrite.example.com.", dns.TypeA)
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 1) {
+		assert.Equal(t, "10.0.0.1", rrCache.Answer[0].(*dns.A).A.String()) //nolint:forcetypeassert // Checked by test.
+	}
+
+	// Check that CNAME rewrites are resolved further.
+	rrCache, err = resolve("alias.rewrite.example.com.", dns.TypeA)
+	if assert.NoError(t, err) && assert.Len(t, rrCache.Answer, 2) {
+		assert.Equal(t, "static.rewrite.example.com.", rrCache.Answer[0].(*dns.CNAME).Target) //nolint:forcetypeassert // Checked by test.
+		assert.Equal(t, "10.0.0.5", rrCache.Answer[1].(*dns.A).A.String())                    //nolint:forcetypeassert // Checked by test.
+	}
+
+	// Check the loop protection.
+	_, err = resolve("loop1.rewrite.example.com.", dns.TypeA)
+	assert.ErrorIs(t, err, ErrFailure)
+}
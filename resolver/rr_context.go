@@ -16,6 +16,7 @@ type DNSRequestContext struct {
 	RequestingNew   bool
 	IsBackup        bool
 	Filtered        bool
+	Source          AnswerSource
 
 	Modified time.Time
 	Expires  time.Time
@@ -32,6 +33,7 @@ func (rrCache *RRCache) ToDNSRequestContext() *DNSRequestContext {
 		RequestingNew:   rrCache.RequestingNew,
 		IsBackup:        rrCache.IsBackup,
 		Filtered:        rrCache.Filtered,
+		Source:          rrCache.Source(),
 
 		Modified: time.Unix(rrCache.Modified, 0),
 		Expires:  time.Unix(rrCache.Expires, 0),
@@ -33,13 +33,36 @@ type RRCache struct {
 	// Resolver Information
 	Resolver *ResolverInfo `json:"-"`
 
+	// DNSSEC Validation Result
+	AuthenticatedData bool
+	Insecure          bool
+
+	// EDNS Client Subnet used for the query and the scope returned by the server.
+	ECSNetwork string
+	ECSScope   uint8
+
+	// ClientScope separates the cached answer from those of other clients.
+	ClientScope string
+
+	// ExtendedError holds the extended DNS error of the response, if any.
+	// See RFC 8914.
+	ExtendedError *dns.EDNS0_EDE
+
+	// Public suffix data of the queried domain, copied from the query.
+	ICANNSpace bool
+	DomainRoot string
+
 	// Metadata about the request and handling
 	ServedFromCache bool
 	RequestingNew   bool
 	IsBackup        bool
+	ServedStale     bool
 	Filtered        bool
 	FilteredEntries []string
 
+	// Warnings holds non-fatal issues encountered while resolving.
+	Warnings []string
+
 	// Modified holds when this entry was last changed, ie. saved to database.
 	// This field is only populated when the entry comes from the cache.
 	Modified int64
@@ -47,7 +70,7 @@ type RRCache struct {
 
 // ID returns the ID of the RRCache consisting of the domain and question type.
 func (rrCache *RRCache) ID() string {
-	return rrCache.Domain + rrCache.Question.String()
+	return rrCache.Domain + rrCache.Question.String() + makeCacheKeySuffix(rrCache.ECSNetwork, rrCache.ClientScope)
 }
 
 // Expired returns whether the record has expired.
@@ -55,36 +78,71 @@ func (rrCache *RRCache) Expired() bool {
 	return rrCache.Expires <= time.Now().Unix()
 }
 
-// ExpiresSoon returns whether the record will expire soon and should already be refreshed.
-func (rrCache *RRCache) ExpiresSoon() bool {
-	return rrCache.Expires <= time.Now().Unix()+refreshTTL
+// ExpiresSoon returns whether the record will expire within the given refresh
+// TTL and should already be refreshed.
+func (rrCache *RRCache) ExpiresSoon(refreshTTL uint32) bool {
+	return rrCache.Expires <= time.Now().Unix()+int64(refreshTTL)
 }
 
-// Clean sets all TTLs to 17 and sets cache expiry with specified minimum.
-func (rrCache *RRCache) Clean(minExpires uint32) {
-	var lowestTTL uint32 = 0xFFFFFFFF
+// Clean sets all TTLs to 17 and sets cache expiry with specified minimum and maximum.
+// The minimum is raised to the TTL floor configured for the domain, if higher.
+func (rrCache *RRCache) Clean(minExpires, maxExpires uint32) {
 	var header *dns.RR_Header
 
+	// Apply the TTL floor of the domain.
+	if floor, ok := getTTLFloor(rrCache.Domain); ok && floor > minExpires {
+		minExpires = floor
+		if maxExpires < minExpires {
+			maxExpires = minExpires
+		}
+	}
+
+	// Remove extra sections, if enabled.
+	if stripExtraSections() {
+		rrCache.stripExtraSections()
+	}
+
+	// Get negative caching TTL from SOA record, see RFC 2308.
+	negativeTTL, hasNegativeTTL := rrCache.negativeTTL()
+
+	// The whole response expires together with its shortest lived record.
+	lowestTTL := rrCache.lowestTTL()
+
 	// set TTLs to 17
 	// TODO: double append? is there something more elegant?
 	for _, rr := range append(rrCache.Answer, append(rrCache.Ns, rrCache.Extra...)...) {
 		header = rr.Header()
-		if lowestTTL > header.Ttl {
-			lowestTTL = header.Ttl
-		}
 		header.Ttl = 17
 	}
 
 	// TTL range limits
 	switch {
 	case lowestTTL < minExpires:
+		if len(rrCache.Answer) > 0 {
+			rrCache.addWarning("TTL clamped from %ds to %ds", lowestTTL, minExpires)
+		}
 		lowestTTL = minExpires
-	case lowestTTL > maxTTL:
-		lowestTTL = maxTTL
+	case lowestTTL > maxExpires:
+		if lowestTTL != 0xFFFFFFFF {
+			rrCache.addWarning("TTL clamped from %ds to %ds", lowestTTL, maxExpires)
+		}
+		lowestTTL = maxExpires
 	}
 
 	// shorten caching
 	switch {
+	case (rrCache.RCode == dns.RcodeNameError || rrCache.IsNODATA()) && hasNegativeTTL &&
+		!netenv.IsConnectivityDomain(rrCache.Domain):
+		// Domain or queried RR does not exist: Honor the negative caching TTL
+		// of the zone.
+		switch {
+		case negativeTTL < minExpires:
+			lowestTTL = minExpires
+		case negativeTTL > maxExpires:
+			lowestTTL = maxExpires
+		default:
+			lowestTTL = negativeTTL
+		}
 	case rrCache.RCode != dns.RcodeSuccess:
 		// Any sort of error.
 		lowestTTL = 10
@@ -103,6 +161,85 @@ func (rrCache *RRCache) Clean(minExpires uint32) {
 	rrCache.Expires = time.Now().Unix() + int64(lowestTTL)
 }
 
+// ttlSpreadFactor is the factor by which the TTLs of answer records must differ
+// in order to be logged.
+const ttlSpreadFactor = 10
+
+// lowestTTL returns the lowest TTL of all records in the response, or
+// 0xFFFFFFFF if there are no records. As the response is cached as a whole,
+// this is the TTL of the whole response, even if the answer records have
+// different TTLs.
+func (rrCache *RRCache) lowestTTL() uint32 {
+	var lowestAnswerTTL, highestAnswerTTL uint32 = 0xFFFFFFFF, 0
+	for _, rr := range rrCache.Answer {
+		ttl := rr.Header().Ttl
+		if lowestAnswerTTL > ttl {
+			lowestAnswerTTL = ttl
+		}
+		if highestAnswerTTL < ttl {
+			highestAnswerTTL = ttl
+		}
+	}
+
+	lowestTTL := lowestAnswerTTL
+	for _, rr := range append(rrCache.Ns, rrCache.Extra...) {
+		if ttl := rr.Header().Ttl; lowestTTL > ttl {
+			lowestTTL = ttl
+		}
+	}
+
+	// Answers with wildly different TTLs are cached much shorter than some
+	// of their records would permit.
+	if len(rrCache.Answer) > 1 && highestAnswerTTL/ttlSpreadFactor > lowestAnswerTTL {
+		log.Tracef(
+			"resolver: TTLs of answer records for %s%s range from %ds to %ds, using the lowest",
+			rrCache.Domain, rrCache.Question, lowestAnswerTTL, highestAnswerTTL,
+		)
+	}
+
+	return lowestTTL
+}
+
+// stripExtraSections removes all records from the authority and additional
+// sections, except for the records needed for negative caching and negative
+// DNSSEC answers: SOA, NSEC and NSEC3 records and their signatures.
+func (rrCache *RRCache) stripExtraSections() {
+	var kept []dns.RR
+	for _, rr := range rrCache.Ns {
+		rrType := rr.Header().Rrtype
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			rrType = sig.TypeCovered
+		}
+		switch rrType {
+		case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+			kept = append(kept, rr)
+		}
+	}
+	rrCache.Ns = kept
+	rrCache.Extra = nil
+}
+
+// negativeTTL returns the negative caching TTL derived from the SOA record in
+// the authority section, which is the lower value of the SOA MINIMUM field and
+// the TTL of the SOA record itself.
+func (rrCache *RRCache) negativeTTL() (ttl uint32, ok bool) {
+	for _, rr := range rrCache.Ns {
+		if soa, isSOA := rr.(*dns.SOA); isSOA {
+			if soa.Minttl < soa.Hdr.Ttl {
+				return soa.Minttl, true
+			}
+			return soa.Hdr.Ttl, true
+		}
+	}
+	return 0, false
+}
+
+// IsNODATA returns whether the RRCache is a NODATA response, meaning that the
+// domain exists, but has no records of the queried type. See RFC 2308.
+func (rrCache *RRCache) IsNODATA() bool {
+	return rrCache.RCode == dns.RcodeSuccess && len(rrCache.Answer) == 0
+}
+
 // ExportAllARecords return of a list of all A and AAAA IP addresses.
 func (rrCache *RRCache) ExportAllARecords() (ips []net.IP) {
 	for _, rr := range rrCache.Answer {
@@ -134,18 +271,36 @@ func (rrCache *RRCache) ToNameRecord() *NameRecord {
 		RCode:    rrCache.RCode,
 		Expires:  rrCache.Expires,
 		Resolver: rrCache.Resolver,
+
+		AuthenticatedData: rrCache.AuthenticatedData,
+		Insecure:          rrCache.Insecure,
+
+		ECSNetwork: rrCache.ECSNetwork,
+		ECSScope:   rrCache.ECSScope,
+
+		ClientScope: rrCache.ClientScope,
 	}
 
-	// Serialize RR entries to strings.
-	newRecord.Answer = toNameRecordSection(rrCache.Answer)
-	newRecord.Ns = toNameRecordSection(rrCache.Ns)
-	newRecord.Extra = toNameRecordSection(rrCache.Extra)
+	// Serialize RR entries to DNS wire format.
+	answer := toNameRecordSection(rrCache.Answer)
+	ns := toNameRecordSection(rrCache.Ns)
+	extra := toNameRecordSection(rrCache.Extra)
+	packed, err := packSections(answer, ns, extra)
+	if err == nil {
+		newRecord.Packed = packed
+		return newRecord
+	}
 
+	// Fall back to serializing RR entries to strings.
+	log.Warningf("resolver: failed to pack records of %s%s, saving as text: %s", rrCache.Domain, rrCache.Question, err)
+	newRecord.Answer = toPresentationFormat(answer)
+	newRecord.Ns = toPresentationFormat(ns)
+	newRecord.Extra = toPresentationFormat(extra)
 	return newRecord
 }
 
-func toNameRecordSection(rrSection []dns.RR) []string {
-	serialized := make([]string, 0, len(rrSection))
+func toNameRecordSection(rrSection []dns.RR) []dns.RR {
+	kept := make([]dns.RR, 0, len(rrSection))
 	for _, entry := range rrSection {
 		// Ignore some RR types.
 		switch entry.Header().Rrtype {
@@ -154,9 +309,17 @@ func toNameRecordSection(rrSection []dns.RR) []string {
 			// additional metadata.
 		case dns.TypeNULL:
 		default:
-			serialized = append(serialized, entry.String())
+			kept = append(kept, entry)
 		}
 	}
+	return kept
+}
+
+func toPresentationFormat(rrSection []dns.RR) []string {
+	serialized := make([]string, 0, len(rrSection))
+	for _, entry := range rrSection {
+		serialized = append(serialized, entry.String())
+	}
 	return serialized
 }
 
@@ -181,34 +344,44 @@ func (rrCache *RRCache) Save() error {
 		return nil
 	}
 
-	return rrCache.ToNameRecord().Save()
+	if err := rrCache.ToNameRecord().Save(); err != nil {
+		return err
+	}
+	emitCacheEvent(CacheEventSaved, rrCache.Domain, rrCache.Question.String())
+	return nil
 }
 
 // GetRRCache tries to load the corresponding NameRecord from the database and convert it.
+// The domain is normalized with NormalizeFQDN.
 func GetRRCache(domain string, question dns.Type) (*RRCache, error) {
+	return getRRCache(NormalizeFQDN(domain), question, "")
+}
+
+// getRRCache loads the RRCache for the given domain, question and cache key suffix.
+func getRRCache(domain string, question dns.Type, keySuffix string) (*RRCache, error) {
 	rrCache := &RRCache{
 		Domain:   domain,
 		Question: question,
 	}
 
-	nameRecord, err := GetNameRecord(domain, question.String())
+	nameRecord, err := GetNameRecord(domain, question.String()+keySuffix)
 	if err != nil {
 		return nil, err
 	}
 
 	rrCache.RCode = nameRecord.RCode
 	rrCache.Expires = nameRecord.Expires
-	for _, entry := range nameRecord.Answer {
-		rrCache.Answer = parseRR(rrCache.Answer, entry)
-	}
-	for _, entry := range nameRecord.Ns {
-		rrCache.Ns = parseRR(rrCache.Ns, entry)
-	}
-	for _, entry := range nameRecord.Extra {
-		rrCache.Extra = parseRR(rrCache.Extra, entry)
+	rrCache.Answer, rrCache.Ns, rrCache.Extra, err = nameRecord.sections()
+	if err != nil {
+		return nil, err
 	}
 
 	rrCache.Resolver = nameRecord.Resolver
+	rrCache.AuthenticatedData = nameRecord.AuthenticatedData
+	rrCache.Insecure = nameRecord.Insecure
+	rrCache.ECSNetwork = nameRecord.ECSNetwork
+	rrCache.ECSScope = nameRecord.ECSScope
+	rrCache.ClientScope = nameRecord.ClientScope
 	rrCache.ServedFromCache = true
 	rrCache.Modified = nameRecord.Meta().Modified
 	return rrCache, nil
@@ -227,7 +400,49 @@ func parseRR(section []dns.RR, entry string) []dns.RR {
 	return section
 }
 
-// Flags formats ServedFromCache and RequestingNew to a condensed, flag-like format.
+// AnswerSource describes where the answer of an RRCache came from.
+type AnswerSource string
+
+// Answer Sources.
+const (
+	// AnswerSourceUpstream is a fresh answer of a DNS server.
+	AnswerSourceUpstream AnswerSource = "upstream"
+	// AnswerSourceCache is a valid answer from the cache.
+	AnswerSourceCache AnswerSource = "cache"
+	// AnswerSourceBackup is an answer from the cache that is served, because
+	// resolving it again failed.
+	AnswerSourceBackup AnswerSource = "backup"
+	// AnswerSourceRefreshing is an answer from the cache that expires soon, or
+	// has already expired, while it is being refreshed in the background.
+	AnswerSourceRefreshing AnswerSource = "refreshing"
+	// AnswerSourceSynthesized is an answer made up by the Portmaster, like
+	// rewritten domains or answers about the Portmaster itself.
+	AnswerSourceSynthesized AnswerSource = "synthesized"
+	// AnswerSourceHosts is an answer from a hosts file.
+	AnswerSourceHosts AnswerSource = "hosts"
+)
+
+// Source returns where the answer came from. It combines IsBackup,
+// RequestingNew and ServedFromCache with the type of the resolver.
+func (rrCache *RRCache) Source() AnswerSource {
+	switch {
+	case rrCache.IsBackup:
+		return AnswerSourceBackup
+	case rrCache.RequestingNew:
+		return AnswerSourceRefreshing
+	case rrCache.Resolver != nil && rrCache.Resolver.Type == ServerTypeHosts:
+		return AnswerSourceHosts
+	case rrCache.Resolver != nil &&
+		(rrCache.Resolver.Type == ServerTypeRewrite || rrCache.Resolver.Type == ServerTypeEnv):
+		return AnswerSourceSynthesized
+	case rrCache.ServedFromCache:
+		return AnswerSourceCache
+	default:
+		return AnswerSourceUpstream
+	}
+}
+
+// Flags formats ServedFromCache, RequestingNew and other metadata to a condensed, flag-like format.
 func (rrCache *RRCache) Flags() string {
 	var s string
 	if rrCache.ServedFromCache {
@@ -239,6 +454,9 @@ func (rrCache *RRCache) Flags() string {
 	if rrCache.IsBackup {
 		s += "B"
 	}
+	if rrCache.ServedStale {
+		s += "S"
+	}
 	if rrCache.Filtered {
 		s += "F"
 	}
@@ -263,15 +481,37 @@ func (rrCache *RRCache) ShallowCopy() *RRCache {
 
 		Resolver: rrCache.Resolver,
 
+		AuthenticatedData: rrCache.AuthenticatedData,
+		Insecure:          rrCache.Insecure,
+
+		ECSNetwork: rrCache.ECSNetwork,
+		ECSScope:   rrCache.ECSScope,
+
+		ClientScope: rrCache.ClientScope,
+
+		ExtendedError: rrCache.ExtendedError,
+
+		ICANNSpace: rrCache.ICANNSpace,
+		DomainRoot: rrCache.DomainRoot,
+
 		ServedFromCache: rrCache.ServedFromCache,
 		RequestingNew:   rrCache.RequestingNew,
 		IsBackup:        rrCache.IsBackup,
+		ServedStale:     rrCache.ServedStale,
 		Filtered:        rrCache.Filtered,
 		FilteredEntries: rrCache.FilteredEntries,
+		Warnings:        rrCache.Warnings,
 		Modified:        rrCache.Modified,
 	}
 }
 
+// addWarning adds a non-fatal issue to the warnings, if there is still space.
+func (rrCache *RRCache) addWarning(format string, a ...interface{}) {
+	if len(rrCache.Warnings) < maxRRCacheWarnings {
+		rrCache.Warnings = append(rrCache.Warnings, fmt.Sprintf(format, a...))
+	}
+}
+
 // ReplaceAnswerNames is a helper function that replaces all answer names, that
 // match the query domain, with another value. This is used to support handling
 // non-standard query names, which are resolved normalized, but have to be
@@ -286,12 +526,14 @@ func (rrCache *RRCache) ReplaceAnswerNames(fqdn string) {
 }
 
 // ReplyWithDNS creates a new reply to the given query with the data from the
-// RRCache, and additional informational records.
+// RRCache, and additional informational records. The answer records are
+// ordered as configured, without changing the RRCache.
 func (rrCache *RRCache) ReplyWithDNS(ctx context.Context, request *dns.Msg) *dns.Msg {
 	// reply to query
 	reply := new(dns.Msg)
 	reply.SetRcode(request, rrCache.RCode)
-	reply.Answer = rrCache.Answer
+	reply.AuthenticatedData = rrCache.AuthenticatedData
+	reply.Answer = orderAnswers(rrCache.Answer, shuffleAnswersMode())
 	reply.Ns = rrCache.Ns
 	reply.Extra = rrCache.Extra
 
@@ -322,6 +564,22 @@ func (rrCache *RRCache) GetExtraRRs(ctx context.Context, query *dns.Msg) (extra
 	if rrCache.IsBackup {
 		extra = addExtra(ctx, extra, "this record is served because a fresh request was unsuccessful")
 	}
+	if rrCache.ServedStale {
+		extra = addExtra(ctx, extra, "this record has expired and is served stale while it is being refreshed")
+	}
+
+	// Add warnings.
+	for _, warning := range rrCache.Warnings {
+		extra = addExtra(ctx, extra, "warning: "+warning)
+	}
+
+	// Add DNSSEC validation result.
+	switch {
+	case rrCache.AuthenticatedData:
+		extra = addExtra(ctx, extra, "record was validated with DNSSEC")
+	case rrCache.Insecure:
+		extra = addExtra(ctx, extra, "record is in an insecure zone and could not be validated with DNSSEC")
+	}
 
 	// Add information about filtered entries.
 	if rrCache.Filtered {
@@ -1,9 +1,13 @@
 package resolver
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/config"
 )
 
 func TestCaching(t *testing.T) {
@@ -44,3 +48,339 @@ func TestCaching(t *testing.T) {
 		t.Fatal("something very is wrong")
 	}
 }
+
+func TestNegativeCachingTTL(t *testing.T) {
+	t.Parallel()
+
+	for _, rcode := range []int{dns.RcodeNameError, dns.RcodeSuccess} {
+		testNegativeCachingTTL(t, rcode, 3600, 1800, 1800)    // SOA MINIMUM is lower than the SOA TTL.
+		testNegativeCachingTTL(t, rcode, 900, 1800, 900)      // SOA TTL is lower than the SOA MINIMUM.
+		testNegativeCachingTTL(t, rcode, 5, 5, defaultMinTTL) // Short negative TTLs are bounded by the minimum.
+		testNegativeCachingTTL(t, rcode, 3*defaultMaxTTL, 3*defaultMaxTTL, defaultMaxTTL)
+	}
+
+	// Without SOA record, a short default is used.
+	rrCache := &RRCache{
+		Domain:   "nxdomain.example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeNameError,
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	assertExpiresIn(t, rrCache, 10)
+	rrCache = &RRCache{
+		Domain:   "nodata.example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	assertExpiresIn(t, rrCache, 60)
+}
+
+func testNegativeCachingTTL(t *testing.T, rcode int, soaTTL, soaMinimum uint32, expectedTTL int64) {
+	t.Helper()
+
+	rrCache := &RRCache{
+		Domain:   "negative.example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    rcode,
+		Ns:       []dns.RR{newTestSOA(soaTTL, soaMinimum)},
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	assertExpiresIn(t, rrCache, expectedTTL)
+}
+
+func newTestSOA(soaTTL, soaMinimum uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soaTTL},
+		Ns:      "ns.example.com.",
+		Mbox:    "hostmaster.example.com.",
+		Serial:  1,
+		Refresh: 7200,
+		Retry:   3600,
+		Expire:  1209600,
+		Minttl:  soaMinimum,
+	}
+}
+
+func TestNODATA(t *testing.T) {
+	t.Parallel()
+
+	rrCache := &RRCache{
+		Domain:   "nodata.rrcache.example.com.",
+		Question: dns.Type(dns.TypeAAAA),
+		RCode:    dns.RcodeSuccess,
+		Ns:       []dns.RR{newTestSOA(3600, 300)},
+		Resolver: envResolver.Info.Copy(),
+	}
+	if !rrCache.IsNODATA() || !rrCache.Cacheable() {
+		t.Fatal("NOERROR response without answers should be a cacheable NODATA response")
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	assertExpiresIn(t, rrCache, 300)
+
+	// The SOA is kept in the cache.
+	if err := rrCache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := GetRRCache(rrCache.Domain, rrCache.Question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.IsNODATA() {
+		t.Error("cached response should be NODATA")
+	}
+	if len(cached.Ns) != 1 || cached.Ns[0].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("cached response should keep the SOA record, got %v", cached.Ns)
+	}
+
+	// Neither answers nor errors are NODATA.
+	if (&RRCache{RCode: dns.RcodeNameError}).IsNODATA() {
+		t.Error("NXDOMAIN response should not be NODATA")
+	}
+	if (&RRCache{RCode: dns.RcodeSuccess, Answer: []dns.RR{newTestSOA(60, 60)}}).IsNODATA() {
+		t.Error("response with answers should not be NODATA")
+	}
+}
+
+func assertExpiresIn(t *testing.T, rrCache *RRCache, ttl int64) {
+	t.Helper()
+
+	expiresIn := rrCache.Expires - time.Now().Unix()
+	if expiresIn < ttl-1 || expiresIn > ttl {
+		t.Errorf("expected record to expire in %ds, but expires in %ds", ttl, expiresIn)
+	}
+}
+
+func TestLowestTTL(t *testing.T) {
+	t.Parallel()
+
+	long, err := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := dns.NewRR("example.com. 60 IN A 192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrCache := &RRCache{
+		Domain:   "example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{long, short},
+	}
+
+	// The whole answer expires with its shortest lived record.
+	if ttl := rrCache.lowestTTL(); ttl != 60 {
+		t.Errorf("expected lowest TTL of 60s, got %ds", ttl)
+	}
+	rrCache.Clean(0, defaultMaxTTL)
+	assertExpiresIn(t, rrCache, 60)
+	for _, rr := range rrCache.Answer {
+		if rr.Header().Ttl != 17 {
+			t.Errorf("expected TTL of %s to be reset to 17s", rr)
+		}
+	}
+
+	// Records of the other sections are included.
+	long, err = dns.NewRR("example.com. 300 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrCache = &RRCache{
+		Domain:   "example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{long},
+		Ns:       []dns.RR{newTestSOA(30, 30)},
+	}
+	if ttl := rrCache.lowestTTL(); ttl != 30 {
+		t.Errorf("expected lowest TTL of 30s, got %ds", ttl)
+	}
+
+	// Without records, there is no lowest TTL.
+	rrCache = &RRCache{Domain: "example.com.", Question: dns.Type(dns.TypeA)}
+	if ttl := rrCache.lowestTTL(); ttl != 0xFFFFFFFF {
+		t.Errorf("expected no lowest TTL, got %ds", ttl)
+	}
+}
+
+func TestRRCacheWarnings(t *testing.T) {
+	t.Parallel()
+
+	rrCache := &RRCache{
+		Domain:   "example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer: []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 5},
+			A:   []byte{192, 0, 2, 1},
+		}},
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	if len(rrCache.Warnings) != 1 || rrCache.Warnings[0] != "TTL clamped from 5s to 60s" {
+		t.Errorf("unexpected warnings: %v", rrCache.Warnings)
+	}
+
+	// Warnings are bounded.
+	for i := 0; i < 2*maxRRCacheWarnings; i++ {
+		rrCache.addWarning("warning %d", i)
+	}
+	if len(rrCache.Warnings) != maxRRCacheWarnings {
+		t.Errorf("expected %d warnings, got %d", maxRRCacheWarnings, len(rrCache.Warnings))
+	}
+}
+
+func TestStripExtraSections(t *testing.T) { //nolint:paralleltest // Changes global config.
+	if err := config.SetConfigOption(CfgOptionStripExtraSectionsKey, true); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := config.SetConfigOption(CfgOptionStripExtraSectionsKey, false); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	mustRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+
+	// Glue records are dropped.
+	rrCache := &RRCache{
+		Domain:   "example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer: []dns.RR{
+			mustRR("example.com. 300 IN A 192.0.2.1"),
+			mustRR("example.com. 300 IN RRSIG A 13 2 300 20300101000000 20200101000000 12345 example.com. dGVzdA=="),
+		},
+		Ns: []dns.RR{
+			mustRR("example.com. 120 IN NS ns1.example.com."),
+		},
+		Extra: []dns.RR{
+			mustRR("ns1.example.com. 120 IN A 192.0.2.53"),
+			mustRR("ns1.example.com. 120 IN AAAA 2001:db8::53"),
+		},
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	if len(rrCache.Answer) != 2 {
+		t.Errorf("expected answer section to be kept, got %v", rrCache.Answer)
+	}
+	if len(rrCache.Ns) != 0 || len(rrCache.Extra) != 0 {
+		t.Errorf("expected extra sections to be stripped, got %v and %v", rrCache.Ns, rrCache.Extra)
+	}
+
+	// SOA and NSEC records are kept for negative answers.
+	rrCache = &RRCache{
+		Domain:   "nxdomain.example.com.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeNameError,
+		Ns: []dns.RR{
+			mustRR("example.com. 900 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 900"),
+			mustRR("example.com. 900 IN RRSIG SOA 13 2 900 20300101000000 20200101000000 12345 example.com. dGVzdA=="),
+			mustRR("example.com. 900 IN NSEC www.example.com. A NS SOA RRSIG NSEC"),
+			mustRR("example.com. 900 IN RRSIG NSEC 13 2 900 20300101000000 20200101000000 12345 example.com. dGVzdA=="),
+			mustRR("example.com. 900 IN NS ns1.example.com."),
+			mustRR("example.com. 900 IN RRSIG NS 13 2 900 20300101000000 20200101000000 12345 example.com. dGVzdA=="),
+		},
+	}
+	rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+	if len(rrCache.Ns) != 4 {
+		t.Errorf("expected SOA and NSEC records with signatures to be kept, got %v", rrCache.Ns)
+	}
+	assertExpiresIn(t, rrCache, 900)
+}
+
+func TestRRCacheSource(t *testing.T) {
+	t.Parallel()
+
+	upstream := &ResolverInfo{Type: ServerTypeDNS}
+	for _, tc := range []struct {
+		rrCache  *RRCache
+		expected AnswerSource
+	}{
+		{&RRCache{Resolver: upstream}, AnswerSourceUpstream},
+		{&RRCache{Resolver: upstream, ServedFromCache: true}, AnswerSourceCache},
+		{&RRCache{Resolver: upstream, ServedFromCache: true, RequestingNew: true}, AnswerSourceRefreshing},
+		{&RRCache{Resolver: upstream, ServedFromCache: true, RequestingNew: true, ServedStale: true}, AnswerSourceRefreshing},
+		{&RRCache{Resolver: upstream, ServedFromCache: true, IsBackup: true}, AnswerSourceBackup},
+		{&RRCache{Resolver: hostsResolver.Info}, AnswerSourceHosts},
+		{&RRCache{Resolver: rewriteResolverInfo}, AnswerSourceSynthesized},
+		{&RRCache{Resolver: envResolver.Info, ServedFromCache: true}, AnswerSourceSynthesized},
+	} {
+		if source := tc.rrCache.Source(); source != tc.expected {
+			t.Errorf("expected source %s for %s, got %s", tc.expected, tc.rrCache.Flags(), source)
+		}
+	}
+}
+
+func TestRRCacheSourceResolving(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	_, _, restore := useTestCountingResolver(0)
+	defer restore()
+
+	// The first answer comes from upstream, the second one from the cache.
+	q := &Query{FQDN: "source." + InternalSpecialUseDomain, QType: dns.Type(dns.TypeA)}
+	rrCache, err := Resolve(silencingTraceCtx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := rrCache.Source(); source != AnswerSourceUpstream {
+		t.Errorf("expected first answer from upstream, got %s", source)
+	}
+	if source := rrCache.ToDNSRequestContext().Source; source != AnswerSourceUpstream {
+		t.Errorf("expected request context with source upstream, got %s", source)
+	}
+
+	rrCache, err = Resolve(silencingTraceCtx, &Query{FQDN: q.FQDN, QType: q.QType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := rrCache.Source(); source != AnswerSourceCache {
+		t.Errorf("expected second answer from cache, got %s", source)
+	}
+
+	// An expired entry is served as backup when resolving fails.
+	conn, testResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 59))
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{testResolver}
+	activeResolvers[testResolver.Info.ID()] = testResolver
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		delete(activeResolvers, testResolver.Info.ID())
+		resolversLock.Unlock()
+	}()
+
+	fqdn := "source-backup." + InternalSpecialUseDomain
+	rr, err := dns.NewRR(fqdn + " 17 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := &RRCache{
+		Domain:   fqdn,
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{rr},
+		Expires:  time.Now().Add(-time.Minute).Unix(),
+		Resolver: testResolver.Info.Copy(),
+	}
+	if err := expired.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rrCache, err = Resolve(silencingTraceCtx, &Query{FQDN: fqdn, QType: dns.Type(dns.TypeA)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.failures.Load() == 0 {
+		t.Error("expected the resolver to be asked")
+	}
+	if source := rrCache.Source(); source != AnswerSourceBackup {
+		t.Errorf("expected backup answer, got %s", source)
+	}
+}
@@ -3,6 +3,7 @@
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -88,6 +89,16 @@
 		".test.",
 	}
 
+	// Reverse-Mapping Domains of Private Addresses
+	// Handling: PTR queries are only sent to local nameservers, so that
+	// private addresses do not leak to public nameservers.
+	// Consists of the reverse-mapping domains of the special use and multicast
+	// domains and the RFC1122 IPv4 loopback network.
+	privateReverseDomains = append(
+		filterReverseDomains(specialUseDomains, multicastDomains),
+		".127.in-addr.arpa.",
+	)
+
 	// Special-Service Domain Names
 	// Handling: Send to nameservers with matching search scope, then local and system assigned nameservers.
 	specialServiceDomains = []string{
@@ -164,6 +175,16 @@ func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, p
 	resolversLock.RLock()
 	defer resolversLock.RUnlock()
 
+	// A forced resolver takes precedence over all scopes.
+	if q.ForceResolverID != "" {
+		resolver, err := getForcedResolver(ctx, q)
+		if err != nil {
+			log.Tracer(ctx).Tracef("resolver: %s", err)
+			return nil, "", false
+		}
+		return []*Resolver{resolver}, resolver.Info.Source, false
+	}
+
 	// Internal use domains
 	if domainInScope(q.dotPrefixedFQDN, internalSpecialUseDomains) {
 		return envResolvers, ServerSourceEnv, false
@@ -176,6 +197,17 @@ func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, p
 		return selected, ServerSourceOperatingSystem, false
 	}
 
+	// Domains defined in the hosts files take precedence.
+	if hostsConn.has(q.FQDN) {
+		return hostsResolvers, ServerSourceHosts, false
+	}
+
+	// Conditional forwarding takes precedence over search scopes and special domains.
+	if rule := getForwardingRule(q.dotPrefixedFQDN); rule != nil {
+		selected = addResolvers(ctx, q, selected, rule.Resolvers)
+		return selected, ServerSourceConfigured, false
+	}
+
 	// Prioritize search scopes
 	for _, scope := range localScopes {
 		if strings.HasSuffix(q.dotPrefixedFQDN, scope.Domain) {
@@ -200,10 +232,35 @@ func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, p
 	}
 
 	// Global domains
+	globalStart := len(selected)
 	selected = addResolvers(ctx, q, selected, globalResolvers)
+	if resolverSelection() == ResolverSelectionLatency {
+		sortResolversByLatency(selected[globalStart:])
+	}
 	return selected, ServerSourceConfigured, false
 }
 
+func getForcedResolverWithLocking(ctx context.Context, q *Query) (*Resolver, error) {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	return getForcedResolver(ctx, q)
+}
+
+// getForcedResolver returns the resolver the query is forced to use, or an
+// error wrapping ErrNoCompliance if it does not exist or does not comply with
+// the query. The resolvers lock must be held.
+func getForcedResolver(ctx context.Context, q *Query) (*Resolver, error) {
+	resolver, ok := activeResolvers[q.ForceResolverID]
+	if !ok {
+		return nil, fmt.Errorf("%w: forced resolver %s does not exist", ErrNoCompliance, q.ForceResolverID)
+	}
+	if err := resolver.checkCompliance(ctx, q); err != nil {
+		return nil, fmt.Errorf("%w: forced resolver %s is not compliant: %s", ErrNoCompliance, resolver.Info.DescriptiveName(), err)
+	}
+	return resolver, nil
+}
+
 func addResolvers(ctx context.Context, q *Query, selected []*Resolver, addResolvers []*Resolver) []*Resolver {
 addNextResolver:
 	for _, resolver := range addResolvers {
@@ -238,11 +295,61 @@ func addResolvers(ctx context.Context, q *Query, selected []*Resolver, addResolv
 	errAssignedServer   = errors.New("assigned (dhcp) nameservers disabled")
 	errMulticastDNS     = errors.New("multicast DNS disabled")
 	errOutOfScope       = errors.New("query out of scope for resolver")
+	errNotLocal         = errors.New("only local resolvers are permitted")
 )
 
-func (q *Query) checkCompliance() error {
+// filterReverseDomains returns the reverse-mapping domains of the given
+// domain lists.
+func filterReverseDomains(domainLists ...[]string) (reverseDomains []string) {
+	for _, domains := range domainLists {
+		for _, domain := range domains {
+			if strings.HasSuffix(domain, ".in-addr.arpa.") || strings.HasSuffix(domain, ".ip6.arpa.") {
+				reverseDomains = append(reverseDomains, domain)
+			}
+		}
+	}
+	return reverseDomains
+}
+
+// isPrivateReverseQuery returns whether the query is a reverse lookup of a
+// private address.
+func (q *Query) isPrivateReverseQuery() bool {
+	return q.QType == dns.Type(dns.TypePTR) && domainInScope(q.dotPrefixedFQDN, privateReverseDomains)
+}
+
+func (q *Query) checkCompliance() (err error) {
+	// attach a stable code to errors
+	defer func() {
+		err = newResolveError(err, "")
+	}()
+
+	// single-label names may be refused or qualified with another domain
+	if err := q.ApplySingleLabelPolicy(); err != nil {
+		return err
+	}
+
+	// configured handling of special-use TLDs takes precedence
+	specialHandling, ok := getSpecialDomainHandling(q.dotPrefixedFQDN)
+	if ok {
+		switch specialHandling.Disposition {
+		case SpecialDomainBlock:
+			return fmt.Errorf("%w: .%s domains are blocked", ErrSpecialDomainsDisabled, specialHandling.TLD)
+		case SpecialDomainNXDomain:
+			return fmt.Errorf("%w: .%s domains are configured to not exist", ErrNotFound, specialHandling.TLD)
+		case SpecialDomainForward:
+			// never fall back to other resolvers
+			resolversLock.RLock()
+			rule := getForwardingRule(q.dotPrefixedFQDN)
+			resolversLock.RUnlock()
+			if rule == nil {
+				return fmt.Errorf("%w: no resolvers to forward .%s domains to", ErrNoCompliance, specialHandling.TLD)
+			}
+		}
+	}
+	forwarded := ok && specialHandling.Disposition == SpecialDomainForward
+
 	// RFC6761 - always respond with nxdomain
-	if strings.HasSuffix(q.dotPrefixedFQDN, invalidDomain) {
+	if !forwarded && strings.HasSuffix(q.dotPrefixedFQDN, invalidDomain) {
 		return ErrNotFound
 	}
 
@@ -256,12 +363,27 @@ func (q *Query) checkCompliance() error {
 		}
 	}
 
+	// blocklists
+	if err := q.checkBlocklist(); err != nil {
+		return err
+	}
+
 	// special TLDs
-	if dontResolveSpecialDomains(q.SecurityLevel) &&
+	if !forwarded && dontResolveSpecialDomains(q.SecurityLevel) &&
 		domainInScope(q.dotPrefixedFQDN, specialServiceDomains) {
 		return ErrSpecialDomainsDisabled
 	}
 
+	// RFC8482 - respond to ANY queries with a minimal response
+	if uint16(q.QType) == dns.TypeANY && minimalANYResponses(q.SecurityLevel) {
+		return errMinimalANYResponse
+	}
+
+	// query types
+	if limitQueryTypes(q.SecurityLevel) && !queryTypePermitted(q.QType) {
+		return fmt.Errorf("%w: %s", ErrQTypeBlocked, q.QType)
+	}
+
 	return nil
 }
 
@@ -276,8 +398,12 @@ func (resolver *Resolver) checkCompliance(_ context.Context, q *Query) error {
 			// compliant
 		case ServerTypeDoH:
 			// compliant
+		case ServerTypeDoQ:
+			// compliant
 		case ServerTypeEnv:
 			// compliant (data is sourced from local network only and is highly limited)
+		case ServerTypeHosts:
+			// compliant (data is sourced from the local hosts files only)
 		default:
 			return errInsecureProtocol
 		}
@@ -295,6 +421,11 @@ func (resolver *Resolver) checkCompliance(_ context.Context, q *Query) error {
 		}
 	}
 
+	// Check if only local resolvers may be used.
+	if q.LocalResolversOnly && !resolver.Info.IPScope.IsLocalhost() && !resolver.Info.IPScope.IsLAN() {
+		return errNotLocal
+	}
+
 	// Check if the resolver should only be used for the search scopes.
 	if resolver.SearchOnly && !domainInScope(q.dotPrefixedFQDN, resolver.Search) {
 		return errOutOfScope
@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portmaster/network/netutils"
+)
+
+func TestPrivateReverseQueries(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	_, publicResolver := newTestProbeResolver(ServerSourceConfigured, net.IPv4(192, 0, 2, 60))
+	publicResolver.Info.IPScope = netutils.Global
+	_, assignedResolver := newTestProbeResolver(ServerSourceOperatingSystem, net.IPv4(192, 0, 2, 61))
+	assignedResolver.Info.IPScope = netutils.Global
+	_, localResolver := newTestProbeResolver(ServerSourceOperatingSystem, net.IPv4(192, 168, 0, 1))
+	localResolver.Info.IPScope = netutils.SiteLocal
+
+	setResolvers := func(local []*Resolver) {
+		resolversLock.Lock()
+		defer resolversLock.Unlock()
+		globalResolvers = []*Resolver{publicResolver}
+		systemResolvers = []*Resolver{assignedResolver}
+		localResolvers = local
+	}
+	resolversLock.Lock()
+	previousGlobal, previousSystem, previousLocal := globalResolvers, systemResolvers, localResolvers
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		globalResolvers, systemResolvers, localResolvers = previousGlobal, previousSystem, previousLocal
+		resolversLock.Unlock()
+	}()
+
+	newPTRQuery := func(ip net.IP) *Query {
+		reverse, err := dns.ReverseAddr(ip.String())
+		require.NoError(t, err)
+		q := &Query{FQDN: reverse, QType: dns.Type(dns.TypePTR), NoCaching: true}
+		require.True(t, q.check())
+		return q
+	}
+
+	// Private PTR queries only use local resolvers.
+	setResolvers([]*Resolver{localResolver})
+	for _, ip := range []net.IP{
+		net.IPv4(10, 1, 2, 3),
+		net.IPv4(172, 20, 0, 1),
+		net.IPv4(192, 168, 1, 1),
+		net.ParseIP("fd00::1"),
+	} {
+		q := newPTRQuery(ip)
+		assert.True(t, q.LocalResolversOnly, ip)
+		selected, _, _ := GetResolversInScope(context.Background(), q)
+		assert.Equal(t, []*Resolver{localResolver}, selected, ip)
+	}
+
+	// Loopback and link-local addresses are private too.
+	for _, ip := range []net.IP{
+		net.IPv4(127, 0, 0, 1),
+		net.IPv4(169, 254, 1, 1),
+		net.ParseIP("fe80::1"),
+	} {
+		assert.True(t, newPTRQuery(ip).LocalResolversOnly, ip)
+	}
+	assert.NotContains(t, privateReverseDomains, ".home.arpa.")
+
+	// Without local resolvers, private PTR queries are not sent anywhere.
+	setResolvers(nil)
+	q := newPTRQuery(net.IPv4(192, 168, 1, 1))
+	selected, _, _ := GetResolversInScope(context.Background(), q)
+	assert.Empty(t, selected)
+	_, err := resolveAndCache(context.Background(), q, nil)
+	assert.ErrorIs(t, err, ErrNoCompliance)
+
+	// Public PTR queries use the global resolvers.
+	q = newPTRQuery(net.IPv4(8, 8, 8, 8))
+	assert.False(t, q.LocalResolversOnly)
+	selected, _, _ = GetResolversInScope(context.Background(), q)
+	assert.Equal(t, []*Resolver{publicResolver}, selected)
+
+	// Other query types of private reverse domains are not affected.
+	q = &Query{FQDN: "1.1.168.192.in-addr.arpa.", QType: dns.Type(dns.TypeTXT)}
+	require.True(t, q.check())
+	assert.False(t, q.LocalResolversOnly)
+}
@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"math/rand"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// answerRotation counts the responses served with rotated answers.
+var answerRotation atomic.Uint64
+
+// rrSetKey identifies the RRset of a record.
+type rrSetKey struct {
+	name   string
+	rrType uint16
+	class  uint16
+}
+
+// orderAnswers returns the answer records in the order defined by mode.
+// Only the records within an RRset change their order, while the RRsets, like
+// the records of a CNAME chain, and their RRSIG records keep their position.
+// The given slice is not modified, as it may be shared with the cache.
+func orderAnswers(answers []dns.RR, mode string) []dns.RR {
+	switch mode {
+	case ShuffleAnswersRandom:
+		return reorderRRSets(answers, func(rrSet []dns.RR) {
+			//nolint:gosec // Spreading load does not require a secure source.
+			rand.Shuffle(len(rrSet), func(i, j int) {
+				rrSet[i], rrSet[j] = rrSet[j], rrSet[i]
+			})
+		})
+
+	case ShuffleAnswersRoundRobin:
+		rotation := answerRotation.Add(1) - 1
+		return reorderRRSets(answers, func(rrSet []dns.RR) {
+			offset := int(rotation % uint64(len(rrSet)))
+			rotated := append(append(make([]dns.RR, 0, len(rrSet)), rrSet[offset:]...), rrSet[:offset]...)
+			copy(rrSet, rotated)
+		})
+
+	default:
+		return answers
+	}
+}
+
+// reorderRRSets returns a copy of answers in which the records of every RRset
+// with multiple records are reordered by reorder. RRSIG records are not
+// reordered.
+func reorderRRSets(answers []dns.RR, reorder func(rrSet []dns.RR)) []dns.RR {
+	// Collect the positions of the records of every RRset.
+	var (
+		order     []rrSetKey
+		positions = make(map[rrSetKey][]int)
+	)
+	for i, rr := range answers {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+
+		key := rrSetKey{
+			name:   strings.ToLower(hdr.Name),
+			rrType: hdr.Rrtype,
+			class:  hdr.Class,
+		}
+		if _, ok := positions[key]; !ok {
+			order = append(order, key)
+		}
+		positions[key] = append(positions[key], i)
+	}
+
+	var reordered []dns.RR
+	for _, key := range order {
+		rrSetPositions := positions[key]
+		if len(rrSetPositions) < 2 {
+			continue
+		}
+		if reordered == nil {
+			reordered = append(make([]dns.RR, 0, len(answers)), answers...)
+		}
+
+		rrSet := make([]dns.RR, 0, len(rrSetPositions))
+		for _, pos := range rrSetPositions {
+			rrSet = append(rrSet, answers[pos])
+		}
+		reorder(rrSet)
+		for i, pos := range rrSetPositions {
+			reordered[pos] = rrSet[i]
+		}
+	}
+
+	if reordered == nil {
+		return answers
+	}
+	return reordered
+}
@@ -0,0 +1,105 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+	return rr
+}
+
+func answerStrings(answers []dns.RR) []string {
+	s := make([]string, 0, len(answers))
+	for _, rr := range answers {
+		s = append(s, rr.String())
+	}
+	return s
+}
+
+func TestShuffleAnswersRoundRobin(t *testing.T) { //nolint:paralleltest // Changes global config.
+	err := config.SetConfigOption(CfgOptionShuffleAnswersKey, ShuffleAnswersRoundRobin)
+	require.NoError(t, err)
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionShuffleAnswersKey, ShuffleAnswersNone)
+	}()
+
+	var (
+		cname = mustRR(t, "www.example.com. 60 IN CNAME example.com.")
+		a1    = mustRR(t, "example.com. 60 IN A 192.0.2.1")
+		a2    = mustRR(t, "example.com. 60 IN A 192.0.2.2")
+		a3    = mustRR(t, "example.com. 60 IN A 192.0.2.3")
+		sig   = mustRR(t, "example.com. 60 IN RRSIG A 13 2 60 20300101000000 20200101000000 12345 example.com. AAAA")
+	)
+	answers := []dns.RR{cname, a1, a2, a3, sig}
+	rrCache := &RRCache{
+		Domain:   "www.example.com.",
+		Question: dns.Type(dns.TypeA),
+		Answer:   answers,
+	}
+	request := new(dns.Msg).SetQuestion("www.example.com.", dns.TypeA)
+
+	// Every response rotates the A records by one, while the CNAME and the
+	// RRSIG stay in place.
+	first := rrCache.ReplyWithDNS(context.Background(), request).Answer
+	offset := -1
+	for i, rr := range []dns.RR{a1, a2, a3} {
+		if first[1] == rr {
+			offset = i
+		}
+	}
+	require.NotEqual(t, -1, offset)
+
+	rotations := [][]dns.RR{
+		{cname, a1, a2, a3, sig},
+		{cname, a2, a3, a1, sig},
+		{cname, a3, a1, a2, sig},
+	}
+	for i := 0; i < 6; i++ {
+		var reply []dns.RR
+		if i == 0 {
+			reply = first
+		} else {
+			reply = rrCache.ReplyWithDNS(context.Background(), request).Answer
+		}
+		assert.Equal(t, answerStrings(rotations[(offset+i)%3]), answerStrings(reply))
+	}
+
+	// The cached answer is not changed.
+	assert.Equal(t, answerStrings([]dns.RR{cname, a1, a2, a3, sig}), answerStrings(rrCache.Answer))
+}
+
+func TestShuffleAnswersRandom(t *testing.T) {
+	t.Parallel()
+
+	var (
+		a1 = mustRR(t, "example.com. 60 IN A 192.0.2.1")
+		a2 = mustRR(t, "example.com. 60 IN A 192.0.2.2")
+		a3 = mustRR(t, "example.com. 60 IN A 192.0.2.3")
+		b  = mustRR(t, "example.com. 60 IN AAAA 2001:db8::1")
+	)
+	answers := []dns.RR{a1, a2, b, a3}
+
+	// Records stay within the positions of their RRset.
+	for i := 0; i < 20; i++ {
+		shuffled := orderAnswers(answers, ShuffleAnswersRandom)
+		require.Len(t, shuffled, 4)
+		assert.Equal(t, b, shuffled[2])
+		assert.ElementsMatch(t, []dns.RR{a1, a2, a3}, []dns.RR{shuffled[0], shuffled[1], shuffled[3]})
+	}
+	assert.Equal(t, []dns.RR{a1, a2, b, a3}, answers)
+
+	// Single records and disabled shuffling keep the answer as is.
+	assert.Equal(t, []dns.RR{b}, orderAnswers([]dns.RR{b}, ShuffleAnswersRandom))
+	assert.Equal(t, answers, orderAnswers(answers, ShuffleAnswersNone))
+}
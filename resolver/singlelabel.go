@@ -0,0 +1,107 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portmaster/status"
+)
+
+// localDomain is appended to single-label names that are resolved on the
+// local network.
+const localDomain = "local."
+
+// isSingleLabelName returns whether the given FQDN consists of a single label.
+func isSingleLabelName(fqdn string) bool {
+	return fqdn != "." && strings.Count(fqdn, ".") == 1
+}
+
+// normalizeSearchDomain returns the search domain in lowercase, without a
+// leading dot and with a trailing dot.
+func normalizeSearchDomain(domain string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(domain), ".")) + "."
+}
+
+// ApplySingleLabelPolicy applies the configured policy to queries for
+// single-label names. Names that are top level domains are not affected.
+// If the name is qualified with another domain, the query is changed to the
+// qualified name and the original name is kept in unqualifiedFQDN.
+// It is called by the compliance check, but must be called before the name
+// of the query is filtered, so that the qualified name is filtered.
+// Applying the policy again to a qualified query has no effect.
+func (q *Query) ApplySingleLabelPolicy() error {
+	switch {
+	case !isSingleLabelName(q.FQDN),
+		q.ICANNSpace,
+		q.dnssecChainQuery,
+		q.unqualifiedFQDN != "",
+		strings.HasSuffix(q.dotPrefixedFQDN, localhostDomain):
+		return nil
+	}
+
+	policy := singleLabelNames()
+	if policy == SingleLabelNamesAuto {
+		if q.SecurityLevel&status.SecurityLevelsHighAndExtreme != 0 {
+			policy = SingleLabelNamesRefuse
+		} else {
+			policy = SingleLabelNamesResolve
+		}
+	}
+
+	switch policy {
+	case SingleLabelNamesResolve:
+		return nil
+	case SingleLabelNamesLocal:
+		q.qualifyName(localDomain)
+		return nil
+	case SingleLabelNamesSearch:
+		domain := searchDomain()
+		if domain == "" {
+			return fmt.Errorf("%w: no search domain configured for single-label name %s", ErrInvalid, q.FQDN)
+		}
+		q.qualifyName(normalizeSearchDomain(domain))
+		return nil
+	default:
+		return fmt.Errorf("%w: single-label name %s is not resolved", ErrInvalid, q.FQDN)
+	}
+}
+
+// qualifyName appends the given domain to the name of the query and updates
+// the data derived from the name.
+func (q *Query) qualifyName(domain string) {
+	q.unqualifiedFQDN = q.FQDN
+	q.FQDN += domain
+	q.dotPrefixedFQDN = "." + q.FQDN
+	q.InitPublicSuffixData()
+}
+
+// withAliasFrom returns a copy of the RRCache for the given alias name. The
+// answer is linked to the alias with a CNAME record, so that clients find the
+// answer for the name they queried.
+func (rrCache *RRCache) withAliasFrom(alias string) *RRCache {
+	aliased := rrCache.ShallowCopy()
+	aliased.Domain = alias
+	if rrCache.RCode != dns.RcodeSuccess || len(rrCache.Answer) == 0 {
+		return aliased
+	}
+
+	ttl := rrCache.Answer[0].Header().Ttl
+	for _, rr := range rrCache.Answer[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   alias,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Target: rrCache.Domain,
+	}
+	aliased.Answer = append([]dns.RR{cname}, rrCache.Answer...)
+	return aliased
+}
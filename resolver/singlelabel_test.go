@@ -0,0 +1,141 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/status"
+)
+
+func TestSingleLabelPolicy(t *testing.T) { //nolint:paralleltest // Changes global config.
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionSingleLabelNamesKey, SingleLabelNamesAuto)
+		_ = config.SetConfigOption(CfgOptionSearchDomainKey, "")
+	}()
+
+	testCases := []struct {
+		policy        string
+		searchDomain  string
+		securityLevel uint8
+		fqdn          string
+		domainRoot    string
+		err           bool
+	}{
+		{policy: SingleLabelNamesAuto, securityLevel: status.SecurityLevelNormal, fqdn: "myhost."},
+		{policy: SingleLabelNamesAuto, securityLevel: status.SecurityLevelHigh, err: true},
+		{policy: SingleLabelNamesResolve, securityLevel: status.SecurityLevelExtreme, fqdn: "myhost."},
+		{policy: SingleLabelNamesRefuse, securityLevel: status.SecurityLevelNormal, err: true},
+		{policy: SingleLabelNamesLocal, fqdn: "myhost.local.", domainRoot: "myhost.local."},
+		{policy: SingleLabelNamesSearch, searchDomain: ".Example.com.", fqdn: "myhost.example.com.", domainRoot: "example.com."},
+		{policy: SingleLabelNamesSearch, err: true},
+	}
+
+	for _, tc := range testCases {
+		require.NoError(t, config.SetConfigOption(CfgOptionSingleLabelNamesKey, tc.policy))
+		require.NoError(t, config.SetConfigOption(CfgOptionSearchDomainKey, tc.searchDomain))
+
+		q := &Query{
+			FQDN:          "myhost",
+			QType:         dns.Type(dns.TypeA),
+			SecurityLevel: tc.securityLevel,
+		}
+		require.True(t, q.check())
+		q.InitPublicSuffixData()
+
+		err := q.checkCompliance()
+		if tc.err {
+			assert.ErrorIs(t, err, ErrInvalid, "policy %s", tc.policy)
+			continue
+		}
+		require.NoError(t, err, "policy %s", tc.policy)
+		assert.Equal(t, tc.fqdn, q.FQDN, "policy %s", tc.policy)
+		assert.Equal(t, "."+tc.fqdn, q.dotPrefixedFQDN, "policy %s", tc.policy)
+		if tc.domainRoot != "" {
+			assert.Equal(t, tc.domainRoot, q.DomainRoot, "policy %s", tc.policy)
+		}
+	}
+
+	// Check that top level domains and localhost are not affected.
+	require.NoError(t, config.SetConfigOption(CfgOptionSingleLabelNamesKey, SingleLabelNamesRefuse))
+	for _, fqdn := range []string{"com.", "localhost."} {
+		q := &Query{FQDN: fqdn, QType: dns.Type(dns.TypeNS)}
+		require.True(t, q.check())
+		q.InitPublicSuffixData()
+		assert.NoError(t, q.ApplySingleLabelPolicy(), fqdn)
+		assert.Equal(t, fqdn, q.FQDN)
+	}
+}
+
+func TestResolveSingleLabelName(t *testing.T) { //nolint:paralleltest // Changes global config and resolvers.
+	_, testResolver, restore := useTestCountingResolver(0)
+	defer restore()
+	require.NoError(t, config.SetConfigOption(CfgOptionSingleLabelNamesKey, SingleLabelNamesSearch))
+	require.NoError(t, config.SetConfigOption(CfgOptionSearchDomainKey, InternalSpecialUseDomain))
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionSingleLabelNamesKey, SingleLabelNamesAuto)
+		_ = config.SetConfigOption(CfgOptionSearchDomainKey, "")
+	}()
+
+	// Check that the answer is linked to the queried name.
+	qualified := "myhost." + InternalSpecialUseDomain
+	rrCache, err := Resolve(silencingTraceCtx, &Query{
+		FQDN:      "myhost",
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "myhost.", rrCache.Domain)
+	assert.Equal(t, testResolver.Info.ID(), rrCache.Resolver.ID())
+	if assert.Len(t, rrCache.Answer, 2) {
+		cname, ok := rrCache.Answer[0].(*dns.CNAME)
+		if assert.True(t, ok) {
+			assert.Equal(t, "myhost.", cname.Hdr.Name)
+			assert.Equal(t, qualified, cname.Target)
+		}
+		assert.Equal(t, qualified, rrCache.Answer[1].Header().Name)
+	}
+}
+
+func TestBlockedQualifiedName(t *testing.T) { //nolint:paralleltest // Changes global config and the blocklist checker.
+	require.NoError(t, config.SetConfigOption(CfgOptionSingleLabelNamesKey, SingleLabelNamesSearch))
+	require.NoError(t, config.SetConfigOption(CfgOptionSearchDomainKey, "example.com"))
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionSingleLabelNamesKey, SingleLabelNamesAuto)
+		_ = config.SetConfigOption(CfgOptionSearchDomainKey, "")
+	}()
+
+	var checked []string
+	SetBlocklistChecker(func(q *Query) (bool, BlockMode) {
+		checked = append(checked, q.FQDN)
+		return q.FQDN == "blocked.example.com.", BlockModeRefused
+	})
+	defer SetBlocklistChecker(nil)
+
+	// Check that the name is qualified before it is filtered, as the nameserver does.
+	q := &Query{
+		FQDN:      "blocked.",
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	}
+	q.InitPublicSuffixData()
+	require.NoError(t, q.ApplySingleLabelPolicy())
+	assert.Equal(t, "blocked.example.com.", q.FQDN)
+
+	// Check that the qualified name is blocked and not qualified again.
+	_, err := Resolve(silencingTraceCtx, q)
+	assert.ErrorIs(t, err, ErrBlocklisted)
+	assert.Equal(t, "blocked.example.com.", q.FQDN)
+
+	// Check that the qualified name is blocked if the policy is applied when resolving.
+	_, err = Resolve(silencingTraceCtx, &Query{
+		FQDN:      "blocked.",
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	})
+	assert.ErrorIs(t, err, ErrBlocklisted)
+	assert.Equal(t, []string{"blocked.example.com.", "blocked.example.com."}, checked)
+}
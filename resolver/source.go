@@ -0,0 +1,139 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/safing/portmaster/netenv"
+)
+
+// resolverSource is the local address or network interface that connections
+// to a resolver are made from.
+type resolverSource struct {
+	ip    net.IP
+	iface string
+}
+
+// parseResolverSource parses the given source, which is either an IP address
+// or the name of a network interface.
+func parseResolverSource(source string) (*resolverSource, error) {
+	if source == "" {
+		return nil, errors.New("source must be an IP address or interface name")
+	}
+	if ip := net.ParseIP(source); ip != nil {
+		if ip.IsUnspecified() || ip.IsMulticast() {
+			return nil, fmt.Errorf("source IP %s is not a unicast address", ip)
+		}
+		return &resolverSource{ip: ip}, nil
+	}
+	return &resolverSource{iface: source}, nil
+}
+
+func (rs *resolverSource) String() string {
+	if rs.ip != nil {
+		return rs.ip.String()
+	}
+	return rs.iface
+}
+
+// getIP returns the source IP to connect to the given address from. The IP
+// version is chosen by the IP of the address. Addresses with a domain prefer
+// IPv4.
+func (rs *resolverSource) getIP(address string) (ip net.IP, zone string, err error) {
+	var wantIPv6 bool
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if remoteIP := net.ParseIP(host); remoteIP != nil {
+			wantIPv6 = remoteIP.To4() == nil
+		}
+	}
+
+	// Check if the configured IP is still assigned.
+	if rs.ip != nil {
+		if (rs.ip.To4() == nil) != wantIPv6 {
+			return nil, "", fmt.Errorf("cannot connect to %s from a different IP version", address)
+		}
+		mine, err := netenv.IsMyIP(rs.ip)
+		switch {
+		case err != nil:
+			return nil, "", err
+		case !mine:
+			return nil, "", errors.New("address is not assigned to this device")
+		}
+		return rs.ip, "", nil
+	}
+
+	// Get an address of the interface.
+	ipv4, ipv6, err := netenv.GetInterfaceAddresses(rs.iface)
+	if err != nil {
+		return nil, "", err
+	}
+	if !wantIPv6 {
+		if len(ipv4) == 0 {
+			return nil, "", errors.New("interface has no IPv4 address")
+		}
+		return ipv4[0], "", nil
+	}
+	// Prefer addresses that do not need a zone.
+	for _, ip6 := range ipv6 {
+		if !ip6.IsLinkLocalUnicast() {
+			return ip6, "", nil
+		}
+	}
+	if len(ipv6) == 0 {
+		return nil, "", errors.New("interface has no IPv6 address")
+	}
+	return ipv6[0], rs.iface, nil
+}
+
+// bindLocalAddr returns the local address to connect to the given address
+// from, using the port of localAddr, if set. If the resolver is not bound to a
+// source, localAddr is returned as is.
+// If the source is not available, ErrSourceUnavailable is returned instead of
+// falling back to the default route.
+func (resolver *Resolver) bindLocalAddr(localAddr net.Addr, network, address string) (net.Addr, error) {
+	if resolver.source == nil {
+		return localAddr, nil
+	}
+
+	ip, zone, err := resolver.source.getIP(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is bound to %s: %s", ErrSourceUnavailable, resolver.Info.DescriptiveName(), resolver.source, err)
+	}
+
+	// Keep the port of the given local address, as it may be pre-authenticated.
+	var port int
+	switch addr := localAddr.(type) {
+	case *net.UDPAddr:
+		if addr != nil {
+			port = addr.Port
+		}
+	case *net.TCPAddr:
+		if addr != nil {
+			port = addr.Port
+		}
+	}
+
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip, Port: port, Zone: zone}, nil
+	}
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}, nil
+}
+
+// bindDialer returns a copy of the dialer that connects to the given address
+// from the source of the resolver. If the resolver is not bound to a source,
+// the dialer is returned as is.
+func (resolver *Resolver) bindDialer(dialer *net.Dialer, network, address string) (*net.Dialer, error) {
+	if resolver.source == nil {
+		return dialer, nil
+	}
+
+	localAddr, err := resolver.bindLocalAddr(dialer.LocalAddr, network, address)
+	if err != nil {
+		return nil, err
+	}
+	boundDialer := *dialer
+	boundDialer.LocalAddr = localAddr
+	return &boundDialer, nil
+}
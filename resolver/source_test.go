@@ -0,0 +1,129 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSourceReportingDNSServer starts a DNS server that answers with the IP
+// address the query was received from.
+func startSourceReportingDNSServer(t *testing.T) (port string) {
+	t.Helper()
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{
+		PacketConn: packetConn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(request)
+			if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   addr.IP,
+				})
+			}
+			_ = w.WriteMsg(msg)
+		}),
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	_, port, err = net.SplitHostPort(packetConn.LocalAddr().String())
+	require.NoError(t, err)
+	return port
+}
+
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface available")
+	return ""
+}
+
+func TestResolverSource(t *testing.T) {
+	t.Parallel()
+
+	port := startSourceReportingDNSServer(t)
+	newBoundResolver := func(source string) *Resolver {
+		resolversLock.Lock()
+		defer resolversLock.Unlock()
+
+		resolver, _, err := createResolver("dns://127.0.0.1:"+port+"?source="+source, ServerSourceConfigured)
+		require.NoError(t, err)
+		return resolver
+	}
+	query := func(resolver *Resolver) (net.IP, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		rrCache, err := resolver.Conn.Query(ctx, &Query{FQDN: "source.example.com.", QType: dns.Type(dns.TypeA)})
+		if err != nil {
+			return nil, err
+		}
+		require.Len(t, rrCache.Answer, 1)
+		return rrCache.Answer[0].(*dns.A).A, nil
+	}
+
+	// Check that queries are sent from the configured IP, if the host supports
+	// using other loopback addresses than the one of the server.
+	if conn, err := net.ListenPacket("udp", "127.0.0.2:0"); err == nil {
+		_ = conn.Close()
+		sourceIP, err := query(newBoundResolver("127.0.0.2"))
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.2", sourceIP.String())
+	}
+
+	// Check that queries are sent from the configured interface.
+	sourceIP, err := query(newBoundResolver(loopbackInterface(t)))
+	require.NoError(t, err)
+	assert.True(t, sourceIP.IsLoopback(), "source %s", sourceIP)
+
+	// Check that unavailable sources fail instead of using the default route.
+	for _, source := range []string{"192.0.2.123", "does-not-exist0"} {
+		_, err := query(newBoundResolver(source))
+		assert.ErrorIs(t, err, ErrSourceUnavailable, source)
+		assert.ErrorIs(t, err, ErrOffline, source)
+	}
+}
+
+func TestResolverSourceParameter(t *testing.T) {
+	t.Parallel()
+
+	resolversLock.Lock()
+	defer resolversLock.Unlock()
+
+	resolver, _, err := createResolver("dns://192.0.2.1?source=192.0.2.2", ServerSourceConfigured)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.2", resolver.source.String())
+
+	resolver, _, err = createResolver("dot://192.0.2.1?verify=dns.example.com&source=eth0", ServerSourceConfigured)
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", resolver.source.iface)
+
+	for _, resolverURL := range []string{
+		"dns://192.0.2.1?source=",
+		"dns://192.0.2.1?source=0.0.0.0",
+		"dns://192.0.2.1?source=2001:db8::1",
+	} {
+		_, _, err := createResolver(resolverURL, ServerSourceConfigured)
+		assert.Error(t, err, resolverURL)
+	}
+}
@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"strings"
+)
+
+// Handling options for special-use TLDs.
+const (
+	SpecialDomainBlock    = "block"
+	SpecialDomainNXDomain = "nxdomain"
+	SpecialDomainForward  = "forward"
+)
+
+// specialDomainHandlingRule is the configured handling of a special-use TLD.
+type specialDomainHandlingRule struct {
+	// TLD is the special-use TLD without dots, eg. "onion".
+	TLD         string
+	Disposition string
+	// Target holds the forwarding targets, if the TLD is forwarded.
+	Target string
+}
+
+// parseSpecialDomainHandling parses an entry in the format "tld:disposition",
+// where the forward disposition takes its targets like "forward=target".
+func parseSpecialDomainHandling(entry string) (rule specialDomainHandlingRule, ok bool) {
+	tld, handling, ok := strings.Cut(entry, ":")
+	if !ok {
+		return rule, false
+	}
+	rule.TLD = strings.ToLower(strings.Trim(strings.TrimSpace(tld), "."))
+	rule.Disposition, rule.Target, _ = strings.Cut(strings.TrimSpace(handling), "=")
+
+	switch {
+	case rule.TLD == "":
+		return rule, false
+	case rule.Disposition == SpecialDomainForward:
+		return rule, rule.Target != ""
+	case rule.Disposition == SpecialDomainBlock, rule.Disposition == SpecialDomainNXDomain:
+		return rule, true
+	default:
+		return rule, false
+	}
+}
+
+// getSpecialDomainHandling returns the configured handling of the special-use
+// TLD of the given domain, if any.
+func getSpecialDomainHandling(dotPrefixedFQDN string) (rule specialDomainHandlingRule, ok bool) {
+	for _, entry := range specialDomainHandling() {
+		rule, ok := parseSpecialDomainHandling(entry)
+		if ok && strings.HasSuffix(dotPrefixedFQDN, "."+rule.TLD+".") {
+			return rule, true
+		}
+	}
+	return rule, false
+}
+
+// specialDomainForwardingRules returns the forwarded special-use TLDs as
+// conditional forwarding rules.
+func specialDomainForwardingRules() (rules []string) {
+	for _, entry := range specialDomainHandling() {
+		rule, ok := parseSpecialDomainHandling(entry)
+		if ok && rule.Disposition == SpecialDomainForward {
+			rules = append(rules, rule.TLD+"="+rule.Target)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/status"
+)
+
+func TestSpecialDomainHandling(t *testing.T) { //nolint:paralleltest // Changes global config.
+	require.NoError(t, config.SetConfigOption(CfgOptionNameServersKey, []string{
+		"dns://192.0.2.53:53?name=Tor",
+		"dot://9.9.9.9:853?verify=dns.quad9.net&name=Quad9",
+	}))
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionNameServersKey, defaultNameServers)
+		_ = config.SetConfigOption(CfgOptionSpecialDomainHandlingKey, []string{})
+		loadResolvers()
+	}()
+	setHandling := func(entries ...string) {
+		require.NoError(t, config.SetConfigOption(CfgOptionSpecialDomainHandlingKey, entries))
+		loadResolvers()
+	}
+	newOnionQuery := func() *Query {
+		q := &Query{
+			FQDN:          "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion.",
+			QType:         dns.Type(dns.TypeA),
+			SecurityLevel: status.SecurityLevelNormal,
+		}
+		require.True(t, q.check())
+		return q
+	}
+
+	// By default, special domains are blocked.
+	assert.ErrorIs(t, newOnionQuery().checkCompliance(), ErrSpecialDomainsDisabled)
+
+	// Block.
+	setHandling("onion:block")
+	_, err := Resolve(silencingTraceCtx, newOnionQuery())
+	assert.ErrorIs(t, err, ErrSpecialDomainsDisabled)
+	assert.ErrorIs(t, err, ErrBlocked)
+
+	// NXDOMAIN.
+	setHandling("onion:nxdomain")
+	_, err = Resolve(silencingTraceCtx, newOnionQuery())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotErrorIs(t, err, ErrBlocked)
+
+	// Forward to a designated resolver, which is not subject to the blocking
+	// of special domains.
+	setHandling("onion:forward=Tor")
+	q := newOnionQuery()
+	assert.NoError(t, q.checkCompliance())
+	resolvers, _, _ := GetResolversInScope(context.Background(), q)
+	if assert.Len(t, resolvers, 1) {
+		assert.Equal(t, "Tor", resolvers[0].Info.Name)
+	}
+
+	// Forwarding to an unknown resolver must not fall back to others.
+	setHandling("onion:forward=unknown")
+	assert.ErrorIs(t, newOnionQuery().checkCompliance(), ErrNoCompliance)
+
+	// Other special domains are not affected.
+	setHandling("onion:nxdomain", "test:block")
+	q = &Query{FQDN: "host.invalid.", QType: dns.Type(dns.TypeA)}
+	require.True(t, q.check())
+	assert.ErrorIs(t, q.checkCompliance(), ErrNotFound)
+	q = &Query{FQDN: "host.test.", QType: dns.Type(dns.TypeA)}
+	require.True(t, q.check())
+	assert.ErrorIs(t, q.checkCompliance(), ErrBlocked)
+}
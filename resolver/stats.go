@@ -0,0 +1,153 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResolverStats holds query statistics of a single resolver.
+type ResolverStats struct {
+	Queries    uint64
+	Successes  uint64
+	NXDomain   uint64
+	Timeouts   uint64
+	Failures   uint64
+	AvgLatency time.Duration
+	// EWMALatency holds the exponentially weighted moving average of the
+	// latency, which is used for latency-based resolver selection.
+	EWMALatency time.Duration
+	// Circuit holds the circuit breaker state of the resolver, if it is
+	// currently active.
+	Circuit string
+}
+
+// Statistics holds query statistics of the resolver module.
+type Statistics struct {
+	// Resolvers holds the statistics of all resolvers that were queried, by
+	// their ID.
+	Resolvers map[string]ResolverStats
+
+	CacheHits     uint64
+	CacheMisses   uint64
+	CacheHitRatio float64
+
+	// ActiveDedupedRequests holds the amount of requests currently tracked
+	// for deduplication.
+	ActiveDedupedRequests int
+}
+
+type resolverStats struct {
+	queries   atomic.Uint64
+	successes atomic.Uint64
+	nxDomain  atomic.Uint64
+	timeouts  atomic.Uint64
+	failures  atomic.Uint64
+	// latency holds the sum of the latency of all answered queries in nanoseconds.
+	latency atomic.Uint64
+	// ewmaLatency holds the moving average of the latency in nanoseconds.
+	ewmaLatency atomic.Int64
+}
+
+var (
+	// resolverStatsMap holds the stats of all resolvers by their ID, so that
+	// the stats survive a reload of the resolvers.
+	resolverStatsMap sync.Map
+
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+)
+
+func getResolverStats(resolver *Resolver) *resolverStats {
+	stats, ok := resolverStatsMap.Load(resolver.Info.ID())
+	if !ok {
+		stats, _ = resolverStatsMap.LoadOrStore(resolver.Info.ID(), &resolverStats{})
+	}
+	return stats.(*resolverStats) //nolint:forcetypeassert // Only this type is stored.
+}
+
+// recordQuery records the result of a query to a resolver.
+func recordQuery(resolver *Resolver, started time.Time, rrCache *RRCache, err error) {
+	stats := getResolverStats(resolver)
+	stats.queries.Add(1)
+
+	switch {
+	case err == nil && rrCache != nil:
+		stats.latency.Add(uint64(time.Since(started)))
+		if rrCache.RCode == dns.RcodeNameError {
+			stats.nxDomain.Add(1)
+		} else {
+			stats.successes.Add(1)
+		}
+	case errors.Is(err, ErrNotFound):
+		stats.latency.Add(uint64(time.Since(started)))
+		stats.nxDomain.Add(1)
+	case errors.Is(err, ErrTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		stats.timeouts.Add(1)
+	case errors.Is(err, ErrContinue),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, ErrShuttingDown):
+		// Not a failure of the resolver.
+	default:
+		stats.failures.Add(1)
+	}
+}
+
+// recordCacheLookup records whether a cache lookup was a hit or a miss.
+func recordCacheLookup(hit bool) {
+	if hit {
+		cacheHits.Add(1)
+	} else {
+		cacheMisses.Add(1)
+	}
+}
+
+// Stats returns the current query statistics of the resolver module.
+func Stats() *Statistics {
+	stats := &Statistics{
+		Resolvers:   make(map[string]ResolverStats),
+		CacheHits:   cacheHits.Load(),
+		CacheMisses: cacheMisses.Load(),
+	}
+
+	// Calculate cache hit ratio.
+	if total := stats.CacheHits + stats.CacheMisses; total > 0 {
+		stats.CacheHitRatio = float64(stats.CacheHits) / float64(total)
+	}
+
+	// Export resolver stats.
+	resolverStatsMap.Range(func(key, value interface{}) bool {
+		rs := value.(*resolverStats) //nolint:forcetypeassert // Only this type is stored.
+		export := ResolverStats{
+			Queries:   rs.queries.Load(),
+			Successes: rs.successes.Load(),
+			NXDomain:  rs.nxDomain.Load(),
+			Timeouts:  rs.timeouts.Load(),
+			Failures:  rs.failures.Load(),
+
+			EWMALatency: time.Duration(rs.ewmaLatency.Load()),
+		}
+		if resolver := getActiveResolverByIDWithLocking(key.(string)); resolver != nil { //nolint:forcetypeassert // Only this type is stored.
+			if cb, ok := resolver.Conn.(circuitBreaker); ok {
+				export.Circuit = cb.CircuitState()
+			}
+		}
+		if answered := export.Successes + export.NXDomain; answered > 0 {
+			export.AvgLatency = time.Duration(rs.latency.Load() / answered)
+		}
+		stats.Resolvers[key.(string)] = export //nolint:forcetypeassert // Only this type is stored.
+		return true
+	})
+
+	// Get size of dedupe map.
+	dupReqLock.Lock()
+	stats.ActiveDedupedRequests = len(dupReqMap)
+	dupReqLock.Unlock()
+
+	return stats
+}
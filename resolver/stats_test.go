@@ -0,0 +1,40 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordQueryStats(t *testing.T) {
+	t.Parallel()
+
+	resolver := &Resolver{
+		Info: &ResolverInfo{
+			Type:   ServerTypeDNS,
+			Source: ServerSourceConfigured,
+			Name:   "stats-test",
+			Port:   5353,
+		},
+	}
+	started := time.Now().Add(-10 * time.Millisecond)
+
+	recordQuery(resolver, started, &RRCache{RCode: dns.RcodeSuccess}, nil)
+	recordQuery(resolver, started, &RRCache{RCode: dns.RcodeNameError}, nil)
+	recordQuery(resolver, started, nil, ErrTimeout)
+	recordQuery(resolver, started, nil, ErrFailure)
+	recordQuery(resolver, started, nil, ErrContinue)
+
+	stats, ok := Stats().Resolvers[resolver.Info.ID()]
+	if !assert.True(t, ok, "resolver stats missing") {
+		return
+	}
+	assert.Equal(t, uint64(5), stats.Queries)
+	assert.Equal(t, uint64(1), stats.Successes)
+	assert.Equal(t, uint64(1), stats.NXDomain)
+	assert.Equal(t, uint64(1), stats.Timeouts)
+	assert.Equal(t, uint64(1), stats.Failures)
+	assert.GreaterOrEqual(t, stats.AvgLatency, 10*time.Millisecond)
+}
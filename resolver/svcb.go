@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"net"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// HTTPSParams holds the parameters of a SVCB or HTTPS record.
+type HTTPSParams struct {
+	// Name is the owner name of the record.
+	Name string
+	// Priority is the priority of the record. Records with a priority of 0 are
+	// in alias mode and only hold a Target.
+	Priority uint16
+	// Target is the domain providing the service. In service mode, it is set to
+	// Name if the record refers to its owner name.
+	Target string
+
+	ALPN          []string
+	NoDefaultALPN bool
+	Port          uint16
+	IPv4Hints     []net.IP
+	IPv6Hints     []net.IP
+	// ECHConfig holds the raw ECHConfigList for Encrypted Client Hello.
+	ECHConfig []byte
+}
+
+// AliasMode returns whether the record is in alias mode, which delegates the
+// service to the Target, similar to a CNAME.
+func (params *HTTPSParams) AliasMode() bool {
+	return params.Priority == 0
+}
+
+// ExportHTTPSParams returns the parameters of all SVCB and HTTPS records in
+// the answer section, sorted by priority.
+func (rrCache *RRCache) ExportHTTPSParams() (params []*HTTPSParams) {
+	for _, rr := range rrCache.Answer {
+		if rr.Header().Class != dns.ClassINET {
+			continue
+		}
+
+		var svcb *dns.SVCB
+		switch v := rr.(type) {
+		case *dns.SVCB:
+			svcb = v
+		case *dns.HTTPS:
+			svcb = &v.SVCB
+		default:
+			continue
+		}
+		params = append(params, parseSVCB(svcb))
+	}
+
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].Priority < params[j].Priority
+	})
+	return params
+}
+
+// parseSVCB converts a SVCB record to HTTPSParams.
+func parseSVCB(svcb *dns.SVCB) *HTTPSParams {
+	params := &HTTPSParams{
+		Name:     svcb.Hdr.Name,
+		Priority: svcb.Priority,
+		Target:   svcb.Target,
+	}
+	if params.Priority > 0 && params.Target == "." {
+		params.Target = params.Name
+	}
+
+	for _, kv := range svcb.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			params.ALPN = v.Alpn
+		case *dns.SVCBNoDefaultAlpn:
+			params.NoDefaultALPN = true
+		case *dns.SVCBPort:
+			params.Port = v.Port
+		case *dns.SVCBIPv4Hint:
+			params.IPv4Hints = v.Hint
+		case *dns.SVCBIPv6Hint:
+			params.IPv6Hints = v.Hint
+		case *dns.SVCBECHConfig:
+			params.ECHConfig = v.ECH
+		}
+	}
+
+	return params
+}
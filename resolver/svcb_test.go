@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportHTTPSParams(t *testing.T) {
+	t.Parallel()
+
+	rrCache := &RRCache{
+		Domain:   "svcb-test.example.com.",
+		Question: dns.Type(dns.TypeHTTPS),
+		RCode:    dns.RcodeSuccess,
+		Expires:  time.Now().Add(time.Hour).Unix(),
+		Resolver: envResolver.Info.Copy(),
+	}
+	for _, record := range []string{
+		`svcb-test.example.com. 300 IN HTTPS 2 backup.example.com. alpn="h2" port="8443"`,
+		`svcb-test.example.com. 300 IN HTTPS 1 . alpn="h3,h2" ipv4hint="192.0.2.1,192.0.2.2" ipv6hint="2001:db8::1" ech="AEX+DQA="`,
+		"svcb-test.example.com. 300 IN A 192.0.2.1",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrCache.Answer = append(rrCache.Answer, rr)
+	}
+
+	// Check that the records survive caching.
+	if err := rrCache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := GetRRCache(rrCache.Domain, rrCache.Question)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := cached.ExportHTTPSParams()
+	if !assert.Len(t, params, 2) {
+		return
+	}
+	assert.Equal(t, uint16(1), params[0].Priority)
+	assert.Equal(t, "svcb-test.example.com.", params[0].Target)
+	assert.Equal(t, []string{"h3", "h2"}, params[0].ALPN)
+	assert.Equal(t, uint16(0), params[0].Port)
+	assert.True(t, params[0].IPv4Hints[1].Equal(net.IPv4(192, 0, 2, 2)))
+	assert.True(t, params[0].IPv6Hints[0].Equal(net.ParseIP("2001:db8::1")))
+	assert.NotEmpty(t, params[0].ECHConfig)
+	assert.False(t, params[0].AliasMode())
+
+	assert.Equal(t, uint16(2), params[1].Priority)
+	assert.Equal(t, "backup.example.com.", params[1].Target)
+	assert.Equal(t, []string{"h2"}, params[1].ALPN)
+	assert.Equal(t, uint16(8443), params[1].Port)
+}
@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// maxThrottledQueries defines the amount of throttled queries at which
+// expired entries are removed.
+const maxThrottledQueries = 1000
+
+type throttledQuery struct {
+	err   error
+	until time.Time
+}
+
+var (
+	throttledQueries     = make(map[string]*throttledQuery)
+	throttledQueriesLock sync.Mutex
+)
+
+// throttleFailedQuery remembers that the query failed with all resolvers, so
+// that identical queries fail with the same error during the cooldown.
+func throttleFailedQuery(q *Query, err error) {
+	cooldown := time.Duration(failedQueryCooldown()) * time.Second
+	if !throttleFailedQueries() || cooldown <= 0 {
+		return
+	}
+
+	throttledQueriesLock.Lock()
+	defer throttledQueriesLock.Unlock()
+
+	now := time.Now()
+	if len(throttledQueries) >= maxThrottledQueries {
+		for id, throttled := range throttledQueries {
+			if now.After(throttled.until) {
+				delete(throttledQueries, id)
+			}
+		}
+	}
+	throttledQueries[q.ID()] = &throttledQuery{
+		err:   err,
+		until: now.Add(cooldown),
+	}
+}
+
+// getThrottledQueryError returns the error of an identical query that failed
+// recently, or nil if the query may be resolved.
+func getThrottledQueryError(q *Query) error {
+	if !throttleFailedQueries() {
+		return nil
+	}
+
+	throttledQueriesLock.Lock()
+	defer throttledQueriesLock.Unlock()
+
+	throttled, ok := throttledQueries[q.ID()]
+	switch {
+	case !ok:
+		return nil
+	case time.Now().After(throttled.until):
+		delete(throttledQueries, q.ID())
+		return nil
+	default:
+		return throttled.err
+	}
+}
@@ -0,0 +1,57 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestThrottleFailedQueries(t *testing.T) { //nolint:paralleltest // Changes global resolvers.
+	// Fail with an error that is reported regardless of the online status.
+	conn, testResolver := newTestProbeResolver(ServerSourceEnv, net.IPv4(192, 0, 2, 58))
+	conn.failErr = ErrBootstrapFailed
+
+	resolversLock.Lock()
+	previousEnvResolvers := envResolvers
+	envResolvers = []*Resolver{testResolver}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		envResolvers = previousEnvResolvers
+		resolversLock.Unlock()
+	}()
+
+	q := &Query{
+		FQDN:      "throttle." + InternalSpecialUseDomain,
+		QType:     dns.Type(dns.TypeA),
+		NoCaching: true,
+	}
+
+	// The first query asks the resolver.
+	_, err := Resolve(silencingTraceCtx, q)
+	assert.ErrorIs(t, err, ErrBootstrapFailed)
+	failures := conn.failures.Load()
+	assert.NotEqual(t, int32(0), failures)
+
+	// Repeated queries fail with the same error, without asking the resolver.
+	_, err = Resolve(silencingTraceCtx, q)
+	assert.ErrorIs(t, err, ErrBootstrapFailed)
+	assert.Equal(t, failures, conn.failures.Load())
+	plan, err := PlanResolve(silencingTraceCtx, &Query{FQDN: q.FQDN, QType: q.QType, NoCaching: true})
+	if assert.NoError(t, err) {
+		assert.True(t, plan.Throttled)
+	}
+
+	// Without throttling, the resolver is asked again.
+	assert.NoError(t, config.SetConfigOption(CfgOptionThrottleFailedQueriesKey, false))
+	defer func() {
+		assert.NoError(t, config.SetConfigOption(CfgOptionThrottleFailedQueriesKey, true))
+	}()
+	_, err = Resolve(silencingTraceCtx, q)
+	assert.ErrorIs(t, err, ErrBootstrapFailed)
+	assert.Greater(t, conn.failures.Load(), failures)
+}
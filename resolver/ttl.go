@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portmaster/status"
+)
+
+// ttlLimits holds the limits that are applied to the TTLs of cached records.
+type ttlLimits struct {
+	min     uint32
+	max     uint32
+	refresh uint32
+}
+
+// getTTLLimits returns the configured TTL limits for the given minimum
+// security level. Like with other security level options, the higher one of
+// the active and the given security level is used.
+func getTTLLimits(minSecurityLevel uint8) ttlLimits {
+	limits := ttlLimits{
+		min:     clampTTLSetting(minCacheTTL()),
+		max:     clampTTLSetting(maxCacheTTL()),
+		refresh: clampTTLSetting(refreshCacheTTL()),
+	}
+
+	// Apply the overrides for the security level.
+	level := status.ActiveSecurityLevel()
+	if minSecurityLevel > level {
+		level = minSecurityLevel
+	}
+	for _, entry := range ttlSecurityLevelOverrides() {
+		entryLevel, overrides, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		if l := parseTTLSecurityLevel(entryLevel); l == status.SecurityLevelOff || l != level {
+			continue
+		}
+		for _, override := range strings.Split(overrides, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(override), "=")
+			if !ok {
+				continue
+			}
+			ttl, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "min":
+				limits.min = uint32(ttl)
+			case "max":
+				limits.max = uint32(ttl)
+			case "refresh":
+				limits.refresh = uint32(ttl)
+			}
+		}
+	}
+
+	// The maximum may never be lower than the minimum.
+	if limits.max < limits.min {
+		limits.max = limits.min
+	}
+
+	return limits
+}
+
+// parseTTLSecurityLevel returns the security level with the given name, as
+// used in the TTL overrides.
+func parseTTLSecurityLevel(name string) uint8 {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "trusted":
+		return status.SecurityLevelNormal
+	case "untrusted":
+		return status.SecurityLevelHigh
+	case "danger":
+		return status.SecurityLevelExtreme
+	default:
+		return status.SecurityLevelOff
+	}
+}
+
+// getTTLFloor returns the configured minimum TTL for the given domain. If
+// multiple configured domains match, the most specific one is used.
+func getTTLFloor(domain string) (floor uint32, ok bool) {
+	domain = "." + strings.ToLower(dns.Fqdn(domain))
+
+	var matched string
+	for _, entry := range ttlFloors() {
+		suffix, value, found := strings.Cut(entry, "=")
+		if !found {
+			continue
+		}
+		suffix = "." + strings.ToLower(dns.Fqdn(strings.TrimPrefix(strings.TrimSpace(suffix), "*.")))
+		if !strings.HasSuffix(domain, suffix) || len(suffix) <= len(matched) {
+			continue
+		}
+		ttl, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		matched = suffix
+		floor = clampTTLSetting(ttl)
+		ok = true
+	}
+
+	return floor, ok
+}
+
+func clampTTLSetting(ttl int64) uint32 {
+	switch {
+	case ttl < 0:
+		return 0
+	case ttl > maxTTLSetting:
+		return maxTTLSetting
+	default:
+		return uint32(ttl)
+	}
+}
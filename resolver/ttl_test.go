@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/status"
+)
+
+func TestTTLLimits(t *testing.T) { //nolint:paralleltest // Changes global config.
+	// Check the defaults.
+	limits := getTTLLimits(status.SecurityLevelExtreme)
+	assert.Equal(t, ttlLimits{min: defaultMinTTL, max: defaultMaxTTL, refresh: defaultRefreshTTL}, limits)
+
+	// Check that overrides only apply to their security level.
+	err := config.SetConfigOption(CfgOptionTTLSecurityLevelOverridesKey, []string{"danger:max=300,refresh=5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionTTLSecurityLevelOverridesKey, []string{})
+		_ = config.SetConfigOption(CfgOptionMinTTLKey, defaultMinTTL)
+	}()
+	limits = getTTLLimits(status.SecurityLevelExtreme)
+	assert.Equal(t, ttlLimits{min: defaultMinTTL, max: 300, refresh: 5}, limits)
+	limits = getTTLLimits(status.SecurityLevelHigh)
+	assert.Equal(t, uint32(defaultMaxTTL), limits.max)
+
+	// Check that the maximum is never lower than the minimum.
+	err = config.SetConfigOption(CfgOptionMinTTLKey, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits = getTTLLimits(status.SecurityLevelExtreme)
+	assert.Equal(t, uint32(600), limits.min)
+	assert.Equal(t, uint32(600), limits.max)
+}
+
+func TestTTLFloors(t *testing.T) { //nolint:paralleltest // Changes global config.
+	err := config.SetConfigOption(CfgOptionTTLFloorsKey, []string{
+		"*.akamaiedge.net=300",
+		"static.akamaiedge.net=600",
+		"example.org=30",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = config.SetConfigOption(CfgOptionTTLFloorsKey, []string{})
+	}()
+
+	// The most specific domain wins.
+	floor, ok := getTTLFloor("e1234.a.akamaiedge.net.")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(300), floor)
+	floor, ok = getTTLFloor("static.akamaiedge.net.")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(600), floor)
+	_, ok = getTTLFloor("notakamaiedge.net.")
+	assert.False(t, ok)
+
+	clean := func(domain string) *RRCache {
+		rrCache := &RRCache{
+			Domain:   domain,
+			Question: dns.Type(dns.TypeA),
+			RCode:    dns.RcodeSuccess,
+			Answer: []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+				A:   []byte{192, 0, 2, 1},
+			}},
+		}
+		rrCache.Clean(defaultMinTTL, defaultMaxTTL)
+		return rrCache
+	}
+
+	// A matched domain gets the higher floor, others the minimum TTL.
+	assert.Equal(t, []string{"TTL clamped from 1s to 300s"}, clean("e1234.a.akamaiedge.net.").Warnings)
+	assert.Equal(t, []string{"TTL clamped from 1s to 60s"}, clean("example.com.").Warnings)
+
+	// Floors lower than the minimum TTL are ignored.
+	assert.Equal(t, []string{"TTL clamped from 1s to 60s"}, clean("www.example.org.").Warnings)
+}